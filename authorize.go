@@ -0,0 +1,31 @@
+package neffos
+
+import "errors"
+
+// ErrForbidden is returned, instead of calling the event callback,
+// when the connection is not authorized for that event, see `Namespaces.Authorize`.
+var ErrForbidden = errors.New("forbidden")
+
+// Authorize wraps the callback of the "event" of the "namespace" with an authorization policy:
+// the "fn" is called with the sender's connection before the callback, i.e to check a state that
+// was stored through `Conn.Set` on login, if it returns false then the callback is not called
+// and the sender receives an `ErrForbidden` error instead.
+// It keeps the authorization separate from the event's logic and it's finer-grained than
+// the `OnNamespaceConnect` one. Multiple policies of the same event should all pass.
+//
+// The event's callback should be registered before the `Authorize` call, otherwise it panics.
+// It should be called before the server or the client starts.
+func (nss Namespaces) Authorize(namespace, event string, fn func(c *Conn) bool) {
+	handler := nss[namespace][event]
+	if handler == nil {
+		panic("neffos: Authorize: the event " + namespace + "." + event + " is not registered")
+	}
+
+	nss[namespace][event] = func(c *NSConn, msg Message) error {
+		if !fn(c.Conn) {
+			return ErrForbidden
+		}
+
+		return handler(c, msg)
+	}
+}
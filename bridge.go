@@ -0,0 +1,85 @@
+package neffos
+
+// Bridge is a peer of a neffos server, i.e another server or a message bus,
+// that the server's broadcasts are forwarded to, so the connections of the peers
+// that are connected to the same namespace, and joined to the same room, receive them too.
+// It's the distributed broadcast primitive, a lightweight alternative
+// of the `StackExchange` when the servers should only share their broadcasts.
+//
+// The peer re-emits a forwarded message to its local connections through its `Server.ReceiveFromBridge`
+// and it never forwards that message again, so the peers can register each other without loops,
+// and a message bus which delivers the published messages back to their publisher is also safe.
+// Keep note that the messages are forwarded to the directly registered peers only,
+// the servers should be fully meshed or share a common message bus.
+//
+// See `Server.UseBridge` and `ServerBridge`.
+type Bridge interface {
+	// Forward should deliver the "msg" to the peer,
+	// the "origin" is the unique identifier of the forwarding server,
+	// it should be passed as it's to the peer's `Server.ReceiveFromBridge`.
+	Forward(origin string, msg Message) error
+}
+
+// UseBridge registers a peer which the broadcasts of this server are forwarded to.
+// It can be called more than once to register more peers. See `Bridge`.
+func (s *Server) UseBridge(peer Bridge) {
+	if peer == nil {
+		return
+	}
+
+	s.bridgesMutex.Lock()
+	s.bridges = append(s.bridges, peer)
+	s.bridgesMutex.Unlock()
+}
+
+// ReceiveFromBridge re-emits a "msg" that a peer forwarded through a `Bridge`
+// to the connections of this server. The message is not forwarded to the peers of this server,
+// and it's ignored when the "origin" is this server itself.
+func (s *Server) ReceiveFromBridge(origin string, msg Message) {
+	if origin == s.uuid {
+		return
+	}
+
+	// the excluded sender is a connection of the origin server.
+	msg.FromExplicit = ""
+
+	s.recordRoomHistory(msg)
+	s.broadcaster.broadcast(msg)
+}
+
+func (s *Server) forwardToBridges(msg Message) {
+	s.bridgesMutex.RLock()
+	bridges := s.bridges
+	s.bridgesMutex.RUnlock()
+
+	for _, peer := range bridges {
+		if err := peer.Forward(s.uuid, msg); err != nil {
+			logger := s.Logger
+			if logger == nil {
+				logger = defaultLogger
+			}
+
+			logger.With("origin", s.uuid).Errorf("bridge forward: %v", err)
+		}
+	}
+}
+
+type serverBridge struct {
+	peer *Server
+}
+
+// ServerBridge returns a `Bridge` which forwards the broadcasts to the "peer" server of the same process,
+// i.e servers with different upgraders or on different endpoints.
+//
+// Example Code:
+//
+//	serverA.UseBridge(neffos.ServerBridge(serverB))
+//	serverB.UseBridge(neffos.ServerBridge(serverA))
+func ServerBridge(peer *Server) Bridge {
+	return &serverBridge{peer: peer}
+}
+
+func (b *serverBridge) Forward(origin string, msg Message) error {
+	b.peer.ReceiveFromBridge(origin, msg)
+	return nil
+}
@@ -0,0 +1,158 @@
+package neffos
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned, instead of calling the event callback,
+// while the `CircuitBreaker` of that event is open.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// CircuitBreaker stops calling an event callback which fails repeatedly, i.e because a downstream dependency is down,
+// for a cool-down period, the messages of that event fail immediately with an `ErrCircuitOpen` error instead.
+// It's shared by all the connections. See `Namespaces.CircuitBreaker`.
+type CircuitBreaker struct {
+	// OnOpen, if not nil, is fired when the circuit opens, with the last error of the callback,
+	// the error is nil when the callback panicked.
+	OnOpen func(namespace, event string, err error)
+	// OnClose, if not nil, is fired when the circuit closes again,
+	// after a successful call of the callback at the end of the cool-down period.
+	OnClose func(namespace, event string)
+
+	namespace        string
+	event            string
+	failureThreshold int
+	cooldown         time.Duration
+
+	mu       sync.Mutex
+	failures int
+	open     bool
+	openedAt time.Time
+	// true while a trial call runs after the cool-down period.
+	trying bool
+}
+
+// CircuitBreaker wraps the callback of the "event" of the "namespace" with a `CircuitBreaker`:
+// after "failureThreshold" consecutive errors of the callback the circuit opens
+// and the callback is not called for the "cooldown" duration, the messages of that event fail
+// immediately with an `ErrCircuitOpen` error. After the "cooldown" a single message is let through,
+// its success closes the circuit and its failure (or panic) opens it again for another "cooldown".
+// Only the errors of the callback itself are failures: the `Reply` results,
+// the `ErrForbidden` and the `ErrCircuitOpen` of a nested breaker are not.
+//
+// The circuit is shared by all the connections, so the wrappers which reject the messages of a single sender
+// should run before it: call the `Authorize` and the `RegisterProto` after the `CircuitBreaker`,
+// the last registered wrapper is the first one to run.
+//
+// The event's callback should be registered before the `CircuitBreaker` call, otherwise it panics.
+// It should be called, and the `CircuitBreaker.OnOpen` and `OnClose` callbacks of the result
+// should be set, before the server or the client starts.
+func (nss Namespaces) CircuitBreaker(namespace, event string, failureThreshold int, cooldown time.Duration) *CircuitBreaker {
+	if failureThreshold <= 0 {
+		failureThreshold = 1
+	}
+
+	b := &CircuitBreaker{
+		namespace:        namespace,
+		event:            event,
+		failureThreshold: failureThreshold,
+		cooldown:         cooldown,
+	}
+
+	handler := nss[namespace][event]
+	if handler == nil {
+		panic("neffos: CircuitBreaker: the event " + namespace + "." + event + " is not registered")
+	}
+
+	nss[namespace][event] = func(c *NSConn, msg Message) error {
+		clock := c.Conn.clock()
+		if !b.allow(clock.Now()) {
+			return ErrCircuitOpen
+		}
+
+		completed := false
+		defer func() {
+			if !completed {
+				// the callback panicked.
+				b.done(clock.Now(), true, nil)
+			}
+		}()
+
+		err := handler(c, msg)
+		completed = true
+		b.done(clock.Now(), isCircuitFailure(err), err)
+		return err
+	}
+
+	return b
+}
+
+// isCircuitFailure reports whether the "err" of an event callback counts as a failure of the circuit.
+func isCircuitFailure(err error) bool {
+	if err == nil || err == ErrForbidden || err == ErrCircuitOpen {
+		return false
+	}
+
+	_, replied := isReply(err)
+	return !replied
+}
+
+// IsOpen reports whether the circuit is open.
+func (b *CircuitBreaker) IsOpen() bool {
+	b.mu.Lock()
+	open := b.open
+	b.mu.Unlock()
+
+	return open
+}
+
+// allow reports whether the callback should be called.
+func (b *CircuitBreaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.open {
+		return true
+	}
+
+	if b.trying || now.Sub(b.openedAt) < b.cooldown {
+		return false
+	}
+
+	b.trying = true
+	return true
+}
+
+// done records the result of a call of the callback.
+func (b *CircuitBreaker) done(now time.Time, failed bool, err error) {
+	b.mu.Lock()
+
+	if !failed {
+		closed := b.open
+		b.failures = 0
+		b.open = false
+		b.trying = false
+		b.mu.Unlock()
+
+		if closed && b.OnClose != nil {
+			b.OnClose(b.namespace, b.event)
+		}
+		return
+	}
+
+	b.failures++
+	opened := false
+	if b.trying || (!b.open && b.failures >= b.failureThreshold) {
+		opened = !b.open
+		b.open = true
+		b.openedAt = now
+		b.trying = false
+	}
+	b.mu.Unlock()
+
+	if opened && b.OnOpen != nil {
+		b.OnOpen(b.namespace, b.event, err)
+	}
+}
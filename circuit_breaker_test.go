@@ -0,0 +1,158 @@
+package neffos
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var (
+		calls   int
+		failing = true
+		errDown = errors.New("downstream is down")
+	)
+
+	namespaces := Namespaces{
+		"default": Events{
+			"query": func(c *NSConn, msg Message) error {
+				calls++
+				if failing {
+					return errDown
+				}
+				return Reply([]byte("ok"))
+			},
+		},
+	}
+
+	var opened, closed int
+	b := namespaces.CircuitBreaker("default", "query", 2, time.Minute)
+	b.OnOpen = func(namespace, event string, err error) {
+		if namespace != "default" || event != "query" || err != errDown {
+			t.Fatalf("unexpected open of %s.%s with error: %v", namespace, event, err)
+		}
+		opened++
+	}
+	b.OnClose = func(namespace, event string) {
+		closed++
+	}
+
+	clock := &fakeClock{now: time.Now()}
+	c := newConn(newPipeSocket(), namespaces, nil)
+	c.setClock(clock)
+	defer c.Close()
+	ns := newNSConn(c, "default", namespaces["default"])
+
+	fire := func() error {
+		return namespaces["default"]["query"](ns, Message{Namespace: "default", Event: "query"})
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := fire(); err != errDown {
+			t.Fatalf("expected the callback's error but got: %v", err)
+		}
+	}
+
+	if !b.IsOpen() || opened != 1 {
+		t.Fatalf("expected the circuit to be opened once after the failures, opened: %d", opened)
+	}
+
+	if err := fire(); err != ErrCircuitOpen {
+		t.Fatalf("expected: %v but got: %v", ErrCircuitOpen, err)
+	}
+
+	if expected, got := 2, calls; expected != got {
+		t.Fatalf("expected %d calls but got %d", expected, got)
+	}
+
+	// the trial call fails and the circuit opens again.
+	clock.Advance(time.Minute)
+	if err := fire(); err != errDown {
+		t.Fatalf("expected the trial call's error but got: %v", err)
+	}
+
+	if err := fire(); err != ErrCircuitOpen {
+		t.Fatalf("expected: %v but got: %v", ErrCircuitOpen, err)
+	}
+
+	// the trial call succeeds and the circuit closes.
+	failing = false
+	clock.Advance(time.Minute)
+	if _, ok := isReply(fire()); !ok {
+		t.Fatalf("expected the trial call's reply")
+	}
+
+	if b.IsOpen() || closed != 1 {
+		t.Fatalf("expected the circuit to be closed once, closed: %d", closed)
+	}
+
+	if _, ok := isReply(fire()); !ok {
+		t.Fatalf("expected the callback to be called")
+	}
+
+	if expected, got := 5, calls; expected != got {
+		t.Fatalf("expected %d calls but got %d", expected, got)
+	}
+}
+
+func TestCircuitBreakerFailures(t *testing.T) {
+	var (
+		panics  = true
+		allowed = false
+	)
+
+	namespaces := Namespaces{
+		"default": Events{
+			"query": func(c *NSConn, msg Message) error {
+				if panics {
+					panic("downstream is down")
+				}
+				return nil
+			},
+		},
+	}
+
+	b := namespaces.CircuitBreaker("default", "query", 1, time.Minute)
+	// the sender's rejections do not reach the circuit.
+	namespaces.Authorize("default", "query", func(*Conn) bool { return allowed })
+
+	clock := &fakeClock{now: time.Now()}
+	c := newConn(newPipeSocket(), namespaces, nil)
+	c.setClock(clock)
+	defer c.Close()
+	ns := newNSConn(c, "default", namespaces["default"])
+
+	fire := func() (panicked bool, err error) {
+		defer func() {
+			panicked = recover() != nil
+		}()
+		return false, namespaces["default"]["query"](ns, Message{Namespace: "default", Event: "query"})
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := fire(); err != ErrForbidden {
+			t.Fatalf("expected: %v but got: %v", ErrForbidden, err)
+		}
+	}
+
+	if b.IsOpen() {
+		t.Fatalf("expected the circuit to be kept closed on the authorization errors")
+	}
+
+	allowed = true
+	if panicked, _ := fire(); !panicked || !b.IsOpen() {
+		t.Fatalf("expected the circuit to be opened on panic")
+	}
+
+	// the panic of the trial call opens the circuit again instead of blocking the next trials forever.
+	clock.Advance(time.Minute)
+	if panicked, _ := fire(); !panicked || !b.IsOpen() {
+		t.Fatalf("expected the trial call to panic")
+	}
+
+	panics = false
+	clock.Advance(time.Minute)
+	if _, err := fire(); err != nil || b.IsOpen() {
+		t.Fatalf("expected the trial call to close the circuit but got: %v", err)
+	}
+}
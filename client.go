@@ -3,6 +3,9 @@ package neffos
 import (
 	"context"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Client is the neffos client. Contains the neffos client-side connection
@@ -12,6 +15,14 @@ import (
 type Client struct {
 	conn *Conn
 
+	// the arguments of the `Dial`, see `EnableReconnect`.
+	dial        Dialer
+	url         string
+	connHandler ConnHandler
+	// guards the namespaces of the "connHandler" against the `On` calls,
+	// shared by the clients of the reconnections.
+	eventsMutex *sync.RWMutex
+
 	// ID comes from server, local changes are not reflected,
 	// use the `Server#IDGenerator` if you want to set a custom logic for ID set.
 	ID string
@@ -23,6 +34,24 @@ type Client struct {
 	// Usage:
 	// <- client.NotifyClose // blocks until local `Close` or remote close of connection.
 	NotifyClose <-chan struct{}
+
+	// ReconnectAttempts is the maximum number of the attempts of a reconnection, see `EnableReconnect`.
+	// Defaults to the `DefaultReconnectAttempts`.
+	ReconnectAttempts int
+	// ReconnectBackoff is the delay before the second attempt of a reconnection,
+	// it's doubled on each next attempt, up to the `MaxReconnectBackoff`.
+	// The first attempt waits for the delay that the server defined instead.
+	// Defaults to the `DefaultReconnectBackoff`.
+	ReconnectBackoff time.Duration
+	// OnReconnecting can be optionally registered to get notified before each attempt of a reconnection,
+	// with the attempt's number, starting from 1, and the delay before it, i.e to show a "reconnecting..." state.
+	OnReconnecting func(attempt int, delay time.Duration)
+	// OnReconnected can be optionally registered to get notified when an attempt of a reconnection succeeds,
+	// right before the `EnableReconnect`'s callback receives the new client.
+	OnReconnected func()
+	// OnReconnectFailed can be optionally registered to get notified when the last attempt of a reconnection fails,
+	// i.e to show a "connection lost" state.
+	OnReconnectFailed func(err error)
 }
 
 // Close method terminates the client-side connection.
@@ -36,6 +65,17 @@ func (c *Client) Close() {
 	c.conn.Close()
 }
 
+// On registers the "handler" to the "event" of the "namespace" of the client's namespaces,
+// the namespace is created if missing. Unlike the `Namespaces.On`, it's safe to call
+// while the client is running, i.e to register the events of a plugin at runtime.
+// The "handler" applies to the messages that arrive after the registration,
+// the clients of the `EnableReconnect` keep it.
+func (c *Client) On(namespace, event string, handler MessageHandlerFunc) {
+	c.eventsMutex.Lock()
+	c.conn.namespaces.On(namespace, event, handler)
+	c.eventsMutex.Unlock()
+}
+
 // WaitServerConnect method blocks until server manually calls the connection's `Connect`
 // on the `Server#OnConnected` event.
 //
@@ -53,6 +93,229 @@ func (c *Client) Connect(ctx context.Context, namespace string) (*NSConn, error)
 	return c.conn.Connect(ctx, namespace)
 }
 
+// BufferOutbound enables an outbound buffer for the "namespace".
+// Messages emitted to that namespace while it's not connected
+// (i.e after a namespace disconnect or, with the `EnableReconnect`, while a lost connection is reconnected)
+// are kept, up to "size" messages, and they are sent, in order, right after the namespace is connected again.
+// Messages that do not fit in the buffer, that cannot be sent on flush or
+// that are still buffered when the client is closed, are passed to the optional "onDrop" callback.
+// System events, native messages and `Ask` messages are never buffered.
+// It gives a best-effort delivery across transient disconnections, it's disabled by default.
+func (c *Client) BufferOutbound(namespace string, size int, onDrop func(Message)) {
+	o := c.conn.outbound
+	o.mu.Lock()
+	if o.buffers == nil {
+		o.buffers = make(map[string]*outboundBuffer)
+	}
+	o.buffers[namespace] = &outboundBuffer{size: size, onDrop: onDrop}
+	o.mu.Unlock()
+}
+
+// SetClock replaces the source of the current time and the timers of this client, i.e a fake clock on tests.
+// It should be called right after the `Dial`. See `Clock`.
+func (c *Client) SetClock(clock Clock) {
+	c.conn.setClock(clock)
+}
+
+// SetReady signals the server that this client is ready to receive events,
+// see `Conn.SetReady` and `Conn.WaitReady`.
+func (c *Client) SetReady() bool {
+	return c.conn.SetReady()
+}
+
+// StrictOrdering stamps the messages that this client writes with sequence numbers and verifies the incoming ones,
+// a message which arrives after a gap or out of order closes the connection with an `ErrOutOfOrder` error.
+// It should be called right after the `Dial`, see `Server.StrictOrdering` for more.
+func (c *Client) StrictOrdering() {
+	atomic.StoreUint32(&c.conn.strictOrdering, 1)
+}
+
+// AutoConnect makes the messages that this client writes to a namespace which is not connected,
+// through the connection's `Write` and `Ask` (i.e `nsConn.Conn.Write(neffos.Message{Namespace: "other", ...})`)
+// or the `NSConn.Emit` of a disconnected namespace, connect that namespace first, as the `Connect` does, and then send them,
+// so the application does not have to order its connects and emits.
+// The write blocks for the connect's round-trip, up to the "timeout", which adds its latency
+// to the first message of each namespace. If the connect fails the message is not sent
+// (or it's kept by the `BufferOutbound` of that namespace, if enabled).
+// Only the namespaces of the client's `ConnHandler` are connected,
+// system events and native messages never trigger a connect.
+// Zero or negative "timeout" disables it, it's disabled by default.
+//
+// Note that the connect waits for the server's reply, which is read by the same goroutine that runs
+// the event callbacks, so messages written from inside the callbacks to a not connected namespace
+// fail after the "timeout", use the `Conn.Go` to write them.
+func (c *Client) AutoConnect(timeout time.Duration) {
+	atomic.StoreInt64(&c.conn.autoConnectTimeout, int64(timeout))
+}
+
+// autoConnect connects the namespace of the "msg" if it's not connected and the `Client.AutoConnect` is enabled,
+// it reports whether the namespace is connected by this call.
+func (c *Conn) autoConnect(msg Message) bool {
+	timeout := time.Duration(atomic.LoadInt64(&c.autoConnectTimeout))
+	if timeout <= 0 || msg.locked || msg.IsNative || IsSystemEvent(msg.Event) || c.IsClosed() {
+		return false
+	}
+
+	if _, ok := c.getEvents(msg.Namespace); !ok || c.Namespace(msg.Namespace) != nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	_, err := c.Connect(ctx, msg.Namespace)
+	return err == nil
+}
+
+// RejoinRooms enables the automatic re-join of the rooms that a namespace was joined to
+// before the server disconnected it, right after that namespace is connected again, i.e
+// through a next `Connect` or a server's force-connect, so a disconnection is transparent to room-based apps.
+// The re-joins run in the background, bound to the connection (see `Conn.Go`),
+// a room that the server does not permit anymore is passed to the optional "onError" callback
+// with the join's error, the rest of the rooms are still re-joined.
+// Namespaces that are disconnected by this client itself (i.e by `NSConn.Disconnect`) are not re-joined.
+// Note that it applies to this client's connection only, a new `Dial` starts with no rooms.
+// It's disabled by default.
+func (c *Client) RejoinRooms(onError func(namespace, room string, err error)) {
+	c.conn.rejoinMutex.Lock()
+	c.conn.rejoin = make(map[string][]string)
+	c.conn.onRejoinError = onError
+	c.conn.rejoinMutex.Unlock()
+}
+
+// keepRoomsToRejoin keeps the joined rooms of the "ns" which is disconnected by the remote side.
+func (c *Conn) keepRoomsToRejoin(ns *NSConn) {
+	c.rejoinMutex.Lock()
+	if c.rejoin != nil {
+		if rooms := ns.RoomNames(); len(rooms) > 0 {
+			c.rejoin[ns.namespace] = rooms
+		}
+	}
+	c.rejoinMutex.Unlock()
+}
+
+// rejoinRooms re-joins the "ns" to its rooms before its last disconnect, see `Client.RejoinRooms`.
+func (c *Conn) rejoinRooms(ns *NSConn) {
+	c.rejoinMutex.Lock()
+	rooms := c.rejoin[ns.namespace]
+	delete(c.rejoin, ns.namespace)
+	onError := c.onRejoinError
+	c.rejoinMutex.Unlock()
+
+	if len(rooms) == 0 {
+		return
+	}
+
+	// in the background, it may be called by the reader, which should be free to read the replies.
+	c.Go(func(ctx context.Context) {
+		for _, room := range rooms {
+			if _, err := ns.JoinRoom(ctx, room); err != nil && onError != nil {
+				onError(ns.namespace, room, err)
+			}
+		}
+	})
+}
+
+// outboundBuffers are the outbound buffers of a client per namespace,
+// they are shared by the clients of its reconnections, see `Client.EnableReconnect`.
+type outboundBuffers struct {
+	mu      sync.Mutex
+	buffers map[string]*outboundBuffer
+}
+
+// outboundBuffer keeps client messages while their namespace is not connected.
+type outboundBuffer struct {
+	size     int
+	messages []Message
+	onDrop   func(Message)
+}
+
+func (b *outboundBuffer) drop(msg Message) {
+	if b.onDrop != nil {
+		b.onDrop(msg)
+	}
+}
+
+// bufferOutbound reports whether the "msg" was buffered to be sent on its namespace connect.
+// A lost connection keeps buffering while it's reconnected, the messages are sent by the new connection.
+func (c *Conn) bufferOutbound(msg Message) bool {
+	if msg.locked || msg.wait != "" || msg.IsNative || IsSystemEvent(msg.Event) || c.outbound == nil {
+		return false
+	}
+
+	if c.IsClosed() && atomic.LoadUint32(&c.reconnecting) == 0 {
+		return false
+	}
+
+	o := c.outbound
+	o.mu.Lock()
+	b, ok := o.buffers[msg.Namespace]
+	o.mu.Unlock()
+	if !ok || c.Namespace(msg.Namespace) != nil { // only when namespace is not connected.
+		return false
+	}
+
+	o.mu.Lock()
+	if msg.Coalesce {
+		b.remove(coalesceKeyOf(msg))
+	}
+
+	if len(b.messages) >= b.size {
+		o.mu.Unlock()
+		b.drop(msg)
+		return false
+	}
+	b.messages = append(b.messages, msg)
+	o.mu.Unlock()
+
+	return true
+}
+
+func (c *Conn) flushOutbound(namespace string) {
+	o := c.outbound
+	if o == nil {
+		return
+	}
+
+	o.mu.Lock()
+	b, ok := o.buffers[namespace]
+	if !ok || len(b.messages) == 0 {
+		o.mu.Unlock()
+		return
+	}
+	messages := b.messages
+	b.messages = nil
+	o.mu.Unlock()
+
+	for _, msg := range messages {
+		if !c.Write(msg) {
+			b.drop(msg)
+		}
+	}
+}
+
+// dropOutbound drops the buffered messages, the buffers are kept for a reconnection.
+func (c *Conn) dropOutbound() {
+	o := c.outbound
+	if o == nil {
+		return
+	}
+
+	o.mu.Lock()
+	buffers := make(map[*outboundBuffer][]Message, len(o.buffers))
+	for _, b := range o.buffers {
+		buffers[b] = b.messages
+		b.messages = nil
+	}
+	o.mu.Unlock()
+
+	for b, messages := range buffers {
+		for _, msg := range messages {
+			b.drop(msg)
+		}
+	}
+}
+
 // Dialer is the definition type of a dialer, gorilla or gobwas or custom.
 // It is the second parameter of the `Dial` function.
 type Dialer func(ctx context.Context, url string) (Socket, error)
@@ -67,6 +330,12 @@ type Dialer func(ctx context.Context, url string) (Socket, error)
 //
 // See examples for more.
 func Dial(ctx context.Context, dial Dialer, url string, connHandler ConnHandler) (*Client, error) {
+	return dialClient(ctx, dial, url, connHandler, new(sync.RWMutex), new(outboundBuffers))
+}
+
+// dialClient is the `Dial` with the "eventsMutex" which guards the namespaces of the "connHandler"
+// and the "outbound" buffers, the reconnections pass the ones of the previous client.
+func dialClient(ctx context.Context, dial Dialer, url string, connHandler ConnHandler, eventsMutex *sync.RWMutex, outbound *outboundBuffers) (*Client, error) {
 	if ctx == nil {
 		ctx = context.Background()
 	}
@@ -84,7 +353,10 @@ func Dial(ctx context.Context, dial Dialer, url string, connHandler ConnHandler)
 		connHandler = Namespaces{}
 	}
 
-	c := newConn(underline, connHandler.GetNamespaces())
+	c := newConn(underline, connHandler.GetNamespaces(), nil)
+	c.eventsMutex = eventsMutex
+	c.outbound = outbound
+	c.values = ctx
 	readTimeout, writeTimeout := getTimeouts(connHandler)
 	c.readTimeout = readTimeout
 	c.writeTimeout = writeTimeout
@@ -95,5 +367,13 @@ func Dial(ctx context.Context, dial Dialer, url string, connHandler ConnHandler)
 		return nil, err
 	}
 
-	return &Client{conn: c, ID: c.id, NotifyClose: c.closeCh}, nil
+	return &Client{
+		conn:        c,
+		dial:        dial,
+		url:         url,
+		connHandler: connHandler,
+		eventsMutex: eventsMutex,
+		ID:          c.id,
+		NotifyClose: c.closeCh,
+	}, nil
 }
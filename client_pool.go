@@ -0,0 +1,230 @@
+package neffos
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrClientPoolExhausted is returned from a `ClientPool.Acquire` when all of the pool's connections
+// have a session of the requested namespace and the pool is full.
+var ErrClientPoolExhausted = errors.New("client pool exhausted")
+
+// ClientPool reuses a limited number of client connections to the same server
+// for many short-lived logical sessions, so bursty clients do not pay a websocket handshake per session.
+//
+// The sessions are multiplexed over the namespaces of the pooled connections:
+// a session is a connected namespace, an `NSConn`, of a pooled connection, it's started by `Acquire`
+// and ended by `Release`, which disconnects that namespace and makes it available to the next session.
+// A connection can be connected to a namespace once, so the concurrent sessions of the same namespace
+// are isolated to different connections, while the sessions of different namespaces share the same connection.
+// Like any `NSConn`, the rooms and the events of a session are scoped to its namespace,
+// but the connection-level state (i.e the `Client.ID` on the server-side) is shared.
+//
+// The pooled connections are checked before their reuse, the closed ones are discarded
+// (see `HealthCheck` too) and a new connection is dialed when there is room in the pool.
+// The connections are kept open, even without sessions, until the pool's `Close`.
+type ClientPool struct {
+	// HealthCheck can be optionally registered to check a pooled connection before its reuse,
+	// if it returns false then the connection is closed and discarded. Closed connections are always discarded.
+	HealthCheck func(client *Client) bool
+
+	dial        Dialer
+	url         string
+	connHandler ConnHandler
+	maxConns    int
+	// shared by the pooled clients, they serve the same namespaces, see `Client.On`.
+	eventsMutex *sync.RWMutex
+
+	mu      sync.Mutex
+	clients []*Client
+	// the namespaces that have a session per connection.
+	sessions map[*Client]map[string]struct{}
+	// the connections that are being dialed, they count to the "maxConns".
+	dialing int
+	closed  bool
+}
+
+// NewClientPool returns a new `ClientPool` of up to "maxConns" connections to the "url",
+// they are dialed on demand with the "dial" and the "connHandler", like the `Dial` does.
+// A zero or negative "maxConns" means no limit.
+func NewClientPool(dial Dialer, url string, connHandler ConnHandler, maxConns int) *ClientPool {
+	return &ClientPool{
+		dial:        dial,
+		url:         url,
+		connHandler: connHandler,
+		maxConns:    maxConns,
+		eventsMutex: new(sync.RWMutex),
+		sessions:    make(map[*Client]map[string]struct{}),
+	}
+}
+
+// Acquire starts a new session of the "namespace" on a pooled connection which has no session of that namespace,
+// or on a new connection if there is none and the pool is not full, otherwise it fails with an `ErrClientPoolExhausted`.
+// The session should be ended by `Release`.
+func (p *ClientPool) Acquire(ctx context.Context, namespace string) (*NSConn, error) {
+	client, err := p.reserve(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	ns, err := client.Connect(ctx, namespace)
+	if err != nil {
+		p.unreserve(client, namespace)
+		return nil, err
+	}
+
+	return ns, nil
+}
+
+// Release ends the session of the "ns", which is acquired by `Acquire`,
+// it disconnects its namespace and its connection is kept for the next sessions.
+func (p *ClientPool) Release(ctx context.Context, ns *NSConn) error {
+	p.mu.Lock()
+	var client *Client
+	for _, c := range p.clients {
+		if c.conn == ns.Conn {
+			client = c
+			break
+		}
+	}
+	p.mu.Unlock()
+
+	if client == nil {
+		// discarded in the meantime.
+		return ns.Disconnect(ctx)
+	}
+
+	err := ns.Disconnect(ctx)
+	if err != nil {
+		// can not be reused by the namespace's next sessions.
+		client.Close()
+	}
+
+	p.unreserve(client, ns.namespace)
+	return err
+}
+
+// Len returns the number of the pooled connections.
+func (p *ClientPool) Len() int {
+	p.mu.Lock()
+	n := len(p.clients)
+	p.mu.Unlock()
+	return n
+}
+
+// Close closes all of the pooled connections, the pool is not usable after that.
+func (p *ClientPool) Close() {
+	p.mu.Lock()
+	clients := p.clients
+	p.clients = nil
+	p.sessions = make(map[*Client]map[string]struct{})
+	p.closed = true
+	p.mu.Unlock()
+
+	for _, client := range clients {
+		client.Close()
+	}
+}
+
+// reserve returns a pooled, or a newly dialed, connection with the "namespace" reserved for a new session.
+func (p *ClientPool) reserve(ctx context.Context, namespace string) (*Client, error) {
+	p.discardUnhealthy()
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrWrite
+	}
+
+	for _, client := range p.clients {
+		if _, ok := p.sessions[client][namespace]; !ok {
+			p.sessions[client][namespace] = struct{}{}
+			p.mu.Unlock()
+			return client, nil
+		}
+	}
+
+	if p.maxConns > 0 && len(p.clients)+p.dialing >= p.maxConns {
+		p.mu.Unlock()
+		return nil, ErrClientPoolExhausted
+	}
+
+	p.dialing++
+	p.mu.Unlock()
+
+	client, err := dialClient(ctx, p.dial, p.url, p.connHandler, p.eventsMutex, new(outboundBuffers))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dialing--
+
+	if err != nil {
+		return nil, err
+	}
+
+	if p.closed {
+		client.Close()
+		return nil, ErrWrite
+	}
+
+	p.clients = append(p.clients, client)
+	p.sessions[client] = map[string]struct{}{namespace: {}}
+	return client, nil
+}
+
+func (p *ClientPool) unreserve(client *Client, namespace string) {
+	p.mu.Lock()
+	if sessions, ok := p.sessions[client]; ok {
+		delete(sessions, namespace)
+	}
+	p.mu.Unlock()
+}
+
+// discardUnhealthy removes the closed connections from the pool, their sessions, if any, end with them,
+// and closes and removes the unhealthy connections without sessions, the ones in use are checked after their release.
+// The `HealthCheck` and the close are called without holding the pool's lock.
+func (p *ClientPool) discardUnhealthy() {
+	p.mu.Lock()
+	var candidates []*Client
+	for _, client := range p.clients {
+		if client.conn.IsClosed() || len(p.sessions[client]) == 0 {
+			candidates = append(candidates, client)
+		}
+	}
+	p.mu.Unlock()
+
+	var unhealthy []*Client
+	for _, client := range candidates {
+		if client.conn.IsClosed() || (p.HealthCheck != nil && !p.HealthCheck(client)) {
+			unhealthy = append(unhealthy, client)
+		}
+	}
+
+	if len(unhealthy) == 0 {
+		return
+	}
+
+	discarded := unhealthy[:0]
+	p.mu.Lock()
+	for _, client := range unhealthy {
+		// a healthy-checked one may be reserved by a new session in the meantime.
+		if !client.conn.IsClosed() && len(p.sessions[client]) > 0 {
+			continue
+		}
+
+		for i, c := range p.clients {
+			if c == client {
+				p.clients = append(p.clients[:i], p.clients[i+1:]...)
+				delete(p.sessions, client)
+				discarded = append(discarded, client)
+				break
+			}
+		}
+	}
+	p.mu.Unlock()
+
+	for _, client := range discarded {
+		client.Close()
+	}
+}
@@ -1,7 +1,15 @@
 package neffos_test
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
 
 	"github.com/kataras/neffos"
 
@@ -34,3 +42,656 @@ func runTestClient(addr string, connHandler neffos.ConnHandler, testFn func(stri
 	testFn("gorilla", gorillaClient)
 	return teardown
 }
+
+func TestClientBufferOutbound(t *testing.T) {
+	var (
+		wg        sync.WaitGroup
+		namespace = "default"
+		body      = []byte("buffered")
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"event": func(c *neffos.NSConn, msg neffos.Message) error {
+					if !c.Conn.IsClient() {
+						if !bytes.Equal(msg.Body, body) {
+							t.Fatalf("expected buffered message's body to be: %s but got: %s", body, msg.Body)
+						}
+						wg.Done()
+					}
+					return nil
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		dropped := 0
+		client.BufferOutbound(namespace, 1, func(neffos.Message) { dropped++ })
+
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = c.Disconnect(nil); err != nil {
+			t.Fatal(err)
+		}
+
+		if !c.Emit("event", body) {
+			t.Fatalf("[%s] expected message to be buffered while namespace is disconnected", dialer)
+		}
+
+		if c.Emit("event", body) || dropped != 1 {
+			t.Fatalf("[%s] expected message to be dropped because buffer is full", dialer)
+		}
+
+		wg.Add(1)
+		if _, err = client.Connect(nil, namespace); err != nil {
+			t.Fatal(err)
+		}
+		wg.Wait()
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientAutoConnect(t *testing.T) {
+	var (
+		wg        sync.WaitGroup
+		namespace = "default"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"event": func(c *neffos.NSConn, msg neffos.Message) error {
+					if !c.Conn.IsClient() {
+						wg.Done()
+					}
+					return nil
+				},
+				"ask": neffos.ReplyHandler(func(c *neffos.NSConn, msg neffos.Message) ([]byte, error) {
+					return msg.Body, nil
+				}),
+			},
+			"other": neffos.Events{},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		client.AutoConnect(3 * time.Second)
+
+		other, err := client.Connect(nil, "other")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wg.Add(1)
+		if !other.Conn.Write(neffos.Message{Namespace: namespace, Event: "event"}) {
+			t.Fatalf("[%s] expected the write to connect the namespace", dialer)
+		}
+		wg.Wait()
+
+		c := other.Conn.Namespace(namespace)
+		if c == nil {
+			t.Fatalf("[%s] expected the namespace to be connected", dialer)
+		}
+
+		if err = c.Disconnect(nil); err != nil {
+			t.Fatal(err)
+		}
+
+		wg.Add(1)
+		if !c.Emit("event", nil) {
+			t.Fatalf("[%s] expected the emit to connect the namespace again", dialer)
+		}
+		wg.Wait()
+
+		if err = other.Conn.Namespace(namespace).Disconnect(nil); err != nil {
+			t.Fatal(err)
+		}
+
+		reply, err := other.Conn.Ask(nil, neffos.Message{Namespace: namespace, Event: "ask", Body: []byte("data")})
+		if err != nil {
+			t.Fatalf("[%s] expected the ask to connect the namespace but got: %v", dialer, err)
+		}
+		if expected, got := "data", string(reply.Body); expected != got {
+			t.Fatalf("[%s] expected reply: %s but got: %s", dialer, expected, got)
+		}
+
+		if other.Conn.Write(neffos.Message{Namespace: "unknown", Event: "event"}) {
+			t.Fatalf("[%s] expected the write to a not registered namespace to fail", dialer)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientAutoConnectOn(t *testing.T) {
+	var (
+		wg           sync.WaitGroup
+		namespace    = "plugin"
+		serverEvents = neffos.Namespaces{
+			"default": neffos.Events{},
+			namespace: neffos.Events{
+				"event": func(c *neffos.NSConn, msg neffos.Message) error {
+					wg.Done()
+					return nil
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", serverEvents)
+	defer teardownServer()
+
+	dialers := map[string]neffos.Dialer{"gobwas": gobwas.DefaultDialer, "gorilla": gorilla.DefaultDialer}
+	for dialer, dial := range dialers {
+		// the client registers a namespace at runtime, so the namespaces are not shared.
+		client, err := neffos.Dial(nil, dial, "ws://localhost:8080/"+dialer, neffos.Namespaces{"default": neffos.Events{}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		client.AutoConnect(3 * time.Second)
+
+		c, err := client.Connect(nil, "default")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		client.On(namespace, "event", func(*neffos.NSConn, neffos.Message) error { return nil })
+
+		// registrations while the writes look up the namespaces.
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 100; i++ {
+				client.On("other"+strconv.Itoa(i), "event", func(*neffos.NSConn, neffos.Message) error { return nil })
+			}
+		}()
+
+		for i := 0; i < 100; i++ {
+			c.Conn.Write(neffos.Message{Namespace: "unknown", Event: "event"})
+			time.Sleep(time.Millisecond)
+		}
+
+		wg.Add(1)
+		if !c.Conn.Write(neffos.Message{Namespace: namespace, Event: "event"}) {
+			t.Fatalf("[%s] expected the write to connect the runtime registered namespace", dialer)
+		}
+		wg.Wait()
+
+		<-done
+		client.Close()
+	}
+}
+
+func TestClientRejoinRooms(t *testing.T) {
+	var (
+		wg        sync.WaitGroup
+		namespace = "default"
+		banned    uint32
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				neffos.OnNamespaceDisconnect: func(c *neffos.NSConn, msg neffos.Message) error {
+					if c.Conn.IsClient() && !msg.IsLocal {
+						wg.Done()
+					}
+					return nil
+				},
+				neffos.OnRoomJoin: func(c *neffos.NSConn, msg neffos.Message) error {
+					if !c.Conn.IsClient() && msg.Room == "room2" && atomic.LoadUint32(&banned) == 1 {
+						return errors.New("banned")
+					}
+					return nil
+				},
+				"kick": func(c *neffos.NSConn, msg neffos.Message) error {
+					if !c.Conn.IsClient() {
+						atomic.StoreUint32(&banned, 1)
+						go c.Disconnect(nil)
+					}
+					return nil
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+		atomic.StoreUint32(&banned, 0)
+
+		rejected := make(chan string, 1)
+		client.RejoinRooms(func(namespace, room string, err error) {
+			rejected <- room
+		})
+
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, room := range []string{"room1", "room2"} {
+			if _, err = c.JoinRoom(nil, room); err != nil {
+				t.Fatalf("[%s] %v", dialer, err)
+			}
+		}
+
+		wg.Add(1)
+		c.Emit("kick", nil)
+		wg.Wait()
+		// give some time to the server to complete the namespace disconnect after the client's reply.
+		time.Sleep(100 * time.Millisecond)
+
+		if c, err = client.Connect(nil, namespace); err != nil {
+			t.Fatalf("[%s] %v", dialer, err)
+		}
+
+		select {
+		case room := <-rejected:
+			if room != "room2" {
+				t.Fatalf("[%s] expected room2 to be rejected but got: %s", dialer, room)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("[%s] expected the rejected room to be reported", dialer)
+		}
+
+		// the re-joins run in the background, in any order.
+		for deadline := time.Now().Add(5 * time.Second); c.Room("room1") == nil; time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("[%s] expected room1 to be re-joined", dialer)
+			}
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientEnableReconnect(t *testing.T) {
+	var (
+		servers   []*neffos.Server
+		namespace = "default"
+		room      = "room1"
+		events    = neffos.Namespaces{namespace: neffos.Events{}}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.ReconnectStagger = 50 * time.Millisecond
+		servers = append(servers, s)
+	})
+	defer teardownServer()
+
+	client, err := neffos.Dial(nil, gobwas.DefaultDialer, "ws://localhost:8080/gobwas", events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var states []string
+	client.OnReconnecting = func(attempt int, delay time.Duration) {
+		states = append(states, fmt.Sprintf("reconnecting %d after %s", attempt, delay))
+	}
+	client.OnReconnected = func() {
+		states = append(states, "reconnected")
+	}
+
+	reconnected := make(chan *neffos.Client, 1)
+	client.EnableReconnect(func(newClient *neffos.Client, err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		reconnected <- newClient
+	})
+
+	c, err := client.Connect(nil, namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = c.JoinRoom(nil, room); err != nil {
+		t.Fatal(err)
+	}
+
+	// move from the gobwas server to the gorilla one.
+	if expected, got := 1, servers[0].RequestReconnect("ws://localhost:8080/gorilla"); expected != got {
+		t.Fatalf("expected the request to be written to %d connections but got %d", expected, got)
+	}
+
+	var newClient *neffos.Client
+	select {
+	case newClient = <-reconnected:
+		defer newClient.Close()
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected the client to reconnect")
+	}
+
+	select {
+	case <-client.NotifyClose:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected the old client to be closed")
+	}
+
+	if newClient.ID == client.ID {
+		t.Fatalf("expected a new connection")
+	}
+
+	// the first (and only) connection of the server is not delayed.
+	if expected := []string{"reconnecting 1 after 0s", "reconnected"}; !reflect.DeepEqual(expected, states) {
+		t.Fatalf("expected reconnection states: %v but got: %v", expected, states)
+	}
+
+	if info := servers[1].RoomsInfo(namespace); info[room] != 1 {
+		t.Fatalf("expected the new connection to be re-joined to the %s room but got %v", room, info)
+	}
+
+	// the old server handles the disconnect in the background.
+	time.Sleep(200 * time.Millisecond)
+	if info := servers[0].RoomsInfo(namespace); len(info) != 0 {
+		t.Fatalf("expected no rooms on the old server but got %v", info)
+	}
+}
+
+func TestClientReconnectOnLostConnection(t *testing.T) {
+	var (
+		servers   []*neffos.Server
+		namespace = "default"
+		room      = "room1"
+		events    = neffos.Namespaces{namespace: neffos.Events{}}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		servers = append(servers, s)
+	})
+	defer teardownServer()
+
+	client, err := neffos.Dial(nil, gobwas.DefaultDialer, "ws://localhost:8080/gobwas", events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var states []string
+	client.OnReconnecting = func(attempt int, delay time.Duration) {
+		states = append(states, fmt.Sprintf("reconnecting %d after %s", attempt, delay))
+	}
+	client.OnReconnected = func() {
+		states = append(states, "reconnected")
+	}
+
+	reconnected := make(chan *neffos.Client, 1)
+	client.EnableReconnect(func(newClient *neffos.Client, err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		reconnected <- newClient
+	})
+
+	c, err := client.Connect(nil, namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = c.JoinRoom(nil, room); err != nil {
+		t.Fatal(err)
+	}
+
+	// i.e a network failure.
+	servers[0].GetConnections()[client.ID].Close()
+
+	select {
+	case newClient := <-reconnected:
+		defer newClient.Close()
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected the client to reconnect")
+	}
+
+	if expected := []string{"reconnecting 1 after 0s", "reconnected"}; !reflect.DeepEqual(expected, states) {
+		t.Fatalf("expected reconnection states: %v but got: %v", expected, states)
+	}
+
+	if info := servers[0].RoomsInfo(namespace); info[room] != 1 {
+		t.Fatalf("expected the new connection to be re-joined to the %s room but got %v", room, info)
+	}
+}
+
+func TestClientBufferOutboundReconnect(t *testing.T) {
+	var (
+		namespace = "default"
+		received  = make(chan string, 2)
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"chat": func(c *neffos.NSConn, msg neffos.Message) error {
+					received <- string(msg.Body)
+					return nil
+				},
+			},
+		}
+		servers []*neffos.Server
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		servers = append(servers, s)
+	})
+	defer teardownServer()
+
+	client, err := neffos.Dial(nil, gobwas.DefaultDialer, "ws://localhost:8080/gobwas", events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var dropped uint32
+	client.BufferOutbound(namespace, 2, func(neffos.Message) { atomic.AddUint32(&dropped, 1) })
+
+	// holds the reconnection until the emits of the lost connection.
+	reconnecting, emitted := make(chan struct{}), make(chan struct{})
+	client.OnReconnecting = func(attempt int, delay time.Duration) {
+		close(reconnecting)
+		<-emitted
+	}
+
+	reconnected := make(chan *neffos.Client, 1)
+	client.EnableReconnect(func(newClient *neffos.Client, err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		reconnected <- newClient
+	})
+
+	c, err := client.Connect(nil, namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// i.e a network failure.
+	servers[0].GetConnections()[client.ID].Close()
+	<-reconnecting
+	<-client.NotifyClose
+
+	for _, body := range []string{"1", "2"} {
+		if !c.Emit("chat", []byte(body)) {
+			t.Fatalf("expected the emit of %s to be buffered while reconnecting", body)
+		}
+	}
+	close(emitted)
+
+	select {
+	case newClient := <-reconnected:
+		defer newClient.Close()
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected the client to reconnect")
+	}
+
+	for _, expected := range []string{"1", "2"} {
+		select {
+		case got := <-received:
+			if expected != got {
+				t.Fatalf("expected the buffered message: %s but got: %s", expected, got)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("expected the buffered message: %s to be sent by the new client", expected)
+		}
+	}
+
+	if n := atomic.LoadUint32(&dropped); n != 0 {
+		t.Fatalf("expected no dropped messages but got %d", n)
+	}
+}
+
+func TestClientReconnectFailed(t *testing.T) {
+	events := neffos.Namespaces{"default": neffos.Events{}}
+
+	var servers []*neffos.Server
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		servers = append(servers, s)
+	})
+	defer teardownServer()
+
+	client, err := neffos.Dial(nil, gobwas.DefaultDialer, "ws://localhost:8080/gobwas", events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var (
+		states []string
+		failed = make(chan error, 1)
+	)
+	client.ReconnectAttempts = 3
+	client.ReconnectBackoff = 10 * time.Millisecond
+	client.OnReconnecting = func(attempt int, delay time.Duration) {
+		states = append(states, fmt.Sprintf("reconnecting %d after %s", attempt, delay))
+	}
+	client.OnReconnectFailed = func(err error) {
+		states = append(states, "failed")
+	}
+	client.EnableReconnect(func(newClient *neffos.Client, err error) {
+		failed <- err
+	})
+
+	if _, err = client.Connect(nil, "default"); err != nil {
+		t.Fatal(err)
+	}
+
+	servers[0].RequestReconnect("ws://localhost:8080/notfound")
+
+	select {
+	case err = <-failed:
+		if err == nil {
+			t.Fatalf("expected a reconnection error")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected the reconnection to fail")
+	}
+
+	expected := []string{
+		"reconnecting 1 after 0s",
+		"reconnecting 2 after 10ms",
+		"reconnecting 3 after 20ms",
+		"failed",
+	}
+	if !reflect.DeepEqual(expected, states) {
+		t.Fatalf("expected reconnection states: %v but got: %v", expected, states)
+	}
+
+	select {
+	case <-client.NotifyClose:
+		t.Fatalf("expected the client to be kept on failure")
+	default:
+	}
+}
+
+func TestClientPool(t *testing.T) {
+	events := neffos.Namespaces{"a": neffos.Events{}, "b": neffos.Events{}}
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	pool := neffos.NewClientPool(gobwas.DefaultDialer, "ws://localhost:8080/gobwas", events, 2)
+	defer pool.Close()
+
+	a1, err := pool.Acquire(nil, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b1, err := pool.Acquire(nil, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a1.Conn != b1.Conn {
+		t.Fatalf("expected sessions of different namespaces to share a connection")
+	}
+
+	a2, err := pool.Acquire(nil, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a2.Conn == a1.Conn {
+		t.Fatalf("expected sessions of the same namespace to be isolated to different connections")
+	}
+
+	if _, err = pool.Acquire(nil, "a"); err != neffos.ErrClientPoolExhausted {
+		t.Fatalf("expected error: %v but got: %v", neffos.ErrClientPoolExhausted, err)
+	}
+
+	if err = pool.Release(nil, a1); err != nil {
+		t.Fatal(err)
+	}
+
+	a3, err := pool.Acquire(nil, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a3.Conn != a1.Conn {
+		t.Fatalf("expected the released connection to be reused")
+	}
+	if expected, got := 2, pool.Len(); expected != got {
+		t.Fatalf("expected %d pooled connections but got %d", expected, got)
+	}
+
+	// closed connections are discarded and replaced.
+	a2.Conn.Close()
+
+	a4, err := pool.Acquire(nil, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a4.Conn == a2.Conn || a4.Conn == a3.Conn {
+		t.Fatalf("expected a new connection")
+	}
+	if expected, got := 2, pool.Len(); expected != got {
+		t.Fatalf("expected %d pooled connections but got %d", expected, got)
+	}
+
+	// the health check runs outside of the pool's lock and only for the connections not in use.
+	if err = pool.Release(nil, a4); err != nil {
+		t.Fatal(err)
+	}
+	pool.HealthCheck = func(client *neffos.Client) bool {
+		pool.Len()
+		return false
+	}
+
+	a5, err := pool.Acquire(nil, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a5.Conn == a4.Conn {
+		t.Fatalf("expected the unhealthy connection to be replaced")
+	}
+	if a3.Conn.IsClosed() {
+		t.Fatalf("expected the connection in use to be kept")
+	}
+}
@@ -0,0 +1,88 @@
+package neffos
+
+import (
+	"time"
+)
+
+// Clock is the source of the current time and the timers of the time-dependent logic of the connections,
+// i.e the `Server.Dedupe` windows, the `Server.RoomRateLimit` buckets, the latency measurements and
+// the waits for the acknowledgement and the `Conn.Go` goroutines on close, the `Server.HandlerDeadline` watchdogs,
+// the `Server.Drain` and the expiration of the `Server.SessionResumeTimeout` sessions.
+// A fake clock can be injected on tests to advance the time instantly instead of sleeping,
+// see `Server.Clock` and `Client.SetClock`.
+//
+// The network deadlines of the sockets and the deadlines of the contexts always use the real time.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// After waits for the duration "d" to elapse and then sends the current time on the returned channel.
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// RealClock is the default `Clock`, it uses the standard time package.
+var RealClock Clock = realClock{}
+
+func (s *Server) now() time.Time {
+	return s.clock().Now()
+}
+
+// clock returns the `Clock` of the server.
+func (s *Server) clock() Clock {
+	if s.Clock != nil {
+		return s.Clock
+	}
+
+	return RealClock
+}
+
+// clockValue wraps a `Clock` to be kept in an atomic.Value, which requires the same concrete type on each store.
+type clockValue struct {
+	Clock
+}
+
+// setClock replaces the `Clock` of this connection, it's safe to call while the connection is used.
+func (c *Conn) setClock(clock Clock) {
+	c.clk.Store(clockValue{clock})
+}
+
+// clock returns the `Clock` of this connection.
+func (c *Conn) clock() Clock {
+	if clk, ok := c.clk.Load().(clockValue); ok && clk.Clock != nil {
+		return clk.Clock
+	}
+
+	if !c.IsClient() {
+		return c.server.clock()
+	}
+
+	return RealClock
+}
+
+// afterFunc waits for the duration "d" of the "clock" to elapse and then calls "f" in its own goroutine,
+// unless the returned function, which stops the wait, is called before that.
+func afterFunc(clock Clock, d time.Duration, f func()) (stop func()) {
+	if clock == RealClock {
+		timer := time.AfterFunc(d, f)
+		return func() { timer.Stop() }
+	}
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-clock.After(d):
+			select {
+			case <-done: // stopped in the meantime.
+			default:
+				f()
+			}
+		case <-done:
+		}
+	}()
+
+	return func() { close(done) }
+}
@@ -0,0 +1,69 @@
+package neffos
+
+import (
+	"testing"
+	"time"
+)
+
+// manualClock fires its timers only when the test sends to its "fire" channel.
+type manualClock struct {
+	fakeClock
+	fire chan time.Time
+}
+
+func (c *manualClock) After(time.Duration) <-chan time.Time { return c.fire }
+
+func TestAfterFunc(t *testing.T) {
+	clock := &manualClock{fakeClock: fakeClock{now: time.Now()}, fire: make(chan time.Time)}
+
+	called := make(chan struct{}, 1)
+	afterFunc(clock, time.Hour, func() { called <- struct{}{} })
+
+	select {
+	case <-called:
+		t.Fatalf("expected the function to wait for the clock")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clock.fire <- clock.Now()
+	select {
+	case <-called:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected the function to be called when the clock fires")
+	}
+
+	stop := afterFunc(clock, time.Hour, func() { called <- struct{}{} })
+	stop()
+
+	select {
+	case clock.fire <- clock.Now():
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	select {
+	case <-called:
+		t.Fatalf("expected the stopped function to not be called")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestConnSetClockWhileUsed(t *testing.T) {
+	c := newConn(newPipeSocket(), Namespaces{"default": Events{}}, nil)
+	defer c.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			c.clock().Now()
+		}
+	}()
+
+	clock := &fakeClock{now: time.Now()}
+	c.setClock(clock)
+	<-done
+
+	if c.clock() != Clock(clock) {
+		t.Fatalf("expected the replaced clock")
+	}
+}
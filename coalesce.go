@@ -0,0 +1,41 @@
+package neffos
+
+import (
+	"sync/atomic"
+)
+
+// coalesceKey is the key of the outgoing messages that replace each other while queued, see `Message.Coalesce`.
+type coalesceKey struct {
+	namespace string
+	room      string
+	event     string
+}
+
+func coalesceKeyOf(msg Message) coalesceKey {
+	return coalesceKey{namespace: msg.Namespace, room: msg.Room, event: msg.Event}
+}
+
+// removePendingWrite removes the queued coalescing write of the "key", if any.
+// Locks required, see `writePending`.
+func (c *Conn) removePendingWrite(key coalesceKey) {
+	for i, w := range c.pendingWrites {
+		if w.coalesce && w.key == key {
+			// at most one per key.
+			w.done(ErrCoalesced)
+			c.pendingWrites = append(c.pendingWrites[:i], c.pendingWrites[i+1:]...)
+			atomic.AddInt32(&c.pendingWritesLen, -1)
+			return
+		}
+	}
+}
+
+// remove removes the buffered coalescing message of the "key", if any.
+// Locks required, see `Conn.bufferOutbound`.
+func (b *outboundBuffer) remove(key coalesceKey) {
+	for i, msg := range b.messages {
+		if msg.Coalesce && coalesceKeyOf(msg) == key {
+			b.messages = append(b.messages[:i], b.messages[i+1:]...)
+			return
+		}
+	}
+}
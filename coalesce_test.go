@@ -0,0 +1,57 @@
+package neffos
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMessageCoalesce(t *testing.T) {
+	namespaces := Namespaces{"default": Events{}, "offline": Events{}}
+
+	c := newConn(newPipeSocket(), namespaces, nil)
+	ns := newNSConn(c, "default", namespaces["default"])
+	ns.setRoom(newRoom(ns, "room"))
+	c.connectedNamespaces["default"] = ns
+	c.outbound = &outboundBuffers{buffers: map[string]*outboundBuffer{"offline": {size: 10}}}
+	defer c.Close()
+
+	price := func(namespace, body string) Message {
+		return Message{Namespace: namespace, Event: "price", Body: []byte(body), Coalesce: true}
+	}
+
+	for _, msg := range []Message{
+		price("default", "1"),
+		price("default", "2"),
+		{Namespace: "default", Event: "chat", Body: []byte("hello")},
+		{Namespace: "default", Room: "room", Event: "price", Body: []byte("room"), Coalesce: true},
+		price("default", "3"),
+	} {
+		if !c.Write(msg) {
+			t.Fatalf("expected the write of %s to be queued", msg.Body)
+		}
+	}
+
+	if expected, got := 3, c.WriteQueueLen(); expected != got {
+		t.Fatalf("expected write queue length: %d but got: %d", expected, got)
+	}
+
+	var bodies []string
+	for _, w := range c.pendingWrites {
+		bodies = append(bodies, string(c.DeserializeMessage(w.b).Body))
+	}
+
+	if expected, got := "hello room 3", strings.Join(bodies, " "); expected != got {
+		t.Fatalf("expected the queued bodies: %s but got: %s", expected, got)
+	}
+
+	// the client's outbound buffer.
+	c.Write(price("offline", "1"))
+	c.Write(price("offline", "2"))
+	if expected, got := 1, len(c.outbound.buffers["offline"].messages); expected != got {
+		t.Fatalf("expected %d buffered messages but got %d", expected, got)
+	}
+
+	if expected, got := "2", string(c.outbound.buffers["offline"].messages[0].Body); expected != got {
+		t.Fatalf("expected the newest buffered message: %s but got: %s", expected, got)
+	}
+}
@@ -1,10 +1,12 @@
 package neffos
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"net"
 	"net/http"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -24,6 +26,56 @@ type (
 		// WriteText sends a text message to the remote connection.
 		WriteText(body []byte, timeout time.Duration) error
 	}
+
+	// SocketSubprotocoler is an optional interface that a `Socket` can implement
+	// to report the negotiated websocket subprotocol.
+	//
+	// See `Conn.Subprotocol`.
+	SocketSubprotocoler interface {
+		// Subprotocol returns the negotiated subprotocol or empty.
+		Subprotocol() string
+	}
+
+	// SocketExtensioner is an optional interface that a `Socket` can implement
+	// to report the negotiated websocket extensions.
+	//
+	// See `Conn.Extensions`.
+	SocketExtensioner interface {
+		// Extensions returns the names of the negotiated extensions, i.e "permessage-deflate", or nil.
+		Extensions() []string
+	}
+
+	// SocketCompressionThresholder is an optional interface that a `Socket` can implement
+	// to send messages smaller than a threshold uncompressed,
+	// even if the per-message compression extension is negotiated.
+	//
+	// See `Server.CompressionThreshold`.
+	SocketCompressionThresholder interface {
+		// SetCompressionThreshold sets the minimum size, in bytes, of a message to be compressed.
+		SetCompressionThreshold(n int)
+	}
+
+	// SocketFragmentLimiter is an optional interface that a `Socket` can implement
+	// to limit the reassembly of the fragmented incoming messages,
+	// a message that exceeds the limits fails the read with an `ErrFragmentLimit` error.
+	//
+	// See `Server.MaxFragments` and `Server.MaxMessageSize`.
+	SocketFragmentLimiter interface {
+		// SetFragmentLimits sets the maximum number of frames and the maximum total size, in bytes,
+		// of an incoming message. Zero or negative value means no limit.
+		SetFragmentLimits(maxFragments int, maxMessageSize int64)
+	}
+
+	// SocketPinger is an optional interface that a `Socket` can implement
+	// to send websocket pings with an application payload and report the payloads of the pongs.
+	//
+	// See `Conn.PingWithData`.
+	SocketPinger interface {
+		// Ping sends a ping control frame with the "data" as its payload.
+		Ping(data []byte, timeout time.Duration) error
+		// SetPongHandler registers the "handler" to be called, by the reader, with the payload of each incoming pong.
+		SetPongHandler(handler func(data []byte))
+	}
 )
 
 // Conn contains the websocket connection and the neffos communication functionality.
@@ -31,6 +83,18 @@ type (
 // Each connection can connect to one or more declared namespaces.
 // Each `NSConn` can join to multiple rooms.
 type Conn struct {
+	// The timeouts are accessed atomically, they are kept first
+	// so they are 64-bit aligned on 32-bit platforms too.
+
+	// maximum wait time allowed to read a message from the connection.
+	// Defaults to no timeout.
+	// Accessed atomically after the connection is ready, see `SetReadTimeout`.
+	readTimeout time.Duration
+	// maximum wait time allowed to write a message to the connection.
+	// Defaults to no timeout.
+	// Accessed atomically after the connection is ready, see `SetWriteTimeout`.
+	writeTimeout time.Duration
+
 	// the ID generated by `Server#IDGenerator`.
 	id string
 	// serverConnID is unique per server instance and it can be comparable only within the
@@ -43,26 +107,56 @@ type Conn struct {
 
 	// the gorilla or gobwas socket.
 	socket Socket
+	// keeps the values of the request's (or dial's) context, see `Context`.
+	values context.Context
 	// ReconnectTries, if > 0 then this connection is a result of a client-side reconnection,
 	// see `WasReconnected() bool`.
 	ReconnectTries int
 
 	// non-nil if server-side connection.
 	server *Server
+	// the client-side clock, a clockValue, see `Client.SetClock`.
+	clk atomic.Value
+	// true if server-side connection sent a valid `Server.TrustedSecret`.
+	trusted bool
+	// non-nil if server-side connection and `Server.Dedupe` is used.
+	dedupe *dedupeSet
+	// the key-value pairs that this server-side connection is registered to, see `Server.ReplaceExisting`.
+	// Guarded by the server's sessionsMutex.
+	sessionKeys []sessionKey
+	// the *qosClass of this server-side connection, see `SetQoS`.
+	qos atomic.Value
+	// the token buckets of the rate limited rooms, see `Server.RoomRateLimit`.
+	roomBuckets      map[roomKey]*tokenBucket
+	roomBucketsMutex sync.Mutex
+	// non-zero if the outgoing messages are stamped with sequence numbers
+	// and the incoming ones are verified, see `Server.StrictOrdering`.
+	strictOrdering uint32
+	// the last sequence number of the written messages, guarded by the writeSeqMutex
+	// which also serializes their writes.
+	writeSeq      uint64
+	writeSeqMutex sync.Mutex
+	// the last sequence number of the received messages, accessed atomically.
+	readSeq uint64
+	// the token of this connection's session, see `Server.SessionResumeTimeout`.
+	resumeToken      string
+	resumeTokenMutex sync.Mutex
+	// the timeout of the client-side connects of the written messages' namespaces, accessed atomically, see `Client.AutoConnect`.
+	autoConnectTimeout int64
+	// closed when the client-side signals that it's ready to receive events, see `WaitReady`.
+	readyCh    chan struct{}
+	readyMutex sync.Mutex
+	readyOnce  sync.Once
 	// when sever or client is ready to handle messages,
 	// ack and queue is available,
 	// see `Server#ServeHTTP.?OnConnect!=nil`.
 	readiness *waiterOnce
 
-	// maximum wait time allowed to read a message from the connection.
-	// Defaults to no timeout.
-	readTimeout time.Duration
-	// maximum wait time allowed to write a message to the connection.
-	// Defaults to no timeout.
-	writeTimeout time.Duration
-
 	// the defined namespaces, allowed to connect.
 	namespaces Namespaces
+	// guards the "namespaces" against the `Server.On` and `Client.On` calls,
+	// it's the server's or the client's one.
+	eventsMutex *sync.RWMutex
 
 	// more than 0 if acknowledged.
 	acknowledged *uint32
@@ -70,6 +164,12 @@ type Conn struct {
 	// the connection's current connected namespace.
 	connectedNamespaces      map[string]*NSConn
 	connectedNamespacesMutex sync.RWMutex
+	// the rejection channels of the waiting `WaitConnect` calls per namespace,
+	// guarded by the connectedNamespacesMutex.
+	connectWaiters map[string][]chan error
+	// the in-flight connects per namespace, see `askConnect`.
+	connecting      map[string]*connectCall
+	connectingMutex sync.Mutex
 	// used to block certain actions until other action is finished,
 	// i.e `askConnect: myNamespace` blocks the `tryNamespace: myNamespace` until finish.
 	processes *processes
@@ -83,6 +183,71 @@ type Conn struct {
 
 	queue      [][]byte
 	queueMutex sync.Mutex
+	// the length of the queue, accessed atomically, see `ReadQueueLen`.
+	queueLen int32
+
+	// outgoing messages written before the ack, see `MaxPendingWrites`.
+	pendingWrites      []pendingWrite
+	pendingWritesMutex sync.Mutex
+	// the length of the pendingWrites, accessed atomically, see `WriteQueueLen`.
+	pendingWritesLen int32
+	// the number of the written messages which are not flushed yet, accessed atomically, see `InflightWrites`.
+	inflightWrites int32
+	// the messages that were not sent when the connection was closed, guarded by the pendingWritesMutex, see `PendingWrites`.
+	closedPendingWrites []Message
+	pendingWritesClosed bool
+
+	// client-side outbound buffers per namespace, see `Client.BufferOutbound`,
+	// shared by the clients of the reconnections.
+	outbound *outboundBuffers
+
+	// client-side rooms to re-join per namespace, non-nil when enabled, see `Client.RejoinRooms`.
+	rejoin        map[string][]string
+	onRejoinError func(namespace, room string, err error)
+	rejoinMutex   sync.Mutex
+
+	// client-side, non-nil when enabled, see `Client.EnableReconnect`,
+	// the empty "url" is the one of the `Dial`.
+	reconnect      func(url string, delay time.Duration, rooms map[string][]string)
+	reconnectMutex sync.Mutex
+	// 1 while a reconnection is in progress, so a requested one and a lost connection do not start two of them.
+	reconnecting uint32
+
+	// non-nil when reads are paused, closed on resume, see `PauseReads`.
+	readsResume      chan struct{}
+	readsResumeMutex sync.Mutex
+
+	// background goroutines bound to this connection, see `Go`.
+	goroutines      sync.WaitGroup
+	goroutinesMutex sync.Mutex
+	hasGoroutines   bool
+	// the running goroutines started by `Go`, accessed atomically, see `Info`.
+	goroutinesCount int32
+
+	// the totals of the read and written bytes and the unix nanoseconds of the last of them,
+	// accessed atomically, see `Info`.
+	bytesIn      uint64
+	bytesOut     uint64
+	lastActivity int64
+
+	// the outgoing messages dropped because of their `Message.ExpiresAt`, accessed atomically.
+	droppedExpired uint64
+
+	// server-side, the unix nanoseconds until the incoming events are dropped, accessed atomically, see `Mute`.
+	mutedUntil int64
+
+	// server-side, the invalid incoming messages of the current window, see `Server.MaxInvalidMessages`.
+	invalidMessages      int
+	invalidWindowStart   time.Time
+	invalidMessagesMutex sync.Mutex
+
+	// the pings that wait for their pongs, see `PingWithData`.
+	pongWaiters []pongWaiter
+	pongMutex   sync.Mutex
+
+	// the error which terminated the connection, if any, see `Wait`.
+	closeErr      error
+	closeErrMutex sync.Mutex
 
 	// used to fire `conn#Close` once.
 	closed *uint32
@@ -90,21 +255,31 @@ type Conn struct {
 	closeCh chan struct{}
 }
 
-func newConn(socket Socket, namespaces Namespaces) *Conn {
+// newConn returns a new connection, the "maps" can be nil, see `Server.Prewarm`.
+func newConn(socket Socket, namespaces Namespaces, maps *connMaps) *Conn {
+	if maps == nil {
+		maps = newConnMaps()
+	}
+
 	c := &Conn{
 		socket:                         socket,
 		namespaces:                     namespaces,
+		eventsMutex:                    new(sync.RWMutex),
 		readiness:                      newWaiterOnce(),
 		acknowledged:                   new(uint32),
-		connectedNamespaces:            make(map[string]*NSConn),
+		connectedNamespaces:            maps.connectedNamespaces,
 		processes:                      newProcesses(),
-		waitingMessages:                make(map[string]chan Message),
+		waitingMessages:                maps.waitingMessages,
 		allowNativeMessages:            false,
 		shouldHandleOnlyNativeMessages: false,
 		closed:                         new(uint32),
 		closeCh:                        make(chan struct{}),
 	}
 
+	if p, ok := socket.(SocketPinger); ok {
+		p.SetPongHandler(c.handlePong)
+	}
+
 	if emptyNamespace := namespaces[""]; emptyNamespace != nil && emptyNamespace[OnNativeMessage] != nil {
 		c.allowNativeMessages = true
 
@@ -124,6 +299,95 @@ func newConn(socket Socket, namespaces Namespaces) *Conn {
 	return c
 }
 
+// Context returns a context which holds the values of the HTTP request's context
+// (i.e filled by an authentication middleware) for server-side connections
+// or the `Dial`'s context for client-side connections.
+// Unlike the request's context, it is not canceled when the upgrade request is finished,
+// it is canceled when the connection is closed.
+func (c *Conn) Context() context.Context {
+	return connContext{c}
+}
+
+// connContext completes the `context.Context` interface,
+// it's cancelled on connection close, see `Conn.Context`.
+type connContext struct{ c *Conn }
+
+func (ctx connContext) Deadline() (deadline time.Time, ok bool) { return }
+
+func (ctx connContext) Done() <-chan struct{} { return ctx.c.closeCh }
+
+func (ctx connContext) Err() error {
+	select {
+	case <-ctx.c.closeCh:
+		return context.Canceled
+	default:
+		return nil
+	}
+}
+
+func (ctx connContext) Value(key interface{}) interface{} {
+	if ctx.c.values == nil {
+		return nil
+	}
+
+	return ctx.c.values.Value(key)
+}
+
+// GoCloseTimeout is the maximum time that a `Conn.Close` call waits
+// for the connection's background goroutines, started by `Conn.Go`, to return.
+var GoCloseTimeout = 5 * time.Second
+
+// Go runs "fn" in a new goroutine which is bound to this connection's lifecycle,
+// i.e a subscription pump which should stop when the connection is closed.
+// The "ctx" is the connection's `Context`, which is canceled on close,
+// the "fn" should return as soon as possible after that.
+// The `Close` method waits for these goroutines to return, up to `GoCloseTimeout`,
+// so "fn" should not call `Close` itself without its own goroutine.
+func (c *Conn) Go(fn func(ctx context.Context)) {
+	ctx := c.Context()
+
+	c.goroutinesMutex.Lock()
+	if c.IsClosed() {
+		c.goroutinesMutex.Unlock()
+		// too late to be waited, the "ctx" is already canceled.
+		go fn(ctx)
+		return
+	}
+	c.goroutines.Add(1)
+	c.hasGoroutines = true
+	c.goroutinesMutex.Unlock()
+
+	atomic.AddInt32(&c.goroutinesCount, 1)
+	go func() {
+		defer c.goroutines.Done()
+		defer atomic.AddInt32(&c.goroutinesCount, -1)
+		fn(ctx)
+	}()
+}
+
+// waitGoroutines waits, up to `GoCloseTimeout`, for the goroutines started by `Go` to return.
+func (c *Conn) waitGoroutines() {
+	// no new goroutines can be added after the close.
+	c.goroutinesMutex.Lock()
+	hasGoroutines := c.hasGoroutines
+	c.goroutinesMutex.Unlock()
+
+	if !hasGoroutines {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		c.goroutines.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-c.clock().After(GoCloseTimeout):
+	}
+}
+
 // Is reports whether the "connID" is part of this server's connections and their IDs are equal.
 func (c *Conn) Is(connID string) bool {
 	if connID == "" {
@@ -139,7 +403,8 @@ func (c *Conn) Is(connID string) bool {
 
 // ID method returns the unique identifier of the connection.
 // If this is a server-side connection then this value is the generated one by the `Server#IDGenerator`.
-// If this is a client-side connection then this value is filled on the acknowledgment process which is done on the `Client#Dial`.
+// If this is a client-side connection then this value is filled on the acknowledgment process which is done on the `Client#Dial`,
+// it's the server's `PublicIDFunc` result, if any.
 func (c *Conn) ID() string {
 	return c.id
 }
@@ -156,6 +421,30 @@ func (c *Conn) Socket() Socket {
 	return c.socket
 }
 
+// Subprotocol returns the negotiated websocket subprotocol, if any.
+// It's empty if subprotocol was not negotiated
+// or the underline socket does not complete the `SocketSubprotocoler` interface.
+//
+// See `Server.Subprotocols` too.
+func (c *Conn) Subprotocol() string {
+	if s, ok := c.socket.(SocketSubprotocoler); ok {
+		return s.Subprotocol()
+	}
+
+	return ""
+}
+
+// Extensions returns the names of the negotiated websocket extensions, i.e "permessage-deflate", if any.
+// It's nil if no extension was negotiated (i.e compression is not enabled on both sides or a proxy stripped it)
+// or the underline socket does not complete the `SocketExtensioner` interface.
+func (c *Conn) Extensions() []string {
+	if s, ok := c.socket.(SocketExtensioner); ok {
+		return s.Extensions()
+	}
+
+	return nil
+}
+
 // IsClient method reports whether this connections is a client-side connetion.
 func (c *Conn) IsClient() bool {
 	return c.server == nil
@@ -170,6 +459,12 @@ func (c *Conn) Server() *Server {
 	return c.server
 }
 
+// IsTrusted reports whether this server-side connection
+// has sent a valid `Server.TrustedSecret` on its upgrade request.
+func (c *Conn) IsTrusted() bool {
+	return c.trusted
+}
+
 // Set sets a value to this connection's store.
 func (c *Conn) Set(key string, value interface{}) {
 	c.storeMutex.Lock()
@@ -206,6 +501,28 @@ func (c *Conn) WasReconnected() bool {
 	return c.ReconnectTries > 0
 }
 
+// SetReadTimeout sets the maximum wait time allowed to read a message from the connection,
+// it's respected on the next read. Zero means no timeout.
+// Safe for concurrent use.
+func (c *Conn) SetReadTimeout(d time.Duration) {
+	atomic.StoreInt64((*int64)(&c.readTimeout), int64(d))
+}
+
+func (c *Conn) getReadTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64((*int64)(&c.readTimeout)))
+}
+
+// SetWriteTimeout sets the maximum wait time allowed to write a message to the connection,
+// it's respected on the next write. Zero means no timeout.
+// Safe for concurrent use.
+func (c *Conn) SetWriteTimeout(d time.Duration) {
+	atomic.StoreInt64((*int64)(&c.writeTimeout), int64(d))
+}
+
+func (c *Conn) getWriteTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64((*int64)(&c.writeTimeout)))
+}
+
 func (c *Conn) isAcknowledged() bool {
 	return atomic.LoadUint32(c.acknowledged) > 0
 }
@@ -248,9 +565,16 @@ func (c *Conn) startReader() {
 	// CLIENT is ready when ACK done
 	// SERVER is ready when ACK is done AND `Server#OnConnected` returns with nil error.
 	for {
-		b, err := c.socket.ReadData(c.readTimeout)
+		if !c.waitReads() {
+			return
+		}
+
+		b, err := c.socket.ReadData(c.getReadTimeout())
 		if err != nil {
 			c.readiness.unwait(err)
+			// before the close, which forgets the namespaces and rooms.
+			c.reconnectOnLost()
+			c.setCloseError(err)
 			return
 		}
 
@@ -258,6 +582,8 @@ func (c *Conn) startReader() {
 			continue
 		}
 
+		c.trackRead(len(b))
+
 		if !c.isAcknowledged() {
 			if !c.handleACK(b) {
 				return
@@ -265,26 +591,132 @@ func (c *Conn) startReader() {
 			continue
 		}
 
-		c.HandlePayload(b)
+		c.handleRawPayload(b)
+	}
+}
+
+// handleRawPayload passes the incoming "b" to the `Server.OnRawMessage`, if any, before its normal handling.
+func (c *Conn) handleRawPayload(b []byte) {
+	if !c.IsClient() && c.server.OnRawMessage != nil && !c.server.OnRawMessage(c, b) {
+		return
+	}
+
+	c.HandlePayload(b)
+}
+
+// PauseReads stops the connection's reader from consuming any more incoming messages
+// until `ResumeReads` is called, so the remote side gets blocked by the network's backpressure
+// instead of buffering messages on this side.
+// A message that is already being read at the time of the call is still handled.
+// Note that while reads are paused, writes are still allowed but
+// control frames (i.e pings) are not answered either.
+func (c *Conn) PauseReads() {
+	c.readsResumeMutex.Lock()
+	if c.readsResume == nil {
+		c.readsResume = make(chan struct{})
+	}
+	c.readsResumeMutex.Unlock()
+}
+
+// ResumeReads resumes the connection's reader after a `PauseReads` call.
+func (c *Conn) ResumeReads() {
+	c.readsResumeMutex.Lock()
+	if c.readsResume != nil {
+		close(c.readsResume)
+		c.readsResume = nil
+	}
+	c.readsResumeMutex.Unlock()
+}
+
+// waitReads blocks while reads are paused,
+// it reports false if the connection was closed in the meantime.
+func (c *Conn) waitReads() bool {
+	c.readsResumeMutex.Lock()
+	ch := c.readsResume
+	c.readsResumeMutex.Unlock()
+
+	if ch == nil {
+		return true
+	}
+
+	select {
+	case <-ch:
+		return true
+	case <-c.closeCh:
+		return false
+	}
+}
+
+// Acknowledger is an optional interface which can be set to the `Server.Acknowledger` field
+// to customize the server-side handshake, i.e an app-layer authentication embedded in the acknowledgement.
+type Acknowledger interface {
+	// Acknowledge is called, on the connection's read loop, with each incoming message
+	// of a server-side connection which is not yet acknowledged, instead of the default handshake.
+	// It should call the `Conn.Acknowledge` to complete the handshake once its own validation passed.
+	// A non-nil error rejects the connection: its text is sent as the acknowledgement failure,
+	// which the neffos client's `Dial` returns, and the connection is closed.
+	Acknowledge(c *Conn, payload []byte) error
+}
+
+// Acknowledge marks this server-side connection as acknowledged: it sends its ID to the remote side,
+// as the default handshake does on the neffos client's acknowledgement message,
+// and handles the messages that were received before that.
+// It waits for the `Server.OnConnect` to return and it returns its error, if any,
+// or `ErrWrite` if the ID could not be sent.
+//
+// It's an extension point for custom handshakes, see `Acknowledger`, and
+// it should be called inside the `Acknowledger.Acknowledge` only.
+// It does nothing for client-side or already acknowledged connections.
+func (c *Conn) Acknowledge() error {
+	if c.IsClient() || c.isAcknowledged() {
+		return nil
+	}
+
+	if err := c.readiness.wait(); err != nil {
+		return err
+	}
+
+	// it's ok send ID.
+	id := c.id
+	if c.server.PublicIDFunc != nil {
+		id = c.server.PublicIDFunc(id)
+	}
+
+	if !c.write(append([]byte{ackIDBinary}, []byte(id)...), false) {
+		return ErrWrite
+	}
+
+	c.acknowledge()
+	c.issueResumeToken()
+	c.handleQueue()
+	return nil
+}
+
+// rejectACK sends the "err" of a failed server-side acknowledgement which client's Dial should return.
+func (c *Conn) rejectACK(err error) {
+	if err != ErrWrite {
+		c.write(append([]byte{ackNotOKBinary}, []byte(err.Error())...), false)
 	}
 }
 
 // ack uses binary, bytebuffer messages type, after this client/server can still use binary if `Message#SetBinary` or text message by-default.
 func (c *Conn) handleACK(b []byte) bool {
+	if !c.IsClient() && c.server.Acknowledger != nil {
+		if err := c.server.Acknowledger.Acknowledge(c, b); err != nil {
+			c.rejectACK(err)
+			return false
+		}
+		return true
+	}
+
 	switch typ := b[0]; typ {
 	case ackBinary:
 		// from client startup to server.
-		err := c.readiness.wait()
-		if err != nil {
+		if err := c.Acknowledge(); err != nil {
 			// it's not Ok, send error which client's Dial should return.
-			c.write(append([]byte{ackNotOKBinary}, []byte(err.Error())...), false)
+			c.rejectACK(err)
 			return false
 		}
-		atomic.StoreUint32(c.acknowledged, 1)
-		c.handleQueue()
-
-		// it's ok send ID.
-		return c.write(append([]byte{ackIDBinary}, []byte(c.id)...), false)
 
 	// case ackOKBinary:
 	// 	// from client to server.
@@ -297,7 +729,7 @@ func (c *Conn) handleACK(b []byte) bool {
 		id := string(b[1:])
 		c.id = id
 
-		atomic.StoreUint32(c.acknowledged, 1)
+		c.acknowledge()
 		c.readiness.unwait(nil)
 		// c.write([]byte{ackOKBinary})
 		// println("ackIDBinary: pass with nil")
@@ -310,7 +742,20 @@ func (c *Conn) handleACK(b []byte) bool {
 		return false
 	default:
 		c.queueMutex.Lock()
+		if len(c.queue) >= MaxPreAckMessages {
+			// the remote side floods before the acknowledgement, drop all and terminate.
+			dropped := len(c.queue) + 1
+			c.queue = nil
+			atomic.StoreInt32(&c.queueLen, 0)
+			c.queueMutex.Unlock()
+
+			if !c.IsClient() && c.server.OnPreAckOverflow != nil {
+				c.server.OnPreAckOverflow(c, dropped)
+			}
+			return false
+		}
 		c.queue = append(c.queue, b)
+		atomic.AddInt32(&c.queueLen, 1)
 		c.queueMutex.Unlock()
 	}
 
@@ -318,17 +763,191 @@ func (c *Conn) handleACK(b []byte) bool {
 
 }
 
+// MaxPreAckMessages is the maximum number of incoming messages that a connection
+// keeps while it is not yet acknowledged, they are handled, in order, right after the acknowledgement.
+// When the limit is exceeded the remote side is considered misbehaving (or malicious):
+// the kept messages are dropped and the connection is closed, see `Server.OnPreAckOverflow`.
+var MaxPreAckMessages = 256
+
+// MaxPendingWrites is the maximum number of outgoing messages that a connection
+// keeps while it is not yet acknowledged, i.e messages sent from a `Server.OnConnect` callback.
+// They are sent, in order, right after the acknowledgement.
+// When the limit is reached the next messages are dropped and their `Write` calls report false.
+// A connection's `QoS.MaxPendingWrites` overrides it.
+var MaxPendingWrites = 256
+
+type pendingWrite struct {
+	b      []byte
+	binary bool
+	// the pooled buffer of the "b", if any, released when the write is done, see `MaxPooledBufferSize`.
+	buf *bytes.Buffer
+	// if true then the "b" is shared by the writes to many connections and its buffer is released
+	// by the caller after them, so it's copied when the write is queued, see `Conn.writeSerialized`.
+	shared bool
+	// the counter of the inflight writes that it's counted to, if any, see `Conn.InflightWrites`.
+	inflight  *int32
+	expiresAt time.Time
+	// if true then it's replaced by a newer write of the same key, see `Message.Coalesce`.
+	coalesce bool
+	key      coalesceKey
+	// the optional channel of its write's result, see `WriteResult`.
+	result chan<- error
+	// the written message, its Event is empty on internal writes, see `PendingWrites`.
+	msg Message
+}
+
+// pendingWriteOf returns the write of the "msg" serialized as "b".
+func pendingWriteOf(msg Message, b []byte) pendingWrite {
+	return pendingWrite{
+		b:         b,
+		binary:    msg.SetBinary,
+		expiresAt: msg.ExpiresAt,
+		coalesce:  msg.Coalesce,
+		key:       coalesceKeyOf(msg),
+		msg:       msg,
+	}
+}
+
+// acknowledge marks the connection as acknowledged
+// and sends the messages that were written before that, in order.
+func (c *Conn) acknowledge() {
+	c.pendingWritesMutex.Lock()
+	for _, w := range c.pendingWrites {
+		if c.dropExpired(w.expiresAt) {
+			w.done(ErrExpired)
+		} else {
+			w.done(c.writeData(w.b, w.binary))
+		}
+		atomic.AddInt32(&c.pendingWritesLen, -1)
+	}
+	c.pendingWrites = nil
+	// after the flush, so any new writes are not sent before the pending ones.
+	atomic.StoreUint32(c.acknowledged, 1)
+	c.pendingWritesMutex.Unlock()
+}
+
+// writeOrQueue writes "b" if the connection is acknowledged, otherwise it queues it until then.
+func (c *Conn) writeOrQueue(b []byte, binary bool) bool {
+	return c.writePending(pendingWrite{b: b, binary: binary})
+}
+
+// writePending is like `writeOrQueue` but a queued "w" is dropped if its expiration time has passed
+// when the queue is sent (see `Message.ExpiresAt`) and it replaces a queued write of the same key (see `Message.Coalesce`).
+func (c *Conn) writePending(w pendingWrite) bool {
+	if !c.acquireInflightWrite(&w) {
+		return false
+	}
+
+	if c.isAcknowledged() {
+		return c.writeNow(w)
+	}
+
+	c.pendingWritesMutex.Lock()
+	if c.isAcknowledged() {
+		c.pendingWritesMutex.Unlock()
+		return c.writeNow(w)
+	}
+
+	if w.coalesce {
+		c.removePendingWrite(w.key)
+	}
+
+	if c.IsClosed() || len(c.pendingWrites) >= c.maxPendingWrites() {
+		c.pendingWritesMutex.Unlock()
+		w.done(ErrWrite)
+		return false
+	}
+
+	if w.shared {
+		w.b = append([]byte(nil), w.b...)
+		w.shared = false
+	}
+
+	c.pendingWrites = append(c.pendingWrites, w)
+	atomic.AddInt32(&c.pendingWritesLen, 1)
+	c.pendingWritesMutex.Unlock()
+	return true
+}
+
 func (c *Conn) handleQueue() {
 	c.queueMutex.Lock()
 	defer c.queueMutex.Unlock()
 
 	for _, b := range c.queue {
-		c.HandlePayload(b)
+		c.handleRawPayload(b)
+		atomic.AddInt32(&c.queueLen, -1)
 	}
 
 	c.queue = c.queue[0:0]
 }
 
+// ReadQueueLen returns the number of the incoming messages that are kept until the connection's acknowledgement,
+// they are handled right after it, see `MaxPreAckMessages`. After the acknowledgement
+// the incoming messages are handled directly by the connection's reader and it's zero.
+// It's a cheap atomic read, i.e for a latency gauge.
+func (c *Conn) ReadQueueLen() int {
+	return int(atomic.LoadInt32(&c.queueLen))
+}
+
+// WriteQueueLen returns the number of the outgoing messages that are kept until the connection's acknowledgement,
+// they are sent right after it, see `MaxPendingWrites`. After the acknowledgement
+// the outgoing messages are written directly and it's zero.
+// It's a cheap atomic read, i.e for a backpressure gauge.
+func (c *Conn) WriteQueueLen() int {
+	return int(atomic.LoadInt32(&c.pendingWritesLen))
+}
+
+// PendingWrites returns a snapshot of the outgoing messages of this connection that are not sent yet:
+// the ones written before the acknowledgement (see `MaxPendingWrites`) and, on the client-side,
+// the ones kept by the outbound buffers of the not connected namespaces (see `Client.BufferOutbound`), in that order.
+// After the connection is closed it returns the messages that were not sent at close time,
+// so the application can persist them or replay them on a new connection instead of losing them
+// (the outbound buffers' "onDrop" callbacks are still called on close).
+// A `Client.EnableReconnect` replays them on the new client automatically.
+func (c *Conn) PendingWrites() []Message {
+	c.pendingWritesMutex.Lock()
+	if c.pendingWritesClosed {
+		messages := make([]Message, len(c.closedPendingWrites))
+		copy(messages, c.closedPendingWrites)
+		c.pendingWritesMutex.Unlock()
+		return messages
+	}
+	c.pendingWritesMutex.Unlock()
+
+	return c.snapshotPendingWrites(true)
+}
+
+// snapshotPendingWrites returns the not sent messages, including the outbound buffers' ones if "outbound" is true.
+func (c *Conn) snapshotPendingWrites(outbound bool) []Message {
+	var messages []Message
+
+	c.pendingWritesMutex.Lock()
+	for _, w := range c.pendingWrites {
+		// replies and system messages are meaningful to this connection only.
+		if w.msg.Event != "" && w.msg.wait == "" && !IsSystemEvent(w.msg.Event) {
+			messages = append(messages, w.msg)
+		}
+	}
+	c.pendingWritesMutex.Unlock()
+
+	if !outbound || c.outbound == nil {
+		return messages
+	}
+
+	c.outbound.mu.Lock()
+	namespaces := make([]string, 0, len(c.outbound.buffers))
+	for namespace := range c.outbound.buffers {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	for _, namespace := range namespaces {
+		messages = append(messages, c.outbound.buffers[namespace].messages...)
+	}
+	c.outbound.mu.Unlock()
+
+	return messages
+}
+
 // ErrInvalidPayload can be returned by the internal `handleMessage`.
 // In the future it may be exposed by an error listener.
 var ErrInvalidPayload = errors.New("invalid payload")
@@ -338,6 +957,23 @@ func (c *Conn) handleMessage(msg Message) error {
 		return ErrInvalidPayload
 	}
 
+	if msg.seq > 0 && !c.checkSequence(msg.seq) {
+		c.setCloseError(ErrOutOfOrder)
+		c.Close()
+		return ErrOutOfOrder
+	}
+
+	if !c.IsClient() && c.server.OnMessage != nil {
+		c.server.OnMessage(c, &msg)
+	}
+
+	if c.dedupe != nil {
+		if key := msg.Headers[IdempotencyKeyHeader]; key != "" && c.dedupe.seen(key) {
+			// already handled, drop it.
+			return nil
+		}
+	}
+
 	if msg.IsNative && c.allowNativeMessages {
 		ns := c.Namespace("")
 		return ns.events.fireEvent(ns, msg)
@@ -349,19 +985,16 @@ func (c *Conn) handleMessage(msg Message) error {
 		ch, ok := c.server.waitingMessages[msg.wait]
 		c.server.waitingMessagesMutex.RUnlock()
 		if ok {
-			ch <- msg
+			select {
+			case ch <- msg:
+			default: // the ask is already answered or canceled.
+			}
 			return nil
 		}
 	}
 
-	if msg.isWait(isClient) {
-		c.waitingMessagesMutex.RLock()
-		ch, ok := c.waitingMessages[msg.wait]
-		c.waitingMessagesMutex.RUnlock()
-		if ok {
-			ch <- msg
-			return nil
-		}
+	if msg.isWait(isClient) && c.deliverReply(msg) {
+		return nil
 	}
 
 	switch msg.Event {
@@ -377,6 +1010,21 @@ func (c *Conn) handleMessage(msg Message) error {
 		if ns, ok := c.tryNamespace(msg); ok {
 			ns.replyRoomLeave(msg)
 		}
+	case OnNamespaceSubscribe:
+		if ns, ok := c.tryNamespace(msg); ok {
+			ns.replySubscribe(msg)
+		}
+	case OnError:
+		// local only, it's never accepted from the remote side.
+		return nil
+	case onReady:
+		c.markReady()
+	case onReconnect:
+		c.handleReconnectRequest(msg)
+	case onResumeToken:
+		c.handleResumeToken(msg)
+	case onResume:
+		c.handleResume(msg)
 	default:
 		ns, ok := c.tryNamespace(msg)
 		if !ok {
@@ -385,9 +1033,57 @@ func (c *Conn) handleMessage(msg Message) error {
 		}
 
 		msg.IsLocal = false
+
+		if msg.Err != nil && msg.wait == "" && ns.events.fireError(ns, msg) {
+			// an error that no one waits for, handled by the `OnError`.
+			return nil
+		}
+
+		if !isClient {
+			if c.server.MessageFilter != nil && !c.server.MessageFilter(c, msg) {
+				// drop it, only an `Ask` gets an answer.
+				if msg.wait != "" {
+					msg.Err = ErrMessageFiltered
+					c.Write(msg)
+				}
+				return nil
+			}
+
+			if c.IsMuted() {
+				// drop it, only an `Ask`, or any message if the server notifies, gets an answer.
+				if msg.wait != "" || c.server.NotifyMuted {
+					msg.Err = ErrMuted
+					c.Write(msg)
+				}
+				return nil
+			}
+
+			if msg.Room != "" && !c.allowRoomEmit(msg) {
+				if c.server.OnRoomRateLimited != nil {
+					c.server.OnRoomRateLimited(c, msg.Namespace, msg.Room, msg.Event)
+				}
+
+				// drop it, only an `Ask` gets an answer.
+				if msg.wait != "" {
+					msg.Err = ErrRoomRateLimited
+					c.Write(msg)
+				}
+				return nil
+			}
+
+			if c.server.IsDraining() {
+				msg.Err = ErrServerDraining
+				c.Write(msg)
+				return ErrServerDraining
+			}
+
+			atomic.AddInt64(&c.server.inflight, 1)
+			defer atomic.AddInt64(&c.server.inflight, -1)
+		}
+
 		err := ns.events.fireEvent(ns, msg)
 		if err != nil {
-			msg.Err = err
+			msg.Err = c.transformError(err)
 			c.Write(msg)
 			return err
 		}
@@ -396,6 +1092,24 @@ func (c *Conn) handleMessage(msg Message) error {
 	return nil
 }
 
+// transformError returns the client-facing error of an event callback's "err",
+// see `Server.ErrorTransformer`.
+func (c *Conn) transformError(err error) error {
+	if c.IsClient() || c.server.ErrorTransformer == nil {
+		return err
+	}
+
+	if _, ok := isReply(err); ok {
+		return err
+	}
+
+	if transformed := c.server.ErrorTransformer(err); transformed != nil {
+		return transformed
+	}
+
+	return err
+}
+
 // DeserializeMessage returns a Message from the "payload".
 func (c *Conn) DeserializeMessage(payload []byte) Message {
 	return deserializeMessage(nil, payload, c.allowNativeMessages, c.shouldHandleOnlyNativeMessages)
@@ -403,7 +1117,12 @@ func (c *Conn) DeserializeMessage(payload []byte) Message {
 
 // HandlePayload fires manually a local event based on the "payload".
 func (c *Conn) HandlePayload(payload []byte) error {
-	return c.handleMessage(c.DeserializeMessage(payload))
+	msg := c.DeserializeMessage(payload)
+	if msg.isInvalid {
+		c.handleInvalidMessage(payload)
+	}
+
+	return c.handleMessage(msg)
 }
 
 const syncWaitDur = 15 * time.Millisecond
@@ -416,6 +1135,12 @@ const maxSyncWaitDur = 10 * time.Second
 // The "namespace" should be declared in the `connHandler` of both server and client sides.
 // If this is a client-side connection then the server-side namespace's `OnNamespaceConnect` event callback MUST return null
 // in order to allow this client-side connection to connect, otherwise a non-nil error is returned instead.
+// If the connection is already connected to the "namespace" then its `NSConn` is returned without a round-trip
+// and the concurrent calls for the same "namespace" share the result of a single connect,
+// i.e the error of the first call's "ctx".
+// A server-side connection waits for its acknowledgement first, if not already acknowledged,
+// and it returns the "ctx"'s error if the acknowledgement does not complete in time,
+// a "ctx" without a deadline waits up to 10 seconds.
 func (c *Conn) Connect(ctx context.Context, namespace string) (*NSConn, error) {
 	// if c.IsClosed() {
 	// 	return nil, ErrWrite
@@ -423,31 +1148,52 @@ func (c *Conn) Connect(ctx context.Context, namespace string) (*NSConn, error) {
 
 	if !c.IsClient() {
 		c.readiness.unwait(nil)
-		// server-side check for ack-ed, it should be done almost immediately the client connected
-		// but give it sometime for slow networks and add an extra check for closed after 5 seconds and a deadline of 10seconds.
-		t := maxSyncWaitDur
-		for !c.isAcknowledged() {
-			time.Sleep(syncWaitDur)
-			t = -syncWaitDur
-
-			if t <= maxSyncWaitDur/2 { // check once after 5 seconds if closed.
-				if c.IsClosed() {
-					return nil, ErrWrite
-				}
-			}
+		// server-side check for ack-ed, it should be done almost immediately the client connected.
+		if err := c.waitAcknowledged(ctx); err != nil {
+			return nil, err
+		}
+	}
 
-			if t == 0 {
-				// when maxSyncWaitDur passed,
-				// we could use the context's deadline but it will make things slower (extracting its value slower than the sleep time).
-				if c.IsClosed() {
-					return nil, ErrWrite
-				}
-				return nil, context.DeadlineExceeded
+	return c.askConnect(ctx, namespace)
+}
+
+// waitAcknowledged blocks until the server-side connection is acknowledged,
+// the "ctx" is closed or the connection is closed.
+// A "ctx" without a deadline waits for the acknowledgement up to the `maxSyncWaitDur`.
+func (c *Conn) waitAcknowledged(ctx context.Context) error {
+	if c.isAcknowledged() {
+		return nil
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	clock := c.clock()
+	var timeout <-chan time.Time
+	if _, has := ctx.Deadline(); !has {
+		timeout = clock.After(maxSyncWaitDur)
+	}
+
+	for !c.isAcknowledged() {
+		select {
+		case <-ctx.Done():
+			if c.IsClosed() {
+				return ErrWrite
+			}
+			return ctx.Err()
+		case <-timeout:
+			if c.IsClosed() {
+				return ErrWrite
 			}
+			return context.DeadlineExceeded
+		case <-c.closeCh:
+			return ErrWrite
+		case <-clock.After(syncWaitDur):
 		}
 	}
 
-	return c.askConnect(ctx, namespace)
+	return nil
 }
 
 // const defaultNS = ""
@@ -461,6 +1207,9 @@ func (c *Conn) Connect(ctx context.Context, namespace string) (*NSConn, error) {
 // and this side wants to "waits" for that signal.
 //
 // Nil context means try without timeout, wait until it connects to the specific namespace.
+// If a connect to that namespace is rejected, by either side, while it waits
+// then it returns the rejection error, i.e the error of the `OnNamespaceConnect` event callback,
+// the rejections before the call are not kept. It returns `ErrWrite` if the connection is closed.
 // Note that, this function will not return an `ErrBadNamespace` if namespace does not exist in the server-side
 // or it's not defined in the client-side, it waits until deadline (if any, or loop forever, so a context with deadline is highly recommended).
 func (c *Conn) WaitConnect(ctx context.Context, namespace string) (ns *NSConn, err error) {
@@ -468,22 +1217,71 @@ func (c *Conn) WaitConnect(ctx context.Context, namespace string) (ns *NSConn, e
 		ctx = context.TODO()
 	}
 
+	rejected, stopWaiting := c.waitConnectRejection(namespace)
+	defer stopWaiting()
+
 	for {
+		if ns == nil {
+			ns = c.Namespace(namespace)
+		}
+
+		if ns != nil && c.isAcknowledged() {
+			return
+		}
+
 		select {
 		case <-ctx.Done():
 			return nil, ctx.Err()
-		default:
-			if ns == nil {
-				ns = c.Namespace(namespace)
-			}
+		case <-c.closeCh:
+			return nil, ErrWrite
+		case err = <-rejected:
+			return nil, err
+		case <-c.clock().After(syncWaitDur):
+		}
+	}
+}
 
-			if ns != nil && c.isAcknowledged() {
-				return
+// waitConnectRejection registers a channel which receives the error of the next rejected connect to the "namespace",
+// the caller should call the returned function when it stops waiting, see `WaitConnect`.
+func (c *Conn) waitConnectRejection(namespace string) (<-chan error, func()) {
+	ch := make(chan error, 1)
+
+	c.connectedNamespacesMutex.Lock()
+	if c.connectWaiters == nil {
+		c.connectWaiters = make(map[string][]chan error)
+	}
+	c.connectWaiters[namespace] = append(c.connectWaiters[namespace], ch)
+	c.connectedNamespacesMutex.Unlock()
+
+	return ch, func() {
+		c.connectedNamespacesMutex.Lock()
+		waiters := c.connectWaiters[namespace]
+		for i, w := range waiters {
+			if w == ch {
+				waiters = append(waiters[:i], waiters[i+1:]...)
+				break
 			}
+		}
+		if len(waiters) == 0 {
+			delete(c.connectWaiters, namespace)
+		} else {
+			c.connectWaiters[namespace] = waiters
+		}
+		c.connectedNamespacesMutex.Unlock()
+	}
+}
 
-			time.Sleep(syncWaitDur)
+// rejectConnect sends the "err" of a rejected connect to the "namespace" to its waiting `WaitConnect` calls, if any,
+// nothing is kept when no one waits.
+func (c *Conn) rejectConnect(namespace string, err error) {
+	c.connectedNamespacesMutex.Lock()
+	for _, ch := range c.connectWaiters[namespace] {
+		select {
+		case ch <- err:
+		default: // already rejected.
 		}
 	}
+	c.connectedNamespacesMutex.Unlock()
 }
 
 // Namespace method returns an already-connected `NSConn` value based on the given "namespace".
@@ -495,6 +1293,14 @@ func (c *Conn) Namespace(namespace string) *NSConn {
 	return ns
 }
 
+// EmitTo sends a message with the "event" and the "body" to the remote side's "namespace" of this connection,
+// i.e to reach another namespace of the connection from an event callback, without keeping its `NSConn`.
+// It reports false, and nothing is sent, if the "namespace" is not connected.
+// It's a shortcut of `c.Namespace(namespace).Emit(event, body)`.
+func (c *Conn) EmitTo(namespace, event string, body []byte) bool {
+	return c.Namespace(namespace).Emit(event, body)
+}
+
 func (c *Conn) tryNamespace(in Message) (*NSConn, bool) {
 	// for atomic.LoadUint32(c.isConnectingProcess) > 0 {
 	// }
@@ -509,14 +1315,64 @@ func (c *Conn) tryNamespace(in Message) (*NSConn, bool) {
 		return nil, false
 	}
 
+	ns.touch()
 	return ns, true
 }
 
+// connectCall is an in-flight connect to a namespace, see `askConnect`.
+type connectCall struct {
+	done chan struct{}
+	ns   *NSConn
+	err  error
+}
+
 // server#OnConnected -> conn#Connect
 // client#WaitConnect
 // or
 // client#Connect
+
+// askConnect connects to the "namespace" once: it returns the connected `NSConn` without a round-trip
+// and the callers of an in-flight connect to the same namespace wait for its result.
 func (c *Conn) askConnect(ctx context.Context, namespace string) (*NSConn, error) {
+	if ns := c.Namespace(namespace); ns != nil {
+		return ns, nil
+	}
+
+	c.connectingMutex.Lock()
+	if call, ok := c.connecting[namespace]; ok {
+		c.connectingMutex.Unlock()
+
+		var done <-chan struct{}
+		if ctx != nil {
+			done = ctx.Done()
+		}
+
+		select {
+		case <-call.done:
+			return call.ns, call.err
+		case <-done:
+			return nil, ctx.Err()
+		}
+	}
+
+	if c.connecting == nil {
+		c.connecting = make(map[string]*connectCall)
+	}
+	call := &connectCall{done: make(chan struct{})}
+	c.connecting[namespace] = call
+	c.connectingMutex.Unlock()
+
+	call.ns, call.err = c.doAskConnect(ctx, namespace)
+
+	c.connectingMutex.Lock()
+	delete(c.connecting, namespace)
+	c.connectingMutex.Unlock()
+	close(call.done)
+
+	return call.ns, call.err
+}
+
+func (c *Conn) doAskConnect(ctx context.Context, namespace string) (*NSConn, error) {
 	p := c.processes.get(namespace)
 	p.start()      // block any `tryNamespace` with that "namespace".
 	defer p.stop() // unblock.
@@ -530,7 +1386,7 @@ func (c *Conn) askConnect(ctx context.Context, namespace string) (*NSConn, error
 		return ns, nil
 	}
 
-	events, ok := c.namespaces[namespace]
+	events, ok := c.getEvents(namespace)
 	if !ok {
 		return nil, ErrBadNamespace
 	}
@@ -541,15 +1397,28 @@ func (c *Conn) askConnect(ctx context.Context, namespace string) (*NSConn, error
 		IsLocal:   true,
 	}
 
+	if !c.reserveNamespace(namespace) {
+		return nil, ErrNamespaceFull
+	}
+
 	ns = newNSConn(c, namespace, events)
 	err := events.fireEvent(ns, connectMessage)
 	if err != nil {
+		c.releaseNamespace(namespace)
+		c.rejectConnect(namespace, err)
+		// notify the remote side, it may wait for this namespace, see `WaitConnect`.
+		c.Write(Message{Namespace: namespace, Event: OnNamespaceConnect, Err: c.transformError(err)})
 		return nil, err
 	}
 
 	// println("ask connect")
-	_, err = c.Ask(ctx, connectMessage) // waits for answer no matter if already connected on the other side.
+	reply, err := c.Ask(ctx, connectMessage) // waits for answer no matter if already connected on the other side.
 	if err != nil {
+		c.releaseNamespace(namespace)
+		if reply.Err != nil {
+			// rejected by the remote side.
+			c.rejectConnect(namespace, err)
+		}
 		return nil, err
 	}
 	// println("got connect")
@@ -562,6 +1431,12 @@ func (c *Conn) askConnect(ctx context.Context, namespace string) (*NSConn, error
 	// }
 
 	c.connectedNamespacesMutex.Lock()
+	if c.connectedNamespaces == nil {
+		// closed in the meantime and its maps were released, see `Server.Prewarm`.
+		c.connectedNamespacesMutex.Unlock()
+		c.releaseNamespace(namespace)
+		return nil, ErrWrite
+	}
 	c.connectedNamespaces[namespace] = ns
 	c.connectedNamespacesMutex.Unlock()
 
@@ -577,6 +1452,12 @@ func (c *Conn) askConnect(ctx context.Context, namespace string) (*NSConn, error
 }
 
 func (c *Conn) replyConnect(msg Message) {
+	if msg.wait == "" && msg.Err != nil {
+		// the remote side rejected its own connect, see `askConnect`.
+		c.rejectConnect(msg.Namespace, msg.Err)
+		return
+	}
+
 	// must give answer even a noOp if already connected.
 	if msg.wait == "" || msg.isNoOp {
 		return
@@ -588,22 +1469,47 @@ func (c *Conn) replyConnect(msg Message) {
 		return
 	}
 
-	events, ok := c.namespaces[msg.Namespace]
-	if !ok {
+	if !c.IsClient() && c.server.IsDraining() {
+		msg.Err = ErrServerDraining
+		c.Write(msg)
+		return
+	}
+
+	events, ok := c.getEvents(msg.Namespace)
+	if !ok || (events == nil && !c.IsClient() && c.server.StrictNamespaces) {
 		msg.Err = ErrBadNamespace
+		c.rejectConnect(msg.Namespace, msg.Err)
 		c.Write(msg)
 		return
 	}
 
-	ns = newNSConn(c, msg.Namespace, events)
-	err := events.fireEvent(ns, msg)
-	if err != nil {
-		msg.Err = err
+	if !c.reserveNamespace(msg.Namespace) {
+		msg.Err = ErrNamespaceFull
+		c.rejectConnect(msg.Namespace, msg.Err)
 		c.Write(msg)
 		return
 	}
 
+	ns = newNSConn(c, msg.Namespace, events)
+	// trusted connections skip the namespace connect authorization.
+	if !c.trusted {
+		err := events.fireEvent(ns, msg)
+		if err != nil {
+			c.releaseNamespace(msg.Namespace)
+			c.rejectConnect(msg.Namespace, err)
+			msg.Err = c.transformError(err)
+			c.Write(msg)
+			return
+		}
+	}
+
 	c.connectedNamespacesMutex.Lock()
+	if c.connectedNamespaces == nil {
+		// closed in the meantime, see `Server.Prewarm`.
+		c.connectedNamespacesMutex.Unlock()
+		c.releaseNamespace(msg.Namespace)
+		return
+	}
 	c.connectedNamespaces[msg.Namespace] = ns
 	c.connectedNamespacesMutex.Unlock()
 
@@ -616,6 +1522,11 @@ func (c *Conn) notifyNamespaceConnected(ns *NSConn, connectMsg Message) {
 	connectMsg.Event = OnNamespaceConnected
 	ns.events.fireEvent(ns, connectMsg) // omit error, it's connected.
 
+	if c.IsClient() {
+		c.flushOutbound(ns.namespace)
+		c.rejoinRooms(ns)
+	}
+
 	if !c.IsClient() && c.server.usesStackExchange() {
 		c.server.StackExchange.Subscribe(c, ns.namespace)
 	}
@@ -635,13 +1546,13 @@ func (c *Conn) DisconnectAll(ctx context.Context) error {
 		return nil
 	}
 
-	c.connectedNamespacesMutex.Lock()
-	defer c.connectedNamespacesMutex.Unlock()
-
-	disconnectMsg := Message{Event: OnNamespaceDisconnect, IsLocal: true, locked: true}
-	for namespace := range c.connectedNamespaces {
+	// the lock must not be held while waiting for the remote side,
+	// the reader needs it to dispatch any incoming event that arrives before the reply.
+	disconnectMsg := Message{Event: OnNamespaceDisconnect, IsLocal: true}
+	for _, namespace := range c.connectedNamespaceNames() {
 		disconnectMsg.Namespace = namespace
-		if err := c.askDisconnect(ctx, disconnectMsg, false); err != nil {
+		if err := c.askDisconnect(ctx, disconnectMsg, true); err != nil && err != ErrBadNamespace {
+			// ErrBadNamespace means that it's already disconnected in the meantime.
 			return err
 		}
 	}
@@ -649,6 +1560,18 @@ func (c *Conn) DisconnectAll(ctx context.Context) error {
 	return nil
 }
 
+// connectedNamespaceNames returns a snapshot of the connected namespaces.
+func (c *Conn) connectedNamespaceNames() []string {
+	c.connectedNamespacesMutex.RLock()
+	names := make([]string, 0, len(c.connectedNamespaces))
+	for namespace := range c.connectedNamespaces {
+		names = append(names, namespace)
+	}
+	c.connectedNamespacesMutex.RUnlock()
+
+	return names
+}
+
 func (c *Conn) askDisconnect(ctx context.Context, msg Message, lock bool) error {
 	if lock {
 		c.connectedNamespacesMutex.RLock()
@@ -672,12 +1595,13 @@ func (c *Conn) askDisconnect(ctx context.Context, msg Message, lock bool) error
 	// if disconnect is allowed then leave rooms first with force property
 	// before namespace's deletion.
 	ns.forceLeaveAll(true)
+	ns.flushRoomChanges()
 
 	if lock {
 		c.connectedNamespacesMutex.Lock()
 	}
 
-	delete(c.connectedNamespaces, msg.Namespace)
+	c.deleteNamespace(msg.Namespace)
 
 	if lock {
 		c.connectedNamespacesMutex.Unlock()
@@ -703,9 +1627,12 @@ func (c *Conn) replyDisconnect(msg Message) {
 
 	// if client then we need to respond to server and delete the namespace without ask the local event.
 	if c.IsClient() {
+		c.keepRoomsToRejoin(ns)
+
 		// if disconnect is allowed then leave rooms first with force property
 		// before namespace's deletion.
 		ns.forceLeaveAll(false)
+		ns.flushRoomChanges()
 
 		c.connectedNamespacesMutex.Lock()
 		delete(c.connectedNamespaces, msg.Namespace)
@@ -720,15 +1647,16 @@ func (c *Conn) replyDisconnect(msg Message) {
 	// server-side, check for error on the local event first.
 	err := ns.events.fireEvent(ns, msg)
 	if err != nil {
-		msg.Err = err
+		msg.Err = c.transformError(err)
 		c.Write(msg)
 		return
 	}
 
 	ns.forceLeaveAll(false)
+	ns.flushRoomChanges()
 
 	c.connectedNamespacesMutex.Lock()
-	delete(c.connectedNamespaces, msg.Namespace)
+	c.deleteNamespace(msg.Namespace)
 	c.connectedNamespacesMutex.Unlock()
 
 	c.notifyNamespaceDisconnect(ns, msg)
@@ -736,22 +1664,37 @@ func (c *Conn) replyDisconnect(msg Message) {
 	c.writeEmptyReply(msg.wait)
 }
 
+func (c *Conn) writeNow(w pendingWrite) bool {
+	err := c.writeData(w.b, w.binary)
+	w.done(err)
+	return err == nil
+}
+
 func (c *Conn) write(b []byte, binary bool) bool {
+	return c.writeData(b, binary) == nil
+}
+
+func (c *Conn) writeData(b []byte, binary bool) error {
 	var err error
 	if binary {
-		err = c.socket.WriteBinary(b, c.writeTimeout)
+		err = c.socket.WriteBinary(b, c.getWriteTimeout())
 	} else {
-		err = c.socket.WriteText(b, c.writeTimeout)
+		err = c.socket.WriteText(b, c.getWriteTimeout())
 	}
 
 	if err != nil {
+		// the socket may be closed by another goroutine
+		// between the `canWrite` check and the actual write (i.e on broadcasting),
+		// its close error marks this connection as closed too.
 		if IsCloseError(err) {
+			c.setCloseError(err)
 			c.Close()
 		}
-		return false
+		return err
 	}
 
-	return true
+	c.trackWrite(len(b))
+	return nil
 }
 
 func (c *Conn) canWrite(msg Message) bool {
@@ -764,7 +1707,8 @@ func (c *Conn) canWrite(msg Message) bool {
 		c.readiness.unwait(nil)
 	}
 
-	if !msg.isConnect() && !msg.isDisconnect() {
+	// the resume request and its reply are connection-level.
+	if !msg.isConnect() && !msg.isDisconnect() && msg.Event != onResume {
 		if !msg.locked {
 			c.connectedNamespacesMutex.RLock()
 		}
@@ -779,7 +1723,13 @@ func (c *Conn) canWrite(msg Message) bool {
 			return false
 		}
 
-		if msg.Room != "" && !msg.isRoomJoin() && !msg.isRoomLeft() {
+		// replies and errors are always sent, no matter the remote side's subscriptions.
+		if !c.IsClient() && msg.wait == "" && msg.Err == nil && !IsSystemEvent(msg.Event) && !ns.isSubscribed(msg.Event) {
+			return false
+		}
+
+		// a forced leave is sent after the room was left on this side, see `KickFromRoom`.
+		if msg.Room != "" && !msg.isRoomJoin() && !msg.isRoomLeave() && !msg.isRoomLeft() {
 			if !msg.locked {
 				ns.roomsMutex.RLock()
 			}
@@ -818,14 +1768,51 @@ func (c *Conn) canWrite(msg Message) bool {
 // Write method sends a message to the remote side,
 // reports whether the connection is still available
 // or when this message is not allowed to be sent to the remote side.
+//
+// Client-side messages to a not connected namespace, which buffers its outbound messages,
+// are kept to be sent on its next connect and in that case it reports true, see `Client.BufferOutbound`.
+// Messages written before the connection is acknowledged are queued
+// and sent right after the acknowledgement, see `MaxPendingWrites`.
 func (c *Conn) Write(msg Message) bool {
+	return c.writeMessage(msg, nil)
+}
+
+// writeMessage writes the "msg" and, if not nil, sends the result of the write to the "result", see `WriteResult`.
+func (c *Conn) writeMessage(msg Message, result chan<- error) bool {
 	if !c.canWrite(msg) {
+		if c.IsClient() && result == nil {
+			if msg.wait == "" && c.autoConnect(msg) {
+				return c.writeMessage(msg, nil)
+			}
+			return c.bufferOutbound(msg)
+		}
+		return false
+	}
+
+	if c.dropExpired(msg.ExpiresAt) {
+		if result != nil {
+			result <- ErrExpired
+		}
 		return false
 	}
 
 	msg.FromExplicit = ""
-	b := serializeMessage(nil, msg)
-	return c.write(b, msg.SetBinary)
+
+	if !c.IsClient() && c.server.OnWriteMessage != nil {
+		c.server.OnWriteMessage(c, &msg)
+	}
+
+	// the messages which are kept until the acknowledgement are not numbered,
+	// they may expire or be coalesced in the meantime, their queue keeps them in order anyway.
+	if c.isStrictOrdering() && !msg.isNoOp && c.isAcknowledged() {
+		return c.writeInSequence(msg, result)
+	}
+
+	buf := acquireBuffer()
+	w := pendingWriteOf(msg, serializeMessageTo(buf, msg))
+	w.buf = buf
+	w.result = result
+	return c.writePending(w)
 }
 
 // used when `Ask` caller cares only for successful call and not the message, for performance reasons we just use raw bytes.
@@ -838,6 +1825,11 @@ func (c *Conn) waitConfirmation(wait string) {
 
 	ch := make(chan Message)
 	c.waitingMessagesMutex.Lock()
+	if c.waitingMessages == nil {
+		// closed, see `Server.Prewarm`.
+		c.waitingMessagesMutex.Unlock()
+		return
+	}
 	c.waitingMessages[wait] = ch
 	c.waitingMessagesMutex.Unlock()
 	<-ch
@@ -865,73 +1857,387 @@ func (c *Conn) Ask(ctx context.Context, msg Message) (Message, error) {
 		ctx = context.TODO()
 	} else {
 		if deadline, has := ctx.Deadline(); has {
+			// the deadlines of the contexts are on the real time, not on the connection's `Clock`.
 			if deadline.Before(time.Now().Add(-1 * time.Second)) {
 				return Message{}, context.DeadlineExceeded
 			}
 		}
 	}
 
-	ch := make(chan Message)
 	c.waitingMessagesMutex.Lock()
+	if c.waitingMessages == nil {
+		// closed in the meantime, see `Server.Prewarm`.
+		c.waitingMessagesMutex.Unlock()
+		return msg, CloseError{Code: -1, error: ErrWrite}
+	}
+	if max := c.maxPendingAsks(); max > 0 && len(c.waitingMessages) >= max {
+		c.waitingMessagesMutex.Unlock()
+		return Message{}, ErrTooManyPendingAsks
+	}
+	ch := acquireReplyChan()
 	c.waitingMessages[msg.wait] = ch
 	c.waitingMessagesMutex.Unlock()
 
+	defer func() {
+		c.waitingMessagesMutex.Lock()
+		delete(c.waitingMessages, msg.wait)
+		c.waitingMessagesMutex.Unlock()
+		releaseReplyChan(ch)
+	}()
+
+	var onComplete func(event string, d time.Duration, err error)
+	if !c.IsClient() {
+		onComplete = c.server.OnAskComplete
+	}
+
+	var start time.Time
+	if onComplete != nil {
+		start = c.clock().Now()
+	}
+
+	if c.IsClient() {
+		c.autoConnect(msg)
+	}
+
 	if !c.Write(msg) {
 		// println("fail to write connect message.")
 		return Message{}, ErrWrite
 	}
 
-	select {
-	case <-ctx.Done():
-		if c.IsClosed() {
-			return Message{}, ErrWrite
+	for {
+		select {
+		case <-ctx.Done():
+			if c.IsClosed() {
+				return Message{}, ErrWrite
+			}
+			if onComplete != nil && ctx.Err() == context.DeadlineExceeded {
+				onComplete(msg.Event, c.clock().Now().Sub(start), ctx.Err())
+			}
+			return Message{}, ctx.Err()
+		case receive := <-ch:
+			if receive.wait != msg.wait {
+				// a late reply of a previous ask of the pooled channel.
+				continue
+			}
+
+			if onComplete != nil && receive.Err == nil {
+				onComplete(msg.Event, c.clock().Now().Sub(start), nil)
+			}
+			return receive, receive.Err
 		}
-		return Message{}, ctx.Err()
-	case receive := <-ch:
+	}
+}
+
+// StreamEndHeader is the `Message.Headers` key that marks a reply as the last one of a stream,
+// see `Conn.AskStream` and `NSConn.ReplyStream`.
+const StreamEndHeader = "Stream-End"
+
+func isStreamEnd(msg Message) bool {
+	return msg.Err != nil || msg.Headers[StreamEndHeader] != ""
+}
+
+// AskStream method sends a message to the remote side and returns a channel
+// which receives all of its replies, in order, i.e for server-streaming responses.
+//
+// The remote side sends each reply through the `NSConn.ReplyStream` method
+// of the incoming message and the stream is terminated by a reply
+// with the `StreamEndHeader` header set or by a reply with a non-nil `Message.Err`
+// (i.e the remote event's callback returned an error).
+// The terminator reply is delivered as well and then the channel is closed.
+// The channel is also closed, without any further messages, when the "ctx" is done or the connection is closed.
+func (c *Conn) AskStream(ctx context.Context, msg Message) (<-chan Message, error) {
+	if c.shouldHandleOnlyNativeMessages {
+		return nil, ErrWrite
+	}
+
+	if c.IsClosed() {
+		return nil, CloseError{Code: -1, error: ErrWrite}
+	}
+
+	if ctx == nil {
+		ctx = context.TODO()
+	}
+
+	msg.wait = genWait(c.IsClient())
+
+	// buffered, see the `Ask` and the deletion below.
+	ch := make(chan Message, 1)
+	c.waitingMessagesMutex.Lock()
+	if c.waitingMessages == nil {
+		// closed in the meantime, see `Server.Prewarm`.
+		c.waitingMessagesMutex.Unlock()
+		return nil, CloseError{Code: -1, error: ErrWrite}
+	}
+	if max := c.maxPendingAsks(); max > 0 && len(c.waitingMessages) >= max {
+		c.waitingMessagesMutex.Unlock()
+		return nil, ErrTooManyPendingAsks
+	}
+	c.waitingMessages[msg.wait] = ch
+	c.waitingMessagesMutex.Unlock()
+
+	stop := func() {
 		c.waitingMessagesMutex.Lock()
 		delete(c.waitingMessages, msg.wait)
 		c.waitingMessagesMutex.Unlock()
 
-		return receive, receive.Err
+		// the reader may hold the channel and try to send one last message
+		// after the deletion, make room for it so it does not block.
+		for {
+			select {
+			case <-ch:
+			default:
+				return
+			}
+		}
+	}
+
+	if !c.Write(msg) {
+		stop()
+		return nil, ErrWrite
+	}
+
+	out := make(chan Message)
+	go func() {
+		defer close(out)
+		defer stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-c.closeCh:
+				return
+			case receive := <-ch:
+				select {
+				case out <- receive:
+				case <-ctx.Done():
+					return
+				case <-c.closeCh:
+					return
+				}
+
+				if isStreamEnd(receive) {
+					return
+				}
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// ErrTooManyPendingAsks may return from a server-side connection's `Ask` method
+// when the connection already waits for `Server.MaxPendingAsks` replies.
+var ErrTooManyPendingAsks = errors.New("too many pending asks")
+
+func (c *Conn) maxPendingAsks() int {
+	if c.IsClient() {
+		return 0
+	}
+
+	if qos := c.getQoS(); qos != nil && qos.MaxPendingAsks > 0 {
+		return qos.MaxPendingAsks
+	}
+
+	return c.server.MaxPendingAsks
+}
+
+// closeReasonTimeout is the maximum time that `closeWithReason` waits for the remote side
+// to acknowledge each one of its namespaces' disconnect.
+const closeReasonTimeout = 5 * time.Second
+
+// closeWithReason sends a forced disconnect, with the "reason" as its body, for each connected namespace
+// and then terminates the connection.
+// The remote side receives the "reason" through its `OnNamespaceDisconnect` event's `Message.Body`.
+func (c *Conn) closeWithReason(reason []byte) {
+	if len(reason) > 0 && !c.shouldHandleOnlyNativeMessages {
+		ctx, cancel := context.WithTimeout(context.Background(), closeReasonTimeout)
+
+		// the lock must not be held while waiting for the remote side,
+		// the reader needs it to dispatch any incoming event that arrives before the reply.
+		disconnectMsg := Message{Event: OnNamespaceDisconnect, Body: reason, IsForced: true, IsLocal: true}
+		for _, namespace := range c.connectedNamespaceNames() {
+			disconnectMsg.Namespace = namespace
+			// best-effort, the connection is terminated anyway.
+			c.askDisconnect(ctx, disconnectMsg, true)
+		}
+
+		cancel()
+	}
+
+	c.Close()
+}
+
+// KickFromRoom forces this connection to leave the "room" of the "namespace",
+// i.e a moderation action of the server-side.
+// The room is left on this side first, with the `Message.IsForced` of its local `OnRoomLeave` and `OnRoomLeft`
+// events set to true, their errors can not prevent the leave. Then the remote side is notified to leave the room too,
+// its `OnRoomLeave` and `OnRoomLeft` events are fired so its UI can be updated.
+//
+// The notification is best-effort and it is not waited, so it's safe to call it
+// from an event callback of this connection itself, which blocks the connection's read loop until it returns.
+// It returns `ErrBadNamespace` or `ErrBadRoom` if the connection is not connected to the namespace
+// or not joined to the room.
+func (c *Conn) KickFromRoom(namespace, room string) error {
+	ns := c.Namespace(namespace)
+	if ns == nil {
+		return ErrBadNamespace
+	}
+
+	if !ns.forceLeaveRoom(room) {
+		return ErrBadRoom
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), closeReasonTimeout)
+		c.Ask(ctx, Message{Namespace: namespace, Room: room, Event: OnRoomLeave})
+		cancel()
+	}()
+
+	return nil
+}
+
+// ForceLeaveAll forces this connection to leave all of its joined rooms of all of its connected namespaces,
+// the namespaces stay connected. See `KickFromRoom` for details.
+func (c *Conn) ForceLeaveAll() {
+	for _, namespace := range c.connectedNamespaceNames() {
+		ns := c.Namespace(namespace)
+		if ns == nil {
+			continue
+		}
+
+		for _, room := range ns.RoomNames() {
+			c.KickFromRoom(namespace, room)
+		}
 	}
 }
 
 // Close method will force-disconnect from all connected namespaces and force-leave from all joined rooms
 // and finally will terminate the underline websocket connection.
+// The events are fired in order: the room leave events of all namespaces first, then the namespace disconnect events,
+// the namespaces and the rooms in alphabetical order, and the connection-level `Server.OnDisconnect` after all of them.
+// It waits, up to `GoCloseTimeout`, for the connection's goroutines started by `Go` to return.
 // After this method call the `Conn` is not usable anymore, a new `Dial` call is required.
 func (c *Conn) Close() {
 	if atomic.CompareAndSwapUint32(c.closed, 0, 1) {
 		if !c.shouldHandleOnlyNativeMessages {
-			disconnectMsg := Message{Event: OnNamespaceDisconnect, IsForced: true, IsLocal: true}
 			c.connectedNamespacesMutex.Lock()
-			for namespace, ns := range c.connectedNamespaces {
-				// leave rooms first with force and local property before remove the namespace completely.
-				ns.forceLeaveAll(true)
-
-				disconnectMsg.Namespace = ns.namespace
-				ns.events.fireEvent(ns, disconnectMsg)
-				delete(c.connectedNamespaces, namespace)
+			if !c.IsClient() {
+				c.detachSession()
 			}
-			c.connectedNamespacesMutex.Unlock()
+			namespaces := c.forceDisconnectAll()
 
 			c.waitingMessagesMutex.Lock()
 			for wait := range c.waitingMessages {
 				delete(c.waitingMessages, wait)
 			}
+			c.releaseConnMaps()
 			c.waitingMessagesMutex.Unlock()
+			c.connectedNamespacesMutex.Unlock()
+
+			for _, ns := range namespaces {
+				ns.flushRoomChanges()
+			}
 		}
 
 		atomic.StoreUint32(c.acknowledged, 0)
 
-		go func() {
-			if !c.IsClient() {
-				c.server.disconnect <- c
-			}
-		}()
+		// the outbound buffers are handed over to the client of the reconnection in progress, if any.
+		handover := atomic.LoadUint32(&c.reconnecting) > 0
+		pending := c.snapshotPendingWrites(!handover)
+		if !handover {
+			c.dropOutbound()
+		}
+
+		c.pendingWritesMutex.Lock()
+		for _, w := range c.pendingWrites {
+			w.done(ErrWrite)
+		}
+		c.pendingWrites = nil
+		c.closedPendingWrites = pending
+		c.pendingWritesClosed = true
+		atomic.StoreInt32(&c.pendingWritesLen, 0)
+		c.pendingWritesMutex.Unlock()
 
 		close(c.closeCh)
 		c.socket.NetConn().Close()
+
+		c.waitGoroutines()
+
+		// after everything else, so the `Server.OnDisconnect` reads the final state.
+		if s := c.server; s != nil {
+			go func() {
+				select {
+				case s.disconnect <- c:
+				case <-s.stopped: // nothing to handle it.
+				}
+			}()
+		}
+	}
+}
+
+// forceDisconnectAll force-disconnects this connection from all of its namespaces on `Close`,
+// in a deterministic order so the cleanup logic of the event callbacks can depend on each other:
+// first all the rooms of all the namespaces are left (the `OnRoomLeave` and `OnRoomLeft` of each room),
+// then the `OnNamespaceDisconnect` of each namespace is fired, the namespaces and the rooms in alphabetical order.
+// The connection-level close, the `Server.OnDisconnect` and the `Client.NotifyClose`, follows after all of them.
+// It returns the disconnected namespaces, see `NSConn.flushRoomChanges`. Locks required.
+func (c *Conn) forceDisconnectAll() []*NSConn {
+	namespaces := make([]*NSConn, 0, len(c.connectedNamespaces))
+	for _, ns := range c.connectedNamespaces {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i].namespace < namespaces[j].namespace
+	})
+
+	// leave rooms first with force and local property before remove the namespaces completely.
+	for _, ns := range namespaces {
+		ns.forceLeaveAll(true)
+	}
+
+	disconnectMsg := Message{Event: OnNamespaceDisconnect, IsForced: true, IsLocal: true}
+	for _, ns := range namespaces {
+		disconnectMsg.Namespace = ns.namespace
+		ns.events.fireEvent(ns, disconnectMsg)
+		c.deleteNamespace(ns.namespace)
+	}
+
+	return namespaces
+}
+
+// Done returns a channel which is closed when this connection is remotely or manually terminated,
+// so callers can select on the connection's termination among their own work.
+// It returns the same channel across calls.
+func (c *Conn) Done() <-chan struct{} {
+	return c.closeCh
+}
+
+// Wait blocks until this connection is remotely or manually terminated
+// and returns the read or write error which terminated it,
+// nil if it was terminated by a `Close` call.
+// It returns immediately if the connection is already closed.
+func (c *Conn) Wait() error {
+	<-c.closeCh
+
+	c.closeErrMutex.Lock()
+	err := c.closeErr
+	c.closeErrMutex.Unlock()
+	return err
+}
+
+// setCloseError keeps the "err" as the reason of the termination,
+// if the connection is not already closed.
+func (c *Conn) setCloseError(err error) {
+	if c.IsClosed() {
+		return
+	}
+
+	c.closeErrMutex.Lock()
+	if c.closeErr == nil {
+		c.closeErr = err
 	}
+	c.closeErrMutex.Unlock()
 }
 
 // IsClosed method reports whether this connection is remotely or manually terminated.
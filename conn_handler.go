@@ -37,15 +37,107 @@ func (e Events) GetNamespaces() Namespaces {
 }
 
 func (e Events) fireEvent(c *NSConn, msg Message) error {
-	if h, ok := e[msg.Event]; ok {
+	return e.fire(c, msg.Event, msg)
+}
+
+// fireError fires the `OnError` event callback with the "msg" which carries an error
+// that no one waits for, it reports false if there is no `OnError` event callback.
+func (e Events) fireError(c *NSConn, msg Message) bool {
+	if !e.has(c, OnError) {
+		return false
+	}
+
+	e.fire(c, OnError, msg)
+	return true
+}
+
+// has reports whether a callback, of its own or a default one, is registered for the "event".
+func (e Events) has(c *NSConn, event string) bool {
+	if c == nil || c.Conn == nil {
+		_, ok := e[event]
+		return ok
+	}
+
+	c.Conn.eventsMutex.RLock()
+	_, ok := e[event]
+	if !ok && c.Conn.server != nil {
+		_, ok = c.Conn.server.DefaultEvents[event]
+	}
+	c.Conn.eventsMutex.RUnlock()
+
+	return ok
+}
+
+// fire calls the callback of the "event" with the "msg", the "event" may differ than the `Message.Event`.
+func (e Events) fire(c *NSConn, event string, msg Message) error {
+	var server *Server
+	locked := c != nil && c.Conn != nil
+	if locked {
+		server = c.Conn.server
+		c.Conn.eventsMutex.RLock()
+	}
+
+	var defaults Events
+	if server != nil {
+		defaults = server.DefaultEvents
+	}
+
+	h, ok := e[event]
+	if !ok {
+		h, ok = defaults[event]
+	}
+	if !ok && event != OnError {
+		// the metrics of the unknown events are collected under the OnAnyEvent.
+		event = OnAnyEvent
+		h, ok = e[OnAnyEvent]
+		if !ok {
+			h, ok = defaults[OnAnyEvent]
+		}
+	}
+	if locked {
+		c.Conn.eventsMutex.RUnlock()
+	}
+
+	if !ok {
+		return nil
+	}
+
+	if server == nil {
+		return h(c, msg)
+	}
+
+	measure := server.SlowHandlerThreshold > 0 && server.OnSlowHandler != nil
+	collect := server.CollectEventMetrics
+	if !measure && !collect && server.HandlerDeadline <= 0 {
 		return h(c, msg)
 	}
 
-	if h, ok := e[OnAnyEvent]; ok {
+	if server.HandlerDeadline > 0 {
+		next := h
+		h = func(c *NSConn, msg Message) error {
+			return server.watchHandler(c, msg, next)
+		}
+	}
+
+	if !measure && !collect {
 		return h(c, msg)
 	}
 
-	return nil
+	clock := c.Conn.clock()
+	start := clock.Now()
+	err := h(c, msg)
+	d := clock.Now().Sub(start)
+
+	if measure && d >= server.SlowHandlerThreshold {
+		server.OnSlowHandler(c.Conn, msg.Namespace, msg.Event, d)
+	}
+
+	if collect {
+		_, replied := isReply(err)
+		server.eventMetrics.record(c.namespace, event, d, err != nil && !replied)
+	}
+
+	return err
 }
 
 // Namespaces completes the `ConnHandler` interface.
@@ -59,6 +151,26 @@ type Namespaces map[string]Events
 // GetNamespaces just returns the "nss" namespaces.
 func (nss Namespaces) GetNamespaces() Namespaces { return nss }
 
+// On registers the "handler" to the "event" of the "namespace", the namespace is created if missing.
+// It should be called before the server or the client starts,
+// use the `Server.On` and `Client.On` to register events while they are running.
+func (nss Namespaces) On(namespace, event string, handler MessageHandlerFunc) {
+	events, ok := nss[namespace]
+	if !ok || events == nil {
+		events = make(Events)
+		nss[namespace] = events
+	}
+	events[event] = handler
+}
+
+// getEvents returns the registered events of the "namespace" of the connection's namespaces.
+func (c *Conn) getEvents(namespace string) (Events, bool) {
+	c.eventsMutex.RLock()
+	events, ok := c.namespaces[namespace]
+	c.eventsMutex.RUnlock()
+	return events, ok
+}
+
 // WithTimeout completes the `ConnHandler` interface.
 // Can be used to register namespaces and events or just events on an empty namespace
 // with Read and Write timeouts.
@@ -0,0 +1,31 @@
+package neffos
+
+import "testing"
+
+func TestEventsWithoutConn(t *testing.T) {
+	var fired bool
+	events := Events{"event": func(c *NSConn, msg Message) error {
+		fired = true
+		return nil
+	}}
+
+	for _, c := range []*NSConn{nil, {}} {
+		fired = false
+
+		if !events.has(c, "event") {
+			t.Fatalf("expected the event to be registered")
+		}
+
+		if events.has(c, "missing") {
+			t.Fatalf("expected the missing event to not be registered")
+		}
+
+		if err := events.fire(c, "event", Message{Event: "event"}); err != nil {
+			t.Fatal(err)
+		}
+
+		if !fired {
+			t.Fatalf("expected the event's callback to be fired")
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package neffos
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// ConnInfo is a snapshot of a connection's state, see `Conn.Info` and `Server.DebugHandler`.
+type ConnInfo struct {
+	ID         string `json:"id"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+	// Extensions are the negotiated websocket extensions, see `Conn.Extensions`.
+	Extensions []string `json:"extensions,omitempty"`
+	// Namespaces are the connected namespaces with their joined rooms.
+	Namespaces map[string][]string `json:"namespaces"`
+	// PendingAsks is the number of the replies that the connection waits for.
+	PendingAsks int `json:"pending_asks"`
+	// BytesIn and BytesOut are the total size of the read and the written messages.
+	BytesIn  uint64 `json:"bytes_in"`
+	BytesOut uint64 `json:"bytes_out"`
+	// LastActivity is the time of the last read or written message, zero if none.
+	LastActivity time.Time `json:"last_activity"`
+	// Goroutines is the number of the running goroutines started by `Conn.Go`.
+	Goroutines int `json:"goroutines"`
+}
+
+// trackRead counts an incoming message of "n" bytes.
+func (c *Conn) trackRead(n int) {
+	atomic.AddUint64(&c.bytesIn, uint64(n))
+	atomic.StoreInt64(&c.lastActivity, c.clock().Now().UnixNano())
+}
+
+// trackWrite counts an outgoing message of "n" bytes.
+func (c *Conn) trackWrite(n int) {
+	atomic.AddUint64(&c.bytesOut, uint64(n))
+	atomic.StoreInt64(&c.lastActivity, c.clock().Now().UnixNano())
+}
+
+// Info returns a snapshot of the connection's state, i.e for live debugging.
+// It's safe to call it concurrently.
+func (c *Conn) Info() ConnInfo {
+	info := ConnInfo{
+		ID:         c.ID(),
+		Extensions: c.Extensions(),
+		Namespaces: make(map[string][]string),
+		BytesIn:    atomic.LoadUint64(&c.bytesIn),
+		BytesOut:   atomic.LoadUint64(&c.bytesOut),
+		Goroutines: int(atomic.LoadInt32(&c.goroutinesCount)),
+	}
+
+	if r := c.Socket().Request(); r != nil {
+		info.RemoteAddr = r.RemoteAddr
+	}
+
+	if t := atomic.LoadInt64(&c.lastActivity); t > 0 {
+		info.LastActivity = time.Unix(0, t)
+	}
+
+	c.connectedNamespacesMutex.RLock()
+	namespaces := make([]*NSConn, 0, len(c.connectedNamespaces))
+	for _, ns := range c.connectedNamespaces {
+		namespaces = append(namespaces, ns)
+	}
+	c.connectedNamespacesMutex.RUnlock()
+
+	for _, ns := range namespaces {
+		rooms := ns.RoomNames()
+		sort.Strings(rooms)
+		info.Namespaces[ns.namespace] = rooms
+	}
+
+	c.waitingMessagesMutex.RLock()
+	info.PendingAsks = len(c.waitingMessages)
+	c.waitingMessagesMutex.RUnlock()
+
+	return info
+}
+
+// DebugHandler returns an `http.Handler` which serves the `ConnInfo` of all the server's connections
+// as a JSON array, sorted by their IDs, for live debugging, like the net/http/pprof does for the runtime.
+// It exposes internal details, it should be registered behind an authentication
+// or on a private, administration, port.
+//
+// Example Code:
+//
+//	adminMux.Handle("/debug/neffos", basicAuth(server.DebugHandler()))
+func (s *Server) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		conns := make([]*Conn, 0, len(s.connections))
+		for c := range s.connections {
+			conns = append(conns, c)
+		}
+		s.mu.RUnlock()
+
+		infos := make([]ConnInfo, 0, len(conns))
+		for _, c := range conns {
+			infos = append(infos, c.Info())
+		}
+
+		sort.Slice(infos, func(i, j int) bool {
+			return infos[i].ID < infos[j].ID
+		})
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(infos)
+	})
+}
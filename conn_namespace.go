@@ -3,6 +3,8 @@ package neffos
 import (
 	"context"
 	"reflect"
+	"sort"
+	"strings"
 	"sync"
 )
 
@@ -11,6 +13,10 @@ import (
 // A single `Conn` can be connected to one or more namespaces,
 // each connected namespace is described by this structure.
 type NSConn struct {
+	// the unix nanoseconds of the last incoming message, see `Server.NamespaceIdleTimeout`.
+	// Accessed atomically, it's kept first so it's 64-bit aligned on 32-bit platforms too.
+	lastActivity int64
+
 	Conn *Conn
 	// Static from server, client can select which to use or not.
 	// Client and server can ask to connect.
@@ -24,6 +30,15 @@ type NSConn struct {
 	// Namespace(room(fire event)).
 	rooms      map[string]*Room
 	roomsMutex sync.RWMutex
+	// server-side only, the joins and leaves to be announced to the `StackExchange`, guarded by the roomsMutex,
+	// see `flushRoomChanges`.
+	roomChanges      []roomChange
+	roomChangesMutex sync.Mutex
+
+	// server-side only, the events that the remote side is interested in,
+	// nil means all events, see `Subscribe`.
+	subscriptions      map[string]struct{}
+	subscriptionsMutex sync.RWMutex
 
 	// value is just a temporarily value.
 	// Storage across event callbacks for this namespace.
@@ -32,10 +47,11 @@ type NSConn struct {
 
 func newNSConn(c *Conn, namespace string, events Events) *NSConn {
 	return &NSConn{
-		Conn:      c,
-		namespace: namespace,
-		events:    events,
-		rooms:     make(map[string]*Room),
+		Conn:         c,
+		namespace:    namespace,
+		events:       events,
+		rooms:        make(map[string]*Room),
+		lastActivity: c.clock().Now().UnixNano(),
 	}
 }
 
@@ -56,6 +72,42 @@ func (ns *NSConn) Emit(event string, body []byte) bool {
 	return ns.Conn.Write(Message{Namespace: ns.namespace, Event: event, Body: body})
 }
 
+// EmitTyped method sends a message to the remote side like the `Emit` does
+// and carries the "contentType" of its body, i.e "application/x-protobuf", through the `ContentTypeHeader` header,
+// the receiver reads it through the `Message.ContentType` method to decide how to decode the body.
+// An empty "contentType" adds no header, the format is application-defined.
+func (ns *NSConn) EmitTyped(event, contentType string, body []byte) bool {
+	if ns == nil {
+		return false
+	}
+
+	msg := Message{Namespace: ns.namespace, Event: event, Body: body}
+	if contentType != "" {
+		msg.Headers = map[string]string{ContentTypeHeader: contentType}
+	}
+
+	return ns.Conn.Write(msg)
+}
+
+// EmitLocal fires the "event" of this namespace with the "body" on this side of the connection,
+// through its own event callbacks, like an incoming message, without going over the socket,
+// i.e to simulate an event in tests or to trigger a callback internally.
+// The callback receives a message with its `Message.IsLocal` set to true
+// and its result is returned as it is, nothing is sent to the remote side.
+// The incoming messages' hooks and limits, like the `Server.OnMessage`, are not applied.
+func (ns *NSConn) EmitLocal(event string, body []byte) error {
+	if ns == nil {
+		return ErrBadNamespace
+	}
+
+	return ns.events.fireEvent(ns, Message{
+		Namespace: ns.namespace,
+		Event:     event,
+		Body:      body,
+		IsLocal:   true,
+	})
+}
+
 // Ask method writes a message to the remote side and blocks until a response or an error received.
 func (ns *NSConn) Ask(ctx context.Context, event string, body []byte) (Message, error) {
 	if ns == nil {
@@ -65,6 +117,113 @@ func (ns *NSConn) Ask(ctx context.Context, event string, body []byte) (Message,
 	return ns.Conn.Ask(ctx, Message{Namespace: ns.namespace, Event: event, Body: body})
 }
 
+// AskStream method writes a message to the remote side and returns a channel
+// which receives all of its replies until the remote side ends the stream.
+//
+// See `Conn.AskStream` and `ReplyStream` for more details.
+func (ns *NSConn) AskStream(ctx context.Context, event string, body []byte) (<-chan Message, error) {
+	if ns == nil {
+		return nil, ErrWrite
+	}
+
+	return ns.Conn.AskStream(ctx, Message{Namespace: ns.namespace, Event: event, Body: body})
+}
+
+// ReplyStream method sends a reply, with the given "body", to the remote `AskStream` of the "in" message.
+// It can be called many times inside (or outside) the "in" event's callback,
+// a true "end" marks this reply as the last one of the stream.
+// Note that if the event's callback returns an error the stream ends as well.
+func (ns *NSConn) ReplyStream(in Message, body []byte, end bool) bool {
+	if ns == nil {
+		return false
+	}
+
+	in.Body = body
+	in.Err = nil
+	if end {
+		headers := make(map[string]string, len(in.Headers)+1)
+		for k, v := range in.Headers {
+			headers[k] = v
+		}
+		headers[StreamEndHeader] = "1"
+		in.Headers = headers
+	}
+
+	return ns.Conn.Write(in)
+}
+
+// Subscribe method declares the events that this connection is interested in,
+// so the server sends only those events to it (replies to its own messages are always sent).
+// Each call replaces the previous events, no events means all events, which is the default.
+// On client-side connections the server gets notified and its `OnNamespaceSubscribe` event is fired,
+// on server-side connections it applies immediately.
+func (ns *NSConn) Subscribe(ctx context.Context, events ...string) error {
+	if ns == nil {
+		return ErrWrite
+	}
+
+	if ns.Conn.IsClient() {
+		_, err := ns.Conn.Ask(ctx, Message{
+			Namespace: ns.namespace,
+			Event:     OnNamespaceSubscribe,
+			Body:      []byte(strings.Join(events, subscriptionSeparator)),
+		})
+		return err
+	}
+
+	ns.setSubscriptions(events)
+	return nil
+}
+
+const subscriptionSeparator = "\n"
+
+func (ns *NSConn) setSubscriptions(events []string) {
+	var subscriptions map[string]struct{}
+	if len(events) > 0 {
+		subscriptions = make(map[string]struct{}, len(events))
+		for _, event := range events {
+			subscriptions[event] = struct{}{}
+		}
+	}
+
+	ns.subscriptionsMutex.Lock()
+	ns.subscriptions = subscriptions
+	ns.subscriptionsMutex.Unlock()
+}
+
+// isSubscribed reports whether the remote side is interested in the "event".
+func (ns *NSConn) isSubscribed(event string) bool {
+	ns.subscriptionsMutex.RLock()
+	defer ns.subscriptionsMutex.RUnlock()
+
+	if ns.subscriptions == nil {
+		return true
+	}
+
+	_, ok := ns.subscriptions[event]
+	return ok
+}
+
+func (ns *NSConn) replySubscribe(msg Message) {
+	if ns == nil || msg.wait == "" || msg.isNoOp {
+		return
+	}
+
+	if err := ns.events.fireEvent(ns, msg); err != nil {
+		msg.Err = ns.Conn.transformError(err)
+		ns.Conn.Write(msg)
+		return
+	}
+
+	var events []string
+	if len(msg.Body) > 0 {
+		events = strings.Split(string(msg.Body), subscriptionSeparator)
+	}
+	ns.setSubscriptions(events)
+
+	ns.Conn.writeEmptyReply(msg.wait)
+}
+
 // JoinRoom method can be used to join a connection to a specific room, rooms are dynamic.
 // Returns the joined `Room`.
 func (ns *NSConn) JoinRoom(ctx context.Context, roomName string) (*Room, error) {
@@ -102,6 +261,26 @@ func (ns *NSConn) Rooms() []*Room {
 	return rooms
 }
 
+// RoomNames returns a slice copy of the joined rooms' names.
+// It's cheap and safe to call from any goroutine,
+// the result does not reflect any future join or leave.
+//
+// See `Rooms` too.
+func (ns *NSConn) RoomNames() []string {
+	if ns == nil {
+		return nil
+	}
+
+	ns.roomsMutex.RLock()
+	names := make([]string, 0, len(ns.rooms))
+	for roomName := range ns.rooms {
+		names = append(names, roomName)
+	}
+	ns.roomsMutex.RUnlock()
+
+	return names
+}
+
 // LeaveAll method sends a remote and local leave room signal `OnRoomLeave` to and for all rooms
 // and fires the `OnRoomLeft` event if succeed.
 func (ns *NSConn) LeaveAll(ctx context.Context) error {
@@ -109,6 +288,8 @@ func (ns *NSConn) LeaveAll(ctx context.Context) error {
 		return nil
 	}
 
+	defer ns.flushRoomChanges()
+
 	ns.roomsMutex.Lock()
 	defer ns.roomsMutex.Unlock()
 
@@ -123,16 +304,25 @@ func (ns *NSConn) LeaveAll(ctx context.Context) error {
 	return nil
 }
 
+// forceLeaveAll leaves all the joined rooms on this side,
+// the caller should `flushRoomChanges` after releasing its locks.
 func (ns *NSConn) forceLeaveAll(isLocal bool) {
 	ns.roomsMutex.Lock()
 	defer ns.roomsMutex.Unlock()
 
-	leaveMsg := Message{Namespace: ns.namespace, Event: OnRoomLeave, IsForced: true, IsLocal: isLocal}
+	rooms := make([]string, 0, len(ns.rooms))
 	for room := range ns.rooms {
+		rooms = append(rooms, room)
+	}
+	// in alphabetical order, see `Conn.forceDisconnectAll`.
+	sort.Strings(rooms)
+
+	leaveMsg := Message{Namespace: ns.namespace, Event: OnRoomLeave, IsForced: true, IsLocal: isLocal}
+	for _, room := range rooms {
 		leaveMsg.Room = room
 		ns.events.fireEvent(ns, leaveMsg)
 
-		delete(ns.rooms, room)
+		ns.deleteRoom(room)
 
 		leaveMsg.Event = OnRoomLeft
 		ns.events.fireEvent(ns, leaveMsg)
@@ -141,6 +331,28 @@ func (ns *NSConn) forceLeaveAll(isLocal bool) {
 	}
 }
 
+// forceLeaveRoom leaves the "room" on this side without asking the local events, see `Conn.KickFromRoom`,
+// it reports whether the room was joined.
+func (ns *NSConn) forceLeaveRoom(room string) bool {
+	defer ns.flushRoomChanges()
+
+	ns.roomsMutex.Lock()
+	defer ns.roomsMutex.Unlock()
+
+	if _, ok := ns.rooms[room]; !ok {
+		return false
+	}
+
+	leaveMsg := Message{Namespace: ns.namespace, Room: room, Event: OnRoomLeave, IsForced: true, IsLocal: true}
+	ns.events.fireEvent(ns, leaveMsg)
+
+	ns.deleteRoom(room)
+
+	leaveMsg.Event = OnRoomLeft
+	ns.events.fireEvent(ns, leaveMsg)
+	return true
+}
+
 // Disconnect method sends a disconnect signal to the remote side and fires the local `OnNamespaceDisconnect` event.
 func (ns *NSConn) Disconnect(ctx context.Context) error {
 	if ns == nil {
@@ -178,10 +390,15 @@ func (ns *NSConn) askRoomJoin(ctx context.Context, roomName string) (*Room, erro
 		return nil, err
 	}
 
+	if !ns.Conn.IsClient() {
+		ns.Conn.server.replayRoomHistory(ns, roomName)
+	}
+
 	room = newRoom(ns, roomName)
 	ns.roomsMutex.Lock()
-	ns.rooms[roomName] = room
+	ns.setRoom(room)
 	ns.roomsMutex.Unlock()
+	ns.flushRoomChanges()
 
 	joinMsg.Event = OnRoomJoined
 	ns.events.fireEvent(ns, joinMsg)
@@ -195,17 +412,32 @@ func (ns *NSConn) replyRoomJoin(msg Message) {
 
 	ns.roomsMutex.RLock()
 	_, ok := ns.rooms[msg.Room]
+	joined := len(ns.rooms)
 	ns.roomsMutex.RUnlock()
 	if !ok {
+		if !ns.Conn.IsClient() {
+			if max := ns.Conn.server.MaxRoomsPerConnection; max > 0 && joined >= max {
+				msg.Err = ErrTooManyRooms
+				ns.Conn.Write(msg)
+				return
+			}
+		}
+
 		err := ns.events.fireEvent(ns, msg)
 		if err != nil {
-			msg.Err = err
+			msg.Err = ns.Conn.transformError(err)
 			ns.Conn.Write(msg)
 			return
 		}
+		if !ns.Conn.IsClient() {
+			// replayed before the room is joined, so the live messages of the room come after them.
+			ns.Conn.server.replayRoomHistory(ns, msg.Room)
+		}
+
 		ns.roomsMutex.Lock()
-		ns.rooms[msg.Room] = newRoom(ns, msg.Room)
+		ns.setRoom(newRoom(ns, msg.Room))
 		ns.roomsMutex.Unlock()
+		ns.flushRoomChanges()
 
 		msg.Event = OnRoomJoined
 		ns.events.fireEvent(ns, msg)
@@ -246,10 +478,11 @@ func (ns *NSConn) askRoomLeave(ctx context.Context, msg Message, lock bool) erro
 		ns.roomsMutex.Lock()
 	}
 
-	delete(ns.rooms, msg.Room)
+	ns.deleteRoom(msg.Room)
 
 	if lock {
 		ns.roomsMutex.Unlock()
+		ns.flushRoomChanges()
 	}
 
 	msg.Event = OnRoomLeft
@@ -274,8 +507,9 @@ func (ns *NSConn) replyRoomLeave(msg Message) {
 		ns.events.fireEvent(ns, msg)
 
 		ns.roomsMutex.Lock()
-		delete(ns.rooms, msg.Room)
+		ns.deleteRoom(msg.Room)
 		ns.roomsMutex.Unlock()
+		ns.flushRoomChanges()
 
 		ns.Conn.writeEmptyReply(msg.wait)
 
@@ -287,14 +521,15 @@ func (ns *NSConn) replyRoomLeave(msg Message) {
 	// server-side, check for error on the local event first.
 	err := ns.events.fireEvent(ns, msg)
 	if err != nil {
-		msg.Err = err
+		msg.Err = ns.Conn.transformError(err)
 		ns.Conn.Write(msg)
 		return
 	}
 
 	ns.roomsMutex.Lock()
-	delete(ns.rooms, msg.Room)
+	ns.deleteRoom(msg.Room)
 	ns.roomsMutex.Unlock()
+	ns.flushRoomChanges()
 
 	msg.Event = OnRoomLeft
 	ns.events.fireEvent(ns, msg)
@@ -2,6 +2,8 @@ package neffos_test
 
 import (
 	"bytes"
+	"errors"
+	"strconv"
 	"sync"
 	"testing"
 
@@ -77,6 +79,10 @@ func TestJoinAndLeaveRoom(t *testing.T) {
 				t.Fatalf("expected joined room name to be: %s but got: %s", roomName, room.Name)
 			}
 
+			if names := c.RoomNames(); len(names) != 1 || names[0] != roomName {
+				t.Fatalf("expected joined room names to be: [%s] but got: %v", roomName, names)
+			}
+
 			// 1 -> to catch its own event and
 			// 2 -> to notify about room leave inside the event itself for both clients ofc.
 			wg.Add(2)
@@ -90,3 +96,346 @@ func TestJoinAndLeaveRoom(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestNSConnSubscribe(t *testing.T) {
+	var (
+		wg        sync.WaitGroup
+		namespace = "default"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"trigger": func(c *neffos.NSConn, msg neffos.Message) error {
+					if !c.Conn.IsClient() {
+						c.Emit("skipped", nil)
+						c.Emit("wanted", nil)
+					}
+					return nil
+				},
+				"skipped": func(c *neffos.NSConn, msg neffos.Message) error {
+					t.Fatalf("expected unsubscribed event to not be received")
+					return nil
+				},
+				"wanted": func(c *neffos.NSConn, msg neffos.Message) error {
+					wg.Done()
+					return nil
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = c.Subscribe(nil, "wanted"); err != nil {
+			t.Fatalf("[%s] %v", dialer, err)
+		}
+
+		wg.Add(1)
+		c.Emit("trigger", nil)
+		wg.Wait()
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRoomEmitN(t *testing.T) {
+	var (
+		namespace = "default"
+		roomName  = "room1"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"count": func(c *neffos.NSConn, msg neffos.Message) error {
+					room := c.Room(roomName)
+					if room == nil {
+						return neffos.Reply([]byte("-1"))
+					}
+
+					return neffos.Reply([]byte(strconv.Itoa(room.EmitN("chat", msg.Body))))
+				},
+				"chat": func(c *neffos.NSConn, msg neffos.Message) error { return nil },
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = c.JoinRoom(nil, roomName); err != nil {
+			t.Fatal(err)
+		}
+
+		reply, err := c.Ask(nil, "count", []byte("data"))
+		if err != nil {
+			t.Fatalf("[%s] %v", dialer, err)
+		}
+
+		if expected, got := "1", string(reply.Body); expected != got {
+			t.Fatalf("[%s] expected delivery count: %s but got: %s", dialer, expected, got)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNSConnEmitTyped(t *testing.T) {
+	var (
+		wg        sync.WaitGroup
+		namespace = "default"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"typed": func(c *neffos.NSConn, msg neffos.Message) error {
+					if expected, got := "application/json", msg.ContentType(); expected != got {
+						t.Fatalf("expected content type: %s but got: %s", expected, got)
+					}
+					wg.Done()
+					return nil
+				},
+				"untyped": func(c *neffos.NSConn, msg neffos.Message) error {
+					if got := msg.ContentType(); got != "" {
+						t.Fatalf("expected an empty content type but got: %s", got)
+					}
+					wg.Done()
+					return nil
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wg.Add(2)
+		c.EmitTyped("typed", "application/json", []byte(`{"a":1}`))
+		c.EmitTyped("untyped", "", []byte("a"))
+		wg.Wait()
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNSConnEmitLocal(t *testing.T) {
+	var (
+		namespace = "default"
+		errLocal  = errors.New("local error")
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"local": func(c *neffos.NSConn, msg neffos.Message) error {
+					if !c.Conn.IsClient() {
+						t.Fatalf("expected the local event to not reach the server")
+					}
+
+					if !msg.IsLocal {
+						t.Fatalf("expected a local message")
+					}
+
+					if expected, got := "data", string(msg.Body); expected != got {
+						t.Fatalf("expected body: %s but got: %s", expected, got)
+					}
+
+					return errLocal
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = c.EmitLocal("local", []byte("data")); err != errLocal {
+			t.Fatalf("[%s] expected error: %v but got: %v", dialer, errLocal, err)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testProtoUser is a hand-written protobuf message of a single string field,
+// which marshals itself like the gogo/protobuf generated messages.
+type testProtoUser struct {
+	Name string
+}
+
+func (m *testProtoUser) Reset()         { *m = testProtoUser{} }
+func (m *testProtoUser) String() string { return m.Name }
+func (*testProtoUser) ProtoMessage()    {}
+
+func (m *testProtoUser) Marshal() ([]byte, error) {
+	// field 1, wire type 2 (length-delimited).
+	return append([]byte{0x0a, byte(len(m.Name))}, m.Name...), nil
+}
+
+func (m *testProtoUser) Unmarshal(b []byte) error {
+	if len(b) < 2 || b[0] != 0x0a || int(b[1]) != len(b)-2 {
+		return errors.New("invalid testProtoUser")
+	}
+
+	m.Name = string(b[2:])
+	return nil
+}
+
+func TestNSConnEmitProto(t *testing.T) {
+	var (
+		wg        sync.WaitGroup
+		namespace = "default"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"user": func(c *neffos.NSConn, msg neffos.Message) error {
+					user, ok := msg.Proto.(*testProtoUser)
+					if !ok {
+						t.Fatalf("expected a *testProtoUser but got: %T", msg.Proto)
+					}
+
+					if expected, got := "kataras", user.Name; expected != got {
+						t.Fatalf("expected name: %s but got: %s", expected, got)
+					}
+
+					if expected, got := neffos.ProtoContentType, msg.ContentType(); expected != got {
+						t.Fatalf("expected content type: %s but got: %s", expected, got)
+					}
+
+					wg.Done()
+					return nil
+				},
+				"raw": func(c *neffos.NSConn, msg neffos.Message) error {
+					if msg.Proto != nil {
+						t.Fatalf("expected no protobuf message on a raw event but got: %T", msg.Proto)
+					}
+
+					if expected, got := "raw", string(msg.Body); expected != got {
+						t.Fatalf("expected body: %s but got: %s", expected, got)
+					}
+
+					wg.Done()
+					return nil
+				},
+				neffos.OnError: func(c *neffos.NSConn, msg neffos.Message) error {
+					if msg.Event != "user" || msg.Err == nil {
+						t.Fatalf("expected the decode error of the user event but got: %#+v", msg)
+					}
+
+					wg.Done()
+					return nil
+				},
+			},
+		}
+	)
+
+	events.RegisterProto(namespace, "user", &testProtoUser{})
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wg.Add(2)
+		if err = c.EmitProto("user", &testProtoUser{Name: "kataras"}); err != nil {
+			t.Fatal(err)
+		}
+		c.Emit("raw", []byte("raw"))
+		wg.Wait()
+
+		// the server fails to decode it and the client receives the error.
+		wg.Add(1)
+		c.Emit("user", []byte("invalid"))
+		wg.Wait()
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNSConnEmitter(t *testing.T) {
+	var (
+		wg        sync.WaitGroup
+		namespace = "default"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"notify": func(c *neffos.NSConn, msg neffos.Message) error {
+					if expected, got := "data", string(msg.Body); expected != got {
+						t.Fatalf("expected body: %s but got: %s", expected, got)
+					}
+					wg.Done()
+					return nil
+				},
+				"echo": neffos.ReplyHandler(func(c *neffos.NSConn, msg neffos.Message) ([]byte, error) {
+					return msg.Body, nil
+				}),
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		e := c.Emitter()
+		if _, ok := e.(*neffos.NSConn); ok {
+			t.Fatalf("expected the emitter to hide its NSConn")
+		}
+
+		wg.Add(1)
+		if !e.Emit("notify", []byte("data")) {
+			t.Fatalf("[%s] expected the emit to be written", dialer)
+		}
+		wg.Wait()
+
+		reply, err := e.Ask(nil, "echo", []byte("data"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, got := "data", string(reply.Body); expected != got {
+			t.Fatalf("[%s] expected reply: %s but got: %s", dialer, expected, got)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
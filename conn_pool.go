@@ -0,0 +1,131 @@
+package neffos
+
+import (
+	"runtime"
+	"sync"
+)
+
+// connMaps are the per-connection maps that can be reused across connections, see `Server.Prewarm`.
+type connMaps struct {
+	connectedNamespaces map[string]*NSConn
+	waitingMessages     map[string]chan Message
+}
+
+func newConnMaps() *connMaps {
+	return &connMaps{
+		connectedNamespaces: make(map[string]*NSConn),
+		waitingMessages:     make(map[string]chan Message),
+	}
+}
+
+// Prewarm enables the reuse of the per-connection structures (the connected namespaces and the waiting replies maps)
+// across the lifecycles of this server's connections through a `sync.Pool`, so a new connection
+// does not allocate them on the hot path under a high connection churn, see the `BenchmarkConnMaps`.
+// It also pre-allocates a set of them, one per logical CPU.
+//
+// The structures are cleared and detached from a connection on its `Close`,
+// nothing of a closed connection leaks to the next one and a closed connection
+// reports no connected namespaces.
+//
+// It should be called before serve, it affects only new connections.
+func (s *Server) Prewarm() {
+	if s.connMapsPool != nil {
+		return
+	}
+
+	pool := &sync.Pool{
+		New: func() interface{} {
+			return newConnMaps()
+		},
+	}
+
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		pool.Put(newConnMaps())
+	}
+
+	s.connMapsPool = pool
+}
+
+// getConnMaps returns the per-connection maps of a new connection.
+func (s *Server) getConnMaps() *connMaps {
+	if s.connMapsPool == nil {
+		return nil
+	}
+
+	return s.connMapsPool.Get().(*connMaps)
+}
+
+// releaseConnMaps detaches the, already cleared, maps of the closed "c" and puts them back to the pool.
+// Locks required.
+func (c *Conn) releaseConnMaps() {
+	if c.server == nil || c.server.connMapsPool == nil {
+		return
+	}
+
+	maps := &connMaps{
+		connectedNamespaces: c.connectedNamespaces,
+		waitingMessages:     c.waitingMessages,
+	}
+	c.connectedNamespaces = nil
+	c.waitingMessages = nil
+
+	if len(maps.connectedNamespaces) > 0 || len(maps.waitingMessages) > 0 {
+		// should never happen, they are cleared on close.
+		return
+	}
+
+	c.server.connMapsPool.Put(maps)
+}
+
+// replyChans are the reply channels of the `Conn.Ask` calls, reused across all connections
+// to reduce the allocations and the GC pressure under a heavy ask load, see the `BenchmarkReplyChan`.
+//
+// A released channel may still receive a late reply of its previous ask,
+// i.e on a context cancelation, `Conn.Ask` discards the replies of a different wait ID.
+var replyChans = sync.Pool{
+	New: func() interface{} {
+		// buffered, so a late reply does not block the reader after a context cancelation.
+		return make(chan Message, 1)
+	},
+}
+
+func acquireReplyChan() chan Message {
+	return replyChans.Get().(chan Message)
+}
+
+// releaseReplyChan drains and puts the "ch" back to the pool,
+// it should be called after its wait ID is removed from the waiting messages.
+func releaseReplyChan(ch chan Message) {
+	select {
+	case <-ch:
+	default:
+	}
+
+	replyChans.Put(ch)
+}
+
+// deliverReply sends the "msg" to the `Ask` or the `AskStream` which waits for its reply,
+// it reports false if there is none.
+//
+// The send happens under the lock while there is room in the channel, so after an `Ask`
+// removes its wait ID there is no reader which can still deliver to its (released) channel.
+func (c *Conn) deliverReply(msg Message) bool {
+	c.waitingMessagesMutex.RLock()
+	ch, ok := c.waitingMessages[msg.wait]
+	if !ok {
+		c.waitingMessagesMutex.RUnlock()
+		return false
+	}
+
+	select {
+	case ch <- msg:
+		c.waitingMessagesMutex.RUnlock()
+		return true
+	default:
+	}
+	c.waitingMessagesMutex.RUnlock()
+
+	// full, i.e a stream's consumer is behind, wait for it without holding the lock.
+	ch <- msg
+	return true
+}
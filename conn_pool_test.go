@@ -0,0 +1,193 @@
+package neffos
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestServerPrewarm(t *testing.T) {
+	namespaces := Namespaces{"default": Events{}}
+	s := New(nil, namespaces)
+	defer s.Close()
+	s.Prewarm()
+
+	for i := 0; i < 10; i++ {
+		c := newConn(newPipeSocket(), namespaces, s.getConnMaps())
+		c.server = s
+
+		if len(c.connectedNamespaces) != 0 || len(c.waitingMessages) != 0 {
+			t.Fatalf("[%d] expected a clean state from the pool", i)
+		}
+
+		c.connectedNamespaces["default"] = newNSConn(c, "default", namespaces["default"])
+		c.waitingMessages["wait"] = make(chan Message, 1)
+		c.Close()
+
+		if c.Namespace("default") != nil {
+			t.Fatalf("[%d] expected no connected namespaces after close", i)
+		}
+
+		if _, err := c.Ask(nil, Message{Namespace: "default", Event: "event"}); err == nil {
+			t.Fatalf("[%d] expected an error on ask after close", i)
+		}
+	}
+}
+
+func BenchmarkConnMaps(b *testing.B) {
+	namespaces := Namespaces{"default": Events{}}
+
+	run := func(b *testing.B, prewarm bool) {
+		s := New(nil, namespaces)
+		defer s.Close()
+		if prewarm {
+			s.Prewarm()
+		}
+
+		ch := make(chan Message, 1)
+
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			c := newConn(newPipeSocket(), namespaces, s.getConnMaps())
+			c.server = s
+			// grow the maps like a connected namespace and a pending ask do.
+			c.connectedNamespaces["default"] = nil
+			c.waitingMessages["wait"] = ch
+			delete(c.connectedNamespaces, "default")
+			c.Close()
+		}
+	}
+
+	b.Run("alloc", func(b *testing.B) { run(b, false) })
+	b.Run("pool", func(b *testing.B) { run(b, true) })
+}
+
+func BenchmarkConnWrite(b *testing.B) {
+	namespaces := Namespaces{"default": Events{}}
+	msg := Message{Namespace: "default", Room: "room", Event: "chat", Body: []byte("a chat message of a room")}
+
+	run := func(b *testing.B, maxPooledBufferSize int) {
+		defer func(n int) { MaxPooledBufferSize = n }(MaxPooledBufferSize)
+		MaxPooledBufferSize = maxPooledBufferSize
+
+		c := newConn(newPipeSocket(), namespaces, nil)
+		ns := newNSConn(c, "default", namespaces["default"])
+		ns.rooms["room"] = newRoom(ns, "room")
+		c.connectedNamespaces["default"] = ns
+		c.acknowledge()
+		defer c.Close()
+
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			c.Write(msg)
+		}
+	}
+
+	b.Run("alloc", func(b *testing.B) { run(b, 0) })
+	b.Run("pool", func(b *testing.B) { run(b, 64*1024) })
+}
+
+func TestConnAskReplyChan(t *testing.T) {
+	namespaces := Namespaces{"default": Events{}}
+
+	c := newConn(newPipeSocket(), namespaces, nil)
+	c.connectedNamespaces["default"] = newNSConn(c, "default", namespaces["default"])
+	c.acknowledge()
+	defer c.Close()
+
+	type result struct {
+		msg Message
+		err error
+	}
+
+	resultCh := make(chan result, 1)
+	go func() {
+		msg, err := c.Ask(context.Background(), Message{Namespace: "default", Event: "event"})
+		resultCh <- result{msg, err}
+	}()
+
+	var (
+		wait string
+		ch   chan Message
+	)
+	for ch == nil {
+		c.waitingMessagesMutex.RLock()
+		for w, waitCh := range c.waitingMessages {
+			wait, ch = w, waitCh
+		}
+		c.waitingMessagesMutex.RUnlock()
+		time.Sleep(time.Millisecond)
+	}
+
+	// a late reply of a previous ask of the same pooled channel.
+	ch <- Message{wait: "stale", Body: []byte("stale")}
+
+	if !c.deliverReply(Message{wait: wait, Body: []byte("reply")}) {
+		t.Fatalf("expected the reply to be delivered")
+	}
+
+	res := <-resultCh
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+
+	if expected, got := "reply", string(res.msg.Body); expected != got {
+		t.Fatalf("expected reply: %s but got: %s", expected, got)
+	}
+
+	if c.deliverReply(Message{wait: wait}) {
+		t.Fatalf("expected no delivery after the ask is completed")
+	}
+
+	if len(ch) != 0 {
+		t.Fatalf("expected the released channel to be drained")
+	}
+}
+
+func BenchmarkReplyChan(b *testing.B) {
+	namespaces := Namespaces{"default": Events{}}
+
+	run := func(b *testing.B, pool bool) {
+		c := newConn(newPipeSocket(), namespaces, nil)
+		defer c.Close()
+
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				// the lifecycle of a reply channel on a pending ask.
+				wait := genWait(true)
+
+				var ch chan Message
+				if pool {
+					ch = acquireReplyChan()
+				} else {
+					ch = make(chan Message, 1)
+				}
+
+				c.waitingMessagesMutex.Lock()
+				c.waitingMessages[wait] = ch
+				c.waitingMessagesMutex.Unlock()
+
+				c.deliverReply(Message{wait: wait})
+				<-ch
+
+				c.waitingMessagesMutex.Lock()
+				delete(c.waitingMessages, wait)
+				c.waitingMessagesMutex.Unlock()
+
+				if pool {
+					releaseReplyChan(ch)
+				}
+			}
+		})
+	}
+
+	b.Run("alloc", func(b *testing.B) { run(b, false) })
+	b.Run("pool", func(b *testing.B) { run(b, true) })
+}
@@ -0,0 +1,39 @@
+package neffos
+
+import (
+	"testing"
+)
+
+func TestConnQueueLen(t *testing.T) {
+	c := newConn(newPipeSocket(), Namespaces{"default": Events{}}, nil)
+	defer c.Close()
+
+	for i := 0; i < 2; i++ {
+		if !c.writeOrQueue([]byte("write"), false) {
+			t.Fatalf("[%d] expected the write to be queued", i)
+		}
+	}
+
+	if !c.handleACK([]byte("read")) {
+		t.Fatalf("expected the read to be queued")
+	}
+
+	if expected, got := 2, c.WriteQueueLen(); expected != got {
+		t.Fatalf("expected write queue length: %d but got: %d", expected, got)
+	}
+
+	if expected, got := 1, c.ReadQueueLen(); expected != got {
+		t.Fatalf("expected read queue length: %d but got: %d", expected, got)
+	}
+
+	c.acknowledge()
+	c.handleQueue()
+
+	if got := c.WriteQueueLen(); got != 0 {
+		t.Fatalf("expected an empty write queue after the acknowledgement but got: %d", got)
+	}
+
+	if got := c.ReadQueueLen(); got != 0 {
+		t.Fatalf("expected an empty read queue after the acknowledgement but got: %d", got)
+	}
+}
@@ -2,8 +2,13 @@ package neffos
 
 import (
 	"context"
+	"errors"
 )
 
+// ErrQuorumNotReached is returned from a `Room.EmitQuorum` when the room has fewer members than the quorum
+// or when too many of them failed to acknowledge the message for the quorum to be reached.
+var ErrQuorumNotReached = errors.New("quorum not reached")
+
 // Room describes a connected connection to a room,
 // emits messages with the `Message.Room` filled to the specific room
 // and `Message.Namespace` to the underline `NSConn`'s namespace.
@@ -39,6 +44,155 @@ func (r *Room) Emit(event string, body []byte) bool {
 	})
 }
 
+// EmitN sends a message to all the members of this room, including this room's connection,
+// and returns the number of the connections that the message was successfully written to,
+// closed connections and failed writes are not counted, i.e a zero result means that the room is empty.
+// A successful write means that the message was enqueued to the connection,
+// not that it was received or processed by its remote side.
+//
+// On the server-side the members are the connections of this server that are joined to this room,
+// the message is written directly, it does not pass through the `StackExchange`.
+// On the client-side the only member is the client's connection itself, so it returns 1 or 0.
+func (r *Room) EmitN(event string, body []byte) int {
+	msg := Message{
+		Namespace: r.NSConn.namespace,
+		Room:      r.Name,
+		Event:     event,
+		Body:      body,
+	}
+
+	if r.NSConn.Conn.IsClient() {
+		if r.NSConn.Conn.Write(msg) {
+			return 1
+		}
+		return 0
+	}
+
+	n := 0
+	for _, ns := range r.NSConn.Conn.server.roomMembers(r.NSConn.namespace, r.Name) {
+		if ns.Conn.Write(msg) {
+			n++
+		}
+	}
+
+	return n
+}
+
+// EmitToOne sends a message to a single member of this room, i.e to assign a task to one worker of a room of workers,
+// and returns the ID of the connection that the message was successfully written to.
+// The member is picked by the `Server.RoomPickStrategy`, round-robin (in join order) by default,
+// closed connections and connections that the write failed are skipped and the next member is tried.
+// It reports false if there is no member that the message could be written to.
+//
+// On the server-side the members are the connections of this server that are joined to this room,
+// the message is written directly, it does not pass through the `StackExchange`.
+// On the client-side the only member is the client's connection itself.
+func (r *Room) EmitToOne(event string, body []byte) (string, bool) {
+	msg := Message{
+		Namespace: r.NSConn.namespace,
+		Room:      r.Name,
+		Event:     event,
+		Body:      body,
+	}
+
+	c := r.NSConn.Conn
+	if c.IsClient() {
+		if c.Write(msg) {
+			return c.ID(), true
+		}
+		return "", false
+	}
+
+	for _, ns := range c.server.roomPickOrder(r.NSConn.namespace, r.Name) {
+		if ns.Conn.IsClosed() {
+			continue
+		}
+
+		if ns.Conn.Write(msg) {
+			return ns.Conn.ID(), true
+		}
+	}
+
+	return "", false
+}
+
+// EmitQuorum sends a message to all the members of this room, including this room's connection,
+// and waits until at least "n" of them acknowledge it, i.e "at least N replicas confirmed" over a room of replicas.
+// The message is sent to each member as an `Ask`, a member acknowledges it by replying to it
+// (see `Reply` and `ReplyHandler`), an error reply or a failed write does not count.
+// It returns nil as soon as the "n"th acknowledgement arrives, the pending asks of the rest of the members are canceled
+// and their late replies are ignored. It returns the "ctx" error if the "ctx" is done before that, so the "ctx"
+// should have a deadline, or an `ErrQuorumNotReached` as soon as the quorum can not be reached anymore.
+// A zero or negative "n" means 1.
+//
+// If the room has fewer than "n" members it fails immediately with an `ErrQuorumNotReached`,
+// without sending the message to any of them.
+//
+// On the server-side the members are the connections of this server that are joined to this room,
+// the message is written directly, it does not pass through the `StackExchange`.
+// On the client-side the only member is the client's connection itself.
+func (r *Room) EmitQuorum(ctx context.Context, event string, body []byte, n int) error {
+	if n <= 0 {
+		n = 1
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	var conns []*Conn
+	if c := r.NSConn.Conn; c.IsClient() {
+		conns = []*Conn{c}
+	} else {
+		for _, ns := range c.server.roomMembers(r.NSConn.namespace, r.Name) {
+			conns = append(conns, ns.Conn)
+		}
+	}
+
+	if len(conns) < n {
+		return ErrQuorumNotReached
+	}
+
+	msg := Message{
+		Namespace: r.NSConn.namespace,
+		Room:      r.Name,
+		Event:     event,
+		Body:      body,
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan error, len(conns)) // buffered, the late results are not received.
+	for _, c := range conns {
+		go func(c *Conn) {
+			_, err := c.Ask(ctx, msg)
+			results <- err
+		}(c)
+	}
+
+	acks, failures := 0, 0
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-results:
+			if err != nil {
+				failures++
+				if len(conns)-failures < n {
+					return ErrQuorumNotReached
+				}
+				continue
+			}
+
+			acks++
+			if acks >= n {
+				return nil
+			}
+		}
+	}
+}
+
 // Leave method sends a remote and local leave room signal `OnRoomLeave` to this specific room
 // and fires the `OnRoomLeft` event if succeed.
 func (r *Room) Leave(ctx context.Context) error {
@@ -0,0 +1,85 @@
+package neffos
+
+import (
+	"testing"
+)
+
+func TestRoomEmitToOne(t *testing.T) {
+	namespaces := Namespaces{"default": Events{}}
+	s := New(nil, namespaces)
+	defer s.Close()
+
+	var rooms []*Room
+	for _, id := range []string{"worker2", "worker1", "worker3"} {
+		c := newConn(newPipeSocket(), namespaces, nil)
+		c.server = s
+		c.id = id
+		c.acknowledge()
+
+		ns := newNSConn(c, "default", namespaces["default"])
+		c.connectedNamespaces["default"] = ns
+		room := newRoom(ns, "workers")
+		ns.setRoom(room)
+		rooms = append(rooms, room)
+	}
+
+	expectPicks := func(expected ...string) {
+		t.Helper()
+		for i, id := range expected {
+			got, ok := rooms[0].EmitToOne("task", nil)
+			if !ok {
+				t.Fatalf("[%d] expected a member to be picked", i)
+			}
+
+			if id != got {
+				t.Fatalf("[%d] expected pick: %s but got: %s", i, id, got)
+			}
+		}
+	}
+
+	expectPicks("worker2", "worker1", "worker3", "worker2")
+
+	// closed connections leave their rooms.
+	rooms[1].NSConn.Conn.Close()
+	expectPicks("worker2", "worker3", "worker2")
+
+	s.RoomPickStrategy = PickRandom
+	for i := 0; i < 10; i++ {
+		if id, _ := rooms[0].EmitToOne("task", nil); id != "worker2" && id != "worker3" {
+			t.Fatalf("[%d] expected a random open member but got: %s", i, id)
+		}
+	}
+}
+
+func TestRoomEmitToOneWeighted(t *testing.T) {
+	namespaces := Namespaces{"default": Events{}}
+	s := New(nil, namespaces)
+	defer s.Close()
+
+	s.RoomPickStrategy = PickWeightedRoundRobin
+	s.RoomMemberWeight = func(ns *NSConn) int {
+		if ns.Conn.ID() == "large" {
+			return 3
+		}
+		return 0
+	}
+
+	var room *Room
+	for _, id := range []string{"small", "large"} {
+		c := newConn(newPipeSocket(), namespaces, nil)
+		c.server = s
+		c.id = id
+		c.acknowledge()
+
+		ns := newNSConn(c, "default", namespaces["default"])
+		c.connectedNamespaces["default"] = ns
+		room = newRoom(ns, "workers")
+		ns.setRoom(room)
+	}
+
+	for i, expected := range []string{"small", "large", "large", "large", "small"} {
+		if got, _ := room.EmitToOne("task", nil); expected != got {
+			t.Fatalf("[%d] expected pick: %s but got: %s", i, expected, got)
+		}
+	}
+}
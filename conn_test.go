@@ -2,12 +2,22 @@ package neffos_test
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/kataras/neffos"
+
+	gorilla "github.com/kataras/neffos/gorilla"
 )
 
 func TestConnect(t *testing.T) {
@@ -421,3 +431,919 @@ func TestOnNativeMessageOnly(t *testing.T) {
 // 		t.Fatal(err)
 // 	}
 // }
+
+// closingSocket fails its writes with an io.ErrClosedPipe once it's closed,
+// like a socket which is closed by another goroutine after the connection's write checks.
+type closingSocket struct {
+	neffos.Socket
+	closed uint32
+}
+
+func (s *closingSocket) WriteBinary(b []byte, timeout time.Duration) error {
+	if atomic.LoadUint32(&s.closed) == 1 {
+		return io.ErrClosedPipe
+	}
+	return s.Socket.WriteBinary(b, timeout)
+}
+
+func (s *closingSocket) WriteText(b []byte, timeout time.Duration) error {
+	if atomic.LoadUint32(&s.closed) == 1 {
+		return io.ErrClosedPipe
+	}
+	return s.Socket.WriteText(b, timeout)
+}
+
+func TestConnWriteCloseError(t *testing.T) {
+	var (
+		namespace = "default"
+		events    = neffos.Namespaces{namespace: neffos.Events{
+			"event": func(c *neffos.NSConn, msg neffos.Message) error { return nil },
+		}}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	var socket *closingSocket
+	dial := func(ctx context.Context, url string) (neffos.Socket, error) {
+		s, err := gorilla.DefaultDialer(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+		socket = &closingSocket{Socket: s}
+		return socket, nil
+	}
+
+	client, err := neffos.Dial(nil, dial, "ws://localhost:8080/gorilla", events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	c, err := client.Connect(nil, namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	atomic.StoreUint32(&socket.closed, 1)
+
+	if c.Emit("event", []byte("data")) {
+		t.Fatalf("expected the write to a closed socket to fail")
+	}
+
+	if !c.Conn.IsClosed() {
+		t.Fatalf("expected the connection to be closed by its socket's close error")
+	}
+}
+
+func TestConnWriteOnClose(t *testing.T) {
+	// race the connection's `Close` against concurrent writes,
+	// the writes should fail gracefully and report false after close.
+	var (
+		namespace = "default"
+		events    = neffos.Namespaces{namespace: neffos.Events{
+			"event": func(c *neffos.NSConn, msg neffos.Message) error { return nil },
+		}}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					c.Emit("event", []byte("data"))
+				}
+			}()
+		}
+
+		client.Close()
+		wg.Wait()
+
+		if c.Emit("event", []byte("data")) {
+			t.Fatalf("[%s] expected write to a closed connection to fail", dialer)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAskStream(t *testing.T) {
+	var (
+		namespace = "default"
+		event     = "stream"
+		chunks    = []string{"a", "b", "c"}
+	)
+
+	teardownServer := runTestServer("localhost:8080", neffos.Namespaces{namespace: neffos.Events{
+		event: func(c *neffos.NSConn, msg neffos.Message) error {
+			for i, chunk := range chunks {
+				c.ReplyStream(msg, []byte(chunk), i == len(chunks)-1)
+			}
+			return nil
+		}}})
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", neffos.Namespaces{namespace: neffos.Events{}}, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		replies, err := c.AskStream(nil, event, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for reply := range replies {
+			got = append(got, string(reply.Body))
+		}
+
+		if !reflect.DeepEqual(got, chunks) {
+			t.Fatalf("[%s] expected stream replies to be: %v but got: %v", dialer, chunks, got)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnWait(t *testing.T) {
+	var (
+		namespace = "default"
+		events    = neffos.Namespaces{namespace: neffos.Events{
+			"close": func(c *neffos.NSConn, msg neffos.Message) error {
+				if !c.Conn.IsClient() {
+					c.Conn.Close()
+				}
+				return nil
+			},
+		}}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.Emit("close", nil)
+		if err = c.Conn.Wait(); err == nil {
+			t.Fatalf("[%s] expected the remote termination's error", dialer)
+		}
+
+		if !c.Conn.IsClosed() {
+			t.Fatalf("[%s] expected connection to be closed after Wait", dialer)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNamespacesOn(t *testing.T) {
+	var (
+		namespace = "default"
+		// the namespaces changed at runtime are guarded by their server's or client's lock,
+		// so they are not shared.
+		serverEvents = neffos.Namespaces{namespace: neffos.Events{}}
+		clientEvents = neffos.Namespaces{namespace: neffos.Events{}}
+	)
+
+	server := neffos.New(gorilla.DefaultUpgrader, serverEvents)
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	defer server.Close()
+
+	client, err := neffos.Dial(nil, gorilla.DefaultDialer, "ws"+strings.TrimPrefix(httpServer.URL, "http"), clientEvents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	c, err := client.Connect(nil, namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pong := make(chan struct{})
+	client.On(namespace, "pong", func(*neffos.NSConn, neffos.Message) error {
+		close(pong)
+		return nil
+	})
+
+	server.On(namespace, "late", neffos.ReplyHandler(func(c *neffos.NSConn, _ neffos.Message) ([]byte, error) {
+		c.Emit("pong", nil)
+		return []byte("registered"), nil
+	}))
+
+	// registrations while the events are fired.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			server.On(namespace, fmt.Sprintf("plugin%d", i), func(*neffos.NSConn, neffos.Message) error { return nil })
+			client.On(namespace, fmt.Sprintf("plugin%d", i), func(*neffos.NSConn, neffos.Message) error { return nil })
+		}
+	}()
+
+	msg, err := c.Ask(nil, "late", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(msg.Body) != "registered" {
+		t.Fatalf("expected reply from the runtime registered event but got: %s", msg.Body)
+	}
+
+	select {
+	case <-pong:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected the runtime registered event of the client to be fired")
+	}
+
+	<-done
+}
+
+func TestConnContext(t *testing.T) {
+	type userKey struct{}
+
+	var (
+		namespace = "default"
+		contexts  = make(chan context.Context, 1)
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"user": neffos.ReplyHandler(func(c *neffos.NSConn, msg neffos.Message) ([]byte, error) {
+					// the request is finished, the connection's context is not canceled.
+					if err := c.Conn.Context().Err(); err != nil {
+						return nil, err
+					}
+
+					contexts <- c.Conn.Context()
+					user, _ := c.Conn.Context().Value(userKey{}).(string)
+					return []byte(user), nil
+				}),
+			},
+		}
+	)
+
+	server := neffos.New(gorilla.DefaultUpgrader, events)
+	// i.e an authentication middleware.
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, "kataras")))
+	}))
+	defer httpServer.Close()
+	defer server.Close()
+
+	client, err := neffos.Dial(nil, gorilla.DefaultDialer, "ws"+strings.TrimPrefix(httpServer.URL, "http"), events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	c, err := client.Connect(nil, namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := c.Ask(nil, "user", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected, got := "kataras", string(reply.Body); expected != got {
+		t.Fatalf("expected the value of the request's context: %s but got: %s", expected, got)
+	}
+
+	ctx := <-contexts
+	client.Close()
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected the connection's context to be canceled on close")
+	}
+
+	if err = ctx.Err(); err != context.Canceled {
+		t.Fatalf("expected error: %v but got: %v", context.Canceled, err)
+	}
+
+	if expected, got := "kataras", ctx.Value(userKey{}); expected != got {
+		t.Fatalf("expected the canceled context to keep the value: %s but got: %v", expected, got)
+	}
+}
+
+func TestConnGo(t *testing.T) {
+	var (
+		namespace = "default"
+		events    = neffos.Namespaces{namespace: neffos.Events{}}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var stopped uint32
+		c.Conn.Go(func(ctx context.Context) {
+			<-ctx.Done()
+			time.Sleep(10 * time.Millisecond)
+			atomic.StoreUint32(&stopped, 1)
+		})
+
+		client.Close()
+		if atomic.LoadUint32(&stopped) != 1 {
+			t.Fatalf("[%s] expected Close to wait for the connection's goroutines", dialer)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnConcurrentNamespaces(t *testing.T) {
+	// connect and disconnect namespaces while the reader dispatches incoming events,
+	// should be run with the -race flag too.
+	var (
+		events = neffos.Namespaces{
+			"ns1": neffos.Events{
+				"spam": func(c *neffos.NSConn, msg neffos.Message) error {
+					if !c.Conn.IsClient() {
+						go func() {
+							for i := 0; i < 500 && c.Emit("tick", nil); i++ {
+							}
+						}()
+					}
+					return nil
+				},
+				"tick": func(*neffos.NSConn, neffos.Message) error { return nil },
+			},
+			"ns2": neffos.Events{},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(nil, "ns1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.Emit("spam", nil)
+
+		for i := 0; i < 20; i++ {
+			other, err := client.Connect(nil, "ns2")
+			if err != nil {
+				t.Fatalf("[%s] %v", dialer, err)
+			}
+
+			if err = other.Disconnect(nil); err != nil {
+				t.Fatalf("[%s] %v", dialer, err)
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err = c.Conn.DisconnectAll(ctx); err != nil {
+			t.Fatalf("[%s] %v", dialer, err)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnDisconnectAllDispatches(t *testing.T) {
+	// the events that arrive while the DisconnectAll waits for a reply are dispatched
+	// and their callbacks can emit and connect.
+	var (
+		emitted = make(chan bool, 2)
+		events  = neffos.Namespaces{
+			"ns1": neffos.Events{
+				neffos.OnNamespaceDisconnect: func(c *neffos.NSConn, msg neffos.Message) error {
+					if !c.Conn.IsClient() {
+						// before the reply of the disconnect.
+						c.Emit("notice", nil)
+					}
+					return nil
+				},
+				"notice": func(c *neffos.NSConn, msg neffos.Message) error {
+					emitted <- c.Emit("ping", nil)
+					c.Conn.Go(func(ctx context.Context) {
+						c.Conn.Connect(ctx, "ns2")
+					})
+					return nil
+				},
+				"ping": func(c *neffos.NSConn, msg neffos.Message) error {
+					return nil
+				},
+			},
+			"ns2": neffos.Events{},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		ns, err := client.Connect(nil, "ns1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		if err = ns.Conn.DisconnectAll(ctx); err != nil {
+			t.Fatalf("[%s] %v", dialer, err)
+		}
+
+		select {
+		case ok := <-emitted:
+			if !ok {
+				t.Fatalf("[%s] expected the emit of the callback to be sent", dialer)
+			}
+		default:
+			t.Fatalf("[%s] expected the event to be dispatched before the reply", dialer)
+		}
+
+		deadline := time.Now().Add(3 * time.Second)
+		for ns.Conn.Namespace("ns2") == nil {
+			if time.Now().After(deadline) {
+				t.Fatalf("[%s] expected the connect of the callback to succeed", dialer)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitConnectRejected(t *testing.T) {
+	var (
+		namespace = "default"
+		rejection = "forbidden"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				neffos.OnNamespaceConnect: func(c *neffos.NSConn, msg neffos.Message) error {
+					if !c.Conn.IsClient() {
+						return errors.New(rejection)
+					}
+					return nil
+				},
+			},
+			"control": neffos.Events{
+				// the server force-connects the client and rejects it on its own `OnNamespaceConnect`.
+				"connect": func(c *neffos.NSConn, msg neffos.Message) error {
+					go c.Conn.Connect(nil, namespace)
+					return nil
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		control, err := client.Connect(nil, "control")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		errCh := make(chan error, 1)
+		go func() {
+			_, err := client.WaitServerConnect(ctx, namespace)
+			errCh <- err
+		}()
+
+		// the rejections before the wait are not kept.
+		time.Sleep(50 * time.Millisecond)
+		control.Emit("connect", nil)
+
+		if err = <-errCh; err == nil || err.Error() != rejection {
+			t.Fatalf("[%s] expected rejection error: %s but got: %v", dialer, rejection, err)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOnError(t *testing.T) {
+	var (
+		wg        sync.WaitGroup
+		namespace = "default"
+		errText   = "oops"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"fail": func(c *neffos.NSConn, msg neffos.Message) error {
+					if c.Conn.IsClient() {
+						t.Fatalf("expected the error to be handled by the OnError event but got: %v", msg.Err)
+					}
+					return errors.New(errText)
+				},
+				neffos.OnError: func(c *neffos.NSConn, msg neffos.Message) error {
+					if !c.Conn.IsClient() {
+						t.Fatalf("unexpected server-side OnError")
+					}
+
+					if msg.Event != "fail" || msg.Err == nil || msg.Err.Error() != errText {
+						t.Fatalf("expected the error of the fail event but got: %s: %v", msg.Event, msg.Err)
+					}
+					wg.Done()
+					return nil
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wg.Add(1)
+		c.Emit("fail", nil)
+		wg.Wait()
+
+		// an `Ask` still gets its error.
+		if _, err = c.Ask(nil, "fail", nil); err == nil || err.Error() != errText {
+			t.Fatalf("[%s] expected error: %s but got: %v", dialer, errText, err)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnWaitReady(t *testing.T) {
+	var (
+		namespace = "default"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"waitReady": func(c *neffos.NSConn, msg neffos.Message) error {
+					ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+					defer cancel()
+
+					if err := c.Conn.WaitReady(ctx); err != nil {
+						return err
+					}
+					return neffos.Reply([]byte("ready"))
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = c.Ask(nil, "waitReady", nil); err == nil || err.Error() != context.DeadlineExceeded.Error() {
+			t.Fatalf("[%s] expected error: %v but got: %v", dialer, context.DeadlineExceeded, err)
+		}
+
+		if !client.SetReady() {
+			t.Fatalf("[%s] expected the ready signal to be sent", dialer)
+		}
+
+		reply, err := c.Ask(nil, "waitReady", nil)
+		if err != nil {
+			t.Fatalf("[%s] %v", dialer, err)
+		}
+
+		if expected, got := "ready", string(reply.Body); expected != got {
+			t.Fatalf("[%s] expected reply: %s but got: %s", dialer, expected, got)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnectConcurrentSameNamespace(t *testing.T) {
+	var (
+		namespace = "default"
+		connects  uint32
+		connected uint32
+		rejects   uint32
+		errReject = errors.New("rejected")
+		events    = neffos.Namespaces{
+			"rejected": neffos.Events{
+				neffos.OnNamespaceConnect: func(c *neffos.NSConn, msg neffos.Message) error {
+					if !c.Conn.IsClient() {
+						atomic.AddUint32(&rejects, 1)
+						time.Sleep(50 * time.Millisecond)
+						return errReject
+					}
+					return nil
+				},
+			},
+			namespace: neffos.Events{
+				neffos.OnNamespaceConnect: func(c *neffos.NSConn, msg neffos.Message) error {
+					if !c.Conn.IsClient() {
+						atomic.AddUint32(&connects, 1)
+						// keep the connect in-flight.
+						time.Sleep(50 * time.Millisecond)
+					}
+					return nil
+				},
+				neffos.OnNamespaceConnected: func(c *neffos.NSConn, msg neffos.Message) error {
+					if c.Conn.IsClient() {
+						atomic.AddUint32(&connected, 1)
+					}
+					return nil
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		atomic.StoreUint32(&connects, 0)
+		atomic.StoreUint32(&connected, 0)
+		atomic.StoreUint32(&rejects, 0)
+
+		var (
+			wg    sync.WaitGroup
+			n     = 10
+			conns = make([]*neffos.NSConn, n)
+		)
+
+		wg.Add(n)
+		for i := 0; i < n; i++ {
+			go func(i int) {
+				defer wg.Done()
+
+				c, err := client.Connect(nil, namespace)
+				if err != nil {
+					t.Errorf("[%s] %v", dialer, err)
+					return
+				}
+				conns[i] = c
+			}(i)
+		}
+		wg.Wait()
+
+		for i := 1; i < n; i++ {
+			if conns[i] != conns[0] {
+				t.Fatalf("[%s] expected the same connected namespace for all calls", dialer)
+			}
+		}
+
+		// already connected, no round-trip.
+		if c, err := client.Connect(nil, namespace); err != nil || c != conns[0] {
+			t.Fatalf("[%s] expected the existing connected namespace but got: %v", dialer, err)
+		}
+
+		if got := atomic.LoadUint32(&connects); got != 1 {
+			t.Fatalf("[%s] expected a single remote connect but got: %d", dialer, got)
+		}
+
+		if got := atomic.LoadUint32(&connected); got != 1 {
+			t.Fatalf("[%s] expected a single connected event but got: %d", dialer, got)
+		}
+
+		// the callers of an in-flight connect share its error too.
+		wg.Add(n)
+		for i := 0; i < n; i++ {
+			go func() {
+				defer wg.Done()
+
+				if _, err := client.Connect(nil, "rejected"); err == nil || err.Error() != errReject.Error() {
+					t.Errorf("[%s] expected error: %v but got: %v", dialer, errReject, err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if got := atomic.LoadUint32(&rejects); got != 1 {
+			t.Fatalf("[%s] expected a single remote connect but got: %d", dialer, got)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnEmitTo(t *testing.T) {
+	var (
+		wg     sync.WaitGroup
+		events = neffos.Namespaces{
+			"chat": neffos.Events{
+				"message": func(c *neffos.NSConn, msg neffos.Message) error {
+					if c.Conn.IsClient() {
+						return nil
+					}
+
+					if !c.Conn.EmitTo("admin", "notify", msg.Body) {
+						t.Fatalf("expected the emit to the connected admin namespace to be sent")
+					}
+
+					if c.Conn.EmitTo("other", "notify", msg.Body) {
+						t.Fatalf("expected the emit to a not connected namespace to fail")
+					}
+
+					return nil
+				},
+			},
+			"admin": neffos.Events{
+				"notify": func(c *neffos.NSConn, msg neffos.Message) error {
+					if expected, got := "hello", string(msg.Body); expected != got {
+						t.Fatalf("expected body: %s but got: %s", expected, got)
+					}
+					wg.Done()
+					return nil
+				},
+			},
+			"other": neffos.Events{},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(nil, "chat")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = client.Connect(nil, "admin"); err != nil {
+			t.Fatal(err)
+		}
+
+		wg.Add(1)
+		c.Emit("message", []byte("hello"))
+		wg.Wait()
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnCloseOrder(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		sequences = make(map[string][]string)
+		record    = func(c *neffos.Conn, call string) {
+			mu.Lock()
+			sequences[c.ID()] = append(sequences[c.ID()], call)
+			mu.Unlock()
+		}
+
+		wg     sync.WaitGroup
+		events = make(neffos.Namespaces)
+	)
+
+	for _, namespace := range []string{"b", "a"} {
+		events[namespace] = neffos.Events{
+			neffos.OnRoomLeave: func(c *neffos.NSConn, msg neffos.Message) error {
+				if !c.Conn.IsClient() {
+					record(c.Conn, "leave "+msg.Namespace+":"+msg.Room)
+				}
+				return nil
+			},
+			neffos.OnRoomLeft: func(c *neffos.NSConn, msg neffos.Message) error {
+				if !c.Conn.IsClient() {
+					record(c.Conn, "left "+msg.Namespace+":"+msg.Room)
+				}
+				return nil
+			},
+			neffos.OnNamespaceDisconnect: func(c *neffos.NSConn, msg neffos.Message) error {
+				if !c.Conn.IsClient() {
+					record(c.Conn, "disconnect "+msg.Namespace)
+				}
+				return nil
+			},
+		}
+	}
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.OnDisconnect = func(c *neffos.Conn) {
+			record(c, "close")
+			wg.Done()
+		}
+	})
+	defer teardownServer()
+
+	wg.Add(2)
+	teardownClient := runTestClient("localhost:8080", events, func(_ string, client *neffos.Client) {
+		for _, namespace := range []string{"b", "a"} {
+			c, err := client.Connect(nil, namespace)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, room := range []string{"room2", "room1"} {
+				if _, err = c.JoinRoom(nil, room); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		client.Close()
+	})
+	defer teardownClient()
+
+	wg.Wait()
+
+	expected := []string{
+		"leave a:room1", "left a:room1", "leave a:room2", "left a:room2",
+		"leave b:room1", "left b:room1", "leave b:room2", "left b:room2",
+		"disconnect a", "disconnect b",
+		"close",
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(sequences) != 2 {
+		t.Fatalf("expected the sequences of two connections but got: %v", sequences)
+	}
+
+	for id, got := range sequences {
+		if !reflect.DeepEqual(expected, got) {
+			t.Fatalf("[%s] expected close order:\n%v\nbut got:\n%v", id, expected, got)
+		}
+	}
+}
+
+func TestConnPingWithData(t *testing.T) {
+	events := neffos.Namespaces{"default": neffos.Events{}}
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	teardownClient := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		c, err := client.Connect(nil, "default")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, data := range [][]byte{[]byte("1571234567890"), nil} {
+			pong, err := c.Conn.PingWithData(data)
+			if err != nil {
+				t.Fatalf("[%s] %v", dialer, err)
+			}
+			if !bytes.Equal(data, pong) {
+				t.Fatalf("[%s] expected pong payload: %q but got: %q", dialer, data, pong)
+			}
+		}
+
+		if _, err = c.Conn.PingWithData(make([]byte, neffos.MaxPingPayload+1)); err != neffos.ErrPingPayloadTooLarge {
+			t.Fatalf("[%s] expected error: %v but got: %v", dialer, neffos.ErrPingPayloadTooLarge, err)
+		}
+	})
+	defer teardownClient()
+}
@@ -0,0 +1,70 @@
+package neffos
+
+import (
+	"sync"
+	"time"
+)
+
+// IdempotencyKeyHeader is the `Message.Headers` key which
+// its value is used to drop duplicated incoming messages, see `Server.Dedupe`.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// DedupeMaxKeys is the maximum number of recently-seen idempotency keys
+// that a single connection keeps when `Server.Dedupe` is used.
+// When the limit is reached the oldest key is dropped before its window expires.
+// The memory cost is about `DedupeMaxKeys` * (len(key) + 32 bytes) per connection.
+var DedupeMaxKeys = 1024
+
+type dedupeEntry struct {
+	key     string
+	expires time.Time
+}
+
+// dedupeSet is a bounded, per-connection, set of recently-seen keys.
+// All keys share the same window so the insertion order is the expiration order as well.
+type dedupeSet struct {
+	clock   Clock
+	window  time.Duration
+	max     int
+	mu      sync.Mutex
+	keys    map[string]struct{}
+	entries []dedupeEntry
+}
+
+func newDedupeSet(clock Clock, window time.Duration, max int) *dedupeSet {
+	return &dedupeSet{
+		clock:  clock,
+		window: window,
+		max:    max,
+		keys:   make(map[string]struct{}),
+	}
+}
+
+// seen reports whether the "key" was already seen inside the window,
+// otherwise it marks it as seen.
+func (d *dedupeSet) seen(key string) bool {
+	now := d.clock.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// remove expired keys.
+	n := 0
+	for ; n < len(d.entries) && !d.entries[n].expires.After(now); n++ {
+		delete(d.keys, d.entries[n].key)
+	}
+	d.entries = d.entries[n:]
+
+	if _, ok := d.keys[key]; ok {
+		return true
+	}
+
+	if d.max > 0 && len(d.entries) >= d.max {
+		delete(d.keys, d.entries[0].key)
+		d.entries = d.entries[1:]
+	}
+
+	d.keys[key] = struct{}{}
+	d.entries = append(d.entries, dedupeEntry{key: key, expires: now.Add(d.window)})
+	return false
+}
@@ -0,0 +1,59 @@
+package neffos
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After fires immediately, there is no waiting on the fake time.
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	ch <- c.Now().Add(d)
+	return ch
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func TestDedupeSet(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	d := newDedupeSet(clock, 50*time.Millisecond, 2)
+
+	if d.seen("a") {
+		t.Fatalf("expected first key to be new")
+	}
+
+	if !d.seen("a") {
+		t.Fatalf("expected same key to be a duplicate inside the window")
+	}
+
+	d.seen("b")
+	d.seen("c") // evicts "a" because of the max limit.
+	if d.seen("a") {
+		t.Fatalf("expected evicted key to be new")
+	}
+
+	clock.Advance(60 * time.Millisecond)
+	if d.seen("c") {
+		t.Fatalf("expected expired key to be new")
+	}
+
+	if len(d.keys) != len(d.entries) {
+		t.Fatalf("expected keys and entries to be in sync but got %d and %d", len(d.keys), len(d.entries))
+	}
+}
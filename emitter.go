@@ -0,0 +1,43 @@
+package neffos
+
+import "context"
+
+// Emitter is the narrow, emit-only, view of a connected namespace, see `NSConn.Emitter`.
+//
+// Pass it to the application code which should only send messages to the remote side
+// instead of the whole `NSConn`, which can also disconnect the namespace or close the connection, i.e:
+//
+//	func notifyOrder(e neffos.Emitter, order Order) bool {
+//		return e.Emit("order", order.Bytes())
+//	}
+//
+//	"placeOrder": func(c *neffos.NSConn, msg neffos.Message) error {
+//		notifyOrder(c.Emitter(), order)
+//		return nil
+//	}
+//
+// Its small surface is easy to mock on the application's tests.
+type Emitter interface {
+	// Emit sends a message of the "event" to the remote side, see `NSConn.Emit`.
+	Emit(event string, body []byte) bool
+	// Ask sends a message of the "event" to the remote side and blocks until its reply, see `NSConn.Ask`.
+	Ask(ctx context.Context, event string, body []byte) (Message, error)
+}
+
+// Emitter returns an `Emitter` of this namespace,
+// it can not be converted back to the `NSConn`.
+func (ns *NSConn) Emitter() Emitter {
+	return nsEmitter{ns: ns}
+}
+
+type nsEmitter struct {
+	ns *NSConn
+}
+
+func (e nsEmitter) Emit(event string, body []byte) bool {
+	return e.ns.Emit(event, body)
+}
+
+func (e nsEmitter) Ask(ctx context.Context, event string, body []byte) (Message, error) {
+	return e.ns.Ask(ctx, event, body)
+}
@@ -14,6 +14,35 @@ import (
 // See examples for more.
 type MessageHandlerFunc func(*NSConn, Message) error
 
+// ReplyHandlerFunc is the definition type of a request/response event's callback.
+// Its result body is sent back to the sender as a reply to its incoming message,
+// its error is sent back as the reply's `Message.Err`.
+// Use the `ReplyHandler` to convert it to a `MessageHandlerFunc`.
+type ReplyHandlerFunc func(*NSConn, Message) ([]byte, error)
+
+// ReplyHandler converts a `ReplyHandlerFunc` to a `MessageHandlerFunc`,
+// so it can be registered as an event's callback
+// and the remote side's `Ask` receives its result body as the response.
+// Fire-and-forget events should keep using the `MessageHandlerFunc` directly.
+//
+// Example Code:
+//
+//	neffos.Events{
+//		"sum": neffos.ReplyHandler(func(c *neffos.NSConn, msg neffos.Message) ([]byte, error) {
+//			return sum(msg.Body)
+//		}),
+//	}
+func ReplyHandler(fn ReplyHandlerFunc) MessageHandlerFunc {
+	return func(c *NSConn, msg Message) error {
+		body, err := fn(c, msg)
+		if err != nil {
+			return err
+		}
+
+		return Reply(body)
+	}
+}
+
 var (
 	// OnNamespaceConnect is the event name which its callback is fired right before namespace connect,
 	// if non-nil error then the remote connection's `Conn.Connect` will fail and send that error text.
@@ -35,6 +64,11 @@ var (
 	OnRoomLeave = "_OnRoomLeave" // able to broadcast bye-bye messages to room.
 	// OnRoomLeft is the event name which its callback is fired after the connection has successfully left from a room.
 	OnRoomLeft = "_OnRoomLeft" // if allowed to join to a room, then its allowed to leave from it.
+	// OnNamespaceSubscribe is the event name which its callback is fired when
+	// a remote connection declares the events it is interested in, see `NSConn.Subscribe`.
+	// The `Message.Body` contains the events separated by new lines.
+	// If non-nil error then the remote connection's `NSConn.Subscribe` will fail and send that error text.
+	OnNamespaceSubscribe = "_OnNamespaceSubscribe"
 	// OnAnyEvent is the event name which its callback is fired when incoming message's event is not declared to the ConnHandler(`Events` or `Namespaces`).
 	OnAnyEvent = "_OnAnyEvent" // when event no match.
 	// OnNativeMessage is fired on incoming native/raw websocket messages.
@@ -42,15 +76,21 @@ var (
 	// with just the Message's Body filled, the Event is "OnNativeMessage" and IsNative always true.
 	// This event should be defined under an empty namespace in order this to work.
 	OnNativeMessage = "_OnNativeMessage"
+	// OnError is the event name which its callback is fired when an incoming message carries an error
+	// that no one waits for, i.e the remote event callback of an `Emit` (which does not wait for a reply) returned an error.
+	// The callback receives that message with its `Message.Err` filled and its `Message.Event` kept to the original event's name.
+	// When it's not registered the error is delivered to the original event's callback instead, as before.
+	// Errors of an `Ask` are always returned to its caller. It's never sent to the remote side.
+	OnError = "_OnError"
 )
 
 // IsSystemEvent reports whether the "event" is a system event,
 // OnNamespaceConnect, OnNamespaceConnected, OnNamespaceDisconnect,
-// OnRoomJoin, OnRoomJoined, OnRoomLeave and OnRoomLeft.
+// OnRoomJoin, OnRoomJoined, OnRoomLeave, OnRoomLeft, OnNamespaceSubscribe and OnError.
 func IsSystemEvent(event string) bool {
 	switch event {
 	case OnNamespaceConnect, OnNamespaceConnected, OnNamespaceDisconnect,
-		OnRoomJoin, OnRoomJoined, OnRoomLeave, OnRoomLeft:
+		OnRoomJoin, OnRoomJoined, OnRoomLeave, OnRoomLeft, OnNamespaceSubscribe, OnError:
 		return true
 	default:
 		return false
@@ -94,7 +134,7 @@ func IsCloseError(err error) bool {
 		return true
 	}
 
-	if err == io.ErrUnexpectedEOF || err == io.EOF {
+	if err == io.ErrUnexpectedEOF || err == io.EOF || err == io.ErrClosedPipe {
 		return true
 	}
 
@@ -0,0 +1,81 @@
+package neffos
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventStat is the collected metrics of an event of a namespace, see `Server.EventMetrics`.
+type EventStat struct {
+	Namespace string
+	Event     string
+	// Received is the number of the messages that the event callback handled.
+	Received uint64
+	// Errors is the number of the messages that the event callback returned an error for,
+	// the `Reply` results are not errors.
+	Errors uint64
+	// AvgDuration is the average execution time of the event callback.
+	AvgDuration time.Duration
+}
+
+type eventCounters struct {
+	namespace string
+	event     string
+
+	received uint64
+	errors   uint64
+	nanos    int64
+}
+
+// eventMetrics keeps the `eventCounters` by their namespace and event.
+type eventMetrics struct {
+	counters sync.Map
+}
+
+func eventMetricsKey(namespace, event string) string {
+	return namespace + ":" + event
+}
+
+func (m *eventMetrics) record(namespace, event string, d time.Duration, failed bool) {
+	key := eventMetricsKey(namespace, event)
+
+	v, ok := m.counters.Load(key)
+	if !ok {
+		v, _ = m.counters.LoadOrStore(key, &eventCounters{namespace: namespace, event: event})
+	}
+
+	counters := v.(*eventCounters)
+	atomic.AddUint64(&counters.received, 1)
+	atomic.AddInt64(&counters.nanos, int64(d))
+	if failed {
+		atomic.AddUint64(&counters.errors, 1)
+	}
+}
+
+// EventMetrics returns the collected metrics of the server-side event callbacks,
+// by their namespace and event in the form of "namespace:event",
+// i.e to find the hot and the erroring events. See `CollectEventMetrics`.
+// An unknown event handled by the `OnAnyEvent` is collected under the `OnAnyEvent`.
+func (s *Server) EventMetrics() map[string]EventStat {
+	stats := make(map[string]EventStat)
+
+	s.eventMetrics.counters.Range(func(key, value interface{}) bool {
+		counters := value.(*eventCounters)
+		stat := EventStat{
+			Namespace: counters.namespace,
+			Event:     counters.event,
+			Received:  atomic.LoadUint64(&counters.received),
+			Errors:    atomic.LoadUint64(&counters.errors),
+		}
+
+		if stat.Received > 0 {
+			stat.AvgDuration = time.Duration(atomic.LoadInt64(&counters.nanos) / int64(stat.Received))
+		}
+
+		stats[key.(string)] = stat
+		return true
+	})
+
+	return stats
+}
@@ -0,0 +1,33 @@
+package neffos
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// dropExpired reports whether the "expiresAt" of an outgoing message has passed,
+// the expired message is counted as dropped, see `Message.ExpiresAt`.
+func (c *Conn) dropExpired(expiresAt time.Time) bool {
+	if expiresAt.IsZero() || c.clock().Now().Before(expiresAt) {
+		return false
+	}
+
+	atomic.AddUint64(&c.droppedExpired, 1)
+	if !c.IsClient() {
+		atomic.AddUint64(&c.server.droppedExpired, 1)
+	}
+
+	return true
+}
+
+// DroppedExpired returns the number of the outgoing messages of this connection
+// that were dropped instead of sent because their `Message.ExpiresAt` had passed.
+func (c *Conn) DroppedExpired() uint64 {
+	return atomic.LoadUint64(&c.droppedExpired)
+}
+
+// DroppedExpired returns the number of the outgoing messages of all the server's connections
+// that were dropped instead of sent because their `Message.ExpiresAt` had passed.
+func (s *Server) DroppedExpired() uint64 {
+	return atomic.LoadUint64(&s.droppedExpired)
+}
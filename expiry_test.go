@@ -0,0 +1,44 @@
+package neffos
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMessageExpiresAt(t *testing.T) {
+	namespaces := Namespaces{"default": Events{}}
+	clock := &fakeClock{now: time.Now()}
+
+	c := newConn(newPipeSocket(), namespaces, nil)
+	c.setClock(clock)
+	c.connectedNamespaces["default"] = newNSConn(c, "default", namespaces["default"])
+	defer c.Close()
+
+	msg := Message{Namespace: "default", Event: "position", ExpiresAt: clock.Now().Add(time.Second)}
+
+	// queued until the acknowledgement.
+	if !c.Write(msg) || !c.Write(Message{Namespace: "default", Event: "chat"}) {
+		t.Fatalf("expected the writes to be queued")
+	}
+
+	clock.Advance(2 * time.Second)
+	c.acknowledge()
+
+	if expected, got := uint64(1), c.DroppedExpired(); expected != got {
+		t.Fatalf("expected %d dropped messages but got %d", expected, got)
+	}
+
+	// already expired.
+	if c.Write(msg) {
+		t.Fatalf("expected the expired message to be dropped")
+	}
+
+	msg.ExpiresAt = clock.Now().Add(time.Second)
+	if !c.Write(msg) {
+		t.Fatalf("expected the message to be sent")
+	}
+
+	if expected, got := uint64(2), c.DroppedExpired(); expected != got {
+		t.Fatalf("expected %d dropped messages but got %d", expected, got)
+	}
+}
@@ -2,6 +2,8 @@ package gobwas
 
 import (
 	"context"
+	"crypto/tls"
+	"net"
 
 	"github.com/kataras/neffos"
 
@@ -11,16 +13,50 @@ import (
 // DefaultDialer is a gobwas/ws dialer with all fields set to the default values.
 var DefaultDialer = Dialer(gobwas.DefaultDialer)
 
+// DialerOption modifies the gobwas/ws dialer of a `Dialer`, see `WithTLSConfig` and `WithNetDial`.
+type DialerOption func(dialer *gobwas.Dialer)
+
+// WithTLSConfig sets the TLS configuration of the "wss://" connections,
+// i.e its `RootCAs` to trust the certificate of a server signed by a private CA
+// or its `Certificates` to send a client certificate.
+// Its `ServerName` is filled by the url's host if empty.
+//
+// Note that the `InsecureSkipVerify` field disables the verification of the server's certificate,
+// any certificate is accepted and the connection is exposed to man-in-the-middle attacks,
+// it should be used only for testing, prefer to add the private CA to the `RootCAs` instead.
+func WithTLSConfig(config *tls.Config) DialerOption {
+	return func(dialer *gobwas.Dialer) {
+		dialer.TLSConfig = config
+	}
+}
+
+// WithNetDial sets the function which opens the plain tcp connection of the dial.
+// The gobwas/ws dialer does not support HTTP proxies,
+// a tunnel through a proxy can be opened by a custom "dial" instead.
+func WithNetDial(dial func(ctx context.Context, network, addr string) (net.Conn, error)) DialerOption {
+	return func(dialer *gobwas.Dialer) {
+		dialer.NetDial = dial
+	}
+}
+
 // Dialer is a `neffos.Dialer` type for the gobwas/ws subprotocol implementation.
 // Should be used on `Dial` to create a new client/client-side connection.
 // To send headers to the server set the dialer's `Header` field to a `gobwas.HandshakeHeaderHTTP`.
-func Dialer(dialer gobwas.Dialer) neffos.Dialer {
+// To request websocket subprotocols set the dialer's `Protocols` field.
+func Dialer(dialer gobwas.Dialer, options ...DialerOption) neffos.Dialer {
+	for _, opt := range options {
+		opt(&dialer)
+	}
+
 	return func(ctx context.Context, url string) (neffos.Socket, error) {
-		underline, _, _, err := dialer.Dial(ctx, url)
+		underline, _, hs, err := dialer.Dial(ctx, url)
 		if err != nil {
 			return nil, err
 		}
 
-		return newSocket(underline, nil, true), nil
+		socket := newSocket(underline, nil, true)
+		socket.subprotocol = hs.Protocol
+		socket.extensions = extensionNames(hs)
+		return socket, nil
 	}
 }
@@ -8,6 +8,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/kataras/neffos"
+
 	gobwas "github.com/gobwas/ws"
 	"github.com/gobwas/ws/wsutil"
 )
@@ -21,6 +23,19 @@ type Socket struct {
 	reader         *wsutil.Reader
 	controlHandler wsutil.FrameHandlerFunc
 	state          gobwas.State
+	// the negotiated websocket subprotocol.
+	subprotocol string
+	// the names of the negotiated websocket extensions.
+	extensions []string
+
+	// non-nil when registered, see `SetPongHandler`.
+	onPong func(data []byte)
+
+	// the limits of the incoming messages and the frames and size of the current one, see `SetFragmentLimits`.
+	maxFragments   int
+	maxMessageSize int64
+	fragments      int
+	messageSize    int64
 
 	mu sync.Mutex
 }
@@ -38,20 +53,48 @@ func newSocket(underline net.Conn, request *http.Request, client bool) *Socket {
 		State:           state,
 		CheckUTF8:       true,
 		SkipHeaderCheck: false,
-		// "intermediate" frames, that possibly could
-		// be received between text/binary continuation frames.
-		// Read `gobwas/wsutil/reader#NextReader`.
-		//
-		OnIntermediate: controlHandler,
 	}
 
-	return &Socket{
+	s := &Socket{
 		UnderlyingConn: underline,
 		request:        request,
 		state:          state,
 		reader:         reader,
 		controlHandler: controlHandler,
 	}
+	// "intermediate" frames, that possibly could
+	// be received between text/binary continuation frames.
+	// Read `gobwas/wsutil/reader#NextReader`.
+	//
+	reader.OnIntermediate = s.handleControl
+	reader.OnContinuation = s.onContinuation
+
+	return s
+}
+
+// SetFragmentLimits sets the maximum number of frames and the maximum total size, in bytes,
+// of an incoming message, a message that exceeds them fails the `ReadData` with a `neffos.ErrFragmentLimit`.
+// Zero or negative value means no limit.
+func (s *Socket) SetFragmentLimits(maxFragments int, maxMessageSize int64) {
+	s.maxFragments = maxFragments
+	s.maxMessageSize = maxMessageSize
+}
+
+// checkFragment counts a frame of the current message against the limits.
+func (s *Socket) checkFragment(length int64) error {
+	s.fragments++
+	s.messageSize += length
+
+	if (s.maxFragments > 0 && s.fragments > s.maxFragments) ||
+		(s.maxMessageSize > 0 && s.messageSize > s.maxMessageSize) {
+		return neffos.ErrFragmentLimit
+	}
+
+	return nil
+}
+
+func (s *Socket) onContinuation(hdr gobwas.Header, _ io.Reader) error {
+	return s.checkFragment(hdr.Length)
 }
 
 // NetConn returns the underline net connection.
@@ -64,6 +107,56 @@ func (s *Socket) Request() *http.Request {
 	return s.request
 }
 
+// Subprotocol returns the negotiated websocket subprotocol.
+func (s *Socket) Subprotocol() string {
+	return s.subprotocol
+}
+
+// Extensions returns the names of the negotiated websocket extensions.
+func (s *Socket) Extensions() []string {
+	return s.extensions
+}
+
+// extensionNames returns the names of the extensions negotiated on the "hs" handshake.
+func extensionNames(hs gobwas.Handshake) []string {
+	if len(hs.Extensions) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(hs.Extensions))
+	for _, ext := range hs.Extensions {
+		names = append(names, string(ext.Name))
+	}
+
+	return names
+}
+
+// Ping sends a ping control frame with the "data" as its payload.
+func (s *Socket) Ping(data []byte, timeout time.Duration) error {
+	return s.write(data, gobwas.OpPing, timeout)
+}
+
+// SetPongHandler registers the "handler" to be called, by the `ReadData`, with the payload of each incoming pong.
+// It should be called before the first `ReadData`.
+func (s *Socket) SetPongHandler(handler func(data []byte)) {
+	s.onPong = handler
+}
+
+// handleControl handles the control frame of the "hdr", its payload is read from the "r".
+func (s *Socket) handleControl(hdr gobwas.Header, r io.Reader) error {
+	if hdr.OpCode == gobwas.OpPong && s.onPong != nil {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+
+		s.onPong(data)
+		return nil
+	}
+
+	return s.controlHandler(hdr, r)
+}
+
 // ReadData reads binary or text messages from the remote connection.
 func (s *Socket) ReadData(timeout time.Duration) ([]byte, error) {
 	for {
@@ -84,7 +177,7 @@ func (s *Socket) ReadData(timeout time.Duration) ([]byte, error) {
 		}
 
 		if hdr.OpCode.IsControl() {
-			err = s.controlHandler(hdr, s.reader)
+			err = s.handleControl(hdr, s.reader)
 			if err != nil {
 				return nil, err
 			}
@@ -99,6 +192,13 @@ func (s *Socket) ReadData(timeout time.Duration) ([]byte, error) {
 			continue
 		}
 
+		// the continuation frames are counted on read, see `onContinuation`.
+		s.fragments = 0
+		s.messageSize = 0
+		if err = s.checkFragment(hdr.Length); err != nil {
+			return nil, err
+		}
+
 		return ioutil.ReadAll(s.reader)
 	}
 
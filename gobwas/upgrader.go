@@ -11,15 +11,37 @@ import (
 // DefaultUpgrader is a gobwas/ws HTTP Upgrader with all fields set to the default values.
 var DefaultUpgrader = Upgrader(gobwas.HTTPUpgrader{})
 
+// GobwasUpgrader returns a new gobwas/ws `neffos.Upgrader` with all fields set to the default values.
+// The gobwas one has a lower memory footprint per connection, see `neffos.Upgrader` too.
+func GobwasUpgrader() neffos.Upgrader {
+	return Upgrader(gobwas.HTTPUpgrader{})
+}
+
 // Upgrader is a `neffos.Upgrader` type for the gobwas/ws subprotocol implementation.
 // Should be used on `neffos.New` to construct the neffos server.
+// If the "upgrader"'s `Protocol` field is nil,
+// the subprotocol selected by the `neffos.Server.Subprotocols` is used instead.
 func Upgrader(upgrader gobwas.HTTPUpgrader) neffos.Upgrader {
 	return func(w http.ResponseWriter, r *http.Request) (neffos.Socket, error) {
-		underline, _, _, err := upgrader.Upgrade(r, w)
+		u := withSubprotocol(upgrader, w.Header().Get(neffos.SubprotocolHeaderKey))
+		underline, _, hs, err := u.Upgrade(r, w)
 		if err != nil {
 			return nil, err
 		}
 
-		return newSocket(underline, r, false), nil
+		socket := newSocket(underline, r, false)
+		socket.subprotocol = hs.Protocol
+		socket.extensions = extensionNames(hs)
+		return socket, nil
+	}
+}
+
+// withSubprotocol returns a copy of the "upgrader" which accepts only the "protocol"
+// if it's not empty and the "upgrader" does not select subprotocols by itself.
+func withSubprotocol(upgrader gobwas.HTTPUpgrader, protocol string) gobwas.HTTPUpgrader {
+	if protocol != "" && upgrader.Protocol == nil {
+		upgrader.Protocol = func(p string) bool { return p == protocol }
 	}
+
+	return upgrader
 }
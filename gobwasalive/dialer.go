@@ -21,12 +21,15 @@ var twDialerRunning = false
 // To send headers to the server set the dialer's `Header` field to a `gobwas.HandshakeHeaderHTTP`.
 func dialer(dialer gobwas.Dialer, idleTime time.Duration) neffos.Dialer {
     return func(ctx context.Context, url string) (neffos.Socket, error) {
-        underline, _, _, err := dialer.Dial(ctx, url)
+        underline, _, hs, err := dialer.Dial(ctx, url)
         if err != nil {
             return nil, err
         }
 
-        return newSocket(underline, nil, true, idleTime, twDialer), nil
+        socket := newSocket(underline, nil, true, idleTime, twDialer)
+        socket.subprotocol = hs.Protocol
+        socket.extensions = extensionNames(hs)
+        return socket, nil
     }
 }
 
@@ -23,6 +23,10 @@ type Socket struct {
 	reader         *wsutil.Reader
 	controlHandler wsutil.FrameHandlerFunc
 	state          gobwas.State
+	// the negotiated websocket subprotocol.
+	subprotocol string
+	// the names of the negotiated websocket extensions.
+	extensions []string
 
 	mu sync.Mutex
 
@@ -77,6 +81,30 @@ func (s *Socket) Request() *http.Request {
 	return s.request
 }
 
+// Subprotocol returns the negotiated websocket subprotocol.
+func (s *Socket) Subprotocol() string {
+	return s.subprotocol
+}
+
+// Extensions returns the names of the negotiated websocket extensions.
+func (s *Socket) Extensions() []string {
+	return s.extensions
+}
+
+// extensionNames returns the names of the extensions negotiated on the "hs" handshake.
+func extensionNames(hs gobwas.Handshake) []string {
+	if len(hs.Extensions) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(hs.Extensions))
+	for _, ext := range hs.Extensions {
+		names = append(names, string(ext.Name))
+	}
+
+	return names
+}
+
 const MinPingTime = 10 * time.Second
 
 func (s *Socket) SendPing() {
@@ -20,12 +20,20 @@ var twServerRunning = false
 // Should be used on `neffos.New` to construct the neffos server.
 func upgrader(upgrader gobwas.HTTPUpgrader, idleTime time.Duration) neffos.Upgrader {
     return func(w http.ResponseWriter, r *http.Request) (neffos.Socket, error) {
-        underline, _, _, err := upgrader.Upgrade(r, w)
+        u := upgrader
+        if protocol := w.Header().Get(neffos.SubprotocolHeaderKey); protocol != "" && u.Protocol == nil {
+            u.Protocol = func(p string) bool { return p == protocol }
+        }
+
+        underline, _, hs, err := u.Upgrade(r, w)
         if err != nil {
             return nil, err
         }
 
-        return newSocket(underline, r, false, idleTime, twServer), nil
+        socket := newSocket(underline, r, false, idleTime, twServer)
+        socket.subprotocol = hs.Protocol
+        socket.extensions = extensionNames(hs)
+        return socket, nil
     }
 }
 
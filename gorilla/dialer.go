@@ -2,7 +2,9 @@ package gorilla
 
 import (
 	"context"
+	"crypto/tls"
 	"net/http"
+	"net/url"
 
 	"github.com/kataras/neffos"
 
@@ -12,15 +14,66 @@ import (
 // DefaultDialer is a gorilla/websocket dialer with all fields set to the default values.
 var DefaultDialer = Dialer(gorilla.DefaultDialer, make(http.Header))
 
+// DialerOption modifies the gorilla/websocket dialer of a `Dialer`,
+// see `WithTLSConfig`, `WithProxy` and `WithTransport`.
+type DialerOption func(dialer *gorilla.Dialer)
+
+// WithTLSConfig sets the TLS configuration of the "wss://" connections,
+// i.e its `RootCAs` to trust the certificate of a server signed by a private CA
+// or its `Certificates` to send a client certificate.
+// Its `ServerName` is filled by the url's host if empty.
+//
+// Note that the `InsecureSkipVerify` field disables the verification of the server's certificate,
+// any certificate is accepted and the connection is exposed to man-in-the-middle attacks,
+// it should be used only for testing, prefer to add the private CA to the `RootCAs` instead.
+func WithTLSConfig(config *tls.Config) DialerOption {
+	return func(dialer *gorilla.Dialer) {
+		dialer.TLSClientConfig = config
+	}
+}
+
+// WithProxy sets the function which returns the proxy of the dial's request,
+// i.e `http.ProxyFromEnvironment` or `http.ProxyURL(proxyURL)`.
+func WithProxy(proxy func(*http.Request) (*url.URL, error)) DialerOption {
+	return func(dialer *gorilla.Dialer) {
+		dialer.Proxy = proxy
+	}
+}
+
+// WithTransport sets the proxy, the TLS configuration and the dial function
+// of a custom "transport" to the dialer, the rest of its fields are not used.
+// See `WithTLSConfig` for the TLS configuration's notes.
+func WithTransport(transport *http.Transport) DialerOption {
+	return func(dialer *gorilla.Dialer) {
+		dialer.Proxy = transport.Proxy
+		dialer.TLSClientConfig = transport.TLSClientConfig
+		if transport.DialContext != nil {
+			dialer.NetDialContext = transport.DialContext
+		}
+	}
+}
+
 // Dialer is a `neffos.Dialer` type for the gorilla/websocket subprotocol implementation.
 // Should be used on `Dial` to create a new client/client-side connection.
-func Dialer(dialer *gorilla.Dialer, requestHeader http.Header) neffos.Dialer {
+// To request websocket subprotocols set the dialer's `Subprotocols` field.
+// The "options" are applied to a copy of the "dialer".
+func Dialer(dialer *gorilla.Dialer, requestHeader http.Header, options ...DialerOption) neffos.Dialer {
+	if len(options) > 0 {
+		d := *dialer
+		for _, opt := range options {
+			opt(&d)
+		}
+		dialer = &d
+	}
+
 	return func(ctx context.Context, url string) (neffos.Socket, error) {
-		underline, _, err := dialer.DialContext(ctx, url, requestHeader)
+		underline, resp, err := dialer.DialContext(ctx, url, requestHeader)
 		if err != nil {
 			return nil, err
 		}
 
-		return newSocket(underline, nil, true), nil
+		socket := newSocket(underline, nil, true)
+		socket.extensions = extensionNames(resp.Header)
+		return socket, nil
 	}
 }
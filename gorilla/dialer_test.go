@@ -0,0 +1,52 @@
+package gorilla
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kataras/neffos"
+
+	gorilla "github.com/gorilla/websocket"
+)
+
+func TestDialerTLS(t *testing.T) {
+	events := neffos.Namespaces{"default": neffos.Events{}}
+
+	server := httptest.NewTLSServer(neffos.New(DefaultUpgrader, events))
+	defer server.Close()
+
+	url := "wss" + strings.TrimPrefix(server.URL, "https")
+
+	// the server's certificate is signed by an unknown authority.
+	if _, err := neffos.Dial(nil, DefaultDialer, url, events); err == nil {
+		t.Fatalf("expected a certificate error")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+
+	dialers := map[string]neffos.Dialer{
+		"WithTLSConfig": Dialer(gorilla.DefaultDialer, make(http.Header), WithTLSConfig(&tls.Config{RootCAs: roots})),
+		"WithTransport": Dialer(gorilla.DefaultDialer, make(http.Header), WithTransport(server.Client().Transport.(*http.Transport))),
+	}
+
+	for name, dialer := range dialers {
+		client, err := neffos.Dial(nil, dialer, url, events)
+		if err != nil {
+			t.Fatalf("[%s] %v", name, err)
+		}
+
+		if _, err = client.Connect(nil, "default"); err != nil {
+			t.Fatalf("[%s] %v", name, err)
+		}
+		client.Close()
+	}
+
+	if gorilla.DefaultDialer.TLSClientConfig != nil {
+		t.Fatalf("expected the options to not modify the given dialer")
+	}
+}
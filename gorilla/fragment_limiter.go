@@ -0,0 +1,148 @@
+package gorilla
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"net/http"
+
+	"github.com/kataras/neffos"
+)
+
+// fragmentLimiter is a `net.Conn` which parses the headers of the incoming websocket frames,
+// as they are read by the gorilla connection, to enforce the limits of the fragmented messages
+// before they are reassembled, see `Socket.SetFragmentLimits`.
+type fragmentLimiter struct {
+	net.Conn
+
+	maxFragments   int
+	maxMessageSize int64
+
+	// the read bytes of the current frame's header.
+	header    [14]byte
+	headerLen int
+	// the unread payload bytes of the current frame.
+	remaining int64
+	// the frames and the size of the current message.
+	fragments   int
+	messageSize int64
+}
+
+func (l *fragmentLimiter) enabled() bool {
+	return l.maxFragments > 0 || l.maxMessageSize > 0
+}
+
+func (l *fragmentLimiter) Read(p []byte) (int, error) {
+	n, err := l.Conn.Read(p)
+	if n > 0 && l.enabled() {
+		if limitErr := l.parse(p[:n]); limitErr != nil {
+			return 0, limitErr
+		}
+	}
+
+	return n, err
+}
+
+func (l *fragmentLimiter) parse(b []byte) error {
+	for len(b) > 0 {
+		if l.remaining > 0 {
+			if int64(len(b)) <= l.remaining {
+				l.remaining -= int64(len(b))
+				return nil
+			}
+
+			b = b[l.remaining:]
+			l.remaining = 0
+			continue
+		}
+
+		l.header[l.headerLen] = b[0]
+		l.headerLen++
+		b = b[1:]
+
+		if l.headerLen < 2 || l.headerLen < frameHeaderLen(l.header[1]) {
+			continue
+		}
+
+		var length int64
+		switch n := l.header[1] & 0x7f; n {
+		case 126:
+			length = int64(binary.BigEndian.Uint16(l.header[2:4]))
+		case 127:
+			length = int64(binary.BigEndian.Uint64(l.header[2:10]))
+		default:
+			length = int64(n)
+		}
+
+		l.headerLen = 0
+		l.remaining = length
+
+		if err := l.frame(l.header[0]&0x0f, length); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// frameHeaderLen returns the length of a frame's header by its second byte.
+func frameHeaderLen(b byte) int {
+	n := 2
+	switch b & 0x7f {
+	case 126:
+		n += 2
+	case 127:
+		n += 8
+	}
+
+	if b&0x80 != 0 { // masked.
+		n += 4
+	}
+
+	return n
+}
+
+func (l *fragmentLimiter) frame(opCode byte, length int64) error {
+	if opCode&0x08 != 0 {
+		// control frames are not part of the message.
+		return nil
+	}
+
+	if opCode != 0 {
+		// a text or binary frame starts a new message,
+		// the rest of its frames are continuation ones.
+		l.fragments = 0
+		l.messageSize = 0
+	}
+
+	l.fragments++
+	l.messageSize += length
+
+	if (l.maxFragments > 0 && l.fragments > l.maxFragments) ||
+		(l.maxMessageSize > 0 && l.messageSize > l.maxMessageSize) {
+		return neffos.ErrFragmentLimit
+	}
+
+	return nil
+}
+
+// limitedResponseWriter passes the `fragmentLimiter` instead of the hijacked connection to the gorilla upgrader.
+type limitedResponseWriter struct {
+	http.ResponseWriter
+	limiter *fragmentLimiter
+}
+
+func (w *limitedResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+
+	conn, brw, err := h.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	w.limiter.Conn = conn
+	return w.limiter, brw, nil
+}
@@ -3,6 +3,7 @@ package gorilla
 import (
 	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -16,6 +17,12 @@ type Socket struct {
 	request        *http.Request
 
 	client bool
+	// messages smaller than this are not compressed, see `SetCompressionThreshold`.
+	compressionThreshold int
+	// non-nil on server-side, see `SetFragmentLimits`.
+	limiter *fragmentLimiter
+	// the names of the negotiated websocket extensions.
+	extensions []string
 
 	mu sync.Mutex
 }
@@ -38,6 +45,79 @@ func (s *Socket) Request() *http.Request {
 	return s.request
 }
 
+// Subprotocol returns the negotiated websocket subprotocol.
+func (s *Socket) Subprotocol() string {
+	return s.UnderlyingConn.Subprotocol()
+}
+
+// Extensions returns the names of the negotiated websocket extensions.
+func (s *Socket) Extensions() []string {
+	return s.extensions
+}
+
+// compressionExtension is the only extension that gorilla/websocket negotiates.
+const compressionExtension = "permessage-deflate"
+
+// extensionNames returns the names of the extensions of the "Sec-WebSocket-Extensions" header.
+func extensionNames(header http.Header) []string {
+	var names []string
+	for _, value := range header["Sec-Websocket-Extensions"] {
+		for _, ext := range strings.Split(value, ",") {
+			// the name is followed by its parameters, i.e "permessage-deflate; client_no_context_takeover".
+			if name := strings.TrimSpace(strings.Split(ext, ";")[0]); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+
+	return names
+}
+
+// SetCompressionThreshold sets the minimum size, in bytes, of a message to be compressed
+// when the per-message compression is negotiated.
+// Zero or negative value compresses all messages.
+func (s *Socket) SetCompressionThreshold(n int) {
+	s.mu.Lock()
+	s.compressionThreshold = n
+	s.mu.Unlock()
+}
+
+// SetFragmentLimits sets the maximum number of frames and the maximum total size, in bytes,
+// of an incoming message, a message that exceeds them fails the `ReadData` with a `neffos.ErrFragmentLimit`.
+// Zero or negative value means no limit.
+// The frames are counted on server-side sockets only, the client-side ones limit the size
+// through the gorilla's `SetReadLimit` instead.
+// It should be called before the first `ReadData`.
+func (s *Socket) SetFragmentLimits(maxFragments int, maxMessageSize int64) {
+	if s.limiter == nil {
+		if maxMessageSize > 0 {
+			s.UnderlyingConn.SetReadLimit(maxMessageSize)
+		}
+		return
+	}
+
+	s.limiter.maxFragments = maxFragments
+	s.limiter.maxMessageSize = maxMessageSize
+}
+
+// Ping sends a ping control frame with the "data" as its payload.
+func (s *Socket) Ping(data []byte, timeout time.Duration) error {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	return s.UnderlyingConn.WriteControl(gorilla.PingMessage, data, deadline)
+}
+
+// SetPongHandler registers the "handler" to be called, by the `ReadData`, with the payload of each incoming pong.
+func (s *Socket) SetPongHandler(handler func(data []byte)) {
+	s.UnderlyingConn.SetPongHandler(func(appData string) error {
+		handler([]byte(appData))
+		return nil
+	})
+}
+
 // ReadData reads binary or text messages from the remote connection.
 func (s *Socket) ReadData(timeout time.Duration) ([]byte, error) {
 	for {
@@ -75,6 +155,10 @@ func (s *Socket) write(body []byte, opCode int, timeout time.Duration) error {
 	}
 
 	s.mu.Lock()
+	if s.compressionThreshold > 0 {
+		// no-op if compression is not negotiated.
+		s.UnderlyingConn.EnableWriteCompression(len(body) >= s.compressionThreshold)
+	}
 	err := s.UnderlyingConn.WriteMessage(opCode, body)
 	s.mu.Unlock()
 
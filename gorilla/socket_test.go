@@ -0,0 +1,94 @@
+package gorilla
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kataras/neffos"
+
+	gorilla "github.com/gorilla/websocket"
+)
+
+// BenchmarkCompression measures the per-message compression cost
+// that gorilla/websocket pays (it uses flate.BestSpeed) for different message sizes,
+// it is used to justify the `neffos.DefaultCompressionThreshold`.
+func BenchmarkCompression(b *testing.B) {
+	for _, size := range []int{64, 256, 1024, 4096} {
+		body := make([]byte, 0, size)
+		for i := 0; len(body) < size; i++ {
+			body = append(body, fmt.Sprintf(`{"id":%d,"event":"chat","room":"room%d"},`, i, i%7)...)
+		}
+		body = body[:size]
+
+		b.Run(fmt.Sprintf("%dB", size), func(b *testing.B) {
+			var buf bytes.Buffer
+			w, _ := flate.NewWriter(&buf, flate.BestSpeed)
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				w.Reset(&buf)
+				w.Write(body)
+				w.Flush()
+			}
+			b.Logf("%d bytes compressed to %d bytes", size, buf.Len())
+		})
+	}
+}
+
+func TestSocketExtensions(t *testing.T) {
+	events := neffos.Namespaces{"default": neffos.Events{}}
+
+	serverConns := make(chan *neffos.Conn, 2)
+	server := neffos.New(Upgrader(gorilla.Upgrader{EnableCompression: true}), events)
+	server.OnConnect = func(c *neffos.Conn) error {
+		serverConns <- c
+		return nil
+	}
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	url := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+
+	tests := []struct {
+		compression bool
+		expected    []string
+	}{
+		{true, []string{"permessage-deflate"}},
+		{false, nil},
+	}
+
+	for _, tt := range tests {
+		dialer := Dialer(&gorilla.Dialer{EnableCompression: tt.compression}, make(http.Header))
+		client, err := neffos.Dial(nil, dialer, url, events)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c, err := client.Connect(nil, "default")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := c.Conn.Extensions(); !reflect.DeepEqual(tt.expected, got) {
+			t.Fatalf("[compression: %v] expected client-side extensions: %v but got: %v", tt.compression, tt.expected, got)
+		}
+
+		serverConn := <-serverConns
+		if got := serverConn.Extensions(); !reflect.DeepEqual(tt.expected, got) {
+			t.Fatalf("[compression: %v] expected server-side extensions: %v but got: %v", tt.compression, tt.expected, got)
+		}
+
+		if got := serverConn.Info().Extensions; !reflect.DeepEqual(tt.expected, got) {
+			t.Fatalf("[compression: %v] expected info's extensions: %v but got: %v", tt.compression, tt.expected, got)
+		}
+
+		client.Close()
+	}
+}
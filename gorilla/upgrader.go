@@ -11,15 +11,35 @@ import (
 // DefaultUpgrader is a gorilla/websocket Upgrader with all fields set to the default values.
 var DefaultUpgrader = Upgrader(gorilla.Upgrader{})
 
+// GorillaUpgrader returns a new gorilla/websocket `neffos.Upgrader` with all fields set to the default values.
+// The gorilla one is the most feature-complete (i.e supports per-message compression), see `neffos.Upgrader` too.
+func GorillaUpgrader() neffos.Upgrader {
+	return Upgrader(gorilla.Upgrader{})
+}
+
 // Upgrader is a `neffos.Upgrader` type for the gorilla/websocket subprotocol implementation.
 // Should be used on `New` to construct the neffos server.
+// If the "upgrader"'s `Subprotocols` field is empty,
+// the subprotocol selected by the `neffos.Server.Subprotocols` is used instead.
 func Upgrader(upgrader gorilla.Upgrader) neffos.Upgrader {
 	return func(w http.ResponseWriter, r *http.Request) (neffos.Socket, error) {
-		underline, err := upgrader.Upgrade(w, r, w.Header())
+		limiter := new(fragmentLimiter)
+		underline, err := upgrader.Upgrade(&limitedResponseWriter{w, limiter}, r, w.Header())
 		if err != nil {
 			return nil, err
 		}
 
-		return newSocket(underline, r, false), nil
+		socket := newSocket(underline, r, false)
+		socket.limiter = limiter
+		if upgrader.EnableCompression {
+			// negotiated when requested, the same way the gorilla's upgrader does.
+			for _, name := range extensionNames(r.Header) {
+				if name == compressionExtension {
+					socket.extensions = []string{compressionExtension}
+					break
+				}
+			}
+		}
+		return socket, nil
 	}
 }
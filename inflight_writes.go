@@ -0,0 +1,45 @@
+package neffos
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrWriteQueueFull is the write result of a message which was dropped
+// because the connection reached the `Server.MaxInflightWrites`, see `Conn.WriteResult`.
+var ErrWriteQueueFull = errors.New("write queue full")
+
+// InflightWrites returns the number of the outgoing messages of this connection which are written
+// but not flushed to its socket yet: the ones that wait for a slow socket write to complete
+// and the ones that are queued until the acknowledgement (see `WriteQueueLen`).
+// It's a cheap atomic read, i.e for a backpressure gauge, see `Server.MaxInflightWrites`.
+func (c *Conn) InflightWrites() int {
+	return int(atomic.LoadInt32(&c.inflightWrites))
+}
+
+func (c *Conn) maxInflightWrites() int {
+	if c.IsClient() {
+		return 0
+	}
+
+	return c.server.MaxInflightWrites
+}
+
+// acquireInflightWrite counts the "w" as inflight until its `done`, it reports false if the "w" is dropped
+// because the connection reached the `Server.MaxInflightWrites`, replies and system messages are never dropped.
+func (c *Conn) acquireInflightWrite(w *pendingWrite) bool {
+	n := int(atomic.AddInt32(&c.inflightWrites, 1))
+	if max := c.maxInflightWrites(); max > 0 && n > max &&
+		w.msg.Event != "" && w.msg.wait == "" && !IsSystemEvent(w.msg.Event) {
+		atomic.AddInt32(&c.inflightWrites, -1)
+		w.done(ErrWriteQueueFull)
+
+		if cb := c.server.OnWriteQueueFull; cb != nil {
+			cb(c, w.msg)
+		}
+		return false
+	}
+
+	w.inflight = &c.inflightWrites
+	return true
+}
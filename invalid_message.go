@@ -0,0 +1,37 @@
+package neffos
+
+// handleInvalidMessage reports the "raw" bytes of an incoming message that can not be deserialized
+// to the `Server.OnInvalidMessage` and closes the connection when it sends too many of them,
+// see `Server.MaxInvalidMessages`.
+func (c *Conn) handleInvalidMessage(raw []byte) {
+	if c.IsClient() {
+		return
+	}
+
+	if c.server.OnInvalidMessage != nil {
+		c.server.OnInvalidMessage(c, raw)
+	}
+
+	max := c.server.MaxInvalidMessages
+	if max <= 0 {
+		return
+	}
+
+	now := c.clock().Now()
+
+	c.invalidMessagesMutex.Lock()
+	if window := c.server.InvalidMessagesWindow; window > 0 && now.Sub(c.invalidWindowStart) > window {
+		c.invalidMessages = 0
+	}
+	if c.invalidMessages == 0 {
+		c.invalidWindowStart = now
+	}
+	c.invalidMessages++
+	exceeded := c.invalidMessages > max
+	c.invalidMessagesMutex.Unlock()
+
+	if exceeded {
+		c.setCloseError(ErrInvalidPayload)
+		c.Close()
+	}
+}
@@ -0,0 +1,52 @@
+package neffos
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestConnInvalidMessages(t *testing.T) {
+	namespaces := Namespaces{"default": Events{}}
+	s := New(nil, namespaces)
+	defer s.Close()
+
+	var invalid [][]byte
+	s.OnInvalidMessage = func(c *Conn, raw []byte) {
+		invalid = append(invalid, raw)
+	}
+	s.MaxInvalidMessages = 2
+	s.InvalidMessagesWindow = time.Minute
+
+	clock := &fakeClock{now: time.Now()}
+	c := newConn(newPipeSocket(), namespaces, nil)
+	c.server = s
+	c.setClock(clock)
+	c.acknowledge()
+
+	c.HandlePayload([]byte("garbage1"))
+	c.HandlePayload([]byte("garbage2"))
+
+	if expected, got := 2, len(invalid); expected != got {
+		t.Fatalf("expected %d invalid messages but got %d", expected, got)
+	}
+	if !bytes.Equal([]byte("garbage1"), invalid[0]) {
+		t.Fatalf("expected the raw bytes of the invalid message but got: %q", invalid[0])
+	}
+
+	// the count starts over after the window.
+	clock.Advance(2 * time.Minute)
+	c.HandlePayload([]byte("garbage3"))
+	c.HandlePayload([]byte("garbage4"))
+	if c.IsClosed() {
+		t.Fatalf("expected the connection to be kept inside the limit")
+	}
+
+	c.HandlePayload([]byte("garbage5"))
+	if !c.IsClosed() {
+		t.Fatalf("expected the connection to be closed after too many invalid messages")
+	}
+	if err := c.Wait(); err != ErrInvalidPayload {
+		t.Fatalf("expected close error: %v but got: %v", ErrInvalidPayload, err)
+	}
+}
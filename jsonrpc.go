@@ -0,0 +1,193 @@
+package neffos
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// JSON-RPC 2.0 pre-defined error codes, see `JSONRPCError`.
+const (
+	JSONRPCParseError     = -32700
+	JSONRPCInvalidRequest = -32600
+	JSONRPCMethodNotFound = -32601
+	JSONRPCInvalidParams  = -32602
+	JSONRPCInternalError  = -32603
+	// JSONRPCServerError is the code of the errors returned by the methods
+	// that are not a `*JSONRPCError` themselves.
+	JSONRPCServerError = -32000
+)
+
+// JSONRPCError is the error object of a JSON-RPC 2.0 response.
+// A method registered on the `NewJSONRPCNamespace` can return a `*JSONRPCError`
+// to control the response's error code and data, i.e `JSONRPCInvalidParams`,
+// any other error is sent with the `JSONRPCServerError` code and its text as the message.
+type JSONRPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// Error completes the Go error interface.
+func (err *JSONRPCError) Error() string {
+	return err.Message
+}
+
+type jsonrpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	// empty on notifications, "null" when the id is null.
+	ID json.RawMessage `json:"id"`
+}
+
+type jsonrpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *JSONRPCError   `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type jsonrpcMethods map[string]func(params json.RawMessage) (interface{}, error)
+
+// NewJSONRPCNamespace returns the events of a namespace which speaks the JSON-RPC 2.0 protocol
+// over native websocket messages, so web clients that use a generic JSON-RPC library
+// can talk to a neffos server without the neffos protocol.
+//
+// Each incoming request calls the method of the "methods" with the same name
+// and the method's result or error is sent back as the response with the request's id,
+// like an `Ask` and its reply. Batch requests are answered with a single batch response
+// and notifications, requests without an id, are not answered at all.
+// The methods run on the connection's read loop, in order, like any other event callback,
+// a method's error passes through the `Server.ErrorTransformer` if it's not a `*JSONRPCError`.
+//
+// The result should be passed on the `New` function directly, it registers the empty namespace
+// with the `OnNativeMessage` event only, so the connections handle native messages only.
+//
+// Example Code:
+//
+//	server := neffos.New(gorilla.DefaultUpgrader, neffos.NewJSONRPCNamespace(map[string]func(json.RawMessage) (interface{}, error){
+//		"sum": func(params json.RawMessage) (interface{}, error) {
+//			var numbers []int
+//			if err := json.Unmarshal(params, &numbers); err != nil {
+//				return nil, &neffos.JSONRPCError{Code: neffos.JSONRPCInvalidParams, Message: err.Error()}
+//			}
+//			// [...]
+//		},
+//	}))
+func NewJSONRPCNamespace(methods map[string]func(params json.RawMessage) (interface{}, error)) Events {
+	m := jsonrpcMethods(methods)
+
+	return Events{
+		OnNativeMessage: func(c *NSConn, msg Message) error {
+			response := m.handle(msg.Body, c.Conn.transformError)
+			if response == nil {
+				return nil
+			}
+
+			c.Conn.Write(Message{Body: response, IsNative: true})
+			return nil
+		},
+	}
+}
+
+// handle returns the response of the "payload" request or batch of requests,
+// a nil result means that there is nothing to send back, i.e notifications.
+func (m jsonrpcMethods) handle(payload []byte, transformError func(error) error) []byte {
+	payload = bytes.TrimSpace(payload)
+
+	if len(payload) > 0 && payload[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(payload, &batch); err != nil {
+			return marshalJSONRPCResponse(newJSONRPCErrorResponse(nil, JSONRPCParseError, "Parse error"))
+		}
+
+		if len(batch) == 0 {
+			return marshalJSONRPCResponse(newJSONRPCErrorResponse(nil, JSONRPCInvalidRequest, "Invalid Request"))
+		}
+
+		var responses []jsonrpcResponse
+		for _, b := range batch {
+			if response, ok := m.call(b, transformError); ok {
+				responses = append(responses, response)
+			}
+		}
+
+		if len(responses) == 0 {
+			return nil
+		}
+
+		return marshalJSONRPCResponse(responses)
+	}
+
+	response, ok := m.call(payload, transformError)
+	if !ok {
+		return nil
+	}
+
+	return marshalJSONRPCResponse(response)
+}
+
+// call calls the method of a single request,
+// it reports false if the request is a notification.
+func (m jsonrpcMethods) call(payload []byte, transformError func(error) error) (jsonrpcResponse, bool) {
+	var req jsonrpcRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		if _, ok := err.(*json.SyntaxError); ok {
+			return newJSONRPCErrorResponse(nil, JSONRPCParseError, "Parse error"), true
+		}
+		return newJSONRPCErrorResponse(nil, JSONRPCInvalidRequest, "Invalid Request"), true
+	}
+
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return newJSONRPCErrorResponse(req.ID, JSONRPCInvalidRequest, "Invalid Request"), true
+	}
+
+	isNotification := len(req.ID) == 0
+
+	method, ok := m[req.Method]
+	if !ok || method == nil {
+		return newJSONRPCErrorResponse(req.ID, JSONRPCMethodNotFound, "Method not found"), !isNotification
+	}
+
+	result, err := method(req.Params)
+	if isNotification {
+		return jsonrpcResponse{}, false
+	}
+
+	if err != nil {
+		if rpcErr, ok := err.(*JSONRPCError); ok {
+			return jsonrpcResponse{JSONRPC: "2.0", Error: rpcErr, ID: req.ID}, true
+		}
+
+		if transformError != nil {
+			err = transformError(err)
+		}
+
+		return newJSONRPCErrorResponse(req.ID, JSONRPCServerError, err.Error()), true
+	}
+
+	b, err := json.Marshal(result)
+	if err != nil {
+		return newJSONRPCErrorResponse(req.ID, JSONRPCInternalError, "Internal error"), true
+	}
+
+	return jsonrpcResponse{JSONRPC: "2.0", Result: b, ID: req.ID}, true
+}
+
+func newJSONRPCErrorResponse(id json.RawMessage, code int, message string) jsonrpcResponse {
+	return jsonrpcResponse{
+		JSONRPC: "2.0",
+		Error:   &JSONRPCError{Code: code, Message: message},
+		ID:      id,
+	}
+}
+
+func marshalJSONRPCResponse(v interface{}) []byte {
+	b, err := json.Marshal(v)
+	if err != nil {
+		// an error's data that cannot be encoded.
+		b, _ = json.Marshal(newJSONRPCErrorResponse(nil, JSONRPCInternalError, "Internal error"))
+	}
+
+	return b
+}
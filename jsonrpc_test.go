@@ -0,0 +1,72 @@
+package neffos
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestJSONRPCHandle(t *testing.T) {
+	notified := 0
+	methods := jsonrpcMethods{
+		"sum": func(params json.RawMessage) (interface{}, error) {
+			var numbers []int
+			if err := json.Unmarshal(params, &numbers); err != nil {
+				return nil, &JSONRPCError{Code: JSONRPCInvalidParams, Message: "Invalid params"}
+			}
+
+			sum := 0
+			for _, n := range numbers {
+				sum += n
+			}
+			return sum, nil
+		},
+		"notify": func(json.RawMessage) (interface{}, error) {
+			notified++
+			return nil, nil
+		},
+		"fail": func(json.RawMessage) (interface{}, error) {
+			return nil, errors.New("pq: relation users does not exist")
+		},
+	}
+
+	sanitize := func(error) error { return errors.New("internal server error") }
+
+	var tests = []struct {
+		request  string
+		response string
+	}{
+		{`{"jsonrpc":"2.0","method":"sum","params":[1,2,3],"id":1}`,
+			`{"jsonrpc":"2.0","result":6,"id":1}`},
+		{`{"jsonrpc":"2.0","method":"sum","params":{"a":1},"id":"a"}`,
+			`{"jsonrpc":"2.0","error":{"code":-32602,"message":"Invalid params"},"id":"a"}`},
+		{`{"jsonrpc":"2.0","method":"notify","id":null}`,
+			`{"jsonrpc":"2.0","result":null,"id":null}`},
+		{`{"jsonrpc":"2.0","method":"fail","id":2}`,
+			`{"jsonrpc":"2.0","error":{"code":-32000,"message":"internal server error"},"id":2}`},
+		{`{"jsonrpc":"2.0","method":"missing","id":3}`,
+			`{"jsonrpc":"2.0","error":{"code":-32601,"message":"Method not found"},"id":3}`},
+		{`{"jsonrpc":"2.0","method":"sum","params":[1,2]`,
+			`{"jsonrpc":"2.0","error":{"code":-32700,"message":"Parse error"},"id":null}`},
+		{`{"jsonrpc":"1.0","method":"sum","id":4}`,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":4}`},
+		{`[]`,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}`},
+		{`[{"jsonrpc":"2.0","method":"sum","params":[1],"id":5},{"jsonrpc":"2.0","method":"notify"},1]`,
+			`[{"jsonrpc":"2.0","result":1,"id":5},{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request"},"id":null}]`},
+		// notifications are not answered.
+		{`{"jsonrpc":"2.0","method":"notify"}`, ``},
+		{`{"jsonrpc":"2.0","method":"missing"}`, ``},
+		{`[{"jsonrpc":"2.0","method":"notify"},{"jsonrpc":"2.0","method":"notify"}]`, ``},
+	}
+
+	for i, tt := range tests {
+		if expected, got := tt.response, string(methods.handle([]byte(tt.request), sanitize)); expected != got {
+			t.Fatalf("[%d] expected response:\n%s\nbut got:\n%s", i, expected, got)
+		}
+	}
+
+	if expected := 5; notified != expected {
+		t.Fatalf("expected the notify method to be called %d times but called %d", expected, notified)
+	}
+}
@@ -0,0 +1,93 @@
+package neffos
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Logger is the structured logger of the messages that the framework itself logs for a connection,
+// i.e the `HandlerDeadline` warnings. Each message carries the connection's fields,
+// its "conn" id, its "remote" address and the "namespace" that it refers to, if any,
+// so the logs can be queried by them. See `Server.Logger` and `NewLogger`.
+//
+// Adapters of third-party structured loggers are small, i.e for the zap's sugared logger:
+//
+//	type zapLogger struct{ *zap.SugaredLogger }
+//
+//	func (l zapLogger) With(key string, value interface{}) neffos.Logger {
+//		return zapLogger{l.SugaredLogger.With(key, value)}
+//	}
+//
+// The `Warnf` and `Errorf` methods are provided by the zap's `SugaredLogger` itself.
+type Logger interface {
+	// With returns a Logger which adds the "key" and "value" field to all of its messages.
+	With(key string, value interface{}) Logger
+	// Warnf logs a warning message.
+	Warnf(format string, args ...interface{})
+	// Errorf logs an error message.
+	Errorf(format string, args ...interface{})
+}
+
+type stdLogger struct {
+	logger *log.Logger
+	fields string
+}
+
+// NewLogger returns a `Logger` which prints through the standard "logger",
+// the fields are appended to the messages as "key=value" pairs.
+// A nil "logger" prints through the standard log package's logger.
+//
+// It's the default `Server.Logger`.
+func NewLogger(logger *log.Logger) Logger {
+	return &stdLogger{logger: logger}
+}
+
+func (l *stdLogger) With(key string, value interface{}) Logger {
+	return &stdLogger{
+		logger: l.logger,
+		fields: fmt.Sprintf("%s %s=%v", l.fields, key, value),
+	}
+}
+
+func (l *stdLogger) Warnf(format string, args ...interface{}) {
+	l.print("warning", format, args)
+}
+
+func (l *stdLogger) Errorf(format string, args ...interface{}) {
+	l.print("error", format, args)
+}
+
+func (l *stdLogger) print(level, format string, args []interface{}) {
+	msg := "neffos: " + level + ": " + strings.TrimSuffix(fmt.Sprintf(format, args...), "\n") + l.fields
+
+	if l.logger == nil {
+		log.Print(msg)
+		return
+	}
+
+	l.logger.Print(msg)
+}
+
+var defaultLogger = NewLogger(nil)
+
+// logger returns the `Server.Logger`, or the default one, with the fields of the "c" connection
+// and the "namespace", if not empty.
+func (s *Server) logger(c *Conn, namespace string) Logger {
+	logger := s.Logger
+	if logger == nil {
+		logger = defaultLogger
+	}
+
+	logger = logger.With("conn", c.ID())
+
+	if r := c.Socket().Request(); r != nil {
+		logger = logger.With("remote", r.RemoteAddr)
+	}
+
+	if namespace != "" {
+		logger = logger.With("namespace", namespace)
+	}
+
+	return logger
+}
@@ -0,0 +1,22 @@
+package neffos
+
+import (
+	"bytes"
+	"log"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := NewLogger(log.New(buf, "", 0))
+
+	connLogger := logger.With("conn", "id").With("namespace", "default")
+	connLogger.Warnf("slow %s", "event\n")
+	logger.Errorf("failed")
+
+	expected := "neffos: warning: slow event conn=id namespace=default\n" +
+		"neffos: error: failed\n"
+	if got := buf.String(); expected != got {
+		t.Fatalf("expected:\n%s\nbut got:\n%s", expected, got)
+	}
+}
@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -22,7 +25,7 @@ import (
 // <room>;
 // <event>;
 // <isError(0-1)>;
-// <isNoOp(0-1)>;
+// <isNoOp(0-1)><headers(optional)>;
 // <body||error_message>
 //
 // Internal `serializeMessage` and `deserializeMessage` functions
@@ -91,9 +94,45 @@ type Message struct {
 	// If true then the writer's checks will not lock connectedNamespacesMutex or roomsMutex again. May be useful in the future, keep that solution.
 	locked bool
 
+	// the sender's sequence number of this message, zero if not stamped, see `Server.StrictOrdering`.
+	// It's serialized through an internal header.
+	seq uint64
+
 	// if server or client should write using Binary message.
 	// This field is not filled on sending/receiving.
 	SetBinary bool
+
+	// Headers is an optional set of application metadata, i.e trace and correlation IDs.
+	// It is serialized only when it's not empty, so it adds zero overhead by default.
+	// Headers are written right after the no-op flag, therefore
+	// peers that are not aware of them just ignore them.
+	// This field is filled on sending/receiving.
+	Headers map[string]string
+
+	// ExpiresAt, if not zero, is the time that an outgoing message becomes stale,
+	// i.e a live position update which is useless after a newer one.
+	// A message which is written after that time, or which expires while it's queued until the connection's acknowledgement
+	// or in the client's outbound buffer (see `Client.BufferOutbound`), is dropped instead of sent,
+	// and it's counted by the `Conn.DroppedExpired` and `Server.DroppedExpired`.
+	// A message which is already handed to the socket is sent anyway, it does not expire behind a slow consumer.
+	// This field is not filled on sending/receiving.
+	ExpiresAt time.Time
+
+	// Coalesce, if true, keeps only the newest of the outgoing messages of the same namespace, room and event
+	// that are queued and not sent yet, i.e for a live price where only the latest value matters,
+	// a queued one with the Coalesce field set is dropped when a newer one is written.
+	// The queues are the messages written before the connection's acknowledgement and
+	// the client's outbound buffer (see `Client.BufferOutbound`), the rest of the writes are sent immediately.
+	// The newest message is queued after the rest of the queued messages,
+	// so it keeps its order relative to the other events, only the older ones of its own key are skipped.
+	// Under strict ordering (see `Server.StrictOrdering`) the messages queued until the acknowledgement
+	// are numbered only after it, so they are coalesced there too.
+	// This field is not filled on sending/receiving.
+	Coalesce bool
+
+	// Proto is the decoded body of an event which is registered through the `Namespaces.RegisterProto`.
+	// This field is not filled on sending/receiving.
+	Proto ProtoMessage
 }
 
 func (m *Message) isConnect() bool {
@@ -108,10 +147,25 @@ func (m *Message) isRoomJoin() bool {
 	return m.Event == OnRoomJoin
 }
 
+func (m *Message) isRoomLeave() bool {
+	return m.Event == OnRoomLeave
+}
+
 func (m *Message) isRoomLeft() bool {
 	return m.Event == OnRoomLeft
 }
 
+// ContentTypeHeader is the `Message.Headers` key which
+// its value is the format of the message's body, see `NSConn.EmitTyped`.
+const ContentTypeHeader = "Content-Type"
+
+// ContentType returns the format of the message's body, i.e "application/json",
+// as set by the sender's `NSConn.EmitTyped`.
+// An empty result means that the format is application-defined.
+func (m *Message) ContentType() string {
+	return m.Headers[ContentTypeHeader]
+}
+
 // Serialize returns this message's transport format.
 func (m Message) Serialize() []byte {
 	return serializeMessage(nil, m)
@@ -236,58 +290,107 @@ var (
 	// we use this because has zero chance to be part of end-developer's Message.Namespace, Room, Event, To and Err fields,
 	// semicolon has higher probability to exists on those values. See `escape` and `unescape`.
 	messageFieldSeparatorReplacement = "@%!semicolon@%!"
+	// replaces the "@" of a value which would be read as the start of a replacement otherwise,
+	// so any value round-trips, even one that contains the replacements themselves. See `escape` and `unescape`.
+	messageFieldAtReplacement = "@%!at@%!"
+	// the common prefix of the replacements.
+	messageReplacementPrefix = "@%!"
 )
 
 // called on `serializeMessage` to all message's fields except the body (and error).
+// The semicolons are replaced with the `messageFieldSeparatorReplacement`
+// and the "@" that starts a "@%!" sequence with the `messageFieldAtReplacement`,
+// the rest of the bytes, including any other "@", are kept as they are (compatible with the neffos.js client).
 func escape(s string) string {
-	if len(s) == 0 {
+	if !strings.Contains(s, messageSeparatorString) && !strings.Contains(s, messageReplacementPrefix) {
 		return s
 	}
 
-	return strings.Replace(s, messageSeparatorString, messageFieldSeparatorReplacement, -1)
+	var b strings.Builder
+	b.Grow(len(s) + len(messageFieldSeparatorReplacement))
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == messageSeparator[0]:
+			b.WriteString(messageFieldSeparatorReplacement)
+		case strings.HasPrefix(s[i:], messageReplacementPrefix):
+			b.WriteString(messageFieldAtReplacement)
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+
+	return b.String()
 }
 
 // called on `deserializeMessage` to all message's fields except the body (and error).
+// It reverses the `escape`: an "@" which is not followed by "%!" is always a literal one.
 func unescape(s string) string {
-	if len(s) == 0 {
+	if !strings.Contains(s, messageReplacementPrefix) {
 		return s
 	}
 
-	return strings.Replace(s, messageFieldSeparatorReplacement, messageSeparatorString, -1)
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], messageFieldSeparatorReplacement):
+			b.WriteString(messageSeparatorString)
+			i += len(messageFieldSeparatorReplacement) - 1
+		case strings.HasPrefix(s[i:], messageFieldAtReplacement):
+			b.WriteByte('@')
+			i += len(messageFieldAtReplacement) - 1
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+
+	return b.String()
 }
 
 func serializeMessage(encrypt MessageEncrypt, msg Message) (out []byte) {
+	out = serializeMessageTo(nil, msg)
+
+	if encrypt != nil {
+		out = encrypt(out)
+	}
+
+	return out
+}
+
+// serializeMessageTo is like `serializeMessage` without encryption but the "msg" is written to the "buf",
+// if not nil, and the result is valid until the "buf" is reused, see `acquireBuffer`.
+func serializeMessageTo(buf *bytes.Buffer, msg Message) []byte {
 	if msg.IsNative && msg.wait == "" {
-		out = msg.Body
-	} else {
-		if msg.FromExplicit != "" {
-			if msg.wait != "" {
-				// this should never happen unless manual set of FromExplicit by end-developer which is forbidden by the higher level calls.
-				panic("msg.wait and msg.FromExplicit cannot work together")
-			}
+		return msg.Body
+	}
 
-			msg.wait = msg.FromExplicit
+	if msg.FromExplicit != "" {
+		if msg.wait != "" {
+			// this should never happen unless manual set of FromExplicit by end-developer which is forbidden by the higher level calls.
+			panic("msg.wait and msg.FromExplicit cannot work together")
 		}
-		out = serializeOutput(msg.wait, escape(msg.Namespace), escape(msg.Room), escape(msg.Event), msg.Body, msg.Err, msg.isNoOp)
+
+		msg.wait = msg.FromExplicit
 	}
 
-	if encrypt != nil {
-		out = encrypt(out)
+	if buf == nil {
+		buf = new(bytes.Buffer)
 	}
 
-	return out
+	writeOutput(buf, msg.wait, escape(msg.Namespace), escape(msg.Room), escape(msg.Event), msg.Body, msg.Err, msg.isNoOp, msg.Headers)
+	return buf.Bytes()
 }
 
-func serializeOutput(wait, namespace, room, event string,
+func writeOutput(buf *bytes.Buffer, wait, namespace, room, event string,
 	body []byte,
 	err error,
 	isNoOp bool,
-) []byte {
+	headers map[string]string,
+) {
 
 	var (
 		isErrorByte = falseByte
 		isNoOpByte  = falseByte
-		waitByte    = []byte{}
 	)
 
 	if err != nil {
@@ -303,21 +406,62 @@ func serializeOutput(wait, namespace, room, event string,
 		isNoOpByte = trueByte
 	}
 
-	if wait != "" {
-		waitByte = []byte(wait)
+	if len(headers) > 0 && !isNoOp {
+		// headers are written right after the no-op flag (and not at the end, body is the rest of the message),
+		// peers that do not know about them compare the whole field to `trueByte` and correctly read a false no-op flag,
+		// that's why they are never attached to no-op messages.
+		isNoOpByte = append([]byte{isNoOpByte[0]}, serializeHeaders(headers)...)
 	}
 
-	msg := bytes.Join([][]byte{ // this number of fields should match the deserializer's, see `validMessageSepCount`.
-		waitByte,
-		[]byte(namespace),
-		[]byte(room),
-		[]byte(event),
-		isErrorByte,
-		isNoOpByte,
-		body,
-	}, messageSeparator)
+	// this number of fields should match the deserializer's, see `validMessageSepCount`.
+	buf.Grow(len(wait) + len(namespace) + len(room) + len(event) + len(isErrorByte) + len(isNoOpByte) + len(body) + validMessageSepCount)
+	buf.WriteString(wait)
+	buf.WriteByte(messageSeparator[0])
+	buf.WriteString(namespace)
+	buf.WriteByte(messageSeparator[0])
+	buf.WriteString(room)
+	buf.WriteByte(messageSeparator[0])
+	buf.WriteString(event)
+	buf.WriteByte(messageSeparator[0])
+	buf.Write(isErrorByte)
+	buf.WriteByte(messageSeparator[0])
+	buf.Write(isNoOpByte)
+	buf.WriteByte(messageSeparator[0])
+	buf.Write(body)
+}
+
+// MaxPooledBufferSize is the maximum capacity, in bytes, of a serialization buffer which is kept
+// to be reused by the next outgoing messages after its message is written to the socket,
+// the larger buffers, of large messages, are left to the garbage collector.
+// Zero or negative value disables the reuse, each outgoing message is serialized to a new buffer.
+// When enabled, the `Socket` implementations must not keep the data of their `WriteBinary` and `WriteText`
+// after they return, like the gorilla and gobwas ones do, e.g. 64 * 1024.
+// Defaults to 0, disabled.
+var MaxPooledBufferSize = 0
+
+var messageBuffers = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// acquireBuffer returns an empty serialization buffer, or nil if the reuse is disabled, see `MaxPooledBufferSize`.
+// It should be released by `releaseBuffer` once its data are written.
+func acquireBuffer() *bytes.Buffer {
+	if MaxPooledBufferSize <= 0 {
+		return nil
+	}
 
-	return msg
+	return messageBuffers.Get().(*bytes.Buffer)
+}
+
+func releaseBuffer(buf *bytes.Buffer) {
+	if buf == nil || buf.Cap() > MaxPooledBufferSize {
+		return
+	}
+
+	buf.Reset()
+	messageBuffers.Put(buf)
 }
 
 // when allowNativeMessages only Body is filled and check about message format is skipped.
@@ -326,7 +470,7 @@ func deserializeMessage(decrypt MessageDecrypt, b []byte, allowNativeMessages, s
 		b = decrypt(b)
 	}
 
-	wait, namespace, room, event, body, err, isNoOp, isInvalid := deserializeInput(b, allowNativeMessages, shouldHandleOnlyNativeMessages)
+	wait, namespace, room, event, body, err, isNoOp, isInvalid, headers := deserializeInput(b, allowNativeMessages, shouldHandleOnlyNativeMessages)
 
 	fromExplicit := ""
 	if isServerConnID(wait) {
@@ -334,6 +478,8 @@ func deserializeMessage(decrypt MessageDecrypt, b []byte, allowNativeMessages, s
 		wait = ""
 	}
 
+	seq, headers := takeSequence(headers)
+
 	return Message{
 		wait:         wait,
 		Namespace:    unescape(namespace),
@@ -352,12 +498,14 @@ func deserializeMessage(decrypt MessageDecrypt, b []byte, allowNativeMessages, s
 		IsNative:     allowNativeMessages && event == OnNativeMessage,
 		locked:       false,
 		SetBinary:    false,
+		Headers:      headers,
+		seq:          seq,
 	}
 }
 
 const validMessageSepCount = 7
 
-var knownErrors = []error{ErrBadNamespace, ErrBadRoom}
+var knownErrors = []error{ErrBadNamespace, ErrBadRoom, ErrServerDraining, ErrRoomRateLimited, ErrTooManyRooms, ErrCircuitOpen, ErrNamespaceFull, ErrMessageFiltered, ErrForbidden, ErrMuted, ErrSessionNotFound}
 
 // RegisterKnownError registers an error that it's "known" to both server and client sides.
 // This simply adds an error to a list which, if its static text matches
@@ -404,6 +552,7 @@ func deserializeInput(b []byte, allowNativeMessages, shouldHandleOnlyNativeMessa
 	err error,
 	isNoOp bool,
 	isInvalid bool,
+	headers map[string]string,
 ) {
 
 	if len(b) == 0 {
@@ -435,7 +584,10 @@ func deserializeInput(b []byte, allowNativeMessages, shouldHandleOnlyNativeMessa
 	room = string(dts[2])
 	event = string(dts[3])
 	isError := bytes.Equal(dts[4], trueByte)
-	isNoOp = bytes.Equal(dts[5], trueByte)
+	if flag := dts[5]; len(flag) > 0 {
+		isNoOp = flag[0] == trueByte[0]
+		headers = deserializeHeaders(flag[1:])
+	}
 	if b := dts[6]; len(b) > 0 {
 		if isError {
 			errorText := string(b)
@@ -448,6 +600,59 @@ func deserializeInput(b []byte, allowNativeMessages, shouldHandleOnlyNativeMessa
 	return
 }
 
+const (
+	headerSeparator      = '&'
+	headerValueSeparator = '='
+)
+
+// serializeHeaders encodes the "headers" as url-escaped key=value pairs,
+// sorted by key, so the result never contains the message separator.
+func serializeHeaders(headers map[string]string) []byte {
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b bytes.Buffer
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(headerSeparator)
+		}
+		b.WriteString(url.QueryEscape(k))
+		b.WriteByte(headerValueSeparator)
+		b.WriteString(url.QueryEscape(headers[k]))
+	}
+
+	return b.Bytes()
+}
+
+// deserializeHeaders decodes the result of `serializeHeaders`,
+// it returns nil if "b" is empty.
+func deserializeHeaders(b []byte) map[string]string {
+	if len(b) == 0 {
+		return nil
+	}
+
+	headers := make(map[string]string)
+	for _, pair := range bytes.Split(b, []byte{headerSeparator}) {
+		kv := bytes.SplitN(pair, []byte{headerValueSeparator}, 2)
+		k, err := url.QueryUnescape(string(kv[0]))
+		if err != nil || k == "" {
+			continue
+		}
+
+		var v string
+		if len(kv) == 2 {
+			v, _ = url.QueryUnescape(string(kv[1]))
+		}
+
+		headers[k] = v
+	}
+
+	return headers
+}
+
 func genEmptyReplyToWait(wait string) []byte {
 	return append([]byte(wait), bytes.Repeat(messageSeparator, validMessageSepCount-1)...)
 }
@@ -80,6 +80,15 @@ func TestMessageSerialization(t *testing.T) {
 			},
 			serialized: []byte("1;default;;chat;0;1;body"),
 		},
+		{ // 8
+			msg: Message{
+				Namespace: "default",
+				Event:     "chat",
+				Body:      []byte("body"),
+				Headers:   map[string]string{"trace-id": "abc;def", "content-type": "text/plain"},
+			},
+			serialized: []byte(";default;;chat;0;0content-type=text%2Fplain&trace-id=abc%3Bdef;body"),
+		},
 	}
 
 	for i, tt := range tests {
@@ -99,6 +108,17 @@ func TestMessageSerialization(t *testing.T) {
 		t.Fatalf("expected message to be invalid but it seems that it is a valid one")
 	}
 
+	// test that a message without headers is compatible with a peer that sends them
+	// and that a peer which does not know about headers can still read the flags.
+	msg = deserializeMessage(nil, []byte(";default;;chat;0;1x-id=1;body"), false, false)
+	if !msg.isNoOp || msg.Headers["x-id"] != "1" || string(msg.Body) != "body" {
+		t.Fatalf("expected no-op message with headers and body but got:\n%#+v", msg)
+	}
+
+	if got := serializeMessage(nil, Message{Event: "chat", Headers: map[string]string{}}); !bytes.Equal(got, []byte(";;;chat;0;0;")) {
+		t.Fatalf("expected empty headers to not be serialized but got: %s", got)
+	}
+
 	nativeMessage := []byte("a native websocket message")
 	msg = deserializeMessage(nil, nativeMessage, true, false)
 	if msg.isInvalid {
@@ -140,3 +160,29 @@ func TestMessageSerialization(t *testing.T) {
 		t.Fatalf("expected a unescaped message to be:\n%#+v\n\tbut got:\n%#+v", msg, msgGot)
 	}
 }
+
+func TestMessageSerializationEscape(t *testing.T) {
+	names := []string{
+		"contains;semi",
+		messageFieldSeparatorReplacement,
+		messageFieldAtReplacement,
+		messageReplacementPrefix,
+		"@%!semicolon;",
+		";@%!at@%!;",
+		"user@example.com",
+		"\x00;\xff@%!\n",
+	}
+
+	for _, name := range names {
+		msg := Message{Namespace: name, Room: name, Event: name}
+		got := deserializeMessage(nil, serializeMessage(nil, msg), false, false)
+		if got.isInvalid || got.Namespace != name || got.Room != name || got.Event != name {
+			t.Fatalf("expected %q to round-trip but got namespace: %q, room: %q, event: %q", name, got.Namespace, got.Room, got.Event)
+		}
+	}
+
+	// values without semicolons and replacements are sent as they are.
+	if expected, got := "user@example.com", escape("user@example.com"); expected != got {
+		t.Fatalf("expected escaped value: %s but got: %s", expected, got)
+	}
+}
@@ -0,0 +1,31 @@
+package neffos
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Mute drops the incoming events of this server-side connection for the "d" duration, i.e for chat moderation
+// without a hard kick: the client stays connected, its namespace connects, room joins and leaves are still handled
+// and it still receives the messages sent to it, but its own events are ignored,
+// only its `Ask` calls get an `ErrMuted` error (see `Server.NotifyMuted` too).
+// A next call replaces the duration, a zero or negative "d" unmutes it.
+// It does nothing on client-side connections.
+func (c *Conn) Mute(d time.Duration) {
+	if c.IsClient() {
+		return
+	}
+
+	var until int64
+	if d > 0 {
+		until = c.clock().Now().Add(d).UnixNano()
+	}
+
+	atomic.StoreInt64(&c.mutedUntil, until)
+}
+
+// IsMuted reports whether the incoming events of this connection are dropped, see `Mute`.
+func (c *Conn) IsMuted() bool {
+	until := atomic.LoadInt64(&c.mutedUntil)
+	return until > 0 && c.clock().Now().UnixNano() < until
+}
@@ -0,0 +1,113 @@
+package neffos
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// SetNamespaceIdleTimeout overrides the `NamespaceIdleTimeout` for the "namespace",
+// i.e a namespace which is used rarely can expire sooner than the rest.
+// A negative "d" disables the expiration of the "namespace" and a zero one removes the override.
+// It applies to the connections that are accepted after it's set.
+func (s *Server) SetNamespaceIdleTimeout(namespace string, d time.Duration) {
+	s.namespaceIdleTimeoutsMutex.Lock()
+	if s.namespaceIdleTimeouts == nil {
+		s.namespaceIdleTimeouts = make(map[string]time.Duration)
+	}
+
+	if d != 0 {
+		s.namespaceIdleTimeouts[namespace] = d
+	} else {
+		delete(s.namespaceIdleTimeouts, namespace)
+	}
+	s.namespaceIdleTimeoutsMutex.Unlock()
+}
+
+// namespaceIdleTimeout returns the idle timeout of the "namespace", zero or negative means that it never expires.
+func (s *Server) namespaceIdleTimeout(namespace string) time.Duration {
+	s.namespaceIdleTimeoutsMutex.RLock()
+	d, ok := s.namespaceIdleTimeouts[namespace]
+	s.namespaceIdleTimeoutsMutex.RUnlock()
+	if ok {
+		return d
+	}
+
+	return s.NamespaceIdleTimeout
+}
+
+// expiresIdleNamespaces reports whether any namespace has an idle timeout.
+func (s *Server) expiresIdleNamespaces() bool {
+	if s.NamespaceIdleTimeout > 0 {
+		return true
+	}
+
+	s.namespaceIdleTimeoutsMutex.RLock()
+	defer s.namespaceIdleTimeoutsMutex.RUnlock()
+	for _, d := range s.namespaceIdleTimeouts {
+		if d > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// idleCheckInterval returns how often the idle namespaces are checked,
+// half of the shortest idle timeout, so a namespace expires up to 1.5 times its timeout.
+func (s *Server) idleCheckInterval() time.Duration {
+	min := s.NamespaceIdleTimeout
+
+	s.namespaceIdleTimeoutsMutex.RLock()
+	for _, d := range s.namespaceIdleTimeouts {
+		if d > 0 && (min <= 0 || d < min) {
+			min = d
+		}
+	}
+	s.namespaceIdleTimeoutsMutex.RUnlock()
+
+	if min <= 0 { // all disabled at runtime.
+		return time.Second
+	}
+
+	return min / 2
+}
+
+// touch marks the "ns" as active, see `Server.NamespaceIdleTimeout`.
+func (ns *NSConn) touch() {
+	atomic.StoreInt64(&ns.lastActivity, ns.Conn.clock().Now().UnixNano())
+}
+
+// expireIdleNamespaces disconnects the connected namespaces of this server-side connection
+// which received no messages for longer than their idle timeouts, until the connection is closed.
+func (c *Conn) expireIdleNamespaces(ctx context.Context) {
+	clock := c.clock()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-clock.After(c.server.idleCheckInterval()):
+		}
+
+		now := clock.Now().UnixNano()
+		var idle []*NSConn
+
+		c.connectedNamespacesMutex.RLock()
+		for namespace, ns := range c.connectedNamespaces {
+			if namespace == "" && c.allowNativeMessages {
+				continue // native clients can not be asked.
+			}
+
+			d := c.server.namespaceIdleTimeout(namespace)
+			if d > 0 && now-atomic.LoadInt64(&ns.lastActivity) > int64(d) {
+				idle = append(idle, ns)
+			}
+		}
+		c.connectedNamespacesMutex.RUnlock()
+
+		for _, ns := range idle {
+			ns.Disconnect(ctx)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package neffos
+
+import (
+	"errors"
+)
+
+// ErrNamespaceFull may return from a `Conn#Connect` method when the namespace
+// has reached its connections limit, see `Server.MaxConnectionsPerNamespace`.
+var ErrNamespaceFull = errors.New("namespace full")
+
+// MaxConnectionsPerNamespace limits the number of the connections that can be connected to the "namespace"
+// at the same time to "n", so a particular namespace can not monopolize the server,
+// i.e a free namespace can be capped while a premium one is not.
+// When the limit is reached the next connects to that namespace are rejected
+// with an `ErrNamespaceFull` error, as if their `OnNamespaceConnect` event callback returned it.
+// A zero or negative "n" removes the limit.
+//
+// It can be called at runtime, a lower limit does not disconnect the already connected ones.
+// Defaults to no limit.
+func (s *Server) MaxConnectionsPerNamespace(namespace string, n int) {
+	s.namespaceConnsMutex.Lock()
+	if s.namespaceLimits == nil {
+		s.namespaceLimits = make(map[string]int)
+	}
+
+	if n > 0 {
+		s.namespaceLimits[namespace] = n
+	} else {
+		delete(s.namespaceLimits, namespace)
+	}
+	s.namespaceConnsMutex.Unlock()
+}
+
+// NamespaceConnections returns the number of the connections that are connected to the "namespace".
+func (s *Server) NamespaceConnections(namespace string) int {
+	s.namespaceConnsMutex.Lock()
+	n := s.namespaceConns[namespace]
+	s.namespaceConnsMutex.Unlock()
+	return n
+}
+
+// reserveNamespace takes a place in the "namespace" for a new connect,
+// it reports false if the namespace is full. The place is given back by `releaseNamespace`.
+func (s *Server) reserveNamespace(namespace string) bool {
+	s.namespaceConnsMutex.Lock()
+	defer s.namespaceConnsMutex.Unlock()
+
+	n := s.namespaceConns[namespace]
+	if max, ok := s.namespaceLimits[namespace]; ok && n >= max {
+		return false
+	}
+
+	if s.namespaceConns == nil {
+		s.namespaceConns = make(map[string]int)
+	}
+	s.namespaceConns[namespace] = n + 1
+	return true
+}
+
+func (s *Server) releaseNamespace(namespace string) {
+	s.namespaceConnsMutex.Lock()
+	if n := s.namespaceConns[namespace]; n > 1 {
+		s.namespaceConns[namespace] = n - 1
+	} else {
+		delete(s.namespaceConns, namespace)
+	}
+	s.namespaceConnsMutex.Unlock()
+}
+
+// reserveNamespace reports whether this server-side connection can connect to the "namespace",
+// the client-side connections have no limits.
+func (c *Conn) reserveNamespace(namespace string) bool {
+	return c.IsClient() || c.server.reserveNamespace(namespace)
+}
+
+// releaseNamespace gives back the place that a server-side connection took by `reserveNamespace`.
+func (c *Conn) releaseNamespace(namespace string) {
+	if !c.IsClient() {
+		c.server.releaseNamespace(namespace)
+	}
+}
+
+// deleteNamespace removes the "namespace" from the connected ones
+// and, on the server-side, gives its place back. Locks required.
+func (c *Conn) deleteNamespace(namespace string) {
+	if _, ok := c.connectedNamespaces[namespace]; !ok {
+		return
+	}
+
+	delete(c.connectedNamespaces, namespace)
+	c.releaseNamespace(namespace)
+}
@@ -0,0 +1,90 @@
+package neffos
+
+import (
+	"errors"
+	"strconv"
+	"sync/atomic"
+)
+
+// sequenceHeader is the internal `Message.Headers` key which carries the sender's sequence number
+// of a message, it's never exposed to the event callbacks, see `Server.StrictOrdering`.
+const sequenceHeader = "Neffos-Seq"
+
+// ErrOutOfOrder is the close error of a connection which received a message
+// after a gap or out of order, see `Server.StrictOrdering`.
+var ErrOutOfOrder = errors.New("message out of order")
+
+// takeSequence extracts the sequence number out of the incoming "headers".
+func takeSequence(headers map[string]string) (uint64, map[string]string) {
+	v, ok := headers[sequenceHeader]
+	if !ok {
+		return 0, headers
+	}
+
+	delete(headers, sequenceHeader)
+	if len(headers) == 0 {
+		headers = nil
+	}
+
+	seq, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, headers
+	}
+
+	return seq, headers
+}
+
+func (c *Conn) isStrictOrdering() bool {
+	return atomic.LoadUint32(&c.strictOrdering) > 0
+}
+
+// writeInSequence stamps the "msg" with the next sequence number and writes it,
+// the stamp and the write are serialized so the messages are sent in the order of their numbers.
+// It's called after the acknowledgement, so the numbered messages are written directly
+// and they are never dropped after they are numbered, the peer would see a gap.
+func (c *Conn) writeInSequence(msg Message, result chan<- error) bool {
+	c.writeSeqMutex.Lock()
+	defer c.writeSeqMutex.Unlock()
+
+	seq := c.writeSeq + 1
+	// kept unnumbered, see `PendingWrites`.
+	original := msg
+
+	headers := make(map[string]string, len(msg.Headers)+1)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[sequenceHeader] = strconv.FormatUint(seq, 10)
+	msg.Headers = headers
+
+	buf := acquireBuffer()
+	w := pendingWriteOf(original, serializeMessageTo(buf, msg))
+	w.buf = buf
+	w.result = result
+	if !c.writePending(w) {
+		// not sent, the number is reused by the next one.
+		return false
+	}
+
+	c.writeSeq = seq
+	return true
+}
+
+// checkSequence reports whether the incoming "seq" is the next one of the peer's messages,
+// the first received number sets the base, i.e when the ordering was enabled after the first messages.
+func (c *Conn) checkSequence(seq uint64) bool {
+	if !c.isStrictOrdering() {
+		return true
+	}
+
+	for {
+		last := atomic.LoadUint64(&c.readSeq)
+		if last > 0 && seq != last+1 {
+			return false
+		}
+
+		if atomic.CompareAndSwapUint64(&c.readSeq, last, seq) {
+			return true
+		}
+	}
+}
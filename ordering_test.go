@@ -0,0 +1,45 @@
+package neffos
+
+import "testing"
+
+func TestConnWriteInSequence(t *testing.T) {
+	namespaces := Namespaces{"default": Events{}}
+
+	socket := newPipeSocket()
+	c := newConn(socket, namespaces, nil)
+	c.connectedNamespaces["default"] = newNSConn(c, "default", namespaces["default"])
+	c.strictOrdering = 1
+	defer c.Close()
+
+	// kept until the acknowledgement, the first one is replaced by the second.
+	c.Write(Message{Namespace: "default", Event: "state", Body: []byte("old"), Coalesce: true})
+	c.Write(Message{Namespace: "default", Event: "state", Body: []byte("new"), Coalesce: true})
+	c.acknowledge()
+
+	c.Write(Message{Namespace: "default", Event: "chat", Body: []byte("emit")})
+	// a broadcast is numbered per connection too.
+	broadcast := Message{Namespace: "default", Event: "chat", Body: []byte("broadcast")}
+	c.writeSerialized(broadcast, serializeMessage(nil, broadcast))
+
+	expected := []struct {
+		body string
+		seq  uint64
+	}{
+		{"new", 0},
+		{"emit", 1},
+		{"broadcast", 2},
+	}
+
+	written := socket.Written()
+	if len(written) != len(expected) {
+		t.Fatalf("expected %d written messages but got %d", len(expected), len(written))
+	}
+
+	for i, b := range written {
+		msg := deserializeMessage(nil, b, false, false)
+		if string(msg.Body) != expected[i].body || msg.seq != expected[i].seq {
+			t.Fatalf("[%d] expected message: %s with sequence number: %d but got: %s with %d",
+				i, expected[i].body, expected[i].seq, msg.Body, msg.seq)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package neffos
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConnPauseReads(t *testing.T) {
+	handled := make(chan string, 16)
+	namespaces := Namespaces{
+		"default": Events{
+			"chat": func(c *NSConn, msg Message) error {
+				handled <- string(msg.Body)
+				return nil
+			},
+		},
+	}
+
+	socket := newPipeSocket()
+	c := newConn(socket, namespaces, nil)
+	c.connectedNamespaces["default"] = newNSConn(c, "default", namespaces["default"])
+	c.acknowledge()
+
+	feed := func(bodies ...string) {
+		for _, body := range bodies {
+			socket.Feed(serializeMessage(nil, Message{Namespace: "default", Event: "chat", Body: []byte(body)}))
+		}
+	}
+
+	expectHandled := func(bodies ...string) {
+		t.Helper()
+		for _, expected := range bodies {
+			select {
+			case got := <-handled:
+				if expected != got {
+					t.Fatalf("expected the message: %s but got: %s", expected, got)
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatalf("expected the message: %s to be handled", expected)
+			}
+		}
+	}
+
+	expectNotHandled := func() {
+		t.Helper()
+		select {
+		case got := <-handled:
+			t.Fatalf("expected no message to be handled while reads are paused but got: %s", got)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	c.PauseReads()
+	readerDone := make(chan struct{})
+	go func() {
+		c.startReader()
+		close(readerDone)
+	}()
+
+	feed("1", "2", "3")
+	expectNotHandled()
+	if expected, got := 3, len(socket.reads); expected != got {
+		t.Fatalf("expected %d messages to be left unread but got %d", expected, got)
+	}
+
+	c.ResumeReads()
+	expectHandled("1", "2", "3")
+
+	// the reader already waits for the next message, it's still handled.
+	c.PauseReads()
+	feed("4", "5")
+	expectHandled("4")
+	expectNotHandled()
+
+	c.Close()
+
+	select {
+	case <-readerDone:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected the close to stop the paused reader")
+	}
+	expectNotHandled()
+}
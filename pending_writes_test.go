@@ -0,0 +1,179 @@
+package neffos
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConnPendingWrites(t *testing.T) {
+	namespaces := Namespaces{"default": Events{}, "other": Events{}}
+
+	c := newConn(newPipeSocket(), namespaces, nil)
+	c.connectedNamespaces["default"] = newNSConn(c, "default", namespaces["default"])
+	c.outbound = &outboundBuffers{buffers: map[string]*outboundBuffer{"other": {size: 1}}}
+
+	// queued until the acknowledgement.
+	first := Message{Namespace: "default", Event: "chat", Body: []byte("first")}
+	second := Message{Namespace: "default", Event: "chat", Body: []byte("second")}
+	buffered := Message{Namespace: "other", Event: "chat", Body: []byte("buffered")}
+	for _, msg := range []Message{first, second, buffered} {
+		if !c.Write(msg) {
+			t.Fatalf("expected the write of %s to be kept", msg.Body)
+		}
+	}
+	// internal writes are not included.
+	c.writeOrQueue([]byte("internal"), false)
+
+	expected := []Message{first, second, buffered}
+	if got := c.PendingWrites(); !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected pending writes: %#+v but got: %#+v", expected, got)
+	}
+
+	c.Close()
+
+	if got := c.PendingWrites(); !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected the pending writes at close time: %#+v but got: %#+v", expected, got)
+	}
+
+	if got := c.WriteQueueLen(); got != 0 {
+		t.Fatalf("expected an empty write queue after close but got: %d", got)
+	}
+}
+
+func TestConnPendingWritesPooledBuffers(t *testing.T) {
+	namespaces := Namespaces{"default": Events{}}
+
+	defer func(n int) { MaxPooledBufferSize = n }(MaxPooledBufferSize)
+	MaxPooledBufferSize = 64 * 1024
+
+	socket := newPipeSocket()
+	c := newConn(socket, namespaces, nil)
+	c.connectedNamespaces["default"] = newNSConn(c, "default", namespaces["default"])
+	defer c.Close()
+
+	first := Message{Namespace: "default", Event: "chat", Body: []byte("first")}
+	shared := Message{Namespace: "default", Event: "chat", Body: []byte("shared")}
+	last := Message{Namespace: "default", Event: "chat", Body: []byte("last")}
+
+	// queued until the acknowledgement, with their pooled buffers.
+	if !c.Write(first) {
+		t.Fatalf("expected the write to be kept")
+	}
+
+	// a broadcast's buffer is reused right after its writes.
+	buf := acquireBuffer()
+	b := serializeMessageTo(buf, shared)
+	if !c.writeSerialized(shared, b) {
+		t.Fatalf("expected the shared write to be kept")
+	}
+	for i := range b {
+		b[i] = 'x'
+	}
+	releaseBuffer(buf)
+
+	if !c.Write(last) {
+		t.Fatalf("expected the write to be kept")
+	}
+
+	c.acknowledge()
+
+	var expected [][]byte
+	for _, msg := range []Message{first, shared, last} {
+		expected = append(expected, serializeMessage(nil, msg))
+	}
+
+	if got := socket.Written(); !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected written data: %q but got: %q", expected, got)
+	}
+}
+
+// blockingSocket blocks its writes until it's released, like a slow consumer.
+type blockingSocket struct {
+	*pipeSocket
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingSocket) WriteBinary(body []byte, timeout time.Duration) error {
+	return s.WriteText(body, timeout)
+}
+
+func (s *blockingSocket) WriteText(body []byte, _ time.Duration) error {
+	s.entered <- struct{}{}
+	<-s.release
+	return nil
+}
+
+func TestConnMaxInflightWrites(t *testing.T) {
+	namespaces := Namespaces{"default": Events{}}
+
+	var full []Message
+	s := New(nil, namespaces)
+	s.MaxInflightWrites = 2
+	s.OnWriteQueueFull = func(c *Conn, msg Message) {
+		full = append(full, msg)
+	}
+	defer s.Close()
+
+	socket := &blockingSocket{pipeSocket: newPipeSocket(), entered: make(chan struct{}, 16), release: make(chan struct{})}
+	c := newConn(socket, namespaces, nil)
+	c.server = s
+	c.connectedNamespaces["default"] = newNSConn(c, "default", namespaces["default"])
+	c.acknowledge()
+	defer c.Close()
+
+	msg := Message{Namespace: "default", Event: "chat", Body: []byte("data")}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !c.Write(msg) {
+				t.Errorf("expected the blocked write to succeed")
+			}
+		}()
+	}
+
+	// the first one is blocked by the socket, the second one waits for it.
+	<-socket.entered
+	for c.InflightWrites() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if c.Write(msg) {
+		t.Fatalf("expected the write to be dropped")
+	}
+	if err := <-c.WriteResult(msg); err != ErrWriteQueueFull {
+		t.Fatalf("expected write result: %v but got: %v", ErrWriteQueueFull, err)
+	}
+	if expected, got := 2, len(full); expected != got {
+		t.Fatalf("expected %d full write queue notifications but got %d", expected, got)
+	}
+
+	// replies are never dropped.
+	reply := Message{Namespace: "default", Event: "chat", wait: "1"}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if !c.Write(reply) {
+			t.Errorf("expected the reply to be written")
+		}
+	}()
+	for c.InflightWrites() != 3 {
+		time.Sleep(time.Millisecond)
+	}
+
+	close(socket.release)
+	wg.Wait()
+
+	if got := c.InflightWrites(); got != 0 {
+		t.Fatalf("expected no inflight writes but got %d", got)
+	}
+
+	if !c.Write(msg) {
+		t.Fatalf("expected the write to succeed after the socket is released")
+	}
+}
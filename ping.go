@@ -0,0 +1,99 @@
+package neffos
+
+import (
+	"bytes"
+	"errors"
+	"time"
+)
+
+// MaxPingPayload is the maximum size, in bytes, of the payload of a websocket ping, see `Conn.PingWithData`.
+const MaxPingPayload = 125
+
+var (
+	// ErrPingPayloadTooLarge is returned from `Conn.PingWithData` when the payload exceeds the `MaxPingPayload`.
+	ErrPingPayloadTooLarge = errors.New("ping payload too large")
+	// ErrPingNotSupported is returned from `Conn.PingWithData` when the connection's `Socket`
+	// does not implement the `SocketPinger`.
+	ErrPingNotSupported = errors.New("ping not supported")
+	// ErrPingTimeout is returned from `Conn.PingWithData` when the pong does not arrive in time.
+	ErrPingTimeout = errors.New("ping timeout")
+)
+
+// pongWaiter is a ping that waits for the pong with the same payload.
+type pongWaiter struct {
+	data []byte
+	ch   chan []byte
+}
+
+// PingWithData sends a websocket ping with the "data" as its payload and returns the payload
+// of the pong which echoes it, i.e to correlate pings or to embed a timestamp for a precise round-trip time.
+// The "data" should not exceed the `MaxPingPayload` (125 bytes), otherwise it fails with an `ErrPingPayloadTooLarge`.
+//
+// It blocks until the pong arrives, the connection is closed (`ErrWrite`)
+// or the read timeout passes (`ErrPingTimeout`), if any.
+// The pongs are received by the connection's reader, so it should not be called while its reads are paused.
+// The connection's `Socket` should implement the `SocketPinger`, as the gorilla and gobwas ones do,
+// otherwise it fails with an `ErrPingNotSupported`.
+func (c *Conn) PingWithData(data []byte) ([]byte, error) {
+	if len(data) > MaxPingPayload {
+		return nil, ErrPingPayloadTooLarge
+	}
+
+	pinger, ok := c.socket.(SocketPinger)
+	if !ok {
+		return nil, ErrPingNotSupported
+	}
+
+	if c.IsClosed() {
+		return nil, ErrWrite
+	}
+
+	w := pongWaiter{data: append([]byte(nil), data...), ch: make(chan []byte, 1)}
+	c.pongMutex.Lock()
+	c.pongWaiters = append(c.pongWaiters, w)
+	c.pongMutex.Unlock()
+	defer c.removePongWaiter(w.ch)
+
+	if err := pinger.Ping(data, c.getWriteTimeout()); err != nil {
+		return nil, err
+	}
+
+	var timeout <-chan time.Time
+	if d := c.getReadTimeout(); d > 0 {
+		timeout = c.clock().After(d)
+	}
+
+	select {
+	case pong := <-w.ch:
+		return pong, nil
+	case <-c.closeCh:
+		return nil, ErrWrite
+	case <-timeout:
+		return nil, ErrPingTimeout
+	}
+}
+
+// handlePong passes the "data" of an incoming pong to the first ping with the same payload,
+// unsolicited pongs are ignored.
+func (c *Conn) handlePong(data []byte) {
+	c.pongMutex.Lock()
+	for i, w := range c.pongWaiters {
+		if bytes.Equal(w.data, data) {
+			c.pongWaiters = append(c.pongWaiters[:i], c.pongWaiters[i+1:]...)
+			w.ch <- append([]byte(nil), data...)
+			break
+		}
+	}
+	c.pongMutex.Unlock()
+}
+
+func (c *Conn) removePongWaiter(ch chan []byte) {
+	c.pongMutex.Lock()
+	for i, w := range c.pongWaiters {
+		if w.ch == ch {
+			c.pongWaiters = append(c.pongWaiters[:i], c.pongWaiters[i+1:]...)
+			break
+		}
+	}
+	c.pongMutex.Unlock()
+}
@@ -0,0 +1,250 @@
+package neffos
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pipeSocket is an in-memory `Socket` which reads the data given to its `Feed`
+// and can fail its next read or write with an injected error,
+// to trigger the error paths of a connection deterministically.
+// It records the timeouts of its reads and writes too.
+type pipeSocket struct {
+	conn  net.Conn
+	reads chan pipeRead
+	done  chan struct{}
+	once  sync.Once
+
+	mu            sync.Mutex
+	writeErr      error
+	written       [][]byte
+	readTimeouts  []time.Duration
+	writeTimeouts []time.Duration
+}
+
+type pipeRead struct {
+	data []byte
+	err  error
+}
+
+func newPipeSocket() *pipeSocket {
+	conn, _ := net.Pipe()
+	return &pipeSocket{conn: conn, reads: make(chan pipeRead, 16), done: make(chan struct{})}
+}
+
+// Feed makes the next read return the "data".
+func (s *pipeSocket) Feed(data []byte) { s.reads <- pipeRead{data: data} }
+
+// FailNextRead makes the next read, after the already fed data, fail with the "err".
+func (s *pipeSocket) FailNextRead(err error) { s.reads <- pipeRead{err: err} }
+
+// FailNextWrite makes the next write fail with the "err".
+func (s *pipeSocket) FailNextWrite(err error) {
+	s.mu.Lock()
+	s.writeErr = err
+	s.mu.Unlock()
+}
+
+// Written returns the successfully written data.
+func (s *pipeSocket) Written() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]byte(nil), s.written...)
+}
+
+// Timeouts returns the timeouts that the reads and the writes were called with.
+func (s *pipeSocket) Timeouts() (reads, writes []time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]time.Duration(nil), s.readTimeouts...), append([]time.Duration(nil), s.writeTimeouts...)
+}
+
+func (s *pipeSocket) NetConn() net.Conn      { return pipeNetConn{s.conn, s} }
+func (s *pipeSocket) Request() *http.Request { return nil }
+
+func (s *pipeSocket) ReadData(timeout time.Duration) ([]byte, error) {
+	s.mu.Lock()
+	s.readTimeouts = append(s.readTimeouts, timeout)
+	s.mu.Unlock()
+
+	select {
+	case r := <-s.reads:
+		return r.data, r.err
+	case <-s.done:
+		return nil, io.EOF
+	}
+}
+
+func (s *pipeSocket) WriteBinary(body []byte, timeout time.Duration) error {
+	return s.write(body, timeout)
+}
+
+func (s *pipeSocket) WriteText(body []byte, timeout time.Duration) error {
+	return s.write(body, timeout)
+}
+
+func (s *pipeSocket) write(body []byte, timeout time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.writeTimeouts = append(s.writeTimeouts, timeout)
+
+	if err := s.writeErr; err != nil {
+		s.writeErr = nil
+		return err
+	}
+
+	s.written = append(s.written, append([]byte(nil), body...))
+	return nil
+}
+
+// pipeNetConn unblocks the reads of its pipeSocket on close.
+type pipeNetConn struct {
+	net.Conn
+	s *pipeSocket
+}
+
+func (c pipeNetConn) Close() error {
+	c.s.once.Do(func() { close(c.s.done) })
+	return c.Conn.Close()
+}
+
+func TestConnReadError(t *testing.T) {
+	disconnected := make(chan string, 1)
+	namespaces := Namespaces{
+		"default": Events{
+			OnNamespaceDisconnect: func(c *NSConn, msg Message) error {
+				disconnected <- msg.Namespace
+				return nil
+			},
+		},
+	}
+
+	socket := newPipeSocket()
+	c := newConn(socket, namespaces, nil)
+	c.connectedNamespaces["default"] = newNSConn(c, "default", namespaces["default"])
+	go c.startReader()
+
+	errReset := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}
+	socket.FailNextRead(errReset)
+
+	select {
+	case <-c.Done():
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected the read error to close the connection")
+	}
+
+	if err := c.Wait(); err != errReset {
+		t.Fatalf("expected close error: %v but got: %v", errReset, err)
+	}
+
+	select {
+	case namespace := <-disconnected:
+		if namespace != "default" {
+			t.Fatalf("expected the disconnect of the default namespace but got: %s", namespace)
+		}
+	default:
+		t.Fatalf("expected the disconnect event to be fired")
+	}
+}
+
+func TestConnWriteError(t *testing.T) {
+	namespaces := Namespaces{"default": Events{}}
+
+	socket := newPipeSocket()
+	c := newConn(socket, namespaces, nil)
+	c.connectedNamespaces["default"] = newNSConn(c, "default", namespaces["default"])
+	c.acknowledge()
+
+	errTemporary := errors.New("temporary")
+	socket.FailNextWrite(errTemporary)
+	if err := <-c.WriteResult(Message{Namespace: "default", Event: "chat"}); err != errTemporary {
+		t.Fatalf("expected write error: %v but got: %v", errTemporary, err)
+	}
+	if c.IsClosed() {
+		t.Fatalf("expected a non-close write error to keep the connection")
+	}
+
+	if err := <-c.WriteResult(Message{Namespace: "default", Event: "chat"}); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := 1, len(socket.Written()); expected != got {
+		t.Fatalf("expected %d written messages but got %d", expected, got)
+	}
+
+	socket.FailNextWrite(io.ErrUnexpectedEOF)
+	if c.Write(Message{Namespace: "default", Event: "chat"}) {
+		t.Fatalf("expected the write to fail")
+	}
+	if err := c.Wait(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected close error: %v but got: %v", io.ErrUnexpectedEOF, err)
+	}
+}
+
+func TestConnSetTimeouts(t *testing.T) {
+	handled := make(chan struct{}, 2)
+	namespaces := Namespaces{
+		"default": Events{
+			"chat": func(c *NSConn, msg Message) error {
+				handled <- struct{}{}
+				return nil
+			},
+		},
+	}
+
+	socket := newPipeSocket()
+	c := newConn(socket, namespaces, nil)
+	c.connectedNamespaces["default"] = newNSConn(c, "default", namespaces["default"])
+	c.acknowledge()
+	defer c.Close()
+
+	waitReads := func(n int) {
+		t.Helper()
+		deadline := time.Now().Add(3 * time.Second)
+		for {
+			if reads, _ := socket.Timeouts(); len(reads) >= n {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d reads", n)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	c.SetReadTimeout(time.Second)
+	go c.startReader()
+	waitReads(1)
+
+	// the read in progress keeps its timeout, the next one gets the new one.
+	c.SetReadTimeout(2 * time.Second)
+	msg := serializeMessage(nil, Message{Namespace: "default", Event: "chat"})
+	socket.Feed(msg)
+	<-handled
+	socket.Feed(msg)
+	<-handled
+	waitReads(3)
+
+	c.SetWriteTimeout(time.Second)
+	if err := <-c.WriteResult(Message{Namespace: "default", Event: "chat"}); err != nil {
+		t.Fatal(err)
+	}
+	c.SetWriteTimeout(0)
+	if err := <-c.WriteResult(Message{Namespace: "default", Event: "chat"}); err != nil {
+		t.Fatal(err)
+	}
+
+	reads, writes := socket.Timeouts()
+	if expected, got := []time.Duration{time.Second, 2 * time.Second, 2 * time.Second}, reads[:3]; !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected read timeouts: %v but got: %v", expected, got)
+	}
+	if expected, got := []time.Duration{time.Second, 0}, writes; !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected write timeouts: %v but got: %v", expected, got)
+	}
+}
@@ -24,11 +24,14 @@ func (p *processes) get(name string) *process {
 	p.locker.RUnlock()
 
 	if entry == nil {
-		entry = &process{
-			v: new(uint32),
-		}
 		p.locker.Lock()
-		p.entries[name] = entry
+		// re-check, it may be created by a concurrent call in the meantime.
+		if entry = p.entries[name]; entry == nil {
+			entry = &process{
+				v: new(uint32),
+			}
+			p.entries[name] = entry
+		}
 		p.locker.Unlock()
 	}
 
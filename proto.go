@@ -0,0 +1,123 @@
+package neffos
+
+import (
+	"errors"
+	"reflect"
+)
+
+// ProtoMessage is the interface of the protocol buffers' generated messages,
+// its methods are the same as the `proto.Message` ones of the "github.com/golang/protobuf/proto" package,
+// so the generated messages are valid values without neffos depend on any protobuf package.
+// See `Namespaces.RegisterProto` and `NSConn.EmitProto`.
+type ProtoMessage interface {
+	Reset()
+	String() string
+	ProtoMessage()
+}
+
+// ProtoContentType is the content type of the messages sent by the `NSConn.EmitProto`.
+const ProtoContentType = "application/x-protobuf"
+
+// ErrProtoCodec is returned when the `ProtoMarshal` or the `ProtoUnmarshal`
+// is not set and the protobuf message does not marshal itself.
+var ErrProtoCodec = errors.New("proto codec is missing")
+
+type (
+	protoMarshaler interface {
+		Marshal() ([]byte, error)
+	}
+
+	protoUnmarshaler interface {
+		Unmarshal([]byte) error
+	}
+)
+
+// ProtoMarshal and ProtoUnmarshal are the protobuf codec of the `Namespaces.RegisterProto` events and the `NSConn.EmitProto`.
+// By default the messages marshal and unmarshal themselves, i.e the gogo/protobuf generated messages,
+// set them to the functions of a protobuf package to use it instead.
+//
+// Example Code:
+//
+//	neffos.ProtoMarshal = func(msg neffos.ProtoMessage) ([]byte, error) {
+//		return proto.Marshal(msg)
+//	}
+//	neffos.ProtoUnmarshal = func(b []byte, msg neffos.ProtoMessage) error {
+//		return proto.Unmarshal(b, msg)
+//	}
+var (
+	ProtoMarshal = func(msg ProtoMessage) ([]byte, error) {
+		if m, ok := msg.(protoMarshaler); ok {
+			return m.Marshal()
+		}
+
+		return nil, ErrProtoCodec
+	}
+
+	ProtoUnmarshal = func(b []byte, msg ProtoMessage) error {
+		if m, ok := msg.(protoUnmarshaler); ok {
+			return m.Unmarshal(b)
+		}
+
+		return ErrProtoCodec
+	}
+)
+
+// RegisterProto registers the type of the "msg" protobuf message as the schema of the "event" of the "namespace".
+// The body of the incoming messages of that event is decoded to a new value of that type, through the `ProtoUnmarshal`,
+// before the event's callback is fired, the callback receives it through the `Message.Proto` field,
+// i.e `msg.Proto.(*pb.UserMessage)`. A body which can not be decoded fails with the decode error,
+// like an error returned from the callback itself, without firing the callback.
+//
+// The event's callback should be registered before the `RegisterProto` call
+// and the "msg" should be a pointer, i.e `&pb.UserMessage{}`, otherwise it panics.
+// It should be called before the server or the client starts.
+// The rest of the events keep receiving their raw bytes, see `NSConn.EmitProto` too.
+func (nss Namespaces) RegisterProto(namespace, event string, msg ProtoMessage) {
+	typ := reflect.TypeOf(msg)
+	if typ == nil || typ.Kind() != reflect.Ptr {
+		panic("neffos: RegisterProto: the protobuf message of the event " + namespace + "." + event + " should be a pointer")
+	}
+	typ = typ.Elem()
+
+	handler := nss[namespace][event]
+	if handler == nil {
+		panic("neffos: RegisterProto: the event " + namespace + "." + event + " is not registered")
+	}
+
+	nss[namespace][event] = func(c *NSConn, msg Message) error {
+		v := reflect.New(typ).Interface().(ProtoMessage)
+		if err := ProtoUnmarshal(msg.Body, v); err != nil {
+			return err
+		}
+
+		msg.Proto = v
+		return handler(c, msg)
+	}
+}
+
+// EmitProto method sends the "msg" protobuf message to the remote side as the body of the "event",
+// it's marshaled through the `ProtoMarshal` and it's written as binary with the `ProtoContentType`,
+// the remote side decodes it when the "event" is registered through the `Namespaces.RegisterProto`.
+// It returns the marshal error or `ErrWrite` if the message was not written.
+func (ns *NSConn) EmitProto(event string, msg ProtoMessage) error {
+	if ns == nil {
+		return ErrWrite
+	}
+
+	body, err := ProtoMarshal(msg)
+	if err != nil {
+		return err
+	}
+
+	if !ns.Conn.Write(Message{
+		Namespace: ns.namespace,
+		Event:     event,
+		Body:      body,
+		SetBinary: true,
+		Headers:   map[string]string{ContentTypeHeader: ProtoContentType},
+	}) {
+		return ErrWrite
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package neffos
+
+import (
+	"sort"
+)
+
+// QoS describes the resources of the connections of a quality of service class,
+// i.e a "premium" class with larger buffers and higher rate limits than a "free" one.
+// See `Server.QoSClasses` and `Conn.SetQoS`.
+type QoS struct {
+	// Priority orders the classes, the connections of the lowest priority
+	// are dropped first under pressure, see `Server.DropLowestQoS`.
+	// Connections without a class have a zero priority.
+	Priority int
+	// MaxPendingWrites overrides the global `MaxPendingWrites` for the connections of this class, if positive.
+	MaxPendingWrites int
+	// MaxPendingAsks overrides the `Server.MaxPendingAsks` for the connections of this class, if positive.
+	MaxPendingAsks int
+	// RoomRateFactor multiplies the room rate limits, see `Server.RoomRateLimit`,
+	// for the connections of this class if positive, i.e 2 doubles them and 0.5 halves them.
+	RoomRateFactor float64
+}
+
+type qosClass struct {
+	name string
+	QoS
+}
+
+// SetQoS tags this server-side connection with the "class" of the `Server.QoSClasses`,
+// its limits are applied from now on, i.e it should be called on the `Server.OnConnect`.
+// It reports false if the class is not registered or if this is a client-side connection.
+func (c *Conn) SetQoS(class string) bool {
+	if c.IsClient() {
+		return false
+	}
+
+	qos, ok := c.server.QoSClasses[class]
+	if !ok {
+		return false
+	}
+
+	c.qos.Store(&qosClass{name: class, QoS: qos})
+	return true
+}
+
+// QoS returns the quality of service class of this connection, empty if not set, see `SetQoS`.
+func (c *Conn) QoS() string {
+	if qos := c.getQoS(); qos != nil {
+		return qos.name
+	}
+
+	return ""
+}
+
+func (c *Conn) getQoS() *qosClass {
+	qos, _ := c.qos.Load().(*qosClass)
+	return qos
+}
+
+func (c *Conn) maxPendingWrites() int {
+	if qos := c.getQoS(); qos != nil && qos.MaxPendingWrites > 0 {
+		return qos.MaxPendingWrites
+	}
+
+	return MaxPendingWrites
+}
+
+// scaleRoomRate returns the room rate limit "perSec" of this connection's class.
+func (c *Conn) scaleRoomRate(perSec int) int {
+	qos := c.getQoS()
+	if qos == nil || qos.RoomRateFactor <= 0 {
+		return perSec
+	}
+
+	if scaled := int(float64(perSec) * qos.RoomRateFactor); scaled > 0 {
+		return scaled
+	}
+
+	return 1
+}
+
+// DropLowestQoS terminates up to "n" connections, the ones of the lowest `QoS.Priority` first,
+// i.e to shed load under pressure while the higher classes keep their service.
+// If "reason" is not empty then each connected namespace of a dropped connection
+// receives a forced disconnect with the "reason" as its `Message.Body` before the termination.
+// It returns the number of the dropped connections, the disconnects run in the background.
+//
+// Like the `DisconnectWhere` it works against a snapshot of the current connections.
+func (s *Server) DropLowestQoS(n int, reason []byte) int {
+	if n <= 0 {
+		return 0
+	}
+
+	s.mu.RLock()
+	conns := make([]*Conn, 0, len(s.connections))
+	for c := range s.connections {
+		conns = append(conns, c)
+	}
+	s.mu.RUnlock()
+
+	priority := func(c *Conn) int {
+		if qos := c.getQoS(); qos != nil {
+			return qos.Priority
+		}
+		return 0
+	}
+
+	sort.SliceStable(conns, func(i, j int) bool {
+		return priority(conns[i]) < priority(conns[j])
+	})
+
+	dropped := 0
+	for _, c := range conns {
+		if dropped == n {
+			break
+		}
+
+		if c.IsClosed() {
+			continue
+		}
+
+		dropped++
+		go c.closeWithReason(reason)
+	}
+
+	return dropped
+}
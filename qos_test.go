@@ -0,0 +1,55 @@
+package neffos
+
+import (
+	"testing"
+)
+
+func TestConnQoS(t *testing.T) {
+	namespaces := Namespaces{"default": Events{}}
+	s := New(nil, namespaces)
+	defer s.Close()
+	s.MaxPendingAsks = 10
+	s.QoSClasses = map[string]QoS{
+		"premium": {Priority: 1, MaxPendingWrites: 1024, MaxPendingAsks: 100, RoomRateFactor: 2},
+		"free":    {RoomRateFactor: 0.1},
+	}
+
+	c := newConn(newPipeSocket(), namespaces, nil)
+	c.server = s
+	defer c.Close()
+
+	if c.SetQoS("unknown") || c.QoS() != "" {
+		t.Fatalf("expected an unknown class to be ignored")
+	}
+
+	if expected, got := MaxPendingWrites, c.maxPendingWrites(); expected != got {
+		t.Fatalf("expected the default max pending writes: %d but got: %d", expected, got)
+	}
+
+	if !c.SetQoS("premium") || c.QoS() != "premium" {
+		t.Fatalf("expected the premium class to be set")
+	}
+
+	if expected, got := 1024, c.maxPendingWrites(); expected != got {
+		t.Fatalf("expected max pending writes: %d but got: %d", expected, got)
+	}
+
+	if expected, got := 100, c.maxPendingAsks(); expected != got {
+		t.Fatalf("expected max pending asks: %d but got: %d", expected, got)
+	}
+
+	if expected, got := 10, c.scaleRoomRate(5); expected != got {
+		t.Fatalf("expected room rate: %d but got: %d", expected, got)
+	}
+
+	c.SetQoS("free")
+
+	if expected, got := 10, c.maxPendingAsks(); expected != got {
+		t.Fatalf("expected the server's max pending asks: %d but got: %d", expected, got)
+	}
+
+	// never scaled down to zero.
+	if expected, got := 1, c.scaleRoomRate(5); expected != got {
+		t.Fatalf("expected room rate: %d but got: %d", expected, got)
+	}
+}
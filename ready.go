@@ -0,0 +1,79 @@
+package neffos
+
+import (
+	"context"
+)
+
+// onReady is the internal event of the control message which the client-side sends on `SetReady`.
+const onReady = "_OnReady"
+
+// SetReady signals the server-side that this client-side connection is ready to receive events,
+// i.e after its event callbacks are wired or its initial state is loaded.
+// It's an optional, application-level, readiness phase after the connection's acknowledgement,
+// the server-side waits for it through its `Conn.WaitReady`.
+// It reports whether the signal was sent, it always reports false on a server-side connection.
+func (c *Conn) SetReady() bool {
+	if !c.IsClient() || c.IsClosed() {
+		return false
+	}
+
+	return c.writeOrQueue(serializeMessage(nil, Message{Event: onReady}), false)
+}
+
+// WaitReady blocks until the client-side of this server-side connection signals that it's ready
+// to receive events through its `SetReady`, i.e to not emit before the client's event callbacks are wired.
+// It returns the "ctx"'s error if the signal does not arrive in time or `ErrWrite` if the connection is closed.
+// It returns immediately if the client already signaled or if this is a client-side connection.
+func (c *Conn) WaitReady(ctx context.Context) error {
+	if c.IsClient() {
+		return nil
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	select {
+	case <-c.readyChan():
+		return nil
+	case <-c.closeCh:
+		return ErrWrite
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// IsReady reports whether the client-side of this server-side connection signaled that it's ready,
+// see `WaitReady`.
+func (c *Conn) IsReady() bool {
+	select {
+	case <-c.readyChan():
+		return true
+	default:
+		return false
+	}
+}
+
+// readyChan returns the channel which is closed when the client-side signals that it's ready.
+func (c *Conn) readyChan() chan struct{} {
+	c.readyMutex.Lock()
+	if c.readyCh == nil {
+		c.readyCh = make(chan struct{})
+	}
+	ch := c.readyCh
+	c.readyMutex.Unlock()
+
+	return ch
+}
+
+// markReady handles the client-side's `SetReady` signal.
+func (c *Conn) markReady() {
+	if c.IsClient() {
+		return
+	}
+
+	ch := c.readyChan()
+	c.readyOnce.Do(func() {
+		close(ch)
+	})
+}
@@ -0,0 +1,296 @@
+package neffos
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// onReconnect is the internal event of the control message which the server-side sends on `Server.RequestReconnect`,
+// its body is the new URL and its `reconnectDelayHeader` header is the delay before the client re-dials.
+const onReconnect = "_OnReconnect"
+
+const reconnectDelayHeader = "Neffos-Reconnect-Delay"
+
+// DefaultReconnectStagger is the default `Server.ReconnectStagger`.
+var DefaultReconnectStagger = 5 * time.Second
+
+var (
+	// DefaultReconnectAttempts is the default `Client.ReconnectAttempts`.
+	DefaultReconnectAttempts = 5
+	// DefaultReconnectBackoff is the default `Client.ReconnectBackoff`.
+	DefaultReconnectBackoff = time.Second
+	// MaxReconnectBackoff is the maximum delay between two attempts of a reconnection.
+	MaxReconnectBackoff = 30 * time.Second
+)
+
+// RequestReconnect asks all the connections of this server to reconnect to the "newURL" endpoint,
+// i.e to a new server instance on deploys, and returns the number of the connections that the request was written to.
+// The clients that enabled it through their `Client.EnableReconnect` dial the "newURL",
+// re-connect to the same namespaces and re-join the same rooms and, on success, close their connection to this server.
+// The reconnects are spread evenly over the `ReconnectStagger` duration, to avoid a thundering herd on the new endpoint.
+// The rest of the clients ignore the request.
+//
+// It's usually followed by a `Drain` and a `Close` of this server, after the `ReconnectStagger` duration.
+func (s *Server) RequestReconnect(newURL string) int {
+	s.mu.RLock()
+	conns := make([]*Conn, 0, len(s.connections))
+	for c := range s.connections {
+		conns = append(conns, c)
+	}
+	s.mu.RUnlock()
+
+	stagger := s.ReconnectStagger
+	if stagger <= 0 {
+		stagger = DefaultReconnectStagger
+	}
+
+	n := 0
+	for i, c := range conns {
+		if c.IsClosed() {
+			continue
+		}
+
+		delay := stagger * time.Duration(i) / time.Duration(len(conns))
+		msg := Message{
+			Event: onReconnect,
+			Body:  []byte(newURL),
+			Headers: map[string]string{
+				reconnectDelayHeader: strconv.FormatInt(int64(delay/time.Millisecond), 10),
+			},
+		}
+
+		if c.writeOrQueue(serializeMessage(nil, msg), false) {
+			n++
+		}
+	}
+
+	return n
+}
+
+// handleReconnectRequest handles the server-side's `RequestReconnect` on the client-side.
+func (c *Conn) handleReconnectRequest(msg Message) {
+	if !c.IsClient() {
+		return
+	}
+
+	reconnect := c.startReconnect()
+	if reconnect == nil {
+		return
+	}
+
+	ms, _ := strconv.ParseInt(msg.Headers[reconnectDelayHeader], 10, 64)
+	go reconnect(string(msg.Body), time.Duration(ms)*time.Millisecond, c.connectedRooms())
+}
+
+// reconnectOnLost starts a reconnection to the endpoint of the `Dial`, without a delay,
+// when this client-side connection is lost, i.e on a network failure or when the server closes it.
+// It's called by the reader on a read error, a connection closed by this client is not reconnected.
+func (c *Conn) reconnectOnLost() {
+	if !c.IsClient() || c.IsClosed() {
+		return
+	}
+
+	reconnect := c.startReconnect()
+	if reconnect == nil {
+		return
+	}
+
+	go reconnect("", 0, c.connectedRooms())
+}
+
+// startReconnect returns the reconnection of this client-side connection, if it's enabled
+// and it's not already in progress.
+func (c *Conn) startReconnect() func(url string, delay time.Duration, rooms map[string][]string) {
+	c.reconnectMutex.Lock()
+	reconnect := c.reconnect
+	c.reconnectMutex.Unlock()
+
+	if reconnect == nil || !atomic.CompareAndSwapUint32(&c.reconnecting, 0, 1) {
+		return nil
+	}
+
+	return reconnect
+}
+
+// EnableReconnect enables the reconnection to a new endpoint on the server's `Server.RequestReconnect` request
+// and to the same endpoint when the connection is lost, i.e on a network failure or when the server closes it,
+// a connection closed by this client's `Close` is not reconnected.
+// After the server-defined delay, or right away for a lost connection, the client dials the URL
+// with the same `Dialer` and `ConnHandler` of its `Dial`,
+// connects to the same namespaces and re-joins the same rooms of this client, as a new `Client`.
+// The messages that this client did not send (see `Conn.PendingWrites`) are written through the new client,
+// which shares the outbound buffers of this client (see `Client.BufferOutbound`): the messages emitted
+// while a lost connection is reconnected are kept and sent once their namespace is connected again.
+// On success this client is closed and the new one is passed to the "onReconnect" callback,
+// which should replace this client, the new client has the reconnection enabled as well.
+// A failed attempt is retried, with an exponential backoff, up to the `ReconnectAttempts`,
+// the progress is reported through the `OnReconnecting`, `OnReconnected` and `OnReconnectFailed` callbacks.
+// On failure of the last attempt this client is kept as it's and the "onReconnect" receives the error.
+// It's disabled by default.
+func (c *Client) EnableReconnect(onReconnect func(newClient *Client, err error)) {
+	c.conn.reconnectMutex.Lock()
+	c.conn.reconnect = func(url string, delay time.Duration, rooms map[string][]string) {
+		if url == "" {
+			url = c.url
+		}
+
+		newClient, err := c.reconnect(url, delay, rooms)
+		if err != nil {
+			// this client is kept, it can be reconnected again.
+			atomic.StoreUint32(&c.conn.reconnecting, 0)
+			if c.conn.IsClosed() {
+				// nothing to send them.
+				c.conn.dropOutbound()
+			}
+			if err != ErrWrite && onReconnect != nil {
+				onReconnect(nil, err)
+			}
+			return
+		}
+
+		newClient.EnableReconnect(onReconnect)
+		if onReconnect != nil {
+			onReconnect(newClient, nil)
+		}
+	}
+	c.conn.reconnectMutex.Unlock()
+}
+
+func (c *Client) reconnect(url string, delay time.Duration, rooms map[string][]string) (*Client, error) {
+	attempts := c.ReconnectAttempts
+	if attempts <= 0 {
+		attempts = DefaultReconnectAttempts
+	}
+
+	backoff := c.ReconnectBackoff
+	if backoff <= 0 {
+		backoff = DefaultReconnectBackoff
+	}
+
+	// the "rooms" are the namespaces and their rooms to re-establish,
+	// kept in case the old connection is closed by the remote side between the attempts.
+	for attempt := 1; ; attempt++ {
+		if c.OnReconnecting != nil {
+			c.OnReconnecting(attempt, delay)
+		}
+
+		if !c.waitReconnect(delay) {
+			// closed by this client in the meantime, there is nothing to migrate.
+			return nil, ErrWrite
+		}
+
+		if !c.conn.IsClosed() {
+			rooms = c.conn.connectedRooms()
+		}
+
+		newClient, err := c.reconnectOnce(url, rooms)
+		if err == nil {
+			if c.OnReconnected != nil {
+				c.OnReconnected()
+			}
+			return newClient, nil
+		}
+
+		if attempt >= attempts {
+			if c.OnReconnectFailed != nil {
+				c.OnReconnectFailed(err)
+			}
+			return nil, err
+		}
+
+		delay = backoff
+		if backoff *= 2; backoff > MaxReconnectBackoff {
+			backoff = MaxReconnectBackoff
+		}
+	}
+}
+
+// waitReconnect waits for the "delay" before an attempt of a reconnection,
+// it reports false if this client is closed by itself in the meantime.
+func (c *Client) waitReconnect(delay time.Duration) bool {
+	timer := c.conn.clock().After(delay)
+
+	select {
+	case <-timer:
+		return true
+	case <-c.conn.closeCh:
+		if c.conn.Wait() == nil {
+			// closed by a `Close` call.
+			return false
+		}
+
+		// closed by the remote side, i.e the old server is shut down, the attempts continue.
+		<-timer
+		return true
+	}
+}
+
+// connectedRooms returns the connected namespaces mapped to their joined rooms.
+func (c *Conn) connectedRooms() map[string][]string {
+	rooms := make(map[string][]string)
+	c.connectedNamespacesMutex.RLock()
+	for namespace, ns := range c.connectedNamespaces {
+		rooms[namespace] = ns.RoomNames()
+	}
+	c.connectedNamespacesMutex.RUnlock()
+
+	return rooms
+}
+
+func (c *Client) reconnectOnce(url string, rooms map[string][]string) (*Client, error) {
+	newClient, err := dialClient(nil, c.dial, url, c.connHandler, c.eventsMutex, c.conn.outbound)
+	if err != nil {
+		return nil, err
+	}
+
+	// a failed attempt leaves the shared outbound buffers to the next one.
+	fail := func(err error) (*Client, error) {
+		atomic.StoreUint32(&newClient.conn.reconnecting, 1)
+		newClient.Close()
+		return nil, err
+	}
+
+	if clk, ok := c.conn.clk.Load().(clockValue); ok {
+		newClient.conn.setClock(clk.Clock)
+	}
+	newClient.ReconnectAttempts = c.ReconnectAttempts
+	newClient.ReconnectBackoff = c.ReconnectBackoff
+	newClient.OnReconnecting = c.OnReconnecting
+	newClient.OnReconnected = c.OnReconnected
+	newClient.OnReconnectFailed = c.OnReconnectFailed
+	atomic.StoreUint32(&newClient.conn.strictOrdering, atomic.LoadUint32(&c.conn.strictOrdering))
+
+	ctx := context.Background()
+	for namespace, names := range rooms {
+		ns := newClient.conn.Namespace(namespace)
+		if ns == nil {
+			if ns, err = newClient.Connect(ctx, namespace); err != nil {
+				return fail(err)
+			}
+		}
+
+		for _, room := range names {
+			if _, err = ns.JoinRoom(ctx, room); err != nil {
+				return fail(err)
+			}
+		}
+	}
+
+	c.Close()
+
+	// the messages that this client did not send, see `Conn.PendingWrites`.
+	for _, msg := range c.conn.PendingWrites() {
+		newClient.conn.Write(msg)
+	}
+
+	// this client stops buffering, the messages that it buffered
+	// after the namespaces were connected by the new client are sent now.
+	atomic.StoreUint32(&c.conn.reconnecting, 0)
+	for namespace := range rooms {
+		newClient.conn.flushOutbound(namespace)
+	}
+
+	return newClient, nil
+}
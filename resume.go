@@ -0,0 +1,198 @@
+package neffos
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	uuid "github.com/iris-contrib/go.uuid"
+)
+
+// onResumeToken is the internal event of the control message which the server-side sends, right after the acknowledgement,
+// with the connection's resume token as its body, see `Server.SessionResumeTimeout`.
+const onResumeToken = "_OnResumeToken"
+
+// onResume is the internal event of the client-side's `Client.Resume` request, its body is the resume token.
+const onResume = "_OnResume"
+
+// ErrSessionNotFound is returned from a `Client.Resume` when the server keeps no session of its token,
+// i.e the token is already used or unknown or the session expired, see `Server.SessionResumeTimeout`.
+var ErrSessionNotFound = errors.New("session not found")
+
+// detachedSession is the state of a closed connection which is kept to be resumed by a new one.
+type detachedSession struct {
+	store map[string]interface{}
+	// the connected namespaces mapped to their joined rooms.
+	rooms     map[string][]string
+	expiresAt time.Time
+}
+
+// issueResumeToken sends a new resume token to the remote side of this acknowledged server-side connection,
+// if the server keeps the sessions of the closed connections.
+func (c *Conn) issueResumeToken() {
+	if c.server.SessionResumeTimeout <= 0 {
+		return
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return
+	}
+
+	c.resumeTokenMutex.Lock()
+	c.resumeToken = id.String()
+	c.resumeTokenMutex.Unlock()
+
+	c.writeOrQueue(serializeMessage(nil, Message{Event: onResumeToken, Body: []byte(id.String())}), false)
+}
+
+// handleResumeToken keeps the resume token that the server-side sent to this client-side connection.
+func (c *Conn) handleResumeToken(msg Message) {
+	if !c.IsClient() {
+		return
+	}
+
+	c.resumeTokenMutex.Lock()
+	c.resumeToken = string(msg.Body)
+	c.resumeTokenMutex.Unlock()
+}
+
+func (c *Conn) getResumeToken() string {
+	c.resumeTokenMutex.Lock()
+	token := c.resumeToken
+	c.resumeTokenMutex.Unlock()
+	return token
+}
+
+// detachSession keeps the session of this closing server-side connection, its store and its rooms,
+// under its resume token for the `Server.SessionResumeTimeout`.
+// Only the sessions of the connections that are lost, i.e by a network failure, or closed by the remote side are kept,
+// the ones that the server closes on purpose, i.e a kick, a `Server.DisconnectWhere`, a rejection or the `Server.Close`,
+// cannot be resumed. Locks required, see `Close`.
+func (c *Conn) detachSession() {
+	token := c.getResumeToken()
+	if token == "" {
+		return
+	}
+
+	c.closeErrMutex.Lock()
+	err := c.closeErr
+	c.closeErrMutex.Unlock()
+	// the server's own closes are not caused by a read or a write error,
+	// except the ones of the misbehaving connections.
+	if err == nil || err == ErrOutOfOrder || err == ErrInvalidPayload {
+		return
+	}
+
+	clock := c.clock()
+	sess := &detachedSession{
+		rooms:     make(map[string][]string, len(c.connectedNamespaces)),
+		expiresAt: clock.Now().Add(c.server.SessionResumeTimeout),
+	}
+
+	c.storeMutex.RLock()
+	sess.store = make(map[string]interface{}, len(c.store))
+	for k, v := range c.store {
+		sess.store[k] = v
+	}
+	c.storeMutex.RUnlock()
+
+	for namespace, ns := range c.connectedNamespaces {
+		sess.rooms[namespace] = ns.RoomNames()
+	}
+
+	s := c.server
+	s.detachedSessionsMutex.Lock()
+	if s.detachedSessions == nil {
+		s.detachedSessions = make(map[string]*detachedSession)
+	}
+	s.detachedSessions[token] = sess
+	s.detachedSessionsMutex.Unlock()
+
+	afterFunc(clock, s.SessionResumeTimeout, func() {
+		s.detachedSessionsMutex.Lock()
+		if s.detachedSessions[token] == sess {
+			delete(s.detachedSessions, token)
+		}
+		s.detachedSessionsMutex.Unlock()
+	})
+}
+
+// takeDetachedSession removes and returns the not expired session of the "token", if any.
+func (s *Server) takeDetachedSession(token string) *detachedSession {
+	s.detachedSessionsMutex.Lock()
+	sess, ok := s.detachedSessions[token]
+	if ok {
+		delete(s.detachedSessions, token)
+	}
+	s.detachedSessionsMutex.Unlock()
+
+	if !ok || !s.now().Before(sess.expiresAt) {
+		return nil
+	}
+
+	return sess
+}
+
+// handleResume handles the client-side's `Client.Resume` request on the server-side,
+// the session is restored in the background, the connects and the joins wait for the client's replies,
+// and the request is answered right after.
+func (c *Conn) handleResume(msg Message) {
+	if c.IsClient() {
+		return
+	}
+
+	c.Go(func(ctx context.Context) {
+		msg.Err = c.resumeSession(ctx, string(msg.Body))
+		msg.Body = nil
+		c.Write(msg)
+	})
+}
+
+// resumeSession re-associates this server-side connection with the detached session of the "token":
+// it restores the session's store values, connects its namespaces and joins its rooms.
+func (c *Conn) resumeSession(ctx context.Context, token string) error {
+	sess := c.server.takeDetachedSession(token)
+	if sess == nil {
+		return ErrSessionNotFound
+	}
+
+	for k, v := range sess.store {
+		c.Set(k, v)
+	}
+
+	for namespace, rooms := range sess.rooms {
+		ns, err := c.Connect(ctx, namespace)
+		if err != nil {
+			return err
+		}
+
+		for _, room := range rooms {
+			if _, err = ns.JoinRoom(ctx, room); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// ResumeToken returns the token that the server issued to this client to resume its session
+// after a disconnection, through a new client's `Resume`, see `Server.SessionResumeTimeout`.
+// It's sent by the server right after the acknowledgement, so it may be empty right after the `Dial`,
+// and it's always empty when the server does not keep the sessions.
+func (c *Client) ResumeToken() string {
+	return c.conn.getResumeToken()
+}
+
+// Resume resumes the session of a closed client, the "token" is its `ResumeToken`,
+// i.e right after the `Dial` of a reconnection after a network failure:
+// the server restores the values that the closed connection had stored through the `Conn.Set`,
+// re-connects this client to the same namespaces and re-joins the same rooms,
+// the connects and the joins fire their events on both sides as usual.
+// It blocks until the session is restored. A token can be used once,
+// it fails with an `ErrSessionNotFound` if the server keeps no session of the "token".
+func (c *Client) Resume(ctx context.Context, token string) error {
+	_, err := c.conn.Ask(ctx, Message{Event: onResume, Body: []byte(token)})
+	return err
+}
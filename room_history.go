@@ -0,0 +1,192 @@
+package neffos
+
+import (
+	"sync"
+)
+
+type roomKey struct {
+	namespace string
+	room      string
+}
+
+type roomHistoryEntry struct {
+	event  string
+	data   []byte
+	binary bool
+}
+
+// roomHistory is a fixed-size ring buffer of a room's recently broadcasted, serialized, messages.
+type roomHistory struct {
+	mu      sync.Mutex
+	entries []roomHistoryEntry
+	next    int
+	full    bool
+}
+
+func newRoomHistory(n int) *roomHistory {
+	return &roomHistory{entries: make([]roomHistoryEntry, n)}
+}
+
+func (h *roomHistory) add(entry roomHistoryEntry) {
+	h.mu.Lock()
+	h.entries[h.next] = entry
+	h.next++
+	if h.next == len(h.entries) {
+		h.next = 0
+		h.full = true
+	}
+	h.mu.Unlock()
+}
+
+// snapshot returns the kept entries, oldest first.
+func (h *roomHistory) snapshot() []roomHistoryEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.full {
+		return append([]roomHistoryEntry(nil), h.entries[:h.next]...)
+	}
+
+	entries := make([]roomHistoryEntry, 0, len(h.entries))
+	entries = append(entries, h.entries[h.next:]...)
+	return append(entries, h.entries[:h.next]...)
+}
+
+// resized returns a new history of size "n" which keeps the most recent entries of "h".
+func (h *roomHistory) resized(n int) *roomHistory {
+	entries := h.snapshot()
+	if len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+
+	r := newRoomHistory(n)
+	for _, entry := range entries {
+		r.add(entry)
+	}
+
+	return r
+}
+
+// RoomHistory sets the number of the recently broadcasted messages that the server keeps
+// for the "room" of the "namespace", a connection which joins that room receives them,
+// oldest first, before any live message of the room, i.e the last messages of a chat room.
+// An empty "room" sets the default size of all the rooms of the "namespace"
+// that they do not set their own. A zero or negative "n" removes the size,
+// a room without its own size falls back to its namespace's default one.
+//
+// Only the messages that are broadcasted through this server to a room without a specific receiver
+// are kept, i.e by the `Broadcast` and `RoomEmit` methods, as they are serialized for the remote side,
+// the `OnWriteMessage` is not called on their replay.
+// The history lives in this server's memory, it's not shared through the `StackExchange`.
+// A message that is broadcasted at the same time as the join may be missed by the joining connection.
+//
+// Memory: each room keeps up to "n" messages, the server uses about
+// rooms * n * (the average serialized message size) bytes for the history.
+// A room's history is created on its first broadcasted message and it's kept even if the room has no members,
+// the rooms of a namespace with a default size are never released, until the default size is removed.
+func (s *Server) RoomHistory(namespace, room string, n int) {
+	key := roomKey{namespace, room}
+
+	s.roomHistoryMutex.Lock()
+	defer s.roomHistoryMutex.Unlock()
+
+	if s.roomHistorySizes == nil {
+		s.roomHistorySizes = make(map[roomKey]int)
+		s.roomHistories = make(map[roomKey]*roomHistory)
+	}
+
+	if n > 0 {
+		s.roomHistorySizes[key] = n
+	} else {
+		delete(s.roomHistorySizes, key)
+	}
+
+	// resize the existing histories.
+	for k, h := range s.roomHistories {
+		if k.namespace != namespace || (room != "" && k.room != room) {
+			continue
+		}
+
+		if room == "" {
+			if _, ok := s.roomHistorySizes[k]; ok {
+				// the room's own size wins.
+				continue
+			}
+		}
+
+		if size := s.roomHistorySize(k); size > 0 {
+			s.roomHistories[k] = h.resized(size)
+		} else {
+			delete(s.roomHistories, k)
+		}
+	}
+}
+
+// lock required.
+func (s *Server) roomHistorySize(key roomKey) int {
+	if n, ok := s.roomHistorySizes[key]; ok {
+		return n
+	}
+
+	return s.roomHistorySizes[roomKey{namespace: key.namespace}]
+}
+
+// recordRoomHistory keeps the broadcasted "msg" to its room's history, if any, see `RoomHistory`.
+func (s *Server) recordRoomHistory(msg Message) {
+	if msg.Room == "" || msg.To != "" || msg.wait != "" || msg.Err != nil || msg.isNoOp || IsSystemEvent(msg.Event) {
+		return
+	}
+
+	key := roomKey{msg.Namespace, msg.Room}
+
+	s.roomHistoryMutex.RLock()
+	if len(s.roomHistorySizes) == 0 {
+		s.roomHistoryMutex.RUnlock()
+		return
+	}
+	h := s.roomHistories[key]
+	s.roomHistoryMutex.RUnlock()
+
+	if h == nil {
+		s.roomHistoryMutex.Lock()
+		if h = s.roomHistories[key]; h == nil {
+			if n := s.roomHistorySize(key); n > 0 {
+				h = newRoomHistory(n)
+				s.roomHistories[key] = h
+			}
+		}
+		s.roomHistoryMutex.Unlock()
+
+		if h == nil {
+			return
+		}
+	}
+
+	msg.FromExplicit = ""
+	h.add(roomHistoryEntry{
+		event:  msg.Event,
+		data:   serializeMessage(nil, msg),
+		binary: msg.SetBinary,
+	})
+}
+
+// replayRoomHistory writes the history of the "room" to the server-side "ns" connection.
+func (s *Server) replayRoomHistory(ns *NSConn, room string) {
+	s.roomHistoryMutex.RLock()
+	h := s.roomHistories[roomKey{ns.namespace, room}]
+	s.roomHistoryMutex.RUnlock()
+
+	if h == nil {
+		return
+	}
+
+	for _, entry := range h.snapshot() {
+		if !ns.isSubscribed(entry.event) {
+			continue
+		}
+
+		if !ns.Conn.writeOrQueue(entry.data, entry.binary) {
+			return
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package neffos
+
+import (
+	"math/rand"
+	"sync/atomic"
+)
+
+// roomChange is a join or a leave of a room which is recorded under the locks
+// and announced to the `RoomStore` and to the room's subscribers of the `StackExchange` after them,
+// see `flushRoomChanges`.
+type roomChange struct {
+	room   string
+	joined bool
+}
+
+// setRoom adds the joined "room" to the namespace's rooms and, on the server-side, to the server's room index.
+// The `RoomStore` membership and the subscription to the room are recorded to be sent by the `flushRoomChanges`.
+// Locks required.
+func (ns *NSConn) setRoom(room *Room) {
+	ns.rooms[room.Name] = room
+
+	if !ns.Conn.IsClient() {
+		ns.Conn.server.indexRoom(ns, room.Name)
+		ns.recordRoomChange(roomChange{room: room.Name, joined: true})
+	}
+}
+
+// deleteRoom removes the "room" from the namespace's rooms and, on the server-side, from the server's room index.
+// The `RoomStore` membership removal and the unsubscription from the room are recorded
+// to be sent by the `flushRoomChanges`. Locks required.
+func (ns *NSConn) deleteRoom(room string) {
+	if _, ok := ns.rooms[room]; !ok {
+		return
+	}
+
+	delete(ns.rooms, room)
+
+	if !ns.Conn.IsClient() {
+		ns.Conn.server.unindexRoom(ns, room)
+		ns.recordRoomChange(roomChange{room: room, joined: false})
+	}
+}
+
+func (ns *NSConn) recordRoomChange(change roomChange) {
+	s := ns.Conn.server
+	if _, ok := s.StackExchange.(StackExchangeRoomSubscriber); ok || s.RoomStore != nil {
+		ns.roomChanges = append(ns.roomChanges, change)
+	}
+}
+
+// flushRoomChanges stores to the `RoomStore` and subscribes to the rooms of the `StackExchange`
+// the joins and the leaves since its last call, in order.
+// It must be called without holding the rooms' and the connected namespaces' locks:
+// the store does network I/O and the `StackExchange` may block until its own loop handles the (un)subscription,
+// i.e the redis one, which in the meantime may need those locks to deliver a message to this connection.
+func (ns *NSConn) flushRoomChanges() {
+	if ns.Conn.IsClient() {
+		return
+	}
+
+	// serializes the flushes, so a join and a leave of the same room are not reordered.
+	ns.roomChangesMutex.Lock()
+	defer ns.roomChangesMutex.Unlock()
+
+	ns.roomsMutex.Lock()
+	changes := ns.roomChanges
+	ns.roomChanges = nil
+	ns.roomsMutex.Unlock()
+
+	s := ns.Conn.server
+	sub, ok := s.StackExchange.(StackExchangeRoomSubscriber)
+
+	for _, change := range changes {
+		if change.joined {
+			s.storeRoomMember(ns, change.room)
+			if ok {
+				sub.SubscribeRoom(ns.Conn, ns.namespace, change.room)
+			}
+		} else {
+			s.unstoreRoomMember(ns, change.room)
+			if ok {
+				sub.UnsubscribeRoom(ns.Conn, ns.namespace, change.room)
+			}
+		}
+	}
+}
+
+func (s *Server) indexRoom(ns *NSConn, room string) {
+	weight := s.roomMemberWeight(ns)
+
+	s.roomIndexMutex.Lock()
+	if s.roomIndex == nil {
+		s.roomIndex = make(map[string]map[string]map[*NSConn]struct{})
+	}
+
+	rooms, ok := s.roomIndex[ns.namespace]
+	if !ok {
+		rooms = make(map[string]map[*NSConn]struct{})
+		s.roomIndex[ns.namespace] = rooms
+	}
+
+	members, ok := rooms[room]
+	if !ok {
+		members = make(map[*NSConn]struct{})
+		rooms[room] = members
+	}
+
+	if _, ok := members[ns]; !ok {
+		members[ns] = struct{}{}
+
+		key := roomKey{ns.namespace, room}
+		order, ok := s.roomOrders[key]
+		if !ok {
+			if s.roomOrders == nil {
+				s.roomOrders = make(map[roomKey]*roomOrder)
+			}
+			order = new(roomOrder)
+			s.roomOrders[key] = order
+		}
+		order.add(ns, weight)
+	}
+	s.roomIndexMutex.Unlock()
+}
+
+func (s *Server) unindexRoom(ns *NSConn, room string) {
+	s.roomIndexMutex.Lock()
+	if members, ok := s.roomIndex[ns.namespace][room]; ok {
+		delete(members, ns)
+
+		key := roomKey{ns.namespace, room}
+		if order, ok := s.roomOrders[key]; ok {
+			order.remove(ns)
+		}
+
+		if len(members) == 0 {
+			delete(s.roomIndex[ns.namespace], room)
+			delete(s.roomOrders, key)
+
+			if len(s.roomIndex[ns.namespace]) == 0 {
+				delete(s.roomIndex, ns.namespace)
+			}
+		}
+	}
+	s.roomIndexMutex.Unlock()
+}
+
+// roomMembers returns a snapshot of the connections of this server that are joined to the "room" of the "namespace",
+// they are looked up through the `RoomStore`, if any.
+func (s *Server) roomMembers(namespace, room string) []*NSConn {
+	if s.RoomStore != nil {
+		return s.storedRoomMembers(namespace, room)
+	}
+
+	return s.indexedRoomMembers(namespace, room)
+}
+
+// indexedRoomMembers returns a snapshot of the connections that are joined to the "room" of the "namespace"
+// from the server's room index.
+func (s *Server) indexedRoomMembers(namespace, room string) []*NSConn {
+	s.roomIndexMutex.RLock()
+	members := s.roomIndex[namespace][room]
+	snapshot := make([]*NSConn, 0, len(members))
+	for ns := range members {
+		snapshot = append(snapshot, ns)
+	}
+	s.roomIndexMutex.RUnlock()
+
+	return snapshot
+}
+
+// RoomsInfo returns the active rooms of the "namespace", the rooms with at least one member,
+// mapped to their number of members, the connections of this server that are joined to each room.
+// The result is a consistent snapshot of all rooms at a single point in time,
+// taken from the rooms' membership index that the server maintains on each join and leave,
+// the joins and leaves wait for the copy of the counts, so it's cheap even with thousands of rooms.
+//
+// It's designed for monitoring, i.e a dashboard, it's not aware of the connections of other servers
+// that share the same `StackExchange`.
+func (s *Server) RoomsInfo(namespace string) map[string]int {
+	s.roomIndexMutex.RLock()
+	rooms := s.roomIndex[namespace]
+	info := make(map[string]int, len(rooms))
+	for room, members := range rooms {
+		info[room] = len(members)
+	}
+	s.roomIndexMutex.RUnlock()
+
+	return info
+}
+
+// PickStrategy is the strategy that picks a single member of a room, see `Server.RoomPickStrategy`.
+type PickStrategy uint8
+
+const (
+	// PickRoundRobin picks the members of a room in turn, in the order they joined the room.
+	PickRoundRobin PickStrategy = iota
+	// PickRandom picks a random member of a room.
+	PickRandom
+	// PickWeightedRoundRobin picks the members of a room in turn, in the order they joined the room,
+	// each one as many times in a row as its weight, see `Server.RoomMemberWeight`.
+	PickWeightedRoundRobin
+)
+
+// roomOrder is the pick order of the members of a room, see `Room.EmitToOne`.
+// Its members and weights are guarded by the server's roomIndexMutex.
+type roomOrder struct {
+	// the next pick, accessed atomically.
+	next uint64
+
+	members []*NSConn
+	weights []int
+	// the sum of the weights.
+	total int
+}
+
+func (o *roomOrder) add(ns *NSConn, weight int) {
+	o.members = append(o.members, ns)
+	o.weights = append(o.weights, weight)
+	o.total += weight
+}
+
+func (o *roomOrder) remove(ns *NSConn) {
+	for i, member := range o.members {
+		if member == ns {
+			o.total -= o.weights[i]
+			o.members = append(o.members[:i], o.members[i+1:]...)
+			o.weights = append(o.weights[:i], o.weights[i+1:]...)
+			return
+		}
+	}
+}
+
+// roomMemberWeight returns the weight of a new member of a room for the `PickWeightedRoundRobin`, at least 1.
+// It's called before the server's locks, the weight is fixed while the member stays in the room.
+func (s *Server) roomMemberWeight(ns *NSConn) int {
+	if s.RoomPickStrategy != PickWeightedRoundRobin || s.RoomMemberWeight == nil {
+		return 1
+	}
+
+	if weight := s.RoomMemberWeight(ns); weight > 0 {
+		return weight
+	}
+
+	return 1
+}
+
+// roomPickOrder returns a snapshot of the connections that are joined to the "room" of the "namespace",
+// starting from the one that the `RoomPickStrategy` picks, followed by the rest of them in turn,
+// so the next ones can be tried if the picked one fails.
+func (s *Server) roomPickOrder(namespace, room string) []*NSConn {
+	var stored []*NSConn
+	if s.RoomStore != nil {
+		if stored = s.storedRoomMembers(namespace, room); len(stored) == 0 {
+			return nil
+		}
+	}
+
+	var (
+		members []*NSConn
+		weights []int
+		total   int
+	)
+
+	s.roomIndexMutex.RLock()
+	order := s.roomOrders[roomKey{namespace, room}]
+	if order != nil {
+		members = append(members, order.members...)
+		weights = append(weights, order.weights...)
+		total = order.total
+	}
+	s.roomIndexMutex.RUnlock()
+
+	if stored != nil {
+		members, weights, total = storedPickOrder(stored, members, weights)
+	}
+
+	if len(members) == 0 {
+		return nil
+	}
+
+	var start int
+	switch s.RoomPickStrategy {
+	case PickRandom:
+		start = rand.Intn(len(members))
+	default:
+		var pick uint64
+		if order != nil {
+			pick = atomic.AddUint64(&order.next, 1) - 1
+		}
+		start = weightedIndex(weights, total, pick)
+	}
+
+	return append(members[start:], members[:start]...)
+}
+
+// storedPickOrder orders the "stored" members of a room, the ones that the `RoomStore` lists,
+// like the "members" of the server's room index and keeps their "weights",
+// the stored ones which are not indexed yet go last, with a weight of 1.
+func storedPickOrder(stored, members []*NSConn, weights []int) ([]*NSConn, []int, int) {
+	listed := make(map[*NSConn]struct{}, len(stored))
+	for _, ns := range stored {
+		listed[ns] = struct{}{}
+	}
+
+	var (
+		orderedMembers = make([]*NSConn, 0, len(stored))
+		orderedWeights = make([]int, 0, len(stored))
+		total          int
+	)
+
+	for i, ns := range members {
+		if _, ok := listed[ns]; ok {
+			delete(listed, ns)
+			orderedMembers = append(orderedMembers, ns)
+			orderedWeights = append(orderedWeights, weights[i])
+			total += weights[i]
+		}
+	}
+
+	for _, ns := range stored {
+		if _, ok := listed[ns]; ok {
+			orderedMembers = append(orderedMembers, ns)
+			orderedWeights = append(orderedWeights, 1)
+			total++
+		}
+	}
+
+	return orderedMembers, orderedWeights, total
+}
+
+// weightedIndex returns the index of the member of the "pick" turn,
+// each member takes as many turns in a row as its weight.
+func weightedIndex(weights []int, total int, pick uint64) int {
+	if total == len(weights) {
+		// all weights are 1.
+		return int(pick % uint64(total))
+	}
+
+	turn := int(pick % uint64(total))
+	for i, weight := range weights {
+		if turn < weight {
+			return i
+		}
+		turn -= weight
+	}
+
+	return 0
+}
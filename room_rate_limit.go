@@ -0,0 +1,106 @@
+package neffos
+
+import (
+	"time"
+)
+
+// tokenBucket allows up to "rate" events per second with bursts of up to "rate" events.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int, now time.Time) *tokenBucket {
+	return &tokenBucket{tokens: float64(rate), last: now}
+}
+
+// allow reports whether an event is allowed at "now" and takes its token.
+func (b *tokenBucket) allow(rate int, now time.Time) bool {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * float64(rate)
+		if max := float64(rate); b.tokens > max {
+			b.tokens = max
+		}
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// RoomRateLimit limits how fast a single connection can emit to the "room" of the "namespace",
+// to "perSec" messages per second with bursts of up to "perSec" messages,
+// so an individual flood does not spam a shared room while normal chat rates are allowed.
+// An empty "room" sets the default limit of all the rooms of the "namespace"
+// that they do not set their own. A zero or negative "perSec" removes the limit,
+// a room without its own limit falls back to its namespace's default one.
+//
+// The excess incoming room messages are dropped before their dispatch to the event callbacks
+// and the `OnRoomRateLimited` is fired, an `Ask` gets an `ErrRoomRateLimited` error as its reply.
+// Each connection keeps a token bucket per limited room it emits to, until it's closed.
+// The limits are scaled by the connection's `QoS.RoomRateFactor`, if any.
+// Defaults to no limit.
+func (s *Server) RoomRateLimit(namespace, room string, perSec int) {
+	key := roomKey{namespace, room}
+
+	s.roomRateLimitsMutex.Lock()
+	if s.roomRateLimits == nil {
+		s.roomRateLimits = make(map[roomKey]int)
+	}
+
+	if perSec > 0 {
+		s.roomRateLimits[key] = perSec
+	} else {
+		delete(s.roomRateLimits, key)
+	}
+	s.roomRateLimitsMutex.Unlock()
+}
+
+// roomRateLimit returns the emits per second limit of the "namespace" and "room", zero if not limited.
+func (s *Server) roomRateLimit(namespace, room string) int {
+	s.roomRateLimitsMutex.RLock()
+	defer s.roomRateLimitsMutex.RUnlock()
+
+	if len(s.roomRateLimits) == 0 {
+		return 0
+	}
+
+	if perSec, ok := s.roomRateLimits[roomKey{namespace, room}]; ok {
+		return perSec
+	}
+
+	return s.roomRateLimits[roomKey{namespace: namespace}]
+}
+
+// allowRoomEmit reports whether the incoming room message "msg"
+// of this server-side connection is inside its room's rate limit, see `Server.RoomRateLimit`.
+func (c *Conn) allowRoomEmit(msg Message) bool {
+	perSec := c.server.roomRateLimit(msg.Namespace, msg.Room)
+	if perSec <= 0 {
+		return true
+	}
+	perSec = c.scaleRoomRate(perSec)
+
+	now := c.clock().Now()
+	key := roomKey{msg.Namespace, msg.Room}
+
+	c.roomBucketsMutex.Lock()
+	if c.roomBuckets == nil {
+		c.roomBuckets = make(map[roomKey]*tokenBucket)
+	}
+
+	b, ok := c.roomBuckets[key]
+	if !ok {
+		b = newTokenBucket(perSec, now)
+		c.roomBuckets[key] = b
+	}
+
+	allowed := b.allow(perSec, now)
+	c.roomBucketsMutex.Unlock()
+
+	return allowed
+}
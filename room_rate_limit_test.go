@@ -0,0 +1,42 @@
+package neffos
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(2, now)
+
+	// burst.
+	if !b.allow(2, now) || !b.allow(2, now) {
+		t.Fatalf("expected the first two events to be allowed")
+	}
+
+	if b.allow(2, now) {
+		t.Fatalf("expected the third event to be limited")
+	}
+
+	// refills one token per half second.
+	now = now.Add(500 * time.Millisecond)
+	if !b.allow(2, now) {
+		t.Fatalf("expected an event to be allowed after the refill")
+	}
+
+	if b.allow(2, now) {
+		t.Fatalf("expected the refilled token to be taken")
+	}
+
+	// never more than the burst.
+	now = now.Add(time.Minute)
+	for i := 0; i < 2; i++ {
+		if !b.allow(2, now) {
+			t.Fatalf("[%d] expected the event to be allowed", i)
+		}
+	}
+
+	if b.allow(2, now) {
+		t.Fatalf("expected the tokens to be capped to the rate")
+	}
+}
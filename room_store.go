@@ -0,0 +1,95 @@
+package neffos
+
+import "sort"
+
+// RoomStore keeps the members of the rooms, by their connection IDs, outside of the server,
+// i.e in a Redis set per room, so the room membership can be shared by the servers of a cluster
+// and it can scale beyond the memory of a single server. See `Server.RoomStore`.
+//
+// The methods may be called concurrently, by the join and the leave of the connections
+// and by the `Room.Members`, `Room.EmitN`, `Room.EmitToOne` and `Room.EmitQuorum` calls.
+type RoomStore interface {
+	// AddMember adds the "connID" connection to the members of the "room" of the "namespace".
+	AddMember(namespace, room, connID string) error
+	// RemoveMember removes the "connID" connection from the members of the "room" of the "namespace".
+	RemoveMember(namespace, room, connID string) error
+	// Members returns the connection IDs of the members of the "room" of the "namespace".
+	Members(namespace, room string) ([]string, error)
+}
+
+// storeRoomMember adds the server-side "ns" connection to the members of the "room" of the `RoomStore`, if any.
+func (s *Server) storeRoomMember(ns *NSConn, room string) {
+	if s.RoomStore == nil {
+		return
+	}
+
+	if err := s.RoomStore.AddMember(ns.namespace, room, ns.Conn.ID()); err != nil {
+		s.logger(ns.Conn, ns.namespace).Errorf("room store: add member to room %s: %v", room, err)
+	}
+}
+
+// unstoreRoomMember removes the server-side "ns" connection from the members of the "room" of the `RoomStore`, if any.
+func (s *Server) unstoreRoomMember(ns *NSConn, room string) {
+	if s.RoomStore == nil {
+		return
+	}
+
+	if err := s.RoomStore.RemoveMember(ns.namespace, room, ns.Conn.ID()); err != nil {
+		s.logger(ns.Conn, ns.namespace).Errorf("room store: remove member from room %s: %v", room, err)
+	}
+}
+
+// RoomMembers returns the connection IDs of the members of the "room" of the "namespace".
+// When the server uses a `RoomStore` they are read from the store, so they may include
+// the connections of other servers, otherwise they are the connections of this server, sorted.
+func (s *Server) RoomMembers(namespace, room string) ([]string, error) {
+	if s.RoomStore != nil {
+		return s.RoomStore.Members(namespace, room)
+	}
+
+	members := s.indexedRoomMembers(namespace, room)
+	ids := make([]string, 0, len(members))
+	for _, ns := range members {
+		ids = append(ids, ns.Conn.ID())
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+// Members returns the connection IDs of the members of this room, see `Server.RoomMembers`.
+// On the client-side the only member is the client's connection itself.
+func (r *Room) Members() ([]string, error) {
+	c := r.NSConn.Conn
+	if c.IsClient() {
+		return []string{c.ID()}, nil
+	}
+
+	return c.server.RoomMembers(r.NSConn.namespace, r.Name)
+}
+
+// storedRoomMembers returns the connections of this server that the `RoomStore` lists as members of the "room",
+// the ones of other servers are skipped. It falls back to the server's room index if the store fails.
+func (s *Server) storedRoomMembers(namespace, room string) []*NSConn {
+	ids, err := s.RoomStore.Members(namespace, room)
+	if err != nil {
+		return s.indexedRoomMembers(namespace, room)
+	}
+
+	members := make([]*NSConn, 0, len(ids))
+	s.mu.RLock()
+	for _, id := range ids {
+		c, ok := s.connectionsByID[id]
+		if !ok {
+			continue
+		}
+
+		// the store may be stale, the connection should still be joined to the room.
+		if ns := c.Namespace(namespace); ns != nil && ns.Room(room) != nil {
+			members = append(members, ns)
+		}
+	}
+	s.mu.RUnlock()
+
+	return members
+}
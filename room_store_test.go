@@ -0,0 +1,114 @@
+package neffos
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type testRoomStore struct {
+	mu      sync.Mutex
+	members map[string][]string
+	err     error
+}
+
+func (s *testRoomStore) AddMember(namespace, room, connID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := namespace + ":" + room
+	s.members[key] = append(s.members[key], connID)
+	return nil
+}
+
+func (s *testRoomStore) RemoveMember(namespace, room, connID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := namespace + ":" + room
+	for i, id := range s.members[key] {
+		if id == connID {
+			s.members[key] = append(s.members[key][:i], s.members[key][i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (s *testRoomStore) Members(namespace, room string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	return append([]string(nil), s.members[namespace+":"+room]...), nil
+}
+
+func TestServerRoomStore(t *testing.T) {
+	namespaces := Namespaces{"default": Events{}}
+	s := New(nil, namespaces)
+	defer s.Close()
+
+	store := &testRoomStore{members: make(map[string][]string)}
+	s.RoomStore = store
+
+	var rooms []*Room
+	for _, id := range []string{"a", "b"} {
+		c := newConn(newPipeSocket(), namespaces, nil)
+		c.server = s
+		c.id = id
+		c.acknowledge()
+		s.mu.Lock()
+		s.connectionsByID[id] = c
+		s.mu.Unlock()
+
+		ns := newNSConn(c, "default", namespaces["default"])
+		c.connectedNamespaces["default"] = ns
+		room := newRoom(ns, "room")
+		ns.roomsMutex.Lock()
+		ns.setRoom(room)
+		ns.roomsMutex.Unlock()
+		// the store is not called while the locks are held.
+		if members, _ := store.Members("default", "room"); len(members) != len(rooms) {
+			t.Fatalf("expected the member to be stored after the locks are released but got: %v", members)
+		}
+		ns.flushRoomChanges()
+		rooms = append(rooms, room)
+	}
+
+	// a member of another server.
+	store.AddMember("default", "room", "remote")
+
+	expectMembers := func(expected ...string) {
+		t.Helper()
+		got, err := rooms[0].Members()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expected, got) {
+			t.Fatalf("expected members: %v but got: %v", expected, got)
+		}
+	}
+
+	expectMembers("a", "b", "remote")
+	if expected, got := 2, rooms[0].EmitN("chat", nil); expected != got {
+		t.Fatalf("expected the message to be written to the %d members of this server but got %d", expected, got)
+	}
+
+	rooms[1].NSConn.roomsMutex.Lock()
+	rooms[1].NSConn.deleteRoom("room")
+	rooms[1].NSConn.roomsMutex.Unlock()
+	rooms[1].NSConn.flushRoomChanges()
+	expectMembers("a", "remote")
+	if expected, got := 1, rooms[0].EmitN("chat", nil); expected != got {
+		t.Fatalf("expected the message to be written to %d member but got %d", expected, got)
+	}
+
+	// falls back to the room index.
+	store.err = errors.New("store is down")
+	if expected, got := 1, rooms[0].EmitN("chat", nil); expected != got {
+		t.Fatalf("expected the message to be written to %d member but got %d", expected, got)
+	}
+	if _, err := rooms[0].Members(); err != store.err {
+		t.Fatalf("expected error: %v but got: %v", store.err, err)
+	}
+}
@@ -1,7 +1,9 @@
 package neffos
 
 import (
+	"bytes"
 	"context"
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	"net/http"
@@ -15,7 +17,16 @@ import (
 )
 
 // Upgrader is the definition type of a protocol upgrader, gorilla or gobwas or custom.
-// It is the first parameter of the `New` function which constructs a neffos server.
+// It is the first parameter of the `New` function which constructs a neffos server
+// and it can be changed later on through the `Server.Upgrader` field, no build tags are involved.
+//
+// Built-in upgraders are the `gorilla.GorillaUpgrader()` (or `gorilla.Upgrader(...)`)
+// and the `gobwas.GobwasUpgrader()` (or `gobwas.Upgrader(...)`) of the neffos subpackages,
+// they live there so the neffos package does not depend on any websocket implementation.
+// The gorilla one is the most feature-complete (i.e supports per-message compression)
+// and it's a safe default for most applications,
+// the gobwas one has a lower memory footprint per connection
+// and it is preferred for servers with a huge number of connections.
 type Upgrader func(w http.ResponseWriter, r *http.Request) (Socket, error)
 
 // IDGenerator is the type of function that it is used
@@ -43,26 +54,91 @@ var DefaultIDGenerator IDGenerator = func(http.ResponseWriter, *http.Request) st
 // and `Broadcast` and `Close`.
 // Use the `New` function to create a new server, server starts automatically, no further action is required.
 type Server struct {
+	// The counters are accessed atomically, they are kept first
+	// so they are 64-bit aligned on 32-bit platforms too.
+	count uint64
+	// the number of the event callbacks that are currently running.
+	inflight int64
+	// the outgoing messages dropped because of their `Message.ExpiresAt`.
+	droppedExpired uint64
+
 	uuid string
 
-	upgrader      Upgrader
+	// Upgrader is the protocol upgrader of the incoming connections, see `New`.
+	// It should not be changed after the server started to serve.
+	Upgrader      Upgrader
 	IDGenerator   IDGenerator
 	StackExchange StackExchange
+	// PublicIDFunc can be optionally registered to send a different, public-facing, ID to the clients
+	// than the internal one that the `IDGenerator` generated, i.e an opaque token instead of a sequential ID.
+	// It's called once per connection, on its acknowledgement, and the client's `ID` is the returned value,
+	// while the server-side connection's `ID`, and so the server's lookups by ID (i.e the `Message.To`), keep the internal one.
+	PublicIDFunc func(internalID string) string
+	// Acknowledger can be optionally set to customize the server-side handshake,
+	// the default handshake of the neffos clients is used when it's nil.
+	// It should not be changed after the server started to serve.
+	Acknowledger Acknowledger
 
 	mu         sync.RWMutex
 	namespaces Namespaces
+	// guards the "namespaces" against the `On` calls, shared by the server's connections.
+	eventsMutex sync.RWMutex
 
 	// connection read/write timeouts.
 	readTimeout  time.Duration
 	writeTimeout time.Duration
 
-	count uint64
+	// if > 0 then incoming messages with the same idempotency key are dropped, see `Dedupe`.
+	dedupeWindow time.Duration
+
+	// the rooms' recently broadcasted messages, see `RoomHistory`.
+	roomHistorySizes map[roomKey]int
+	roomHistories    map[roomKey]*roomHistory
+	roomHistoryMutex sync.RWMutex
+
+	// the per-connection emit limits of rooms, see `RoomRateLimit`.
+	roomRateLimits      map[roomKey]int
+	roomRateLimitsMutex sync.RWMutex
+
+	// the server-side connections joined to each room, by namespace and room name, see `RoomsInfo`.
+	roomIndex      map[string]map[string]map[*NSConn]struct{}
+	roomIndexMutex sync.RWMutex
+	// the pick order of each room, guarded by the roomIndexMutex, see `Room.EmitToOne`.
+	roomOrders map[roomKey]*roomOrder
+
+	// the server-side connections connected to each namespace and their limits, see `MaxConnectionsPerNamespace`.
+	namespaceConns      map[string]int
+	namespaceLimits     map[string]int
+	namespaceConnsMutex sync.Mutex
+
+	// the per-namespace overrides of the `NamespaceIdleTimeout`, see `SetNamespaceIdleTimeout`.
+	namespaceIdleTimeouts      map[string]time.Duration
+	namespaceIdleTimeoutsMutex sync.RWMutex
+
+	// non-nil when the per-connection structures are reused, see `Prewarm`.
+	connMapsPool *sync.Pool
+
+	// the single active connection per key-value pair, see `ReplaceExisting`.
+	sessions      map[sessionKey]*Conn
+	sessionsMutex sync.Mutex
+	// the sessions of the closed connections by their resume tokens, see `SessionResumeTimeout`.
+	detachedSessions      map[string]*detachedSession
+	detachedSessionsMutex sync.Mutex
 
 	connections map[*Conn]struct{}
-	connect     chan *Conn
-	disconnect  chan *Conn
-	actions     chan action
+	// the connections by their IDs, guarded by the mu, see `EmitToMany`.
+	connectionsByID map[string]*Conn
+	connect         chan *Conn
+	disconnect      chan *Conn
+	actions         chan action
+	// closed by `Close` to stop the `start` loop, once its connections are gone.
+	shutdown chan struct{}
+	// closed when the `start` loop returns, nothing consumes the above channels after that.
+	stopped     chan struct{}
 	broadcaster *broadcaster
+	// the peers that the broadcasts are forwarded to, see `UseBridge`.
+	bridges      []Bridge
+	bridgesMutex sync.RWMutex
 	// messages that this server must waits
 	// for a reply from one of its own connections(see `waitMessage`)
 	// or TODO: from cloud (see `StackExchange.PublishAndWait`).
@@ -71,6 +147,9 @@ type Server struct {
 
 	closed uint32
 
+	// if > 0 then new events are rejected, see `Drain`.
+	draining uint32
+
 	// OnUpgradeError can be optionally registered to catch upgrade errors.
 	OnUpgradeError func(err error)
 	// OnConnect can be optionally registered to be notified for any new neffos client connection,
@@ -80,9 +159,284 @@ type Server struct {
 	OnConnect func(c *Conn) error
 	// OnDisconnect can be optionally registered to notify about a connection's disconnect.
 	// Don't confuse it with the `OnNamespaceDisconnect`, this callback is for the entire client side connection.
+	// It's fired once per connection, regardless of its namespaces, after the connection is fully closed:
+	// after the `OnNamespaceDisconnect` events of all of its namespaces and the return of its `Conn.Go` goroutines,
+	// so the callback can read its final state, i.e its `Info`, for global cleanups and metrics.
+	// It's not fired for connections that were rejected by the `OnConnect`.
 	OnDisconnect func(c *Conn)
+	// OnMessage can be optionally registered to read or modify any incoming message
+	// of a server-side connection before its dispatch to the event callbacks,
+	// i.e to extract a trace context from the `Message.Headers`.
+	OnMessage func(c *Conn, msg *Message)
+	// OnWriteMessage can be optionally registered to read or modify any outgoing message
+	// of a server-side connection right before its serialization,
+	// i.e to inject a trace context to the `Message.Headers`.
+	// Note that on broadcasting this is called once per receiver connection.
+	OnWriteMessage func(c *Conn, msg *Message)
+	// OnRawMessage can be optionally registered to receive the raw bytes of any incoming message
+	// of a server-side connection before its deserialization, i.e for protocol bridging,
+	// custom framing or passthrough proxying.
+	// If it returns false then the message is consumed and it's not dispatched to the `OnMessage` and the event callbacks.
+	// The acknowledgement's handshake messages are handled before, they never reach it.
+	// Note that it adds a callback call to every incoming message.
+	OnRawMessage func(c *Conn, b []byte) bool
+	// MessageFilter can be optionally registered to enforce a global policy on the incoming event messages
+	// of the server-side connections, i.e to block the events of certain namespaces for unauthenticated connections.
+	// It's called right before their dispatch to the event callbacks, after the protocol messages
+	// (i.e the namespace connect and the room join ones) are handled.
+	// If it returns false then the message is dropped, only an `Ask` gets an `ErrMessageFiltered` error as its reply.
+	MessageFilter func(c *Conn, msg Message) bool
+	// NotifyMuted, if true, answers each incoming event message of a muted connection with an `ErrMuted` error,
+	// which the client receives through its event's callback, or its `OnError` event, so it knows that it's muted.
+	// Otherwise only an `Ask` gets an answer. See `Conn.Mute`.
+	// Defaults to false.
+	NotifyMuted bool
+	// OnInvalidMessage can be optionally registered to receive the raw bytes of each incoming message
+	// of a server-side connection which can not be deserialized, i.e to log misbehaving or version-mismatched clients.
+	// Invalid messages are dropped either way.
+	OnInvalidMessage func(c *Conn, raw []byte)
+	// MaxInvalidMessages, if > 0, closes a server-side connection, as likely incompatible,
+	// with an `ErrInvalidPayload` error when it sends more than this number of invalid messages
+	// inside the `InvalidMessagesWindow`.
+	// Defaults to 0, invalid messages never close the connection.
+	MaxInvalidMessages int
+	// InvalidMessagesWindow is the duration that the `MaxInvalidMessages` are counted in,
+	// the count starts over with the first invalid message after the window.
+	// Defaults to 0, the count never starts over.
+	InvalidMessagesWindow time.Duration
+
+	// StrictNamespaces, if true, remote connect requests to namespaces registered with nil `Events`
+	// fail with an `ErrBadNamespace` error instead of silently ignoring their messages.
+	// Use it along with the `Validate` method to check the registered namespaces on server start.
+	// Defaults to false.
+	StrictNamespaces bool
+
+	// MaxPendingAsks is the maximum number of replies that a single server-side connection
+	// can wait for at the same time, protects the server's memory from misbehaving peers
+	// that do not reply to the `Ask` calls.
+	// When exceeded, new `Ask` calls fail immediately with an `ErrTooManyPendingAsks` error.
+	// A connection's `QoS.MaxPendingAsks` overrides it.
+	// Defaults to 0, unlimited.
+	MaxPendingAsks int
+
+	// MaxInflightWrites is the maximum number of the outgoing messages of a single server-side connection
+	// which are written but not flushed to its socket yet, i.e blocked behind a slow consumer
+	// or queued until the connection's acknowledgement, it bounds the memory that a slow connection holds.
+	// When reached, the next messages are dropped: their `Write` (and `Emit`) calls report false,
+	// their `WriteResult` receives an `ErrWriteQueueFull` error and the `OnWriteQueueFull` is fired.
+	// Replies and system messages are never dropped, they do count to the limit though.
+	// See `Conn.InflightWrites` too.
+	// Defaults to 0, unlimited.
+	MaxInflightWrites int
+
+	// Clock can be optionally set to replace the source of the current time and the timers
+	// of the server's connections, i.e a fake clock on tests. See `Clock`.
+	// It should not be changed after the server started to serve.
+	// Defaults to the `RealClock`.
+	Clock Clock
+
+	// QoSClasses can be optionally set to register the quality of service classes by their names,
+	// a connection is tagged with a class through its `Conn.SetQoS` method, i.e on the `OnConnect`,
+	// and gets the limits of its class instead of the server's defaults.
+	// See `QoS` and `DropLowestQoS` too.
+	// It should not be changed after the server started to serve.
+	QoSClasses map[string]QoS
+
+	// ReconnectStagger is the duration that the reconnects of the clients
+	// are spread over on a `RequestReconnect`.
+	// Defaults to the `DefaultReconnectStagger`, 5 seconds.
+	ReconnectStagger time.Duration
+
+	// StrictOrdering, if true, stamps each message that a connection writes after its acknowledgement,
+	// i.e the emits and the broadcasts, with a per-connection sequence number and verifies the sequence numbers
+	// of its incoming messages: a message which arrives after a gap or out of order is a protocol violation
+	// and its connection is closed with an `ErrOutOfOrder` error.
+	// The broadcasts are serialized for each one of their connections instead of once for all of them.
+	// The messages written in order from one connection are guaranteed to be handled in order by its peer anyway,
+	// this makes that guarantee explicit and enforced, i.e against concurrent writers.
+	// The sequence numbers are sent through an internal header and the messages without one are not verified,
+	// so the client should enable it as well, see `Client.StrictOrdering`.
+	// Defaults to false.
+	StrictOrdering bool
+
+	// MaxRoomsPerConnection is the maximum number of rooms that a single connection
+	// can join inside each of its connected namespaces, protects the server's memory
+	// from clients that join a huge number of rooms.
+	// When exceeded, remote room join requests fail with an `ErrTooManyRooms` error
+	// before the `OnRoomJoin` event is fired. Server-side joins are not limited.
+	// Defaults to 0, unlimited.
+	MaxRoomsPerConnection int
+
+	// RoomPickStrategy is the strategy that the `Room.EmitToOne` uses
+	// to pick the single member of a room which receives the message, i.e a worker of a room of workers.
+	// It should be set before serve.
+	// Defaults to `PickRoundRobin`.
+	RoomPickStrategy PickStrategy
+	// RoomMemberWeight can be optionally registered to give the members of the rooms a weight for the `PickWeightedRoundRobin`,
+	// i.e a worker with twice the capacity of the others should have a weight of 2.
+	// It's called once on each room join, a zero or negative weight means 1.
+	// Defaults to nil, all members have a weight of 1.
+	RoomMemberWeight func(ns *NSConn) int
+
+	// RoomStore can be optionally set to keep the room membership outside of the server, i.e in Redis,
+	// so the members of a room can be shared by the servers of a cluster, it complements the `StackExchange`
+	// which delivers the room messages across them. The `RoomMembers` and the room emits
+	// (`Room.EmitN`, `Room.EmitToOne` and `Room.EmitQuorum`) consult the store, the latter ones write to
+	// the listed connections of this server only. The joins and the leaves of the connections update it,
+	// its failures are logged through the `Logger` and the room emits fall back to the server's room index.
+	//
+	// Consistency: the store is updated after the local join and leave, outside of the connection's locks,
+	// so it may briefly lag behind them,
+	// and the members of a server that crashed are not removed from it, unless the store expires them.
+	// The server verifies that the listed connections of its own are still joined before it writes to them.
+	// It should not be changed after the server started to serve.
+	// Defaults to nil, the room membership is kept in the server's in-memory room index.
+	RoomStore RoomStore
+
+	// NamespaceIdleTimeout is the duration that a connected namespace of a connection may receive no messages
+	// before the server disconnects it, firing its `OnNamespaceDisconnect` event on both sides,
+	// while the connection itself is kept alive, so namespaces that were used briefly do not hold
+	// their resources (rooms, namespace limits) for the connection's lifetime.
+	// The per-namespace `SetNamespaceIdleTimeout` overrides it.
+	// It applies to the connections that are accepted after it's set.
+	// Defaults to 0, disabled.
+	NamespaceIdleTimeout time.Duration
+
+	// SessionResumeTimeout, if positive, enables the resumption of the sessions of the closed connections:
+	// each connection receives a resume token right after its acknowledgement (see `Client.ResumeToken`)
+	// and, when it's lost or closed by the client, the server keeps its session, the values of its `Conn.Set`
+	// and its namespaces and rooms, for that duration. A new connection which presents the token through its `Client.Resume`
+	// gets the values back and it's re-connected to the same namespaces and re-joined to the same rooms,
+	// so a reconnection after a network failure preserves the application's state.
+	// The connections that the server closes on purpose, i.e through `Conn.Close`, `DisconnectWhere` or `Close`,
+	// cannot be resumed.
+	//
+	// Memory cost: the sessions of all the connections that were closed during the last timeout are kept,
+	// the values are referenced and not copied, so a session keeps its values alive until it expires or it's resumed.
+	// Keep it short, i.e a few seconds up to a minute, on servers with a high rate of disconnects.
+	// Defaults to 0, disabled.
+	SessionResumeTimeout time.Duration
+
+	// Subprotocols is an optional list of the server's supported websocket subprotocols, in order of preference.
+	// The first of the client's requested subprotocols (through its dialer's options)
+	// that it is listed here is selected and sent back through the "Sec-WebSocket-Protocol" response header.
+	// The negotiated subprotocol is available through the `Conn.Subprotocol` method.
+	Subprotocols []string
+
+	// CompressionThreshold is the minimum size, in bytes, of an outgoing message to be compressed
+	// when the per-message compression extension is negotiated, i.e by the gorilla upgrader's `EnableCompression`.
+	// Smaller messages are sent uncompressed, compressing them costs CPU and may even grow them.
+	// Receivers handle mixed compressed and uncompressed messages (per-message RSV1 bit).
+	// Zero or negative value compresses all messages.
+	// It has effect only when the upgrader's `Socket` completes the `SocketCompressionThresholder` interface.
+	// Defaults to `DefaultCompressionThreshold`.
+	CompressionThreshold int
+
+	// MaxFragments, if positive, is the maximum number of the frames of a fragmented incoming message,
+	// and MaxMessageSize, if positive, is the maximum total size, in bytes, of an incoming message.
+	// A message that exceeds them, i.e an endless stream of tiny continuation frames without a final one,
+	// closes its connection with an `ErrFragmentLimit` error, before it's reassembled,
+	// so the server's memory is protected from fragmentation-based attacks.
+	// They have effect only when the upgrader's `Socket` completes the `SocketFragmentLimiter` interface,
+	// like the built-in gorilla and gobwas ones.
+	// Default to 0, unlimited.
+	MaxFragments   int
+	MaxMessageSize int64
+
+	// TrustedSecret can be optionally set to auto-trust connections
+	// which send this exact value through the `TrustedSecretHeaderKey` request header,
+	// i.e internal services of a service mesh.
+	// Trusted connections skip the `OnNamespaceConnect` event on remote namespace connect requests.
+	// Defaults to empty, no connection is trusted.
+	TrustedSecret string
+	// OnTrustedConnect can be optionally registered to be notified, i.e for auditing,
+	// when a connection is trusted because of a valid `TrustedSecret`.
+	// It's fired before the `OnConnect`.
+	OnTrustedConnect func(c *Conn)
+
+	// DefaultEvents can be optionally set to register common event callbacks, i.e an error logger,
+	// once for all the registered namespaces.
+	// A default event callback is fired only when the namespace does not register its own callback for that event,
+	// a namespace's `OnAnyEvent` is tried after the default callback of the specific event.
+	// It should not be changed after the server started to serve.
+	DefaultEvents Events
+
+	// SlowHandlerThreshold, if positive, enables the measurement of the server-side event callbacks' execution time.
+	// Callbacks that take at least that long to return are reported to the `OnSlowHandler`,
+	// as they block the connection's read loop.
+	// Defaults to 0, disabled.
+	SlowHandlerThreshold time.Duration
+	// OnSlowHandler is fired after an event callback which took longer than the `SlowHandlerThreshold`,
+	// the "c" connection's `ID` can be used to correlate it with other logs.
+	OnSlowHandler func(c *Conn, namespace, event string, d time.Duration)
+
+	// CollectEventMetrics, if true, enables the collection of the server-side event callbacks' metrics,
+	// the number of the handled messages, of the returned errors and the average execution time,
+	// per namespace and event. See `EventMetrics`.
+	// Defaults to false, disabled.
+	CollectEventMetrics bool
+	eventMetrics        eventMetrics
+
+	// HandlerDeadline, if positive, enables a watchdog for the server-side event callbacks:
+	// a callback which does not return in that time, i.e a deadlocked one that blocks the connection's read loop forever,
+	// is reported to the `OnHandlerDeadline` with its goroutine's stack trace, or logged as a warning through the `Logger` if it's nil,
+	// and its connection is closed if the `CloseOnHandlerDeadline` is true.
+	// Unlike the `SlowHandlerThreshold` it's reported while the callback is still running.
+	// Note that a callback cannot be stopped, it keeps running until it returns.
+	// Defaults to 0, disabled.
+	HandlerDeadline time.Duration
+	// OnHandlerDeadline is fired when an event callback exceeded the `HandlerDeadline`,
+	// the "stack" is the stack trace of the callback's goroutine.
+	OnHandlerDeadline func(c *Conn, namespace, event string, stack []byte)
+	// CloseOnHandlerDeadline, if true, closes the connection of an event callback
+	// which exceeded the `HandlerDeadline`.
+	CloseOnHandlerDeadline bool
+
+	// ErrorTransformer can be optionally registered to modify the errors returned by the event callbacks
+	// before they are sent to the remote side, i.e to log the full error
+	// and send a sanitized one which does not expose internal details.
+	// It is not called for the `Reply` results. A nil result keeps the original error.
+	ErrorTransformer func(err error) error
+
+	// OnAskComplete can be optionally registered to observe the latency of the server-side connections' `Ask` calls,
+	// from the write of the message to its reply, i.e to feed a histogram.
+	// Only the successful asks, with a nil "err", and the timed-out ones,
+	// with a `context.DeadlineExceeded` "err", are reported. The asks that the remote side replied
+	// with an error, that were canceled by the caller, that failed to be written
+	// or were aborted by the connection's close are not reported.
+	OnAskComplete func(event string, d time.Duration, err error)
+
+	// OnSessionReplaced can be optionally registered to be notified when the "old" connection
+	// is replaced by the "new" one through the `ReplaceExisting` method,
+	// it's fired before the "old" connection is closed, i.e to send it a final message.
+	OnSessionReplaced func(old, new *Conn)
+
+	// OnRoomRateLimited can be optionally registered to be notified when an incoming room message
+	// of the "c" connection is dropped because it exceeded its room's rate limit, see `RoomRateLimit`.
+	OnRoomRateLimited func(c *Conn, namespace, room, event string)
+
+	// Logger is the structured logger of the messages that the server logs for its connections,
+	// see `Logger` and `NewLogger`.
+	// Defaults to a `NewLogger(nil)`, which prints through the standard log package's logger.
+	Logger Logger
+
+	// OnPreAckOverflow can be optionally registered to be notified when the "c" connection
+	// sent more than `MaxPreAckMessages` messages before its acknowledgement,
+	// "dropped" is the number of its messages that were dropped. The connection is closed right after.
+	OnPreAckOverflow func(c *Conn, dropped int)
+
+	// OnWriteQueueFull can be optionally registered to be notified when the "msg" to the "c" connection
+	// is dropped because the connection reached the `MaxInflightWrites`, i.e to close a slow consumer.
+	// It's fired by the goroutine which writes the message.
+	OnWriteQueueFull func(c *Conn, msg Message)
 }
 
+// DefaultCompressionThreshold is the default `Server.CompressionThreshold`.
+// Messages under a hundred bytes usually grow when compressed
+// and the compression throughput is poor for messages smaller than a kilobyte,
+// see the `BenchmarkCompression` of the gorilla subpackage.
+const DefaultCompressionThreshold = 1024
+
 // New constructs and returns a new neffos server.
 // Listens to incoming connections automatically, no further action is required from the caller.
 // The second parameter is the "connHandler", it can be
@@ -95,17 +449,22 @@ func New(upgrader Upgrader, connHandler ConnHandler) *Server {
 	namespaces := connHandler.GetNamespaces()
 	s := &Server{
 		uuid:            uuid.Must(uuid.NewV4()).String(),
-		upgrader:        upgrader,
+		Upgrader:        upgrader,
 		namespaces:      namespaces,
 		readTimeout:     readTimeout,
 		writeTimeout:    writeTimeout,
 		connections:     make(map[*Conn]struct{}),
+		connectionsByID: make(map[string]*Conn),
 		connect:         make(chan *Conn, 1),
 		disconnect:      make(chan *Conn),
 		actions:         make(chan action),
+		shutdown:        make(chan struct{}),
+		stopped:         make(chan struct{}),
 		broadcaster:     newBroadcaster(),
 		waitingMessages: make(map[string]chan Message),
 		IDGenerator:     DefaultIDGenerator,
+
+		CompressionThreshold: DefaultCompressionThreshold,
 	}
 
 	//	s.broadcastCond = sync.NewCond(&s.broadcastMu)
@@ -135,6 +494,53 @@ func (s *Server) UseStackExchange(exc StackExchange) error {
 	return nil
 }
 
+// Dedupe enables deduplication of incoming messages based on their `IdempotencyKeyHeader` header value.
+// Each connection keeps the recently-seen keys for the "window" duration
+// and drops any duplicated message before its dispatch,
+// i.e when a client retries to send a message after a perceived timeout.
+// Messages without that header are not affected.
+// Each connection keeps up to `DedupeMaxKeys` keys.
+//
+// It should be called before serve, it affects only new connections.
+func (s *Server) Dedupe(window time.Duration) {
+	s.dedupeWindow = window
+}
+
+// On registers the "handler" to the "event" of the "namespace" of the server's namespaces,
+// the namespace is created if missing. Unlike the `Namespaces.On`, it's safe to call
+// while the server is running, i.e to register the events of a plugin at runtime.
+// The "handler" applies to the messages that arrive after the registration.
+// Note that connections which were already connected to a namespace which
+// was registered with nil `Events` keep their original events.
+func (s *Server) On(namespace, event string, handler MessageHandlerFunc) {
+	s.eventsMutex.Lock()
+	s.namespaces.On(namespace, event, handler)
+	s.eventsMutex.Unlock()
+}
+
+// Validate reports an error if a namespace
+// or an event is registered with a nil value, see `StrictNamespaces` too.
+//
+// It should be called after the server's configuration and before serve.
+func (s *Server) Validate() error {
+	s.eventsMutex.RLock()
+	defer s.eventsMutex.RUnlock()
+
+	for namespace, events := range s.namespaces {
+		if events == nil {
+			return fmt.Errorf("namespace %q: %v: nil events", namespace, ErrBadNamespace)
+		}
+
+		for event, cb := range events {
+			if cb == nil {
+				return fmt.Errorf("namespace %q: event %q: nil callback", namespace, event)
+			}
+		}
+	}
+
+	return nil
+}
+
 // usesStackExchange reports whether this server
 // uses one or more `StackExchange`s.
 func (s *Server) usesStackExchange() bool {
@@ -143,16 +549,47 @@ func (s *Server) usesStackExchange() bool {
 
 func (s *Server) start() {
 	atomic.StoreUint32(&s.closed, 0)
+	defer close(s.stopped)
 
+	shutdown := s.shutdown
 	for {
+		if shutdown == nil && len(s.connections) == 0 {
+			// closed and all the disconnects of its connections are handled.
+			return
+		}
+
 		select {
+		case <-shutdown:
+			shutdown = nil
+			// upgraded before the close but handled after its snapshot.
+			for c := range s.connections {
+				if !c.IsClosed() {
+					go c.Close()
+				}
+			}
 		case c := <-s.connect:
+			if shutdown == nil {
+				// upgraded while closing.
+				go c.Close()
+			}
+
+			s.mu.Lock()
 			s.connections[c] = struct{}{}
+			s.connectionsByID[c.ID()] = c
+			s.mu.Unlock()
 			atomic.AddUint64(&s.count, 1)
 		case c := <-s.disconnect:
+			s.releaseSessions(c)
+
 			if _, ok := s.connections[c]; ok {
 				// close(c.out)
+				// locked for the readers outside of this goroutine, i.e `GetConnections`.
+				s.mu.Lock()
 				delete(s.connections, c)
+				if s.connectionsByID[c.ID()] == c {
+					delete(s.connectionsByID, c.ID())
+				}
+				s.mu.Unlock()
 				atomic.AddUint64(&s.count, ^uint64(0))
 				// println("disconnect...")
 				if s.OnDisconnect != nil {
@@ -180,14 +617,69 @@ func (s *Server) start() {
 }
 
 // Close terminates the server and all of its connections, client connections are getting notified.
+// The connections are closed concurrently, so the wait for their `Conn.Go` goroutines
+// is bounded by a single `GoCloseTimeout` instead of one per connection.
+// The server stops its internal processing once the disconnects of its connections are handled,
+// the `Do` calls are no-op after that.
 func (s *Server) Close() {
 	if atomic.CompareAndSwapUint32(&s.closed, 0, 1) {
-		s.Do(func(c *Conn) {
-			c.Close()
-		}, false)
+		s.mu.RLock()
+		conns := make([]*Conn, 0, len(s.connections))
+		for c := range s.connections {
+			conns = append(conns, c)
+		}
+		s.mu.RUnlock()
+
+		var wg sync.WaitGroup
+		wg.Add(len(conns))
+		for _, c := range conns {
+			go func(c *Conn) {
+				c.Close()
+				wg.Done()
+			}(c)
+		}
+		wg.Wait()
+
+		close(s.shutdown)
 	}
 }
 
+// Drain puts the server in drain mode: connections remain open but new incoming events
+// and namespace connect requests are rejected with an `ErrServerDraining` error,
+// so clients can finish their current operations and reconnect to another server instance.
+// It blocks until all the in-flight event callbacks and server's `Ask` calls are completed
+// or the "ctx" is done, afterwards the caller can `Close` the server.
+//
+// Useful for rolling deployments.
+func (s *Server) Drain(ctx context.Context) error {
+	if ctx == nil {
+		ctx = context.TODO()
+	}
+
+	atomic.StoreUint32(&s.draining, 1)
+
+	for {
+		s.waitingMessagesMutex.RLock()
+		pendingAsks := len(s.waitingMessages)
+		s.waitingMessagesMutex.RUnlock()
+
+		if pendingAsks == 0 && atomic.LoadInt64(&s.inflight) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.clock().After(syncWaitDur):
+		}
+	}
+}
+
+// IsDraining reports whether the server is in drain mode, see `Drain`.
+func (s *Server) IsDraining() bool {
+	return atomic.LoadUint32(&s.draining) > 0
+}
+
 var (
 	errServerClosed  = errors.New("server closed")
 	errInvalidMethod = errors.New("no valid request method")
@@ -235,6 +727,40 @@ func IsTryingToReconnect(err error) (ok bool) {
 	return err != nil && err == errUpgradeOnRetry
 }
 
+// TrustedSecretHeaderKey is the request header key that a client should
+// send the `Server.TrustedSecret` through in order to be trusted.
+const TrustedSecretHeaderKey = "X-Neffos-Secret"
+
+func (s *Server) isTrustedRequest(r *http.Request) bool {
+	if s.TrustedSecret == "" {
+		return false
+	}
+
+	secret := r.Header.Get(TrustedSecretHeaderKey)
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(s.TrustedSecret)) == 1
+}
+
+// SubprotocolHeaderKey is the request and response header key
+// of the websocket subprotocol negotiation, see `Server.Subprotocols`.
+const SubprotocolHeaderKey = "Sec-WebSocket-Protocol"
+
+// selectSubprotocol returns the first of the client's requested subprotocols
+// that is supported by the server or empty.
+func selectSubprotocol(r *http.Request, supported []string) string {
+	for _, values := range r.Header[http.CanonicalHeaderKey(SubprotocolHeaderKey)] {
+		for _, protocol := range strings.Split(values, ",") {
+			protocol = strings.TrimSpace(protocol)
+			for _, s := range supported {
+				if s == protocol {
+					return protocol
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
 // This header key should match with that browser-client's `whenResourceOnline->re-dial` uses.
 const websocketReconectHeaderKey = "X-Websocket-Reconnect"
 
@@ -279,7 +805,14 @@ func (s *Server) Upgrade(
 
 	tryParseURLParamsToHeaders(r)
 
-	socket, err := s.upgrader(w, r)
+	if len(s.Subprotocols) > 0 {
+		if protocol := selectSubprotocol(r, s.Subprotocols); protocol != "" {
+			// upgraders should respect this response header, see the gorilla and gobwas subpackages.
+			w.Header().Set(SubprotocolHeaderKey, protocol)
+		}
+	}
+
+	socket, err := s.Upgrader(w, r)
 	if err != nil {
 		if s.OnUpgradeError != nil {
 			s.OnUpgradeError(err)
@@ -291,7 +824,19 @@ func (s *Server) Upgrade(
 		socket = socketWrapper(socket)
 	}
 
-	c := newConn(socket, s.namespaces)
+	if t, ok := socket.(SocketCompressionThresholder); ok {
+		t.SetCompressionThreshold(s.CompressionThreshold)
+	}
+
+	if s.MaxFragments > 0 || s.MaxMessageSize > 0 {
+		if l, ok := socket.(SocketFragmentLimiter); ok {
+			l.SetFragmentLimits(s.MaxFragments, s.MaxMessageSize)
+		}
+	}
+
+	c := newConn(socket, s.namespaces, s.getConnMaps())
+	c.eventsMutex = &s.eventsMutex
+	c.values = r.Context()
 	if customID != "" {
 		c.id = customID
 	} else {
@@ -303,11 +848,26 @@ func (s *Server) Upgrade(
 	c.writeTimeout = s.writeTimeout
 	c.server = s
 
+	if s.dedupeWindow > 0 {
+		c.dedupe = newDedupeSet(c.clock(), s.dedupeWindow, DedupeMaxKeys)
+	}
+
+	if s.StrictOrdering {
+		c.strictOrdering = 1
+	}
+
 	retriesHeaderValue := r.Header.Get(websocketReconectHeaderKey)
 	if retriesHeaderValue != "" {
 		c.ReconnectTries, _ = strconv.Atoi(retriesHeaderValue)
 	}
 
+	if s.isTrustedRequest(r) {
+		c.trusted = true
+		if s.OnTrustedConnect != nil {
+			s.OnTrustedConnect(c)
+		}
+	}
+
 	// TODO: when ask on cloud uncommented:
 	// if !s.usesStackExchange() {
 	go func(c *Conn) {
@@ -315,7 +875,16 @@ func (s *Server) Upgrade(
 		}
 	}(c)
 
-	s.connect <- c
+	select {
+	case s.connect <- c:
+	case <-s.stopped:
+		c.Close()
+		return nil, errServerClosed
+	}
+
+	if s.expiresIdleNamespaces() {
+		c.Go(c.expireIdleNamespaces)
+	}
 
 	go c.startReader()
 	// Start the reader before `OnConnect`, remember clients may remotely connect to namespace before `Server#OnConnect`
@@ -423,12 +992,47 @@ func (s *Server) Do(fn func(*Conn), async bool) {
 		// <-act.done
 	}
 
-	s.actions <- act
+	select {
+	case s.actions <- act:
+	case <-s.stopped:
+		return
+	}
+
 	if !async {
 		<-act.done
 	}
 }
 
+// DisconnectWhere terminates all connections that the "pred" reports true for,
+// i.e to ban a user across all of its sessions by checking a `Conn.Get("userID")` value.
+// If "reason" is not empty then each connected namespace of a matched connection
+// receives a forced disconnect with the "reason" as its `Message.Body` before the termination.
+// The "pred" is called outside of any server lock, against a snapshot of the current connections.
+// It returns the number of the matched connections, the disconnects run in the background.
+//
+// It's best-effort: connections may close or connect concurrently, a connection that
+// is connected after the snapshot is not checked.
+func (s *Server) DisconnectWhere(pred func(c *Conn) bool, reason []byte) int {
+	s.mu.RLock()
+	conns := make([]*Conn, 0, len(s.connections))
+	for c := range s.connections {
+		conns = append(conns, c)
+	}
+	s.mu.RUnlock()
+
+	n := 0
+	for _, c := range conns {
+		if c.IsClosed() || !pred(c) {
+			continue
+		}
+
+		n++
+		go c.closeWithReason(reason)
+	}
+
+	return n
+}
+
 type stringerValue struct{ v string }
 
 func (s stringerValue) String() string { return s.v }
@@ -478,6 +1082,9 @@ func (s *Server) Broadcast(exceptSender fmt.Stringer, msg Message) {
 
 	// s.broadcastCond.Broadcast()
 
+	s.recordRoomHistory(msg)
+	s.forwardToBridges(msg)
+
 	if s.usesStackExchange() {
 		s.StackExchange.Publish(msg)
 		return
@@ -486,6 +1093,206 @@ func (s *Server) Broadcast(exceptSender fmt.Stringer, msg Message) {
 	s.broadcaster.broadcast(msg)
 }
 
+// RoomEmit sends a message to all connections that are joined to the "room" of the "namespace".
+// It's the server-authoritative alternative of the `Room.Emit`:
+// the `Room.Emit` can only be called by a connection which is joined to that room
+// and it sends the message to its remote side only,
+// while `RoomEmit` does not require a sender connection at all and
+// the message reaches every room's member; the connections that are not
+// joined to the room never receive it.
+//
+// It's a shortcut of `Broadcast(nil, Message{Namespace: namespace, Room: room, Event: event, Body: body})`.
+func (s *Server) RoomEmit(namespace, room, event string, body []byte) {
+	s.Broadcast(nil, Message{
+		Namespace: namespace,
+		Room:      room,
+		Event:     event,
+		Body:      body,
+	})
+}
+
+// NamespaceEmit sends a message to all connections that are connected to the "namespace",
+// no matter their joined rooms, i.e for namespace-wide announcements,
+// and returns the number of the connections that the message was successfully written to,
+// closed connections and connections that are not subscribed to the "event" are skipped.
+//
+// The message is serialized once and the same bytes are written to all connections,
+// unless the `OnWriteMessage` is registered, which is called once per connection.
+//
+// When the server uses a `StackExchange` the message is published through it instead,
+// so it reaches the connections of all servers, and the result is the number of the connections
+// of this server that are connected to the "namespace", or zero if the publish failed.
+func (s *Server) NamespaceEmit(namespace, event string, body []byte) int {
+	msg := Message{
+		Namespace: namespace,
+		Event:     event,
+		Body:      body,
+	}
+
+	members := s.namespaceMembers(namespace)
+
+	if s.usesStackExchange() {
+		if !s.StackExchange.Publish(msg) {
+			return 0
+		}
+
+		return len(members)
+	}
+
+	buf := acquireBuffer()
+	b := s.serializeOnce(buf, msg)
+
+	n := 0
+	for _, ns := range members {
+		if ns.Conn.writeSerialized(msg, b) {
+			n++
+		}
+	}
+	releaseBuffer(buf)
+
+	return n
+}
+
+// BroadcastToRoomAllNamespaces sends a message to all connections that are joined to a "room" of that name,
+// no matter their namespace, i.e for a global announcement to a room named after a tenant ID that spans namespaces,
+// and returns the number of the connections that the message was successfully written to.
+// Each connection receives the message on the namespace of its room.
+//
+// Room names are scoped to their namespace, a room "x" of one namespace is irrelevant to a room "x" of another one,
+// so this is a deliberate cross-cutting operation.
+// The connections are looked up through the server's room index, the message is serialized once per namespace
+// (like the `NamespaceEmit`) and it does not pass through the `StackExchange`, only the connections of this server receive it.
+func (s *Server) BroadcastToRoomAllNamespaces(room, event string, body []byte) int {
+	members := make(map[string][]*NSConn)
+
+	s.roomIndexMutex.RLock()
+	for namespace, rooms := range s.roomIndex {
+		for ns := range rooms[room] {
+			members[namespace] = append(members[namespace], ns)
+		}
+	}
+	s.roomIndexMutex.RUnlock()
+
+	n := 0
+	for namespace, conns := range members {
+		msg := Message{
+			Namespace: namespace,
+			Room:      room,
+			Event:     event,
+			Body:      body,
+		}
+
+		buf := acquireBuffer()
+		b := s.serializeOnce(buf, msg)
+		for _, ns := range conns {
+			if ns.Conn.writeSerialized(msg, b) {
+				n++
+			}
+		}
+		releaseBuffer(buf)
+	}
+
+	return n
+}
+
+// EmitToMany sends the "msg" to the connections of the "ids", i.e to the online friends of a user,
+// it's the alternative of a room when the receivers are computed per message.
+// The connections are looked up by their IDs, the IDs that are not found and the closed connections are skipped,
+// as well as the connections that are not connected to the "msg"'s Namespace (or not joined to its Room, if not empty).
+// It returns the number of the connections that the message was successfully written to.
+//
+// Like the `NamespaceEmit`, the message is serialized once but it does not pass through the `StackExchange`,
+// only the connections of this server are looked up.
+func (s *Server) EmitToMany(ids []string, msg Message) int {
+	conns := make([]*Conn, 0, len(ids))
+
+	s.mu.RLock()
+	for _, id := range ids {
+		if c, ok := s.connectionsByID[id]; ok {
+			conns = append(conns, c)
+		}
+	}
+	s.mu.RUnlock()
+
+	msg.FromExplicit = ""
+	msg.To = ""
+	buf := acquireBuffer()
+	b := s.serializeOnce(buf, msg)
+
+	n := 0
+	for _, c := range conns {
+		if c.writeSerialized(msg, b) {
+			n++
+		}
+	}
+	releaseBuffer(buf)
+
+	return n
+}
+
+// BroadcastExcept sends the "msg" to all connections that are connected to the "namespace",
+// or joined to its "room" if not empty, except the connections of the "exceptIDs",
+// i.e to notify a room except its muted users or the ones of a legacy client version,
+// and returns the number of the connections that the message was successfully written to.
+// It generalizes the `Broadcast`'s single sender exclusion, each member is checked by a lookup of its ID.
+// The "msg"'s Namespace and Room are set to the "namespace" and "room".
+//
+// Like the `NamespaceEmit`, the message is serialized once and closed connections are skipped,
+// but it does not pass through the `StackExchange`, only the connections of this server receive it.
+func (s *Server) BroadcastExcept(namespace, room string, exceptIDs map[string]bool, msg Message) int {
+	var members []*NSConn
+	if room == "" {
+		members = s.namespaceMembers(namespace)
+	} else {
+		members = s.roomMembers(namespace, room)
+	}
+
+	msg.Namespace = namespace
+	msg.Room = room
+	msg.FromExplicit = ""
+	msg.To = ""
+	buf := acquireBuffer()
+	b := s.serializeOnce(buf, msg)
+
+	n := 0
+	for _, ns := range members {
+		if exceptIDs[ns.Conn.ID()] {
+			continue
+		}
+
+		if ns.Conn.writeSerialized(msg, b) {
+			n++
+		}
+	}
+	releaseBuffer(buf)
+
+	return n
+}
+
+// serializeOnce returns the serialized "msg", written to the "buf" if not nil, to be written to many connections,
+// or nil if it should be serialized per connection because the `OnWriteMessage` is registered.
+// The "buf" should be released after the writes, see `writeSerialized`.
+func (s *Server) serializeOnce(buf *bytes.Buffer, msg Message) []byte {
+	if s.OnWriteMessage != nil {
+		return nil
+	}
+
+	return serializeMessageTo(buf, msg)
+}
+
+// writeSerialized writes the "b", the result of the `serializeOnce` of the "msg", to the connection.
+// The "b" is copied if the write is queued, so its buffer can be reused right after the call.
+func (c *Conn) writeSerialized(msg Message, b []byte) bool {
+	// the sequence numbers are per connection, see `StrictOrdering`.
+	if b == nil || c.isStrictOrdering() {
+		return c.Write(msg)
+	}
+
+	w := pendingWriteOf(msg, b)
+	w.shared = true
+	return c.canWrite(msg) && !c.dropExpired(msg.ExpiresAt) && c.writePending(w)
+}
+
 // Ask is like `Broadcast` but it blocks until a response
 // from a specific connection if "msg.To" is filled otherwise
 // from the first connection which will reply to this "msg".
@@ -504,27 +1311,31 @@ func (s *Server) Ask(ctx context.Context, msg Message) (Message, error) {
 		ctx = context.TODO()
 	} else {
 		if deadline, has := ctx.Deadline(); has {
+			// the deadlines of the contexts are on the real time, not on the server's `Clock`.
 			if deadline.Before(time.Now().Add(-1 * time.Second)) {
 				return Message{}, context.DeadlineExceeded
 			}
 		}
 	}
 
-	ch := make(chan Message)
+	ch := make(chan Message, 1)
 	s.waitingMessagesMutex.Lock()
 	s.waitingMessages[msg.wait] = ch
 	s.waitingMessagesMutex.Unlock()
 
+	// a canceled ask is not pending anymore, see `Drain`.
+	defer func() {
+		s.waitingMessagesMutex.Lock()
+		delete(s.waitingMessages, msg.wait)
+		s.waitingMessagesMutex.Unlock()
+	}()
+
 	s.Broadcast(nil, msg)
 
 	select {
 	case <-ctx.Done():
 		return Message{}, ctx.Err()
 	case receive := <-ch:
-		s.waitingMessagesMutex.Lock()
-		delete(s.waitingMessages, msg.wait)
-		s.waitingMessagesMutex.Unlock()
-
 		return receive, receive.Err
 	}
 }
@@ -549,6 +1360,21 @@ func (s *Server) GetConnectionsByNamespace(namespace string) map[string]*NSConn
 	return conns
 }
 
+// namespaceMembers returns a snapshot of the connections that are connected to the "namespace".
+func (s *Server) namespaceMembers(namespace string) []*NSConn {
+	var members []*NSConn
+
+	s.mu.RLock()
+	for c := range s.connections {
+		if ns := c.Namespace(namespace); ns != nil {
+			members = append(members, ns)
+		}
+	}
+	s.mu.RUnlock()
+
+	return members
+}
+
 // GetConnections can be used as an alternative way to retrieve
 // all connected connections to the server on a specific time point.
 // Do not use this function frequently, it is not designed to be fast or cheap, use it for debugging or logging every 'x' time.
@@ -573,4 +1399,21 @@ var (
 	ErrBadRoom = errors.New("bad room")
 	// ErrWrite may return from any connection's method when the underline connection is closed (unexpectedly).
 	ErrWrite = errors.New("write closed")
+	// ErrServerDraining may return from a remote event or namespace connect when the server is in drain mode.
+	// See `Server.Drain`.
+	ErrServerDraining = errors.New("server is draining")
+	// ErrRoomRateLimited may return from a remote room event when the connection exceeded the room's rate limit.
+	// See `Server.RoomRateLimit`.
+	ErrRoomRateLimited = errors.New("room rate limited")
+	// ErrTooManyRooms may return from a `NSConn#JoinRoom` method when the connection
+	// is already joined to the maximum number of rooms of its namespace.
+	// See `Server.MaxRoomsPerConnection`.
+	ErrTooManyRooms = errors.New("too many rooms")
+	// ErrFragmentLimit is the read error of an incoming message which exceeds
+	// the `Server.MaxFragments` or the `Server.MaxMessageSize`.
+	ErrFragmentLimit = errors.New("fragment limit exceeded")
+	// ErrMessageFiltered may return from a remote event when the `Server.MessageFilter` dropped the message.
+	ErrMessageFiltered = errors.New("message filtered")
+	// ErrMuted may return from a remote event when the server muted the connection, see `Conn.Mute`.
+	ErrMuted = errors.New("muted")
 )
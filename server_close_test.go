@@ -0,0 +1,94 @@
+package neffos
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestServerCloseStopsLoop(t *testing.T) {
+	namespaces := Namespaces{"default": Events{}}
+	s := New(nil, namespaces)
+
+	// a server-side connection which is closed after the server stopped consuming its disconnects.
+	c := newConn(newPipeSocket(), namespaces, nil)
+	c.server = s
+
+	s.Close()
+
+	select {
+	case <-s.stopped:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected the server to stop its loop")
+	}
+
+	before := runtime.NumGoroutine()
+	c.Close()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the disconnect of the connection to not leak a goroutine")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.Do(func(*Conn) {
+			t.Errorf("expected no connections to be called after the close")
+		}, false)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected the Do to return after the close")
+	}
+}
+
+func TestServerCloseConcurrently(t *testing.T) {
+	defer func(timeout time.Duration) { GoCloseTimeout = timeout }(GoCloseTimeout)
+	GoCloseTimeout = 500 * time.Millisecond
+
+	namespaces := Namespaces{"default": Events{}}
+	s := New(nil, namespaces)
+
+	release := make(chan struct{})
+	defer close(release)
+
+	var conns []*Conn
+	for i := 0; i < 5; i++ {
+		c := newConn(newPipeSocket(), namespaces, nil)
+		c.id = string(rune('a' + i))
+		c.server = s
+		// a slow goroutine, it ignores the close, so each close waits for the whole timeout.
+		c.Go(func(context.Context) { <-release })
+		s.connect <- c
+		conns = append(conns, c)
+	}
+
+	for s.GetTotalConnections() < 5 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	start := time.Now()
+	s.Close()
+	if elapsed := time.Since(start); elapsed >= 2*GoCloseTimeout {
+		t.Fatalf("expected the connections to be closed concurrently but the close took %s", elapsed)
+	}
+
+	for _, c := range conns {
+		if !c.IsClosed() {
+			t.Fatalf("expected all the connections to be closed")
+		}
+	}
+
+	select {
+	case <-s.stopped:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected the server to stop its loop")
+	}
+}
@@ -2,7 +2,15 @@ package neffos_test
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -12,6 +20,9 @@ import (
 
 	gobwas "github.com/kataras/neffos/gobwas"
 	gorilla "github.com/kataras/neffos/gorilla"
+
+	ws "github.com/gobwas/ws"
+	"github.com/gorilla/websocket"
 )
 
 func runTestServer(addr string, connHandler neffos.ConnHandler, configureServer ...func(*neffos.Server)) func() error {
@@ -195,3 +206,2697 @@ func TestServerAsk(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestServerSubprotocols(t *testing.T) {
+	var (
+		namespace = "default"
+		protocol  = "neffos.v1"
+		events    = neffos.Namespaces{namespace: neffos.Events{}}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(wsServer *neffos.Server) {
+		wsServer.Subprotocols = []string{"neffos.v2", protocol}
+		wsServer.OnConnect = func(c *neffos.Conn) error {
+			if got := c.Subprotocol(); got != protocol {
+				t.Errorf("expected server-side negotiated subprotocol to be: %s but got: %s", protocol, got)
+			}
+			return nil
+		}
+	})
+	defer teardownServer()
+
+	gorillaDialer := gorilla.Dialer(&gorilla.Options{Subprotocols: []string{protocol}}, make(http.Header))
+	gobwasDialer := gobwas.Dialer(gobwas.Options{Protocols: []string{protocol}})
+
+	for dialer, dial := range map[string]neffos.Dialer{"gorilla": gorillaDialer, "gobwas": gobwasDialer} {
+		client, err := neffos.Dial(nil, dial, "ws://localhost:8080/"+dialer, events)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := c.Conn.Subprotocol(); got != protocol {
+			t.Fatalf("[%s] expected client-side negotiated subprotocol to be: %s but got: %s", dialer, protocol, got)
+		}
+
+		client.Close()
+	}
+}
+
+func TestServerDefaultEvents(t *testing.T) {
+	var (
+		namespace = "default"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"own": neffos.ReplyHandler(func(*neffos.NSConn, neffos.Message) ([]byte, error) {
+					return []byte("own"), nil
+				}),
+			},
+		}
+		defaultEvents = neffos.Events{
+			"own": func(*neffos.NSConn, neffos.Message) error {
+				t.Fatalf("expected namespace's own event callback to override the default one")
+				return nil
+			},
+			"shared": neffos.ReplyHandler(func(*neffos.NSConn, neffos.Message) ([]byte, error) {
+				return []byte("shared"), nil
+			}),
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.DefaultEvents = defaultEvents
+	})
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, event := range []string{"own", "shared"} {
+			msg, err := c.Ask(nil, event, nil)
+			if err != nil {
+				t.Fatalf("[%s] %v", dialer, err)
+			}
+
+			if string(msg.Body) != event {
+				t.Fatalf("[%s] expected reply of %s to be: %s but got: %s", dialer, event, event, msg.Body)
+			}
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerOnSlowHandler(t *testing.T) {
+	var (
+		wg        sync.WaitGroup
+		namespace = "default"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"slow": func(c *neffos.NSConn, msg neffos.Message) error {
+					if !c.Conn.IsClient() {
+						time.Sleep(20 * time.Millisecond)
+					}
+					return nil
+				},
+				"fast": func(*neffos.NSConn, neffos.Message) error {
+					return nil
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.SlowHandlerThreshold = 10 * time.Millisecond
+		s.OnSlowHandler = func(c *neffos.Conn, namespace, event string, d time.Duration) {
+			if c.ID() == "" || event != "slow" || d < 10*time.Millisecond {
+				t.Fatalf("unexpected slow handler report for connection: %q, event: %s, duration: %s", c.ID(), event, d)
+			}
+			wg.Done()
+		}
+	})
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wg.Add(1)
+		c.Emit("fast", nil)
+		c.Emit("slow", nil)
+		wg.Wait()
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerDisconnectWhere(t *testing.T) {
+	var (
+		wg        sync.WaitGroup
+		namespace = "default"
+		reason    = []byte("banned")
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"ban": func(c *neffos.NSConn, msg neffos.Message) error {
+					userID := c.Conn.Get("userID")
+					n := c.Conn.Server().DisconnectWhere(func(other *neffos.Conn) bool {
+						return other.Get("userID") == userID
+					}, reason)
+					if n != 1 {
+						t.Fatalf("expected one matched connection but got: %d", n)
+					}
+					return nil
+				},
+				neffos.OnNamespaceDisconnect: func(c *neffos.NSConn, msg neffos.Message) error {
+					if c.Conn.IsClient() {
+						if !bytes.Equal(msg.Body, reason) {
+							t.Fatalf("expected disconnect reason to be: %s but got: %s", reason, msg.Body)
+						}
+						wg.Done()
+					}
+					return nil
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.OnConnect = func(c *neffos.Conn) error {
+			c.Set("userID", c.ID())
+			return nil
+		}
+	})
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wg.Add(1)
+		c.Emit("ban", nil)
+		wg.Wait()
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerErrorTransformer(t *testing.T) {
+	var (
+		namespace = "default"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"query": func(c *neffos.NSConn, msg neffos.Message) error {
+					if !c.Conn.IsClient() {
+						return errors.New("pq: relation users does not exist")
+					}
+					return nil
+				},
+			},
+		}
+		sanitized = errors.New("internal server error")
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.ErrorTransformer = func(err error) error {
+			return sanitized
+		}
+	})
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = c.Ask(nil, "query", nil)
+		if err == nil || err.Error() != sanitized.Error() {
+			t.Fatalf("[%s] expected error: %v but got: %v", dialer, sanitized, err)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerOnAskComplete(t *testing.T) {
+	type report struct {
+		event string
+		err   error
+	}
+
+	var (
+		namespace = "default"
+		reports   = make(chan report, 4)
+		done      = make(chan struct{})
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"trigger": func(c *neffos.NSConn, msg neffos.Message) error {
+					if c.Conn.IsClient() {
+						return nil
+					}
+
+					go func() {
+						defer func() { done <- struct{}{} }()
+
+						// success, reported.
+						c.Ask(nil, "pong", nil)
+						// remote error, not reported.
+						c.Ask(nil, "fail", nil)
+						// timeout, reported.
+						ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+						c.Ask(ctx, "slow", nil)
+						cancel()
+						// canceled by the caller, not reported.
+						ctx, cancel = context.WithCancel(context.Background())
+						time.AfterFunc(50*time.Millisecond, cancel)
+						c.Ask(ctx, "slow", nil)
+					}()
+					return nil
+				},
+				"pong": func(c *neffos.NSConn, msg neffos.Message) error {
+					return neffos.Reply([]byte("pong"))
+				},
+				"fail": func(c *neffos.NSConn, msg neffos.Message) error {
+					return errors.New("remote")
+				},
+				"slow": func(c *neffos.NSConn, msg neffos.Message) error {
+					time.Sleep(200 * time.Millisecond)
+					return neffos.Reply(nil)
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.OnAskComplete = func(event string, d time.Duration, err error) {
+			if d <= 0 {
+				t.Errorf("expected a positive duration of the %s ask but got: %s", event, d)
+			}
+			reports <- report{event, err}
+		}
+	})
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.Emit("trigger", nil)
+		<-done
+
+		var got []report
+		for len(reports) > 0 {
+			got = append(got, <-reports)
+		}
+
+		if expected := []report{{"pong", nil}, {"slow", context.DeadlineExceeded}}; !reflect.DeepEqual(expected, got) {
+			t.Fatalf("[%s] expected ask reports: %v but got: %v", dialer, expected, got)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerRoomHistory(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		received  []string
+		namespace = "default"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"send": func(c *neffos.NSConn, msg neffos.Message) error {
+					c.Conn.Server().RoomEmit(namespace, "room", "chat", msg.Body)
+					return neffos.Reply(nil)
+				},
+				"chat": func(c *neffos.NSConn, msg neffos.Message) error {
+					mu.Lock()
+					received = append(received, string(msg.Body))
+					mu.Unlock()
+					return nil
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.RoomHistory(namespace, "", 5)
+		s.RoomHistory(namespace, "room", 2)
+	})
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		mu.Lock()
+		received = nil
+		mu.Unlock()
+
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, body := range []string{"1", "2", "3"} {
+			if _, err = c.Ask(nil, "send", []byte(body)); err != nil {
+				t.Fatalf("[%s] %v", dialer, err)
+			}
+		}
+
+		if _, err = c.JoinRoom(nil, "room"); err != nil {
+			t.Fatalf("[%s] %v", dialer, err)
+		}
+
+		// the replayed messages are written before the join's reply.
+		mu.Lock()
+		got := append([]string(nil), received...)
+		mu.Unlock()
+
+		if expected := []string{"2", "3"}; len(got) != len(expected) || got[0] != expected[0] || got[1] != expected[1] {
+			t.Fatalf("[%s] expected replayed messages: %v but got: %v", dialer, expected, got)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerReplaceExisting(t *testing.T) {
+	var (
+		wg        sync.WaitGroup
+		namespace = "default"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				neffos.OnNamespaceDisconnect: func(c *neffos.NSConn, msg neffos.Message) error {
+					if c.Conn.IsClient() && bytes.Equal(msg.Body, neffos.SessionReplacedReason) {
+						wg.Done()
+					}
+					return nil
+				},
+				"login": func(c *neffos.NSConn, msg neffos.Message) error {
+					if !c.Conn.IsClient() {
+						c.Conn.Server().ReplaceExisting("userID", string(msg.Body), c.Conn)
+					}
+					return neffos.Reply(nil)
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.OnSessionReplaced = func(old, new *neffos.Conn) {
+			if old == new || old.IsClosed() {
+				t.Fatalf("expected the old connection to be open and different than the new one")
+			}
+			wg.Done()
+		}
+	})
+	defer teardownServer()
+
+	login := func(dialer string, client *neffos.Client) {
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = c.Ask(nil, "login", []byte("user_1")); err != nil {
+			t.Fatalf("[%s] %v", dialer, err)
+		}
+	}
+
+	// one replaced callback and one client disconnect for each server.
+	wg.Add(4)
+
+	teardownClient1 := runTestClient("localhost:8080", events, login)
+	defer teardownClient1()
+
+	teardownClient2 := runTestClient("localhost:8080", events, login)
+	defer teardownClient2()
+
+	wg.Wait()
+}
+
+func TestServerRoomRateLimit(t *testing.T) {
+	var (
+		namespace = "default"
+		roomName  = "room"
+		handled   uint32
+		limited   uint32
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"chat": func(c *neffos.NSConn, msg neffos.Message) error {
+					if !c.Conn.IsClient() {
+						atomic.AddUint32(&handled, 1)
+					}
+					return nil
+				},
+				"sync": func(c *neffos.NSConn, msg neffos.Message) error {
+					return neffos.Reply(nil)
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.RoomRateLimit(namespace, roomName, 2)
+		s.OnRoomRateLimited = func(c *neffos.Conn, namespace, room, event string) {
+			atomic.AddUint32(&limited, 1)
+		}
+	})
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		atomic.StoreUint32(&handled, 0)
+		atomic.StoreUint32(&limited, 0)
+
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		room, err := c.JoinRoom(nil, roomName)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 5; i++ {
+			room.Emit("chat", nil)
+		}
+
+		// the messages are handled in order, so the previous ones are handled when it's answered.
+		if _, err = c.Ask(nil, "sync", nil); err != nil {
+			t.Fatalf("[%s] %v", dialer, err)
+		}
+
+		if expected, got := uint32(2), atomic.LoadUint32(&handled); expected != got {
+			t.Fatalf("[%s] expected %d handled room messages but got %d", dialer, expected, got)
+		}
+
+		if expected, got := uint32(3), atomic.LoadUint32(&limited); expected != got {
+			t.Fatalf("[%s] expected %d rate limited room messages but got %d", dialer, expected, got)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerOnPreAckOverflow(t *testing.T) {
+	defer func(max int) { neffos.MaxPreAckMessages = max }(neffos.MaxPreAckMessages)
+	neffos.MaxPreAckMessages = 2
+
+	var (
+		wg     sync.WaitGroup
+		events = neffos.Namespaces{"default": neffos.Events{}}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.OnPreAckOverflow = func(c *neffos.Conn, dropped int) {
+			if expected := 3; dropped != expected {
+				t.Fatalf("expected %d dropped messages but got %d", expected, dropped)
+			}
+			wg.Done()
+		}
+	})
+	defer teardownServer()
+
+	for _, dialer := range []string{"gobwas", "gorilla"} {
+		// a raw websocket connection which never sends the neffos acknowledgement.
+		conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:8080/"+dialer, nil)
+		if err != nil {
+			t.Fatalf("[%s] %v", dialer, err)
+		}
+
+		wg.Add(1)
+		for i := 0; i < 3; i++ {
+			if err = conn.WriteMessage(websocket.TextMessage, []byte("flood")); err != nil {
+				t.Fatalf("[%s] %v", dialer, err)
+			}
+		}
+		wg.Wait()
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, _, err = conn.ReadMessage(); err == nil {
+			t.Fatalf("[%s] expected the connection to be closed", dialer)
+		} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			t.Fatalf("[%s] expected the connection to be closed but it's still open", dialer)
+		}
+		conn.Close()
+	}
+}
+
+type tokenAcknowledger struct {
+	token string
+}
+
+func (a tokenAcknowledger) Acknowledge(c *neffos.Conn, payload []byte) error {
+	if bytes.HasPrefix(payload, []byte("token:")) {
+		if string(payload[len("token:"):]) != a.token {
+			return errors.New("invalid token")
+		}
+
+		c.Set("authorized", true)
+		return nil
+	}
+
+	if c.Get("authorized") == nil {
+		return errors.New("unauthorized")
+	}
+
+	return c.Acknowledge()
+}
+
+func TestServerAcknowledger(t *testing.T) {
+	events := neffos.Namespaces{"default": neffos.Events{}}
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.Acknowledger = tokenAcknowledger{token: "secret"}
+	})
+	defer teardownServer()
+
+	// the default handshake of a neffos client is rejected.
+	if _, err := neffos.Dial(nil, gorilla.DefaultDialer, "ws://localhost:8080/gorilla", events); err == nil || err.Error() != "unauthorized" {
+		t.Fatalf("expected an unauthorized error but got: %v", err)
+	}
+
+	for _, dialer := range []string{"gobwas", "gorilla"} {
+		conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:8080/"+dialer, nil)
+		if err != nil {
+			t.Fatalf("[%s] %v", dialer, err)
+		}
+
+		conn.WriteMessage(websocket.TextMessage, []byte("token:secret"))
+		conn.WriteMessage(websocket.TextMessage, []byte("M"))
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, b, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("[%s] %v", dialer, err)
+		}
+
+		// the connection's ID, prefixed by the 'A'.
+		if len(b) < 2 || b[0] != 'A' {
+			t.Fatalf("[%s] expected the acknowledgement but got: %q", dialer, b)
+		}
+		conn.Close()
+	}
+}
+
+func TestServerHandlerDeadline(t *testing.T) {
+	var (
+		wg        sync.WaitGroup
+		namespace = "default"
+		release   = make(chan struct{})
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"stuck": func(c *neffos.NSConn, msg neffos.Message) error {
+					if !c.Conn.IsClient() {
+						<-release
+					}
+					return nil
+				},
+			},
+		}
+	)
+	defer close(release)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.HandlerDeadline = 100 * time.Millisecond
+		s.CloseOnHandlerDeadline = true
+		s.OnHandlerDeadline = func(c *neffos.Conn, namespace, event string, stack []byte) {
+			if event != "stuck" || !bytes.Contains(stack, []byte("TestServerHandlerDeadline")) {
+				t.Fatalf("unexpected report for event: %s with stack:\n%s", event, stack)
+			}
+			wg.Done()
+		}
+	})
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wg.Add(1)
+		c.Emit("stuck", nil)
+		wg.Wait()
+
+		select {
+		case <-c.Conn.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatalf("[%s] expected the connection to be closed", dialer)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerNamespaceEmit(t *testing.T) {
+	var (
+		wg        sync.WaitGroup
+		namespace = "default"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"announce": func(c *neffos.NSConn, msg neffos.Message) error {
+					n := c.Conn.Server().NamespaceEmit(namespace, "notice", msg.Body)
+					return neffos.Reply([]byte(strconv.Itoa(n)))
+				},
+				"notice": func(c *neffos.NSConn, msg neffos.Message) error {
+					if expected, got := "hello", string(msg.Body); expected != got {
+						t.Fatalf("expected body: %s but got: %s", expected, got)
+					}
+
+					if msg.Room != "" {
+						t.Fatalf("expected a namespace-wide message but got room: %s", msg.Room)
+					}
+					wg.Done()
+					return nil
+				},
+			},
+			"other": neffos.Events{
+				"notice": func(c *neffos.NSConn, msg neffos.Message) error {
+					t.Fatalf("unexpected notice to the other namespace")
+					return nil
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = client.Connect(nil, "other"); err != nil {
+			t.Fatal(err)
+		}
+
+		// rooms do not matter.
+		if _, err = c.JoinRoom(nil, "room1"); err != nil {
+			t.Fatal(err)
+		}
+
+		wg.Add(1)
+		reply, err := c.Ask(nil, "announce", []byte("hello"))
+		if err != nil {
+			t.Fatalf("[%s] %v", dialer, err)
+		}
+		wg.Wait()
+
+		if expected, got := "1", string(reply.Body); expected != got {
+			t.Fatalf("[%s] expected delivery count: %s but got: %s", dialer, expected, got)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerMaxRoomsPerConnection(t *testing.T) {
+	var (
+		namespace = "default"
+		maxRooms  = 3
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.MaxRoomsPerConnection = maxRooms
+	})
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < maxRooms; i++ {
+			if _, err = c.JoinRoom(nil, "room"+strconv.Itoa(i)); err != nil {
+				t.Fatalf("[%s] %v", dialer, err)
+			}
+		}
+
+		// already joined rooms are not counted again.
+		if _, err = c.JoinRoom(nil, "room0"); err != nil {
+			t.Fatalf("[%s] %v", dialer, err)
+		}
+
+		if _, err = c.JoinRoom(nil, "room"+strconv.Itoa(maxRooms)); err != neffos.ErrTooManyRooms {
+			t.Fatalf("[%s] expected error: %v but got: %v", dialer, neffos.ErrTooManyRooms, err)
+		}
+
+		if room := c.Room("room" + strconv.Itoa(maxRooms)); room != nil {
+			t.Fatalf("[%s] expected the room to not be joined", dialer)
+		}
+
+		// a left room frees its place.
+		if err = c.Room("room0").Leave(nil); err != nil {
+			t.Fatalf("[%s] %v", dialer, err)
+		}
+
+		if _, err = c.JoinRoom(nil, "room"+strconv.Itoa(maxRooms)); err != nil {
+			t.Fatalf("[%s] %v", dialer, err)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerConnectBeforeAcknowledgement(t *testing.T) {
+	var (
+		namespace = "default"
+		events    = neffos.Namespaces{namespace: neffos.Events{}}
+		errCh     = make(chan error, 1)
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.OnConnect = func(c *neffos.Conn) error {
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+				defer cancel()
+
+				_, err := c.Connect(ctx, namespace)
+				errCh <- err
+			}()
+			return nil
+		}
+	})
+	defer teardownServer()
+
+	// a raw websocket client which never sends the acknowledgement.
+	conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:8080/gorilla", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	select {
+	case err = <-errCh:
+		if err != context.DeadlineExceeded {
+			t.Fatalf("expected error: %v but got: %v", context.DeadlineExceeded, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the connect to respect its context's deadline")
+	}
+}
+
+func TestConnKickFromRoom(t *testing.T) {
+	var (
+		wg        sync.WaitGroup
+		servers   []*neffos.Server
+		namespace = "default"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				neffos.OnRoomLeave: func(c *neffos.NSConn, msg neffos.Message) error {
+					if c.Conn.IsClient() {
+						return nil
+					}
+
+					if !msg.IsForced {
+						t.Fatalf("expected a forced room leave")
+					}
+					// can not prevent a kick.
+					return errors.New("forbidden")
+				},
+				neffos.OnRoomLeft: func(c *neffos.NSConn, msg neffos.Message) error {
+					wg.Done()
+					return nil
+				},
+				"leave": func(c *neffos.NSConn, msg neffos.Message) error {
+					return c.Conn.KickFromRoom(namespace, string(msg.Body))
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		servers = append(servers, s)
+	})
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, room := range []string{"room1", "room2"} {
+			if _, err = c.JoinRoom(nil, room); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var serverConn *neffos.Conn
+		for _, server := range servers {
+			if conn, ok := server.GetConnections()[c.Conn.ID()]; ok {
+				serverConn = conn
+			}
+		}
+
+		if err = serverConn.KickFromRoom(namespace, "other"); err != neffos.ErrBadRoom {
+			t.Fatalf("[%s] expected error: %v but got: %v", dialer, neffos.ErrBadRoom, err)
+		}
+
+		// both sides' left event.
+		wg.Add(2)
+		if err = serverConn.KickFromRoom(namespace, "room1"); err != nil {
+			t.Fatalf("[%s] %v", dialer, err)
+		}
+		wg.Wait()
+
+		if c.Room("room1") != nil || serverConn.Namespace(namespace).Room("room1") != nil {
+			t.Fatalf("[%s] expected room1 to be left on both sides", dialer)
+		}
+
+		wg.Add(2)
+		serverConn.ForceLeaveAll()
+		wg.Wait()
+
+		if len(c.Rooms()) != 0 || len(serverConn.Namespace(namespace).Rooms()) != 0 {
+			t.Fatalf("[%s] expected all rooms to be left on both sides", dialer)
+		}
+
+		if c.Conn.Namespace(namespace) == nil {
+			t.Fatalf("[%s] expected the namespace to stay connected", dialer)
+		}
+
+		// from an event callback of the kicked connection itself.
+		if _, err = c.JoinRoom(nil, "room3"); err != nil {
+			t.Fatal(err)
+		}
+
+		wg.Add(2)
+		c.Emit("leave", []byte("room3"))
+
+		left := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(left)
+		}()
+
+		select {
+		case <-left:
+		case <-time.After(3 * time.Second):
+			t.Fatalf("[%s] expected the kick to not wait for the kicked connection's read loop", dialer)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerRoomsInfo(t *testing.T) {
+	var (
+		servers   []*neffos.Server
+		namespace = "default"
+		events    = neffos.Namespaces{namespace: neffos.Events{}}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		servers = append(servers, s)
+	})
+	defer teardownServer()
+
+	roomsInfo := func(c *neffos.NSConn) map[string]int {
+		for _, server := range servers {
+			if _, ok := server.GetConnections()[c.Conn.ID()]; ok {
+				return server.RoomsInfo(namespace)
+			}
+		}
+
+		t.Fatalf("connection %s not found", c.Conn.ID())
+		return nil
+	}
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, room := range []string{"room1", "room2"} {
+			if _, err = c.JoinRoom(nil, room); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if expected, got := map[string]int{"room1": 1, "room2": 1}, roomsInfo(c); !reflect.DeepEqual(expected, got) {
+			t.Fatalf("[%s] expected rooms info: %v but got: %v", dialer, expected, got)
+		}
+
+		if err = c.Room("room1").Leave(nil); err != nil {
+			t.Fatal(err)
+		}
+
+		if expected, got := map[string]int{"room2": 1}, roomsInfo(c); !reflect.DeepEqual(expected, got) {
+			t.Fatalf("[%s] expected rooms info: %v but got: %v", dialer, expected, got)
+		}
+
+		if err = c.Disconnect(nil); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := roomsInfo(c); len(got) != 0 {
+			t.Fatalf("[%s] expected no active rooms but got: %v", dialer, got)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerStrictOrdering(t *testing.T) {
+	var (
+		wg        sync.WaitGroup
+		namespace = "default"
+		count     = 50
+		received  []string
+		mu        sync.Mutex
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"seq": func(c *neffos.NSConn, msg neffos.Message) error {
+					if len(msg.Headers) > 0 {
+						t.Fatalf("expected the sequence number to not be exposed but got headers: %v", msg.Headers)
+					}
+
+					mu.Lock()
+					received = append(received, string(msg.Body))
+					mu.Unlock()
+					wg.Done()
+					return nil
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.StrictOrdering = true
+	})
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+		client.StrictOrdering()
+
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mu.Lock()
+		received = received[:0]
+		mu.Unlock()
+
+		wg.Add(count)
+		for i := 0; i < count; i++ {
+			c.Emit("seq", []byte(strconv.Itoa(i)))
+		}
+		wg.Wait()
+
+		mu.Lock()
+		for i, body := range received {
+			if expected := strconv.Itoa(i); expected != body {
+				t.Fatalf("[%s] expected message: %s but got: %s", dialer, expected, body)
+			}
+		}
+		mu.Unlock()
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a gap is a protocol violation.
+	conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:8080/gorilla", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.WriteMessage(websocket.TextMessage, []byte("M"))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err = conn.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, seq := range []string{"1", "3"} {
+		msg := neffos.Message{Namespace: namespace, Event: "unknown", Headers: map[string]string{"Neffos-Seq": seq}}
+		conn.WriteMessage(websocket.TextMessage, msg.Serialize())
+	}
+
+	for {
+		if _, _, err = conn.ReadMessage(); err != nil {
+			if _, ok := err.(*websocket.CloseError); !ok && !strings.Contains(err.Error(), "EOF") &&
+				!strings.Contains(err.Error(), "reset") {
+				t.Fatalf("expected the connection to be closed but got: %v", err)
+			}
+			break
+		}
+	}
+}
+
+func TestServerDropLowestQoS(t *testing.T) {
+	var (
+		wg        sync.WaitGroup
+		server    *neffos.Server
+		namespace = "default"
+		reason    = []byte("overloaded")
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				neffos.OnNamespaceDisconnect: func(c *neffos.NSConn, msg neffos.Message) error {
+					if c.Conn.IsClient() && bytes.Equal(msg.Body, reason) {
+						if c.Conn.Get("tier") == "premium" {
+							t.Fatalf("expected the premium connection to be kept")
+						}
+						wg.Done()
+					}
+					return nil
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.QoSClasses = map[string]neffos.QoS{
+			"free":    {Priority: 0},
+			"premium": {Priority: 1},
+		}
+		s.OnConnect = func(c *neffos.Conn) error {
+			c.SetQoS(c.Socket().Request().URL.Query().Get("tier"))
+			return nil
+		}
+		server = s
+	})
+	defer teardownServer()
+
+	var clients []*neffos.Client
+	for _, tier := range []string{"free", "premium", "free"} {
+		client, err := neffos.Dial(nil, gorilla.DefaultDialer, "ws://localhost:8080/gorilla?tier="+tier, events)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Conn.Set("tier", tier)
+
+		clients = append(clients, client)
+	}
+
+	wg.Add(2)
+	if expected, got := 2, server.DropLowestQoS(2, reason); expected != got {
+		t.Fatalf("expected %d dropped connections but got %d", expected, got)
+	}
+	wg.Wait()
+
+	premium, err := clients[1].Connect(nil, namespace)
+	if err != nil || premium.Conn.IsClosed() {
+		t.Fatalf("expected the premium connection to be alive but got: %v", err)
+	}
+}
+
+func TestServerEmitToMany(t *testing.T) {
+	var (
+		wg        sync.WaitGroup
+		server    *neffos.Server
+		namespace = "default"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"notify": func(c *neffos.NSConn, msg neffos.Message) error {
+					if c.Conn.Get("excluded") == true {
+						t.Fatalf("unexpected message to the excluded connection")
+					}
+					wg.Done()
+					return nil
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		server = s
+	})
+	defer teardownServer()
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		client, err := neffos.Dial(nil, gorilla.DefaultDialer, "ws://localhost:8080/gorilla", events)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if i == 1 {
+			c.Conn.Set("excluded", true)
+			continue
+		}
+
+		ids = append(ids, client.ID)
+	}
+
+	ids = append(ids, "unknown")
+
+	wg.Add(2)
+	msg := neffos.Message{Namespace: namespace, Event: "notify", Body: []byte("hello")}
+	if expected, got := 2, server.EmitToMany(ids, msg); expected != got {
+		t.Fatalf("expected %d deliveries but got %d", expected, got)
+	}
+	wg.Wait()
+
+	// not connected to the namespace.
+	msg.Namespace = "other"
+	if got := server.EmitToMany(ids, msg); got != 0 {
+		t.Fatalf("expected no deliveries but got %d", got)
+	}
+}
+
+func TestServerBroadcastExcept(t *testing.T) {
+	var (
+		wg        sync.WaitGroup
+		server    *neffos.Server
+		namespace = "default"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"notify": func(c *neffos.NSConn, msg neffos.Message) error {
+					if c.Conn.Get("excluded") == true {
+						t.Fatalf("unexpected message to the excluded connection")
+					}
+					wg.Done()
+					return nil
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		server = s
+	})
+	defer teardownServer()
+
+	exceptIDs := make(map[string]bool)
+	for i := 0; i < 4; i++ {
+		client, err := neffos.Dial(nil, gorilla.DefaultDialer, "ws://localhost:8080/gorilla", events)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if i == 1 {
+			c.Conn.Set("excluded", true)
+			exceptIDs[client.ID] = true
+		}
+
+		// the last one is not a member of the room.
+		if i < 3 {
+			if _, err = c.JoinRoom(nil, "room"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	msg := neffos.Message{Event: "notify", Body: []byte("hello")}
+
+	wg.Add(2)
+	if expected, got := 2, server.BroadcastExcept(namespace, "room", exceptIDs, msg); expected != got {
+		t.Fatalf("expected %d room deliveries but got %d", expected, got)
+	}
+	wg.Wait()
+
+	wg.Add(3)
+	if expected, got := 3, server.BroadcastExcept(namespace, "", exceptIDs, msg); expected != got {
+		t.Fatalf("expected %d namespace deliveries but got %d", expected, got)
+	}
+	wg.Wait()
+}
+
+func TestServerSessionResume(t *testing.T) {
+	var (
+		namespace = "default"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"login": neffos.ReplyHandler(func(c *neffos.NSConn, msg neffos.Message) ([]byte, error) {
+					c.Conn.Set("user", string(msg.Body))
+					return nil, nil
+				}),
+				"whoami": neffos.ReplyHandler(func(c *neffos.NSConn, msg neffos.Message) ([]byte, error) {
+					user, _ := c.Conn.Get("user").(string)
+					return []byte(user), nil
+				}),
+			},
+		}
+	)
+
+	var server *neffos.Server
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.SessionResumeTimeout = 5 * time.Second
+		server = s
+	})
+	defer teardownServer()
+
+	waitResumeToken := func(client *neffos.Client) string {
+		t.Helper()
+
+		token := client.ResumeToken()
+		for i := 0; token == "" && i < 100; i++ {
+			time.Sleep(10 * time.Millisecond)
+			token = client.ResumeToken()
+		}
+		if token == "" {
+			t.Fatalf("expected a resume token")
+		}
+
+		return token
+	}
+
+	client, err := neffos.Dial(nil, gorilla.DefaultDialer, "ws://localhost:8080/gorilla", events)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := client.Connect(nil, namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.JoinRoom(nil, "room"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Ask(nil, "login", []byte("kataras")); err != nil {
+		t.Fatal(err)
+	}
+
+	token := waitResumeToken(client)
+
+	client.Close()
+
+	newClient, err := neffos.Dial(nil, gorilla.DefaultDialer, "ws://localhost:8080/gorilla", events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer newClient.Close()
+
+	// the server may not have noticed the close yet.
+	for i := 0; i < 100; i++ {
+		if err = newClient.Resume(nil, token); err != neffos.ErrSessionNotFound {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = newClient.Connect(nil, namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Room("room") == nil {
+		t.Fatalf("expected the room to be re-joined")
+	}
+
+	reply, err := c.Ask(nil, "whoami", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := "kataras", string(reply.Body); expected != got {
+		t.Fatalf("expected the resumed value: %s but got: %s", expected, got)
+	}
+
+	if err = newClient.Resume(nil, token); err != neffos.ErrSessionNotFound {
+		t.Fatalf("expected error: %v on a used token but got: %v", neffos.ErrSessionNotFound, err)
+	}
+
+	// a connection that the server closes on purpose, i.e a kick, cannot be resumed.
+	token = waitResumeToken(newClient)
+	server.GetConnections()[newClient.ID].Close()
+
+	lastClient, err := neffos.Dial(nil, gorilla.DefaultDialer, "ws://localhost:8080/gorilla", events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lastClient.Close()
+
+	if err = lastClient.Resume(nil, token); err != neffos.ErrSessionNotFound {
+		t.Fatalf("expected error: %v on the token of a kicked connection but got: %v", neffos.ErrSessionNotFound, err)
+	}
+}
+
+type testBus []*neffos.Server
+
+func (b testBus) Forward(origin string, msg neffos.Message) error {
+	for _, s := range b {
+		s.ReceiveFromBridge(origin, msg)
+	}
+	return nil
+}
+
+func TestServerBridge(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		received  = make(map[string]int)
+		servers   []*neffos.Server
+		namespace = "default"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"chat": func(c *neffos.NSConn, msg neffos.Message) error {
+					mu.Lock()
+					received[c.Conn.ID()]++
+					mu.Unlock()
+					return nil
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		servers = append(servers, s)
+	})
+	defer teardownServer()
+
+	// the gobwas and gorilla servers are peers of each other.
+	servers[0].UseBridge(neffos.ServerBridge(servers[1]))
+	servers[1].UseBridge(neffos.ServerBridge(servers[0]))
+
+	var clients []*neffos.Client
+	teardownClient := runTestClient("localhost:8080", events, func(_ string, client *neffos.Client) {
+		if _, err := client.Connect(nil, namespace); err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, client)
+	})
+	defer teardownClient()
+
+	expect := func(n int) {
+		t.Helper()
+		time.Sleep(200 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		for _, client := range clients {
+			if got := received[client.ID]; got != n {
+				t.Fatalf("[%s] expected %d messages but got %d", client.ID, n, got)
+			}
+		}
+	}
+
+	servers[0].Broadcast(nil, neffos.Message{Namespace: namespace, Event: "chat", Body: []byte("from gobwas")})
+	expect(1)
+
+	servers[1].Broadcast(nil, neffos.Message{Namespace: namespace, Event: "chat", Body: []byte("from gorilla")})
+	expect(2)
+
+	// a message bus delivers the messages back to their publisher too.
+	servers[0].UseBridge(testBus{servers[0]})
+	servers[0].Broadcast(nil, neffos.Message{Namespace: namespace, Event: "chat", Body: []byte("through bus")})
+	expect(3)
+}
+
+type testRoomStackExchange struct {
+	mu        sync.Mutex
+	published []neffos.Message
+	rooms     map[string]int
+}
+
+func (exc *testRoomStackExchange) OnConnect(c *neffos.Conn) error               { return nil }
+func (exc *testRoomStackExchange) OnDisconnect(c *neffos.Conn)                  {}
+func (exc *testRoomStackExchange) Subscribe(c *neffos.Conn, namespace string)   {}
+func (exc *testRoomStackExchange) Unsubscribe(c *neffos.Conn, namespace string) {}
+
+func (exc *testRoomStackExchange) Publish(msg neffos.Message) bool {
+	exc.mu.Lock()
+	exc.published = append(exc.published, msg)
+	exc.mu.Unlock()
+	return true
+}
+
+// lookupRoom reads the connection's namespace and room,
+// like the redis StackExchange which may deliver a message to the connection while it (un)subscribes,
+// it blocks forever if the connection's locks are held by the caller.
+func (exc *testRoomStackExchange) lookupRoom(c *neffos.Conn, namespace, room string) {
+	if ns := c.Namespace(namespace); ns != nil {
+		ns.Room(room)
+	}
+}
+
+func (exc *testRoomStackExchange) SubscribeRoom(c *neffos.Conn, namespace, room string) {
+	exc.lookupRoom(c, namespace, room)
+
+	exc.mu.Lock()
+	exc.rooms[namespace+"/"+room]++
+	exc.mu.Unlock()
+}
+
+func (exc *testRoomStackExchange) UnsubscribeRoom(c *neffos.Conn, namespace, room string) {
+	exc.lookupRoom(c, namespace, room)
+
+	exc.mu.Lock()
+	exc.rooms[namespace+"/"+room]--
+	exc.mu.Unlock()
+}
+
+func TestServerStackExchangeRooms(t *testing.T) {
+	var (
+		exc       = &testRoomStackExchange{rooms: make(map[string]int)}
+		server    *neffos.Server
+		namespace = "default"
+		events    = neffos.Namespaces{namespace: neffos.Events{}}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		if err := s.UseStackExchange(exc); err != nil {
+			t.Fatal(err)
+		}
+		server = s
+	})
+	defer teardownServer()
+
+	teardownClient := runTestClient("localhost:8080", events, func(_ string, client *neffos.Client) {
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = c.JoinRoom(nil, "room1"); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = c.JoinRoom(nil, "room2"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = c.Room("room2").Leave(nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+	defer teardownClient()
+
+	exc.mu.Lock()
+	if expected, got := 2, exc.rooms[namespace+"/room1"]; expected != got {
+		t.Fatalf("expected %d subscribers of room1 but got %d", expected, got)
+	}
+	if got := exc.rooms[namespace+"/room2"]; got != 0 {
+		t.Fatalf("expected no subscribers of the left room2 but got %d", got)
+	}
+	exc.mu.Unlock()
+
+	// one connection of each server is connected to the namespace.
+	if expected, got := 1, server.NamespaceEmit(namespace, "notify", []byte("hello")); expected != got {
+		t.Fatalf("expected %d connections but got %d", expected, got)
+	}
+
+	exc.mu.Lock()
+	if expected, got := 1, len(exc.published); expected != got {
+		t.Fatalf("expected %d published messages but got %d", expected, got)
+	}
+	if msg := exc.published[0]; msg.Namespace != namespace || msg.Event != "notify" {
+		t.Fatalf("unexpected published message: %#+v", msg)
+	}
+	exc.mu.Unlock()
+}
+
+func TestServerEventMetrics(t *testing.T) {
+	var (
+		servers   []*neffos.Server
+		namespace = "default"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"ok": func(c *neffos.NSConn, msg neffos.Message) error {
+					return nil
+				},
+				"fail": func(c *neffos.NSConn, msg neffos.Message) error {
+					if c.Conn.IsClient() {
+						// the server's error.
+						return nil
+					}
+					return errors.New("fail")
+				},
+				"reply": neffos.ReplyHandler(func(c *neffos.NSConn, msg neffos.Message) ([]byte, error) {
+					return msg.Body, nil
+				}),
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		// enabled on the gobwas server only.
+		s.CollectEventMetrics = len(servers) == 0
+		servers = append(servers, s)
+	})
+	defer teardownServer()
+
+	teardownClient := runTestClient("localhost:8080", events, func(_ string, client *neffos.Client) {
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.Emit("ok", nil)
+		c.Emit("ok", nil)
+		c.Emit("fail", nil)
+		if _, err = c.Ask(nil, "reply", []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	})
+	defer teardownClient()
+
+	// the messages of a connection are handled in order, the Ask's reply is the last one.
+	stats := servers[0].EventMetrics()
+
+	expect := func(event string, received, errors uint64) {
+		t.Helper()
+		stat, ok := stats[namespace+":"+event]
+		if !ok {
+			t.Fatalf("expected metrics for the %s event but got: %#+v", event, stats)
+		}
+
+		if stat.Namespace != namespace || stat.Event != event {
+			t.Fatalf("expected namespace: %s and event: %s but got: %s and %s", namespace, event, stat.Namespace, stat.Event)
+		}
+
+		if stat.Received != received || stat.Errors != errors {
+			t.Fatalf("[%s] expected %d received and %d errors but got %d and %d", event, received, errors, stat.Received, stat.Errors)
+		}
+	}
+
+	expect("ok", 2, 0)
+	expect("fail", 1, 1)
+	expect("reply", 1, 0)
+
+	// disabled by default.
+	if stats := servers[1].EventMetrics(); len(stats) != 0 {
+		t.Fatalf("expected no metrics but got: %#+v", stats)
+	}
+}
+
+func TestServerMaxFragments(t *testing.T) {
+	events := neffos.Namespaces{"default": neffos.Events{}}
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.MaxFragments = 4
+		s.MaxMessageSize = 1024
+	})
+	defer teardownServer()
+
+	expectClosed := func(endpoint string, frames ...ws.Frame) {
+		t.Helper()
+
+		// the server waits for the final frame forever without the limits.
+		conn, _, _, err := ws.Dial(context.Background(), "ws://localhost:8080/"+endpoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		for _, frame := range frames {
+			if err = ws.WriteFrame(conn, ws.MaskFrame(frame)); err != nil {
+				// closed while writing.
+				return
+			}
+		}
+
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		for {
+			frame, err := ws.ReadFrame(conn)
+			if err != nil {
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					t.Fatalf("[%s] expected the connection to be closed", endpoint)
+				}
+				return
+			}
+
+			if frame.Header.OpCode == ws.OpClose {
+				return
+			}
+		}
+	}
+
+	// an endless stream of tiny continuation frames.
+	fragments := []ws.Frame{ws.NewFrame(ws.OpText, false, []byte("a"))}
+	for i := 0; i < 10; i++ {
+		fragments = append(fragments, ws.NewFrame(ws.OpContinuation, false, []byte("a")))
+	}
+
+	// a message larger than the total size, in few frames, without the final one.
+	large := []ws.Frame{
+		ws.NewFrame(ws.OpBinary, false, make([]byte, 600)),
+		ws.NewFrame(ws.OpContinuation, false, make([]byte, 600)),
+	}
+
+	for _, endpoint := range []string{"gobwas", "gorilla"} {
+		expectClosed(endpoint, fragments...)
+		expectClosed(endpoint, large...)
+	}
+}
+
+func TestServerDebugHandler(t *testing.T) {
+	var (
+		servers   []*neffos.Server
+		namespace = "default"
+		room      = "room1"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"echo": neffos.ReplyHandler(func(c *neffos.NSConn, msg neffos.Message) ([]byte, error) {
+					return msg.Body, nil
+				}),
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		servers = append(servers, s)
+	})
+	defer teardownServer()
+
+	teardownClient := runTestClient("localhost:8080", events, func(_ string, client *neffos.Client) {
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = c.JoinRoom(nil, room); err != nil {
+			t.Fatal(err)
+		}
+
+		// waits for the previous messages to be handled by the server.
+		if _, err = c.Ask(nil, "echo", []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	})
+	defer teardownClient()
+
+	for _, s := range servers {
+		rec := httptest.NewRecorder()
+		s.DebugHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/neffos", nil))
+
+		if expected, got := "application/json; charset=utf-8", rec.Header().Get("Content-Type"); expected != got {
+			t.Fatalf("expected content type: %s but got: %s", expected, got)
+		}
+
+		var infos []neffos.ConnInfo
+		if err := json.NewDecoder(rec.Body).Decode(&infos); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(infos) != 1 {
+			t.Fatalf("expected one connection but got: %#+v", infos)
+		}
+
+		info := infos[0]
+		if info.ID == "" || info.RemoteAddr == "" {
+			t.Fatalf("expected an ID and a remote address but got: %#+v", info)
+		}
+
+		if expected, got := []string{room}, info.Namespaces[namespace]; !reflect.DeepEqual(expected, got) {
+			t.Fatalf("expected rooms: %v but got: %v", expected, got)
+		}
+
+		if info.BytesIn == 0 || info.BytesOut == 0 || info.LastActivity.IsZero() {
+			t.Fatalf("expected traffic to be recorded but got: %#+v", info)
+		}
+
+		if info.PendingAsks != 0 {
+			t.Fatalf("expected no pending asks but got: %d", info.PendingAsks)
+		}
+	}
+}
+
+func TestServerMaxConnectionsPerNamespace(t *testing.T) {
+	var (
+		servers   []*neffos.Server
+		namespace = "free"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{},
+			"premium": neffos.Events{},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.MaxConnectionsPerNamespace(namespace, 1)
+		servers = append(servers, s)
+	})
+	defer teardownServer()
+
+	teardownFirstClient := runTestClient("localhost:8080", events, func(_ string, client *neffos.Client) {
+		if _, err := client.Connect(nil, namespace); err != nil {
+			t.Fatal(err)
+		}
+	})
+	defer teardownFirstClient()
+
+	i := 0
+	teardownSecondClient := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		s := servers[i]
+		i++
+
+		if _, err := client.Connect(nil, namespace); err != neffos.ErrNamespaceFull {
+			t.Fatalf("[%s] expected error: %v but got: %v", dialer, neffos.ErrNamespaceFull, err)
+		}
+
+		// not limited.
+		if _, err := client.Connect(nil, "premium"); err != nil {
+			t.Fatal(err)
+		}
+
+		// adjusted at runtime.
+		s.MaxConnectionsPerNamespace(namespace, 2)
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expected, got := 2, s.NamespaceConnections(namespace); expected != got {
+			t.Fatalf("[%s] expected %d connections but got %d", dialer, expected, got)
+		}
+
+		if err = c.Disconnect(nil); err != nil {
+			t.Fatal(err)
+		}
+
+		if expected, got := 1, s.NamespaceConnections(namespace); expected != got {
+			t.Fatalf("[%s] expected %d connections after disconnect but got %d", dialer, expected, got)
+		}
+	})
+	defer teardownSecondClient()
+}
+
+func TestServerOnRawMessage(t *testing.T) {
+	var (
+		namespace = "default"
+		raw       uint32
+		handled   uint32
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"proxied": func(c *neffos.NSConn, msg neffos.Message) error {
+					atomic.AddUint32(&handled, 1)
+					return nil
+				},
+				"echo": neffos.ReplyHandler(func(c *neffos.NSConn, msg neffos.Message) ([]byte, error) {
+					return msg.Body, nil
+				}),
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.OnRawMessage = func(c *neffos.Conn, b []byte) bool {
+			if bytes.Contains(b, []byte(";proxied;")) {
+				atomic.AddUint32(&raw, 1)
+				return false
+			}
+			return true
+		}
+	})
+	defer teardownServer()
+
+	teardownClient := runTestClient("localhost:8080", events, func(_ string, client *neffos.Client) {
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.Emit("proxied", []byte("data"))
+
+		// the messages of a connection are handled in order.
+		reply, err := c.Ask(nil, "echo", []byte("data"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expected, got := "data", string(reply.Body); expected != got {
+			t.Fatalf("expected reply: %s but got: %s", expected, got)
+		}
+	})
+	defer teardownClient()
+
+	if expected, got := uint32(2), atomic.LoadUint32(&raw); expected != got {
+		t.Fatalf("expected %d consumed raw messages but got %d", expected, got)
+	}
+
+	if got := atomic.LoadUint32(&handled); got != 0 {
+		t.Fatalf("expected consumed messages to not be dispatched but got %d", got)
+	}
+}
+
+func TestServerBroadcastToRoomAllNamespaces(t *testing.T) {
+	var (
+		wg       sync.WaitGroup
+		room     = "tenant1"
+		onNotice = func(c *neffos.NSConn, msg neffos.Message) error {
+			if expected, got := "hello", string(msg.Body); expected != got {
+				t.Fatalf("expected body: %s but got: %s", expected, got)
+			}
+
+			if msg.Room != room {
+				t.Fatalf("expected room: %s but got: %s", room, msg.Room)
+			}
+			wg.Done()
+			return nil
+		}
+		events = neffos.Namespaces{
+			"chat": neffos.Events{
+				"announce": func(c *neffos.NSConn, msg neffos.Message) error {
+					n := c.Conn.Server().BroadcastToRoomAllNamespaces(room, "notice", msg.Body)
+					return neffos.Reply([]byte(strconv.Itoa(n)))
+				},
+				"notice": onNotice,
+			},
+			"billing": neffos.Events{
+				"notice": onNotice,
+			},
+			"other": neffos.Events{
+				"notice": func(c *neffos.NSConn, msg neffos.Message) error {
+					t.Fatalf("unexpected notice to a room of another name")
+					return nil
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		rooms := map[string]string{"chat": room, "billing": room, "other": "tenant2"}
+		for namespace, name := range rooms {
+			c, err := client.Connect(nil, namespace)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err = c.JoinRoom(nil, name); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		wg.Add(2)
+		// already connected.
+		c, err := client.Connect(nil, "chat")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		reply, err := c.Ask(nil, "announce", []byte("hello"))
+		if err != nil {
+			t.Fatalf("[%s] %v", dialer, err)
+		}
+		wg.Wait()
+
+		if expected, got := "2", string(reply.Body); expected != got {
+			t.Fatalf("[%s] expected delivery count: %s but got: %s", dialer, expected, got)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerMessageFilter(t *testing.T) {
+	var (
+		namespace = "default"
+		handled   uint32
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"admin": func(c *neffos.NSConn, msg neffos.Message) error {
+					atomic.AddUint32(&handled, 1)
+					return nil
+				},
+				"echo": neffos.ReplyHandler(func(c *neffos.NSConn, msg neffos.Message) ([]byte, error) {
+					return msg.Body, nil
+				}),
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.MessageFilter = func(c *neffos.Conn, msg neffos.Message) bool {
+			return msg.Event != "admin"
+		}
+	})
+	defer teardownServer()
+
+	teardownClient := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.Emit("admin", nil)
+
+		if _, err = c.Ask(nil, "admin", nil); err != neffos.ErrMessageFiltered {
+			t.Fatalf("[%s] expected error: %v but got: %v", dialer, neffos.ErrMessageFiltered, err)
+		}
+
+		if _, err = c.Ask(nil, "echo", []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	})
+	defer teardownClient()
+
+	if got := atomic.LoadUint32(&handled); got != 0 {
+		t.Fatalf("expected filtered messages to not be dispatched but got %d", got)
+	}
+}
+
+func TestServerAuthorize(t *testing.T) {
+	var (
+		namespace = "default"
+		handled   uint32
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"login": neffos.ReplyHandler(func(c *neffos.NSConn, msg neffos.Message) ([]byte, error) {
+					c.Conn.Set("user", string(msg.Body))
+					return nil, nil
+				}),
+				"secret": neffos.ReplyHandler(func(c *neffos.NSConn, msg neffos.Message) ([]byte, error) {
+					atomic.AddUint32(&handled, 1)
+					return []byte("secret"), nil
+				}),
+			},
+		}
+	)
+
+	events.Authorize(namespace, "secret", func(c *neffos.Conn) bool {
+		return c.Get("user") != nil
+	})
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	for _, dialer := range []string{"gobwas", "gorilla"} {
+		url := "ws://localhost:8080/" + dialer
+		authorized, err := neffos.Dial(nil, gobwas.DefaultDialer, url, events)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer authorized.Close()
+
+		unauthorized, err := neffos.Dial(nil, gobwas.DefaultDialer, url, events)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer unauthorized.Close()
+
+		c, err := authorized.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = c.Ask(nil, "login", []byte("kataras")); err != nil {
+			t.Fatal(err)
+		}
+		reply, err := c.Ask(nil, "secret", nil)
+		if err != nil {
+			t.Fatalf("[%s] expected the authorized connection to pass but got: %v", dialer, err)
+		}
+		if expected, got := "secret", string(reply.Body); expected != got {
+			t.Fatalf("[%s] expected reply: %s but got: %s", dialer, expected, got)
+		}
+
+		c, err = unauthorized.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = c.Ask(nil, "secret", nil); err != neffos.ErrForbidden {
+			t.Fatalf("[%s] expected error: %v but got: %v", dialer, neffos.ErrForbidden, err)
+		}
+	}
+
+	if expected, got := uint32(2), atomic.LoadUint32(&handled); expected != got {
+		t.Fatalf("expected the callback to be called for the authorized connections only, %d times but got %d", expected, got)
+	}
+}
+
+func TestServerNamespaceIdleTimeout(t *testing.T) {
+	var (
+		disconnected = make(chan string, 4)
+		events       = neffos.Namespaces{
+			"idle": neffos.Events{
+				neffos.OnNamespaceDisconnect: func(c *neffos.NSConn, msg neffos.Message) error {
+					if c.Conn.IsClient() {
+						disconnected <- msg.Namespace
+					}
+					return nil
+				},
+			},
+			"kept": neffos.Events{},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.NamespaceIdleTimeout = 50 * time.Millisecond
+		s.SetNamespaceIdleTimeout("kept", -1)
+	})
+	defer teardownServer()
+
+	teardownClient := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		if _, err := client.Connect(nil, "idle"); err != nil {
+			t.Fatal(err)
+		}
+		kept, err := client.Connect(nil, "kept")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case namespace := <-disconnected:
+			if namespace != "idle" {
+				t.Fatalf("[%s] expected the idle namespace to be disconnected but got: %s", dialer, namespace)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("[%s] expected the idle namespace to be disconnected", dialer)
+		}
+
+		if kept.Conn.IsClosed() || kept.Conn.Namespace("kept") == nil {
+			t.Fatalf("[%s] expected the connection and the rest of the namespaces to be kept", dialer)
+		}
+	})
+	defer teardownClient()
+}
+
+func TestServerPublicIDFunc(t *testing.T) {
+	var (
+		namespace = "default"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"whoami": neffos.ReplyHandler(func(c *neffos.NSConn, msg neffos.Message) ([]byte, error) {
+					return []byte(c.Conn.ID()), nil
+				}),
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.IDGenerator = func(w http.ResponseWriter, r *http.Request) string {
+			return "internal-1"
+		}
+		s.PublicIDFunc = func(internalID string) string {
+			return "public-" + internalID
+		}
+	})
+	defer teardownServer()
+
+	teardownClient := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		if expected, got := "public-internal-1", client.ID; expected != got {
+			t.Fatalf("[%s] expected client ID: %s but got: %s", dialer, expected, got)
+		}
+
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, got := "public-internal-1", c.Conn.ID(); expected != got {
+			t.Fatalf("[%s] expected client connection ID: %s but got: %s", dialer, expected, got)
+		}
+
+		reply, err := c.Ask(nil, "whoami", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, got := "internal-1", string(reply.Body); expected != got {
+			t.Fatalf("[%s] expected server connection ID: %s but got: %s", dialer, expected, got)
+		}
+	})
+	defer teardownClient()
+}
+
+func TestServerOnDisconnect(t *testing.T) {
+	var (
+		events = neffos.Namespaces{"default": neffos.Events{}}
+
+		mu           sync.Mutex
+		disconnects  = make(map[string]int)
+		disconnected = make(chan struct{}, 4)
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.OnConnect = func(c *neffos.Conn) error {
+			c.Go(func(ctx context.Context) {
+				<-ctx.Done()
+				time.Sleep(20 * time.Millisecond) // cleanup.
+			})
+			return nil
+		}
+		s.OnDisconnect = func(c *neffos.Conn) {
+			if info := c.Info(); info.Goroutines != 0 || info.BytesIn == 0 {
+				t.Errorf("expected the final state of the connection but got: %#+v", info)
+			}
+
+			mu.Lock()
+			disconnects[c.ID()]++
+			mu.Unlock()
+			disconnected <- struct{}{}
+		}
+	})
+	defer teardownServer()
+
+	teardownClient := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		if _, err := client.Connect(nil, "default"); err != nil {
+			t.Fatal(err)
+		}
+
+		client.Close()
+	})
+	defer teardownClient()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-disconnected:
+		case <-time.After(3 * time.Second):
+			t.Fatalf("expected the disconnects of both connections")
+		}
+	}
+
+	// no more disconnects.
+	time.Sleep(50 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(disconnects) != 2 {
+		t.Fatalf("expected the disconnects of two connections but got: %v", disconnects)
+	}
+	for id, n := range disconnects {
+		if n != 1 {
+			t.Fatalf("[%s] expected a single disconnect but got %d", id, n)
+		}
+	}
+}
+
+func TestRoomEmitQuorum(t *testing.T) {
+	var (
+		namespace   = "default"
+		room        = "replicas"
+		errNotSaved = errors.New("not saved")
+	)
+
+	replica := func(reply func() error) neffos.Namespaces {
+		return neffos.Namespaces{
+			namespace: neffos.Events{
+				"replicate": func(c *neffos.NSConn, msg neffos.Message) error {
+					return reply()
+				},
+			},
+		}
+	}
+	ack := func() error { return neffos.Reply([]byte("saved")) }
+
+	var servers []*neffos.Server
+	teardownServer := runTestServer("localhost:8080", neffos.Namespaces{namespace: neffos.Events{}}, func(s *neffos.Server) {
+		servers = append(servers, s)
+	})
+	defer teardownServer()
+
+	replicas := []neffos.Namespaces{
+		replica(ack),
+		replica(ack),
+		replica(func() error { return errNotSaved }),
+		replica(func() error { time.Sleep(300 * time.Millisecond); return ack() }),
+	}
+
+	var ids []string
+	for _, events := range replicas {
+		client, err := neffos.Dial(nil, gobwas.DefaultDialer, "ws://localhost:8080/gobwas", events)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = c.JoinRoom(nil, room); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, client.ID)
+	}
+
+	r := servers[0].GetConnections()[ids[0]].Namespace(namespace).Room(room)
+
+	if err := r.EmitQuorum(nil, "replicate", []byte("data"), 2); err != nil {
+		t.Fatalf("expected the quorum of 2 to be reached but got: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := r.EmitQuorum(ctx, "replicate", []byte("data"), 3); err != context.DeadlineExceeded {
+		t.Fatalf("expected error: %v but got: %v", context.DeadlineExceeded, err)
+	}
+
+	// one of the 4 members fails.
+	if err := r.EmitQuorum(nil, "replicate", []byte("data"), 4); err != neffos.ErrQuorumNotReached {
+		t.Fatalf("expected error: %v but got: %v", neffos.ErrQuorumNotReached, err)
+	}
+
+	// fewer members than the quorum.
+	if err := r.EmitQuorum(nil, "replicate", []byte("data"), 5); err != neffos.ErrQuorumNotReached {
+		t.Fatalf("expected error: %v but got: %v", neffos.ErrQuorumNotReached, err)
+	}
+}
+
+func TestConnMute(t *testing.T) {
+	var (
+		namespace = "default"
+		counted   uint32
+		notified  = make(chan error, 4)
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"count": func(c *neffos.NSConn, msg neffos.Message) error {
+					atomic.AddUint32(&counted, 1)
+					return nil
+				},
+				"mute": neffos.ReplyHandler(func(c *neffos.NSConn, msg neffos.Message) ([]byte, error) {
+					c.Conn.Mute(200 * time.Millisecond)
+					return nil, nil
+				}),
+				"echo": neffos.ReplyHandler(func(c *neffos.NSConn, msg neffos.Message) ([]byte, error) {
+					return msg.Body, nil
+				}),
+				neffos.OnError: func(c *neffos.NSConn, msg neffos.Message) error {
+					notified <- msg.Err
+					return nil
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.NotifyMuted = true
+	})
+	defer teardownServer()
+
+	teardownClient := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = c.Ask(nil, "mute", nil); err != nil {
+			t.Fatal(err)
+		}
+
+		c.Emit("count", nil)
+		select {
+		case err = <-notified:
+			if err != neffos.ErrMuted {
+				t.Fatalf("[%s] expected notification: %v but got: %v", dialer, neffos.ErrMuted, err)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("[%s] expected the client to be notified that it's muted", dialer)
+		}
+
+		if _, err = c.Ask(nil, "echo", []byte("data")); err != neffos.ErrMuted {
+			t.Fatalf("[%s] expected error: %v but got: %v", dialer, neffos.ErrMuted, err)
+		}
+
+		time.Sleep(250 * time.Millisecond)
+
+		if _, err = c.Ask(nil, "echo", []byte("data")); err != nil {
+			t.Fatalf("[%s] expected the connection to be unmuted but got: %v", dialer, err)
+		}
+		if c.Conn.IsClosed() {
+			t.Fatalf("[%s] expected the muted connection to be kept", dialer)
+		}
+	})
+	defer teardownClient()
+
+	if got := atomic.LoadUint32(&counted); got != 0 {
+		t.Fatalf("expected the events of the muted connections to be dropped but got %d", got)
+	}
+}
+
+func TestServerValidate(t *testing.T) {
+	tests := []struct {
+		namespaces neffos.Namespaces
+		valid      bool
+	}{
+		{neffos.Namespaces{"default": neffos.Events{"event": func(*neffos.NSConn, neffos.Message) error { return nil }}}, true},
+		{neffos.Namespaces{"default": nil}, false},
+		{neffos.Namespaces{"default": neffos.Events{"event": nil}}, false},
+	}
+
+	for i, tt := range tests {
+		server := neffos.New(gobwas.DefaultUpgrader, tt.namespaces)
+		if err := server.Validate(); (err == nil) != tt.valid {
+			t.Fatalf("[%d] expected valid: %v but got error: %v", i, tt.valid, err)
+		}
+		server.Close()
+	}
+}
+
+func TestServerUpgrader(t *testing.T) {
+	events := neffos.Namespaces{"default": neffos.Events{}}
+
+	server := neffos.New(gorilla.GorillaUpgrader(), events)
+	// the upgrader is a runtime option.
+	server.Upgrader = gobwas.GobwasUpgrader()
+
+	sockets := make(chan neffos.Socket, 1)
+	server.OnConnect = func(c *neffos.Conn) error {
+		sockets <- c.Socket()
+		return nil
+	}
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	defer server.Close()
+
+	client, err := neffos.Dial(nil, gorilla.DefaultDialer, "ws"+strings.TrimPrefix(httpServer.URL, "http"), events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, ok := (<-sockets).(*gobwas.Socket); !ok {
+		t.Fatalf("expected a gobwas socket")
+	}
+}
+
+func TestServerTrustedSecret(t *testing.T) {
+	var (
+		namespace = "default"
+		secret    = "mesh-secret"
+		trusted   = make(chan *neffos.Conn, 1)
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				neffos.OnNamespaceConnect: func(c *neffos.NSConn, msg neffos.Message) error {
+					if c.Conn.IsClient() {
+						return nil
+					}
+					return errors.New("unauthorized")
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.TrustedSecret = secret
+		s.OnTrustedConnect = func(c *neffos.Conn) {
+			trusted <- c
+		}
+	})
+	defer teardownServer()
+
+	tests := []struct {
+		secret  string
+		trusted bool
+	}{
+		{secret, true},
+		{"wrong-secret", false},
+		{"", false},
+	}
+
+	for _, dialer := range []string{"gobwas", "gorilla"} {
+		for i, tt := range tests {
+			header := make(http.Header)
+			if tt.secret != "" {
+				header.Set(neffos.TrustedSecretHeaderKey, tt.secret)
+			}
+
+			client, err := neffos.Dial(nil, gorilla.Dialer(&gorilla.Options{}, header), "ws://localhost:8080/"+dialer, events)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = client.Connect(nil, namespace)
+			if tt.trusted && err != nil {
+				t.Fatalf("[%s] [%d] expected the trusted connection to skip the namespace authorization but got: %v", dialer, i, err)
+			}
+			if !tt.trusted && (err == nil || err.Error() != "unauthorized") {
+				t.Fatalf("[%s] [%d] expected the untrusted connection to be authorized but got: %v", dialer, i, err)
+			}
+
+			select {
+			case c := <-trusted:
+				if !tt.trusted || !c.IsTrusted() || c.ID() != client.ID {
+					t.Fatalf("[%s] [%d] unexpected trusted connection", dialer, i)
+				}
+			default:
+				if tt.trusted {
+					t.Fatalf("[%s] [%d] expected the OnTrustedConnect to be fired", dialer, i)
+				}
+			}
+
+			client.Close()
+		}
+	}
+}
+
+func TestServerDrain(t *testing.T) {
+	var (
+		namespace     = "default"
+		started       = make(chan struct{})
+		releaseServer = make(chan struct{})
+		releaseClient = make(chan struct{})
+		received      = make(chan struct{})
+		serverEvents  = neffos.Namespaces{
+			namespace: neffos.Events{
+				"slow": neffos.ReplyHandler(func(*neffos.NSConn, neffos.Message) ([]byte, error) {
+					close(started)
+					<-releaseServer
+					return []byte("done"), nil
+				}),
+				"event": func(*neffos.NSConn, neffos.Message) error { return nil },
+			},
+			"other": neffos.Events{},
+		}
+		clientEvents = neffos.Namespaces{
+			namespace: neffos.Events{
+				"slow": neffos.ReplyHandler(func(*neffos.NSConn, neffos.Message) ([]byte, error) {
+					<-releaseClient
+					return []byte("done"), nil
+				}),
+				"never": func(*neffos.NSConn, neffos.Message) error {
+					close(received)
+					return nil
+				},
+			},
+			"other": neffos.Events{},
+		}
+	)
+
+	server := neffos.New(gorilla.DefaultUpgrader, serverEvents)
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	defer server.Close()
+
+	// the slow callbacks block the reader of their connection, so each one has its own.
+	var conns []*neffos.NSConn
+	for i := 0; i < 2; i++ {
+		client, err := neffos.Dial(nil, gorilla.DefaultDialer, "ws"+strings.TrimPrefix(httpServer.URL, "http"), clientEvents)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, c)
+	}
+	busy, c := conns[0], conns[1]
+
+	// an in-flight event callback.
+	eventDone := make(chan error, 1)
+	go func() {
+		_, err := busy.Ask(nil, "slow", nil)
+		eventDone <- err
+	}()
+	<-started
+
+	drain := func(timeout time.Duration) error {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return server.Drain(ctx)
+	}
+
+	if err := drain(100 * time.Millisecond); err != context.DeadlineExceeded {
+		t.Fatalf("expected the drain to wait for the in-flight event callback but got: %v", err)
+	}
+
+	if !server.IsDraining() {
+		t.Fatalf("expected the server to be draining")
+	}
+
+	if _, err := c.Ask(nil, "event", nil); err != neffos.ErrServerDraining {
+		t.Fatalf("expected the event to be rejected with: %v but got: %v", neffos.ErrServerDraining, err)
+	}
+
+	if _, err := c.Conn.Connect(nil, "other"); err != neffos.ErrServerDraining {
+		t.Fatalf("expected the connect to be rejected with: %v but got: %v", neffos.ErrServerDraining, err)
+	}
+
+	// a canceled server's ask is not waited for.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := server.Ask(ctx, neffos.Message{To: c.Conn.ID(), Namespace: namespace, Event: "never"}); err != context.Canceled {
+		t.Fatalf("expected the ask to be canceled but got: %v", err)
+	}
+	// the next broadcast should wait for the previous one.
+	<-received
+
+	// an in-flight server's ask.
+	askDone := make(chan error, 1)
+	go func() {
+		_, err := server.Ask(nil, neffos.Message{To: c.Conn.ID(), Namespace: namespace, Event: "slow"})
+		askDone <- err
+	}()
+
+	close(releaseServer)
+	if err := <-eventDone; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := drain(100 * time.Millisecond); err != context.DeadlineExceeded {
+		t.Fatalf("expected the drain to wait for the server's ask but got: %v", err)
+	}
+
+	close(releaseClient)
+	if err := <-askDone; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := drain(3 * time.Second); err != nil {
+		t.Fatalf("expected the drain to complete but got: %v", err)
+	}
+}
+
+func TestServerOnMessage(t *testing.T) {
+	var (
+		namespace = "default"
+		news      = make(chan neffos.Message, 2)
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"echo": neffos.ReplyHandler(func(c *neffos.NSConn, msg neffos.Message) ([]byte, error) {
+					return []byte(string(msg.Body) + "|" + msg.Headers["trace-id"]), nil
+				}),
+				"broadcast": func(c *neffos.NSConn, msg neffos.Message) error {
+					c.Conn.Server().Broadcast(nil, neffos.Message{Namespace: namespace, Event: "news", Body: msg.Body})
+					return nil
+				},
+				"news": func(c *neffos.NSConn, msg neffos.Message) error {
+					news <- msg
+					return nil
+				},
+			},
+		}
+	)
+
+	server := neffos.New(gorilla.DefaultUpgrader, events)
+	// extracts the trace context of the incoming messages.
+	server.OnMessage = func(c *neffos.Conn, msg *neffos.Message) {
+		if traceID := msg.Headers["trace-id"]; traceID != "" {
+			msg.Body = []byte("traced:" + string(msg.Body))
+		}
+	}
+	// injects the receiver's trace context to the outgoing messages.
+	server.OnWriteMessage = func(c *neffos.Conn, msg *neffos.Message) {
+		if msg.Headers == nil {
+			msg.Headers = make(map[string]string)
+		}
+		msg.Headers["trace-id"] = c.ID()
+	}
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	defer server.Close()
+
+	var (
+		clients []*neffos.Client
+		conns   []*neffos.NSConn
+	)
+	for i := 0; i < 2; i++ {
+		client, err := neffos.Dial(nil, gorilla.DefaultDialer, "ws"+strings.TrimPrefix(httpServer.URL, "http"), events)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		c, err := client.Connect(nil, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		clients = append(clients, client)
+		conns = append(conns, c)
+	}
+
+	reply, err := conns[0].Conn.Ask(nil, neffos.Message{
+		Namespace: namespace,
+		Event:     "echo",
+		Body:      []byte("body"),
+		Headers:   map[string]string{"trace-id": "abc"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected, got := "traced:body|abc", string(reply.Body); expected != got {
+		t.Fatalf("expected the rewritten incoming message: %s but got: %s", expected, got)
+	}
+
+	if expected, got := clients[0].ID, reply.Headers["trace-id"]; expected != got {
+		t.Fatalf("expected the rewritten outgoing message's trace id: %s but got: %s", expected, got)
+	}
+
+	conns[0].Emit("broadcast", []byte("hello"))
+
+	traceIDs := make(map[string]bool)
+	for i := 0; i < len(clients); i++ {
+		select {
+		case msg := <-news:
+			if expected, got := "hello", string(msg.Body); expected != got {
+				t.Fatalf("expected the broadcasted body: %s but got: %s", expected, got)
+			}
+			traceIDs[msg.Headers["trace-id"]] = true
+		case <-time.After(3 * time.Second):
+			t.Fatalf("expected the broadcasted message to be received by each connection")
+		}
+	}
+
+	// rewritten once per receiver connection.
+	for _, client := range clients {
+		if !traceIDs[client.ID] {
+			t.Fatalf("expected a broadcasted message with the trace id of: %s but got: %v", client.ID, traceIDs)
+		}
+	}
+}
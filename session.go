@@ -0,0 +1,73 @@
+package neffos
+
+// SessionReplacedReason is the `Message.Body` of the forced namespace disconnects
+// that a connection receives when it's replaced by a newer one, see `Server.ReplaceExisting`.
+var SessionReplacedReason = []byte("session replaced")
+
+type sessionKey struct {
+	key   interface{}
+	value interface{}
+}
+
+// ReplaceExisting registers the "newConn" as the only active connection of the "key" and "value" pair,
+// i.e the "userID" key and the authenticated user's id as its value, and
+// closes the connection which was registered with the same pair before, if any,
+// so a user has only one active session at a time ("log out other devices").
+//
+// The previous connection is gracefully closed in the background: each of its connected namespaces
+// receives a forced disconnect with the `SessionReplacedReason` as its `Message.Body`
+// and the `OnSessionReplaced`, if any, is fired right before that.
+// It returns the replaced connection or nil.
+//
+// The registrations are serialized, on two near-simultaneous calls with the same pair
+// the last one wins and the first connection is replaced.
+// A closed "newConn" is not registered. The "key" and "value" should be comparable.
+// The pair is released when its connection is closed.
+//
+// Usage: call it on the `OnConnect` or inside an authentication event callback.
+func (s *Server) ReplaceExisting(key, value interface{}, newConn *Conn) *Conn {
+	k := sessionKey{key, value}
+
+	s.sessionsMutex.Lock()
+	if newConn.IsClosed() {
+		s.sessionsMutex.Unlock()
+		return nil
+	}
+
+	if s.sessions == nil {
+		s.sessions = make(map[sessionKey]*Conn)
+	}
+
+	prev := s.sessions[k]
+	if prev == newConn {
+		s.sessionsMutex.Unlock()
+		return nil
+	}
+
+	s.sessions[k] = newConn
+	newConn.sessionKeys = append(newConn.sessionKeys, k)
+	s.sessionsMutex.Unlock()
+
+	if prev == nil {
+		return nil
+	}
+
+	if s.OnSessionReplaced != nil {
+		s.OnSessionReplaced(prev, newConn)
+	}
+
+	go prev.closeWithReason(SessionReplacedReason)
+	return prev
+}
+
+// releaseSessions removes the pairs that are registered to the closed "c", see `ReplaceExisting`.
+func (s *Server) releaseSessions(c *Conn) {
+	s.sessionsMutex.Lock()
+	for _, k := range c.sessionKeys {
+		if s.sessions[k] == c {
+			delete(s.sessions, k)
+		}
+	}
+	c.sessionKeys = nil
+	s.sessionsMutex.Unlock()
+}
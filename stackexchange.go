@@ -40,6 +40,20 @@ type StackExchangeInitializer interface {
 	Init(Namespaces) error
 }
 
+// StackExchangeRoomSubscriber is an optional interface for a `StackExchange`.
+// It's completed by the stack exchanges that publish the messages of a room
+// to a different channel than the messages of its namespace,
+// so the connections receive the messages of the rooms that they are joined to only,
+// instead of the messages of all the rooms of their namespaces.
+type StackExchangeRoomSubscriber interface {
+	// SubscribeRoom should subscribe to a specific namespace's room,
+	// it's called automatically on neffos room joined.
+	SubscribeRoom(c *Conn, namespace, room string)
+	// UnsubscribeRoom should unsubscribe from a specific namespace's room,
+	// it's called automatically on neffos room left.
+	UnsubscribeRoom(c *Conn, namespace, room string)
+}
+
 func stackExchangeInit(s StackExchange, namespaces Namespaces) error {
 	if s != nil {
 		if sinit, ok := s.(StackExchangeInitializer); ok {
@@ -97,3 +111,23 @@ func (s *stackExchangeWrapper) Unsubscribe(c *Conn, namespace string) {
 	s.parent.Unsubscribe(c, namespace)
 	s.current.Unsubscribe(c, namespace)
 }
+
+func (s *stackExchangeWrapper) SubscribeRoom(c *Conn, namespace, room string) {
+	if sub, ok := s.parent.(StackExchangeRoomSubscriber); ok {
+		sub.SubscribeRoom(c, namespace, room)
+	}
+
+	if sub, ok := s.current.(StackExchangeRoomSubscriber); ok {
+		sub.SubscribeRoom(c, namespace, room)
+	}
+}
+
+func (s *stackExchangeWrapper) UnsubscribeRoom(c *Conn, namespace, room string) {
+	if sub, ok := s.parent.(StackExchangeRoomSubscriber); ok {
+		sub.UnsubscribeRoom(c, namespace, room)
+	}
+
+	if sub, ok := s.current.(StackExchangeRoomSubscriber); ok {
+		sub.UnsubscribeRoom(c, namespace, room)
+	}
+}
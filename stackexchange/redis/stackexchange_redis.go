@@ -56,11 +56,13 @@ type (
 	subscribeAction struct {
 		conn      *neffos.Conn
 		namespace string
+		room      string
 	}
 
 	unsubscribeAction struct {
 		conn      *neffos.Conn
 		namespace string
+		room      string
 	}
 
 	closeAction struct {
@@ -68,7 +70,10 @@ type (
 	}
 )
 
-var _ neffos.StackExchange = (*StackExchange)(nil)
+var (
+	_ neffos.StackExchange               = (*StackExchange)(nil)
+	_ neffos.StackExchangeRoomSubscriber = (*StackExchange)(nil)
+)
 
 // NewStackExchange returns a new redis StackExchange.
 // The "channel" input argument is the channel prefix for publish and subscribe.
@@ -155,13 +160,13 @@ func (exc *StackExchange) run() {
 			exc.subscribers[s.conn] = s
 		case m := <-exc.subscribe:
 			if sub, ok := exc.subscribers[m.conn]; ok {
-				channel := exc.getChannel(m.namespace, "", "")
+				channel := exc.getChannel(m.namespace, m.room, "")
 				// neffos.Debugf("[%s] subscribed to [%s]", m.conn.ID(), channel)
 				sub.pubSub.PSubscribe(sub.msgCh, channel)
 			}
 		case m := <-exc.unsubscribe:
 			if sub, ok := exc.subscribers[m.conn]; ok {
-				channel := exc.getChannel(m.namespace, "", "")
+				channel := exc.getChannel(m.namespace, m.room, "")
 				// neffos.Debugf("[%s] unsubscribed from [%s]", channel)
 				sub.pubSub.PUnsubscribe(sub.msgCh, channel)
 			}
@@ -188,6 +193,11 @@ func (exc *StackExchange) getChannel(namespace, room, connID string) string {
 		panic("namespace cannot be empty when sending to a namespace's room")
 	}
 
+	if room != "" {
+		// the room's messages are received only by its members, see `SubscribeRoom`.
+		return exc.channel + "." + namespace + "." + room + "."
+	}
+
 	return exc.channel + "." + namespace + "."
 }
 
@@ -256,6 +266,26 @@ func (exc *StackExchange) Unsubscribe(c *neffos.Conn, namespace string) {
 	}
 }
 
+// SubscribeRoom subscribes to a specific namespace's room,
+// it's called automatically on neffos room joined.
+func (exc *StackExchange) SubscribeRoom(c *neffos.Conn, namespace, room string) {
+	exc.subscribe <- subscribeAction{
+		conn:      c,
+		namespace: namespace,
+		room:      room,
+	}
+}
+
+// UnsubscribeRoom unsubscribes from a specific namespace's room,
+// it's called automatically on neffos room left.
+func (exc *StackExchange) UnsubscribeRoom(c *neffos.Conn, namespace, room string) {
+	exc.unsubscribe <- unsubscribeAction{
+		conn:      c,
+		namespace: namespace,
+		room:      room,
+	}
+}
+
 // OnDisconnect terminates the connection's subscriber that
 // created on the `OnConnect` method.
 // It unsubscribes to all opened channels and
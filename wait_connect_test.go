@@ -0,0 +1,61 @@
+package neffos
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWaitConnectUnknownNamespaces(t *testing.T) {
+	namespaces := Namespaces{"default": Events{}}
+	s := New(nil, namespaces)
+	defer s.Close()
+
+	socket := newPipeSocket()
+	c := newConn(socket, namespaces, nil)
+	c.server = s
+	c.acknowledge()
+	defer c.Close()
+
+	for i := 0; i < 1000; i++ {
+		c.replyConnect(Message{Namespace: "unknown" + strconv.Itoa(i), Event: OnNamespaceConnect, wait: "1"})
+	}
+
+	if expected, got := 1000, len(socket.Written()); expected != got {
+		t.Fatalf("expected %d rejections but got %d", expected, got)
+	}
+
+	c.connectedNamespacesMutex.RLock()
+	n := len(c.connectWaiters)
+	c.connectedNamespacesMutex.RUnlock()
+	if n != 0 {
+		t.Fatalf("expected the rejections without a waiting WaitConnect to not be kept but got %d", n)
+	}
+}
+
+func TestWaitConnectClosed(t *testing.T) {
+	namespaces := Namespaces{"default": Events{}}
+	c := newConn(newPipeSocket(), namespaces, nil)
+	c.acknowledge()
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := c.WaitConnect(nil, "default")
+		errCh <- err
+	}()
+
+	c.Close()
+
+	select {
+	case err := <-errCh:
+		if err != ErrWrite {
+			t.Fatalf("expected error: %v but got: %v", ErrWrite, err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expected the WaitConnect to return when the connection is closed")
+	}
+
+	if _, err := c.WaitConnect(nil, "default"); err != ErrWrite {
+		t.Fatalf("expected error: %v but got: %v", ErrWrite, err)
+	}
+}
@@ -0,0 +1,71 @@
+package neffos
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"sync/atomic"
+)
+
+// handlerLabel is the profiler label which marks the goroutine of a watched event callback,
+// its value is a token unique per callback call, see `watchHandler`.
+const handlerLabel = "neffos.handler"
+
+// handlerTokens is the last token of a watched event callback, accessed atomically.
+var handlerTokens uint64
+
+// watchHandler calls the event callback "h" with a watchdog, see `Server.HandlerDeadline`.
+// The callback's goroutine is marked through a profiler label instead of looking up its identifier
+// on each call, the (expensive) stack trace is taken only when the deadline is exceeded.
+func (s *Server) watchHandler(c *NSConn, msg Message, h MessageHandlerFunc) (err error) {
+	token := strconv.FormatUint(atomic.AddUint64(&handlerTokens, 1), 10)
+
+	stop := afterFunc(c.Conn.clock(), s.HandlerDeadline, func() {
+		stack := handlerStack(token)
+
+		if s.OnHandlerDeadline != nil {
+			s.OnHandlerDeadline(c.Conn, msg.Namespace, msg.Event, stack)
+		} else {
+			s.logger(c.Conn, msg.Namespace).Warnf("the %s event callback exceeded the handler deadline of %s:\n%s",
+				msg.Event, s.HandlerDeadline, stack)
+		}
+
+		if s.CloseOnHandlerDeadline {
+			// the callback may hold locks that the close needs.
+			go c.Conn.Close()
+		}
+	})
+	defer stop()
+
+	pprof.Do(context.Background(), pprof.Labels(handlerLabel, token), func(context.Context) {
+		err = h(c, msg)
+	})
+
+	return
+}
+
+// handlerStack returns the stack trace of the goroutine marked with the "token",
+// or the stack traces of all goroutines if it's not found, i.e the callback just returned.
+func handlerStack(token string) []byte {
+	buf := new(bytes.Buffer)
+	// the debug=1 goroutine profile is the one which prints the labels.
+	if err := pprof.Lookup("goroutine").WriteTo(buf, 1); err == nil {
+		label := []byte(strconv.Quote(handlerLabel) + ":" + strconv.Quote(token))
+		for _, stack := range bytes.Split(buf.Bytes(), []byte("\n\n")) {
+			if bytes.Contains(stack, label) {
+				return stack
+			}
+		}
+	}
+
+	stack := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(stack, true)
+		if n < len(stack) {
+			return stack[:n]
+		}
+		stack = make([]byte, 2*len(stack))
+	}
+}
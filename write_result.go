@@ -0,0 +1,52 @@
+package neffos
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+var (
+	// ErrExpired is the write result of a message which was dropped
+	// because its `Message.ExpiresAt` had passed, see `Conn.WriteResult`.
+	ErrExpired = errors.New("message expired")
+	// ErrCoalesced is the write result of a queued message which was replaced
+	// by a newer one of the same key, see `Message.Coalesce` and `Conn.WriteResult`.
+	ErrCoalesced = errors.New("message coalesced")
+)
+
+// WriteResult is like `Write` but instead of reporting the result synchronously it returns a channel
+// which receives the result of the write when the message is actually flushed to the underline connection,
+// i.e after the acknowledgement for messages written from a `Server.OnConnect` callback (see `MaxPendingWrites`).
+// The channel receives exactly one value, nil on success, the socket's error or:
+// an `ErrWrite` when the connection is closed or the message is not allowed to be sent,
+// an `ErrExpired` (see `Message.ExpiresAt`) or an `ErrCoalesced` (see `Message.Coalesce`).
+// It lets producers track the writes without blocking.
+//
+// Note that it covers the local write only, a nil result does not mean that the remote side
+// has received or handled the message, use the `Ask` to wait for a reply of the remote side instead.
+// Client-side messages to a not connected namespace are not kept by the `Client.BufferOutbound`, they fail with `ErrWrite`.
+func (c *Conn) WriteResult(msg Message) <-chan error {
+	result := make(chan error, 1)
+	if !c.writeMessage(msg, result) {
+		// not written nor queued, report it if it's not already reported (i.e the socket's error).
+		select {
+		case result <- ErrWrite:
+		default:
+		}
+	}
+
+	return result
+}
+
+// done sends the result of the "w" to its `WriteResult` channel, if any,
+// and releases its buffer, its data are not used after that.
+func (w pendingWrite) done(err error) {
+	releaseBuffer(w.buf)
+	if w.inflight != nil {
+		atomic.AddInt32(w.inflight, -1)
+	}
+
+	if w.result != nil {
+		w.result <- err
+	}
+}
@@ -0,0 +1,51 @@
+package neffos
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConnWriteResult(t *testing.T) {
+	namespaces := Namespaces{"default": Events{}}
+	clock := &fakeClock{now: time.Now()}
+
+	c := newConn(newPipeSocket(), namespaces, nil)
+	c.setClock(clock)
+	c.connectedNamespaces["default"] = newNSConn(c, "default", namespaces["default"])
+
+	expectResult := func(result <-chan error, expected error) {
+		t.Helper()
+		select {
+		case err := <-result:
+			if err != expected {
+				t.Fatalf("expected result: %v but got: %v", expected, err)
+			}
+		default:
+			t.Fatalf("expected a result")
+		}
+	}
+
+	// queued until the acknowledgement.
+	replaced := c.WriteResult(Message{Namespace: "default", Event: "position", Coalesce: true})
+	latest := c.WriteResult(Message{Namespace: "default", Event: "position", Coalesce: true})
+	expired := c.WriteResult(Message{Namespace: "default", Event: "chat", ExpiresAt: clock.Now().Add(time.Second)})
+
+	select {
+	case err := <-latest:
+		t.Fatalf("expected no result before the acknowledgement but got: %v", err)
+	default:
+	}
+
+	expectResult(replaced, ErrCoalesced)
+
+	clock.Advance(2 * time.Second)
+	c.acknowledge()
+
+	expectResult(latest, nil)
+	expectResult(expired, ErrExpired)
+	expectResult(c.WriteResult(Message{Namespace: "default", Event: "chat"}), nil)
+	expectResult(c.WriteResult(Message{Namespace: "other", Event: "chat"}), ErrWrite)
+
+	c.Close()
+	expectResult(c.WriteResult(Message{Namespace: "default", Event: "chat"}), ErrWrite)
+}